@@ -0,0 +1,48 @@
+package main
+
+import "dsp-platform/internal/filesync"
+
+// parseAPIPagination reads the pagination, record path and incremental settings of an
+// api_config
+func parseAPIPagination(msg AgentMessage) filesync.APIPagination {
+	p := filesync.APIPagination{}
+	cfg, ok := msg.Data["api_config"].(map[string]interface{})
+	if !ok {
+		return p
+	}
+	p.Strategy, _ = cfg["pagination"].(string)
+	p.PageParam, _ = cfg["page_param"].(string)
+	p.SizeParam, _ = cfg["size_param"].(string)
+	p.CursorPath, _ = cfg["cursor_path"].(string)
+	p.CursorParam, _ = cfg["cursor_param"].(string)
+	p.HasMorePath, _ = cfg["has_more_path"].(string)
+	p.RecordPath, _ = cfg["record_path"].(string)
+	p.IncrementalParam, _ = cfg["incremental_param"].(string)
+	p.Checkpoint, _ = cfg["checkpoint"].(string)
+	p.FollowOtherHosts, _ = cfg["follow_other_hosts"].(bool)
+	if n, ok := cfg["page_size"].(float64); ok {
+		p.PageSize = int(n)
+	}
+	if n, ok := cfg["start_page"].(float64); ok {
+		p.StartPage = int(n)
+	}
+	if n, ok := cfg["max_pages"].(float64); ok {
+		p.MaxPages = int(n)
+	}
+	return p
+}
+
+// parseAPIOAuth2 reads the OAuth2 client credentials of an api_config (auth_type oauth2)
+func parseAPIOAuth2(msg AgentMessage) filesync.OAuth2Config {
+	o := filesync.OAuth2Config{}
+	cfg, ok := msg.Data["api_config"].(map[string]interface{})
+	if !ok {
+		return o
+	}
+	o.TokenURL, _ = cfg["oauth_token_url"].(string)
+	o.ClientID, _ = cfg["oauth_client_id"].(string)
+	o.ClientSecret, _ = cfg["oauth_client_secret"].(string)
+	o.Scope, _ = cfg["oauth_scope"].(string)
+	o.Audience, _ = cfg["oauth_audience"].(string)
+	return o
+}
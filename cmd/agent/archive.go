@@ -0,0 +1,72 @@
+package main
+
+import (
+	"dsp-platform/internal/filesync"
+	"dsp-platform/internal/logger"
+	"path"
+)
+
+// compressionConfig is the compression section of a file_config
+type compressionConfig struct {
+	compression  string // auto, none, gzip, zip, tar, tar.gz
+	innerPattern string // Archive entries to load, "" for all
+	nameColumn   string // Column receiving the file (or archive entry) name
+}
+
+// parseCompressionConfig reads the compression settings of a command
+func parseCompressionConfig(msg AgentMessage) compressionConfig {
+	cfg := compressionConfig{compression: filesync.CompressionAuto}
+	if fileCfg, ok := msg.Data["file_config"].(map[string]interface{}); ok {
+		if compression, ok := fileCfg["compression"].(string); ok && compression != "" {
+			cfg.compression = compression
+		}
+		cfg.innerPattern, _ = fileCfg["inner_pattern"].(string)
+		cfg.nameColumn, _ = fileCfg["file_name_column"].(string)
+	}
+	return cfg
+}
+
+// fileUnit is something loaded as one file: a plain or gzip file, or an entry of an archive
+type fileUnit struct {
+	name  string // Recorded in the name column, archive entries as archive/entry
+	entry string // Entry path inside the archive, "" for plain files
+	open  filesync.OpenFunc
+}
+
+// expandFile decompresses a source file: a plain file is loaded as is, a gzip file through
+// the decompressor, and an archive is downloaded and split into its entries matching the
+// inner pattern. Returns the compression found, and cleanup removes the downloaded archive
+// once the units are loaded
+func expandFile(filePath, format string, open filesync.OpenFunc, cfg compressionConfig) (units []fileUnit, compression string, cleanup func(), err error) {
+	fileName := path.Base(filePath)
+	cleanup = func() {}
+
+	compression = cfg.compression
+	if compression == filesync.CompressionAuto {
+		if compression, err = filesync.DetectCompression(fileName, format, open); err != nil {
+			return nil, "", cleanup, err
+		}
+	}
+
+	switch {
+	case compression == filesync.CompressionGzip:
+		return []fileUnit{{name: fileName, open: filesync.GunzipOpen(open)}}, compression, cleanup, nil
+	case filesync.IsArchive(compression):
+		archive, err := filesync.OpenArchive(open, compression, cfg.innerPattern, fileStreamRetries)
+		if err != nil {
+			return nil, "", cleanup, err
+		}
+		for _, e := range archive.Entries {
+			units = append(units, fileUnit{name: fileName + "/" + e.Name, entry: e.Name, open: archive.OpenEntry(e.Name)})
+		}
+		logger.Logger.Info().
+			Str("archive", filePath).
+			Str("compression", compression).
+			Str("inner_pattern", cfg.innerPattern).
+			Int("entries", len(units)).
+			Msg("Archive downloaded")
+		return units, compression, func() { archive.Close() }, nil
+	default:
+		return []fileUnit{{name: fileName, open: open}}, filesync.CompressionNone, cleanup, nil
+	}
+}
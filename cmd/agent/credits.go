@@ -0,0 +1,161 @@
+package main
+
+import (
+	"dsp-platform/internal/logger"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// creditStallTimeout bounds how long extraction waits for credits. A master that restarted
+// or lost the run never grants them again, so the batch is sent anyway (without a credit)
+// after it and the window starts over full
+const creditStallTimeout = 10 * time.Minute
+
+// batchCredits is the flow control window of a job run and table: a batch is sent to the
+// master only against a credit, the master grants it back once the batch is written
+type batchCredits struct {
+	key    string
+	jobID  uint
+	tokens chan struct{}
+}
+
+var (
+	runCredits   = make(map[string]*batchCredits)
+	runCreditsMu sync.Mutex
+)
+
+// creditKey identifies the window of a job run and table
+func creditKey(jobID, logID uint, table string) string {
+	return fmt.Sprintf("%d:%d:%s", jobID, logID, table)
+}
+
+// openCredits starts the flow control window of a run, nil when the master didn't send one
+// (older masters): batches are then sent without waiting
+func openCredits(msg AgentMessage, jobID, logID uint, table string) *batchCredits {
+	window, ok := msg.Data["credit_window"].(float64)
+	if !ok || window < 1 {
+		return nil
+	}
+	c := &batchCredits{
+		key:    creditKey(jobID, logID, table),
+		jobID:  jobID,
+		tokens: make(chan struct{}, int(window)),
+	}
+	for i := 0; i < int(window); i++ {
+		c.tokens <- struct{}{}
+	}
+
+	runCreditsMu.Lock()
+	runCredits[c.key] = c
+	runCreditsMu.Unlock()
+	return c
+}
+
+// close stops accepting grants for the run
+func (c *batchCredits) close() {
+	runCreditsMu.Lock()
+	defer runCreditsMu.Unlock()
+	if runCredits[c.key] == c {
+		delete(runCredits, c.key)
+	}
+}
+
+// acquire takes a credit, pausing extraction until the master grants one. Returns how long
+// the batch waited and whether it holds a credit, or an error when the job is aborted while
+// waiting
+func (c *batchCredits) acquire() (time.Duration, bool, error) {
+	start := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.tokens:
+			waited := time.Since(start)
+			if waited >= time.Second {
+				logger.Logger.Info().
+					Uint("job_id", c.jobID).
+					Dur("waited", waited).
+					Msg("Resumed extraction after waiting for Master credits")
+			}
+			return waited, true, nil
+		case <-ticker.C:
+			if isJobAborted(c.jobID) {
+				return 0, false, fmt.Errorf("Aborted by user")
+			}
+			if waited := time.Since(start); waited > creditStallTimeout {
+				logger.Logger.Warn().
+					Uint("job_id", c.jobID).
+					Dur("waited", waited).
+					Msg("No credits granted by Master, sending batch anyway")
+				c.reset()
+				return waited, false, nil
+			}
+		}
+	}
+}
+
+// reset refills the window after a stall: the credits of the batches sent before it are not
+// coming back, late grants for them find the window full and are dropped
+func (c *batchCredits) reset() {
+	for {
+		select {
+		case c.tokens <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+// grantCredits applies a CREDIT message from the master
+func grantCredits(msg AgentMessage) {
+	jobID, logID, table := uint(0), uint(0), ""
+	if id, ok := msg.Data["job_id"].(float64); ok {
+		jobID = uint(id)
+	}
+	if id, ok := msg.Data["log_id"].(float64); ok {
+		logID = uint(id)
+	}
+	if t, ok := msg.Data["target_table"].(string); ok {
+		table = t
+	}
+	n := 1
+	if v, ok := msg.Data["credits"].(float64); ok {
+		n = int(v)
+	}
+
+	runCreditsMu.Lock()
+	c := runCredits[creditKey(jobID, logID, table)]
+	runCreditsMu.Unlock()
+	if c == nil {
+		return // Run already finished
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case c.tokens <- struct{}{}:
+		default:
+			return // Window already full (credits granted after a stall timeout)
+		}
+	}
+}
+
+// sendCreditedBatch sends a partial batch of records against a credit, pausing until the
+// master grants one. Without flow control (nil credits) the batch is sent right away
+func sendCreditedBatch(conn net.Conn, credits *batchCredits, jobID, logID uint, records []map[string]interface{}) error {
+	var wait time.Duration
+	credited := credits
+	if credits != nil {
+		w, ok, err := credits.acquire()
+		if err != nil {
+			return err
+		}
+		wait = w
+		if !ok {
+			credited = nil // Sent after a stall, the Master doesn't grant it back
+		}
+	}
+	sendDataResponseExtended(conn, jobID, logID, records, len(records), "", true, credited, wait)
+	return nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"dsp-platform/internal/database"
+	"dsp-platform/internal/logger"
+	"dsp-platform/internal/mapping"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Abort tracking: jobs the master aborted stop extracting (and writing, in direct mode)
+var (
+	abortedJobs = make(map[uint]bool)
+	abortedMu   sync.RWMutex
+)
+
+// markJobAborted records an ABORT_JOB from the master
+func markJobAborted(jobID uint) {
+	abortedMu.Lock()
+	defer abortedMu.Unlock()
+	abortedJobs[jobID] = true
+}
+
+// isJobAborted checks if the master aborted a job
+func isJobAborted(jobID uint) bool {
+	abortedMu.RLock()
+	defer abortedMu.RUnlock()
+	return abortedJobs[jobID]
+}
+
+// clearJobAborted forgets an abort when the job runs again
+func clearJobAborted(jobID uint) {
+	abortedMu.Lock()
+	defer abortedMu.Unlock()
+	delete(abortedJobs, jobID)
+}
+
+// directWriter writes the batches of a table straight into the target database when the
+// master enabled agent direct write for the network, with the same database.TargetConnection
+// code as the master. Only counts, rejected rows and job log events travel back
+type directWriter struct {
+	jobID         uint
+	logID         uint
+	table         string
+	conn          *database.TargetConnection
+	keyColumns    []string
+	writeMode     string
+	schemaPolicy  string
+	mapping       *mapping.Mapping
+	dedupe        database.Dedupe
+	skipSequences bool
+	uploadPost    string
+	maxRejected   int
+
+	specs    map[string]database.ColumnSpec // Columns already reconciled with the target table
+	ignored  map[string]bool                // Incompatible columns left out of the writes
+	keyReady bool                           // Unique key index checked
+	rejected int
+	events   []string // Job log events not yet reported to the master
+}
+
+// newDirectWriter connects to the target of a direct_write command section and runs the
+// pre-job queries (truncate, upload pre query) the master would otherwise run
+func newDirectWriter(cfg map[string]interface{}, jobID, logID uint) (*directWriter, error) {
+	w := &directWriter{
+		jobID:   jobID,
+		logID:   logID,
+		specs:   make(map[string]database.ColumnSpec),
+		ignored: make(map[string]bool),
+	}
+	if t, ok := cfg["target_table"].(string); ok {
+		w.table = t
+	}
+	if keys, ok := cfg["key_columns"].([]interface{}); ok {
+		for _, k := range keys {
+			if s, ok := k.(string); ok && s != "" {
+				w.keyColumns = append(w.keyColumns, s)
+			}
+		}
+	}
+	if m, ok := cfg["write_mode"].(string); ok {
+		w.writeMode = m
+	}
+	if p, ok := cfg["schema_policy"].(string); ok {
+		w.schemaPolicy = p
+	}
+	if s, ok := cfg["dedupe_strategy"].(string); ok {
+		w.dedupe.Strategy = s
+	}
+	if c, ok := cfg["dedupe_order_column"].(string); ok {
+		w.dedupe.OrderColumn = c
+	}
+	if s, ok := cfg["skip_sequences"].(bool); ok {
+		w.skipSequences = s
+	}
+	if q, ok := cfg["upload_post"].(string); ok {
+		w.uploadPost = q
+	}
+	if n, ok := cfg["max_rejected"].(float64); ok {
+		w.maxRejected = int(n)
+	}
+	if w.table == "" {
+		return nil, fmt.Errorf("direct write: no target table")
+	}
+
+	if def, ok := cfg["mapping"].(string); ok {
+		m, err := mapping.Parse(def)
+		if err != nil {
+			return nil, fmt.Errorf("column mapping: %w", err)
+		}
+		w.mapping = m
+	}
+
+	targetCfg := database.TargetConfig{Driver: "postgres", Port: "5432", SSLMode: "disable"}
+	if tc, ok := cfg["target_db_config"].(map[string]interface{}); ok {
+		if driver, ok := tc["driver"].(string); ok && driver != "" {
+			targetCfg.Driver = driver
+		}
+		if host, ok := tc["host"].(string); ok {
+			targetCfg.Host = host
+		}
+		if port, ok := tc["port"].(string); ok && port != "" {
+			targetCfg.Port = port
+		}
+		if user, ok := tc["user"].(string); ok {
+			targetCfg.User = user
+		}
+		if password, ok := tc["password"].(string); ok {
+			targetCfg.Password = password
+		}
+		if dbName, ok := tc["db_name"].(string); ok {
+			targetCfg.DBName = dbName
+		}
+		if sslMode, ok := tc["sslmode"].(string); ok && sslMode != "" {
+			targetCfg.SSLMode = sslMode
+		}
+	}
+	conn, err := database.ConnectTarget(targetCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to target database: %w", err)
+	}
+	w.conn = conn
+
+	logger.Logger.Info().
+		Str("table", w.table).
+		Str("host", targetCfg.Host).
+		Str("db_name", targetCfg.DBName).
+		Msg("Writing to target database directly")
+
+	if truncate, ok := cfg["truncate"].(bool); ok && truncate {
+		if _, err := conn.DB.Exec(fmt.Sprintf("TRUNCATE TABLE %s", w.table)); err != nil {
+			logger.Logger.Error().Err(err).Str("table", w.table).Msg("Failed to truncate target table")
+			w.event("Failed to truncate table %s: %v", w.table, err)
+		}
+	}
+	if q, ok := cfg["upload_pre"].(string); ok && q != "" {
+		if _, err := conn.DB.Exec(q); err != nil {
+			logger.Logger.Error().Err(err).Str("table", w.table).Msg("Failed to execute Upload Pre Query")
+			w.event("Failed to execute UploadPreQuery for table %s: %v", w.table, err)
+		}
+	}
+	return w, nil
+}
+
+// event queues a job log line for the next message to the master
+func (w *directWriter) event(format string, args ...interface{}) {
+	w.events = append(w.events, fmt.Sprintf(format, args...))
+}
+
+// takeEvents returns and clears the queued job log lines
+func (w *directWriter) takeEvents() []string {
+	events := w.events
+	w.events = nil
+	return events
+}
+
+// ensureSchema reconciles the target table with the batch columns, skipping columns
+// already reconciled during the run (like the master's schema sync cache)
+func (w *directWriter) ensureSchema(specs []database.ColumnSpec) error {
+	covered := true
+	for _, spec := range specs {
+		prev, ok := w.specs[spec.Name]
+		if !ok || prev.Type != spec.Type || spec.Length > prev.Length {
+			covered = false
+			break
+		}
+	}
+	if covered && len(w.specs) > 0 {
+		return nil
+	}
+
+	changes, err := w.conn.SyncTableSchema(w.table, specs, w.schemaPolicy)
+	if changes != nil {
+		for _, stmt := range changes.Statements {
+			w.event("DDL on %s: %s", w.table, stmt)
+		}
+		for _, col := range changes.Ignored {
+			w.event("Column %s.%s ignored: incompatible with target column type", w.table, col)
+			w.ignored[col] = true
+		}
+	}
+	if err != nil {
+		w.event("Schema sync failed for %s: %v", w.table, err)
+		return err
+	}
+
+	// Upserts need a unique index on the key (SCD2 keeps several versions per key)
+	if !w.keyReady && len(w.keyColumns) > 0 && w.writeMode != database.WriteModeSCD2 {
+		stmt, err := w.conn.EnsureUniqueKey(w.table, w.keyColumns)
+		if stmt != "" {
+			w.event("DDL on %s: %s", w.table, stmt)
+		}
+		if err != nil {
+			w.event("Unique key check failed for %s: %v", w.table, err)
+			return err
+		}
+	}
+	w.keyReady = true
+
+	for _, spec := range specs {
+		w.specs[spec.Name] = spec
+	}
+	return nil
+}
+
+// writeBatch maps, reconciles, dedupes and writes a CSV batch the way the master does
+func (w *directWriter) writeBatch(csvData string, columns []string, columnTypes []database.ColumnSpec) (int, []database.RejectedRow, error) {
+	specs := database.TextColumnSpecs(columns)
+	if len(columnTypes) == len(columns) {
+		specs = columnTypes
+	}
+
+	var err error
+	if w.mapping != nil {
+		ctx := mapping.Context{
+			Now:   time.Now(),
+			Agent: AgentName,
+			JobID: w.jobID,
+			RunID: w.logID,
+			Table: w.table,
+		}
+		if csvData, columns, err = w.mapping.ApplyCSV(csvData, columns, ctx); err != nil {
+			return 0, nil, fmt.Errorf("column mapping: %w", err)
+		}
+		specs = w.mapping.OutputSpecs(specs)
+	}
+	if w.writeMode == database.WriteModeSCD2 {
+		specs = append(append([]database.ColumnSpec{}, specs...), database.SCD2Specs()...)
+	}
+	if err := w.ensureSchema(specs); err != nil {
+		return 0, nil, err
+	}
+	if len(w.ignored) > 0 {
+		if csvData, columns, err = database.DropCsvColumns(csvData, columns, w.ignored); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// Collapse duplicate keys before writing
+	if len(w.keyColumns) > 0 {
+		var dropped int
+		if csvData, dropped, err = database.DedupeCsv(csvData, columns, w.keyColumns, w.dedupe); err != nil {
+			return 0, nil, err
+		}
+		if dropped > 0 {
+			w.event("Batch for %s: collapsed %d duplicate rows on (%s), %s",
+				w.table, dropped, strings.Join(w.keyColumns, ", "), w.dedupe)
+		}
+	}
+
+	var count int
+	var rejected []database.RejectedRow
+	binary := w.conn.BinaryColumns(specs)
+	if w.writeMode == database.WriteModeSCD2 || len(binary) > 0 {
+		// Versions are compared row by row, and binary values need decoding for the target dialect
+		var records []map[string]interface{}
+		if records, err = database.ParseCsvRecords(csvData, columns); err == nil {
+			err = database.DecodeBinaryColumns(records, binary)
+		}
+		if err == nil {
+			switch {
+			case w.writeMode == database.WriteModeSCD2:
+				count, err = w.conn.SCD2Batch(w.table, records, w.keyColumns)
+			case len(w.keyColumns) > 0:
+				count, rejected, err = w.conn.UpsertBatch(w.table, records, w.keyColumns)
+			default:
+				count, rejected, err = w.conn.InsertBatch(w.table, records)
+			}
+		}
+	} else if len(w.keyColumns) > 0 {
+		count, rejected, err = w.conn.UpsertCsvBatchParallel(w.table, csvData, columns, w.keyColumns)
+	} else {
+		count, err = w.conn.InsertCsvBatch(w.table, csvData, columns)
+		if err != nil {
+			// COPY is all-or-nothing: retry through the record path to isolate the refused rows
+			logger.Logger.Warn().Err(err).Str("table", w.table).Msg("CSV COPY failed, retrying row by row")
+			var records []map[string]interface{}
+			if records, err = database.ParseCsvRecords(csvData, columns); err == nil {
+				count, rejected, err = w.conn.InsertBatch(w.table, records)
+			}
+		}
+	}
+	w.rejected += len(rejected)
+	return count, rejected, err
+}
+
+// finish runs the end-of-run work of a successful run: sequence resync and upload post query.
+// Returns the rejected-row threshold breach, which fails the run like on the master
+func (w *directWriter) finish() error {
+	if w.maxRejected > 0 && w.rejected > w.maxRejected {
+		return fmt.Errorf("%d rows rejected by the target (limit %d), see quarantine", w.rejected, w.maxRejected)
+	}
+	if isJobAborted(w.jobID) {
+		return fmt.Errorf("Aborted by user")
+	}
+
+	if !w.skipSequences {
+		resets, err := w.conn.ResyncSequences(w.table)
+		for _, reset := range resets {
+			w.event("Sequence reset on %s: %s", w.table, reset)
+		}
+		if err != nil && len(resets) == 0 {
+			w.event("Sequence reset on %s failed: %v", w.table, err)
+		}
+	}
+	if w.uploadPost != "" {
+		if _, err := w.conn.DB.Exec(w.uploadPost); err != nil {
+			logger.Logger.Error().Err(err).Str("table", w.table).Msg("Failed to execute Upload Post Query")
+			w.event("Failed to execute UploadPostQuery for table %s: %v", w.table, err)
+		}
+	}
+	return nil
+}
+
+// Close releases the target connection
+func (w *directWriter) Close() {
+	w.conn.Close()
+}
+
+// sendDirectResponse reports a batch the agent wrote itself: counts, rejected rows and job log
+// events instead of the rows
+func sendDirectResponse(conn net.Conn, jobID, logID uint, targetTable string, recordCount, written int, rejected []database.RejectedRow, events []string, errorMsg string, isPartial bool) {
+	status := "completed"
+	if errorMsg != "" {
+		status = "failed"
+	} else if isPartial {
+		status = "running"
+	}
+
+	response := AgentMessage{
+		Type:      "DATA_RESPONSE",
+		AgentName: AgentName,
+		Status:    status,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"job_id":        jobID,
+			"log_id":        logID,
+			"status":        status,
+			"record_count":  recordCount,
+			"written_count": written,
+			"error":         errorMsg,
+			"partial":       isPartial,
+			"target_table":  targetTable,
+			"direct":        true,
+		},
+	}
+	if len(rejected) > 0 {
+		response.Data["rejected_rows"] = rejected
+	}
+	if len(events) > 0 {
+		response.Data["events"] = events
+	}
+
+	if err := sendMessage(conn, response); err != nil {
+		logger.Logger.Error().Err(err).Msg("Failed to send direct write response")
+	} else {
+		logger.Logger.Info().
+			Uint("job_id", jobID).
+			Int("records", recordCount).
+			Int("written", written).
+			Str("status", status).
+			Bool("partial", isPartial).
+			Msg("Direct write progress sent to Master")
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"dsp-platform/internal/filesync"
+	"dsp-platform/internal/logger"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"time"
+)
+
+// fileConnector connects to the server or bucket files are read from
+type fileConnector func() (filesync.FileSource, error)
+
+// ftpSourceConnector reads the ftp_config of a command. Returns the connector to the FTP or
+// SFTP server and the folder files are read from
+func ftpSourceConnector(msg AgentMessage, sourceType string) (fileConnector, string) {
+	ftpConfig := filesync.FTPConfig{}
+	privateKey := ""
+
+	// Debug: Log raw ftp_config
+	logger.Logger.Debug().Interface("ftp_config_raw", msg.Data["ftp_config"]).Msg("Received ftp_config")
+
+	if cfg, ok := msg.Data["ftp_config"].(map[string]interface{}); ok {
+		if host, ok := cfg["host"].(string); ok {
+			ftpConfig.Host = host
+		}
+		// Port can be string or float64 (from JSON)
+		if port, ok := cfg["port"].(string); ok {
+			ftpConfig.Port = port
+		} else if portNum, ok := cfg["port"].(float64); ok {
+			ftpConfig.Port = fmt.Sprintf("%.0f", portNum)
+		}
+		if user, ok := cfg["user"].(string); ok {
+			ftpConfig.User = user
+		}
+		if password, ok := cfg["password"].(string); ok {
+			ftpConfig.Password = password
+		}
+		if key, ok := cfg["private_key"].(string); ok {
+			privateKey = key
+		}
+		if path, ok := cfg["path"].(string); ok {
+			ftpConfig.Path = path
+		}
+		if passive, ok := cfg["passive"].(bool); ok {
+			ftpConfig.Passive = passive
+		}
+
+		// Debug log extracted values
+		logger.Logger.Info().
+			Str("host", ftpConfig.Host).
+			Str("port", ftpConfig.Port).
+			Str("user", ftpConfig.User).
+			Bool("has_password", ftpConfig.Password != "").
+			Int("password_len", len(ftpConfig.Password)).
+			Bool("has_private_key", privateKey != "").
+			Int("private_key_len", len(privateKey)).
+			Msg("Extracted FTP config values")
+	} else {
+		logger.Logger.Error().Msg("ftp_config is not a valid map or is missing!")
+	}
+
+	if sourceType == "sftp" {
+		sftpConfig := filesync.SFTPConfig{
+			Host:       ftpConfig.Host,
+			Port:       ftpConfig.Port,
+			User:       ftpConfig.User,
+			Password:   ftpConfig.Password,
+			PrivateKey: privateKey,
+			Path:       ftpConfig.Path,
+		}
+		if sftpConfig.Port == "" || sftpConfig.Port == "21" {
+			sftpConfig.Port = "22" // Default SFTP port
+		}
+		return func() (filesync.FileSource, error) {
+			client, err := filesync.NewSFTPClient(sftpConfig)
+			if err != nil {
+				return nil, err
+			}
+			return client, nil
+		}, ftpConfig.Path
+	}
+
+	// FTP
+	if ftpConfig.Port == "" {
+		ftpConfig.Port = "21"
+	}
+	return func() (filesync.FileSource, error) {
+		client, err := filesync.NewFTPClient(ftpConfig)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}, ftpConfig.Path
+}
+
+// parseMinIOConfig reads the minio_config of a command
+func parseMinIOConfig(msg AgentMessage) filesync.MinIOConfig {
+	minioConfig := filesync.MinIOConfig{}
+	if cfg, ok := msg.Data["minio_config"].(map[string]interface{}); ok {
+		if endpoint, ok := cfg["endpoint"].(string); ok {
+			minioConfig.Endpoint = endpoint
+		}
+		if accessKey, ok := cfg["access_key"].(string); ok {
+			minioConfig.AccessKeyID = accessKey
+		}
+		if secretKey, ok := cfg["secret_key"].(string); ok {
+			minioConfig.SecretAccessKey = secretKey
+		}
+		if bucket, ok := cfg["bucket"].(string); ok {
+			minioConfig.BucketName = bucket
+		}
+		if objectPath, ok := cfg["object_path"].(string); ok {
+			minioConfig.ObjectPath = objectPath
+		}
+		if useSSL, ok := cfg["use_ssl"].(bool); ok {
+			minioConfig.UseSSL = useSSL
+		}
+		if region, ok := cfg["region"].(string); ok {
+			minioConfig.Region = region
+		}
+	}
+	return minioConfig
+}
+
+// splitObjectPath splits a MinIO object path into the prefix and the file name pattern
+func splitObjectPath(objectPath string) (string, string) {
+	return path.Split(objectPath)
+}
+
+// minioConnector connects to the bucket of a MinIO config as a file source
+func minioConnector(cfg filesync.MinIOConfig) fileConnector {
+	return func() (filesync.FileSource, error) {
+		client, err := filesync.NewMinIOClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return client.Files(), nil
+	}
+}
+
+// resumableOpen opens a file of the source at a byte offset. A resumed download reconnects
+// once when the connection dropped along with the previous one
+func resumableOpen(src *filesync.FileSource, connect fileConnector, filePath string) filesync.OpenFunc {
+	return func(offset int64) (io.ReadCloser, error) {
+		body, err := (*src).OpenFile(filePath, offset)
+		if err != nil && offset > 0 {
+			(*src).Close()
+			reconnected, cerr := connect()
+			if cerr != nil {
+				return nil, cerr
+			}
+			*src = reconnected
+			return (*src).OpenFile(filePath, offset)
+		}
+		return body, err
+	}
+}
+
+// multiFileConfig is the multi-file section of a file_config
+type multiFileConfig struct {
+	order        string
+	postAction   string
+	archivePath  string
+	renameSuffix string
+	nameColumn   string
+	processed    map[string]bool // path|size|mtime of files the job already loaded
+}
+
+// processedKey identifies a version of a file in the manifest
+func processedKey(filePath string, size, mtime int64) string {
+	return fmt.Sprintf("%s|%d|%d", filePath, size, mtime)
+}
+
+// parseMultiFileConfig reads the multi-file settings, nil for single-file jobs
+func parseMultiFileConfig(msg AgentMessage) *multiFileConfig {
+	cfg, ok := msg.Data["file_config"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if mode, _ := cfg["mode"].(string); mode != "multi" {
+		return nil
+	}
+	m := &multiFileConfig{processed: make(map[string]bool)}
+	m.order, _ = cfg["order"].(string)
+	m.postAction, _ = cfg["post_action"].(string)
+	m.archivePath, _ = cfg["archive_path"].(string)
+	m.renameSuffix, _ = cfg["rename_suffix"].(string)
+	m.nameColumn, _ = cfg["file_name_column"].(string)
+	if entries, ok := cfg["processed"].([]interface{}); ok {
+		for _, e := range entries {
+			entry, ok := e.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			p, _ := entry["path"].(string)
+			size, _ := entry["size"].(float64)
+			mtime, _ := entry["mtime"].(float64)
+			m.processed[processedKey(p, int64(size), int64(mtime))] = true
+		}
+	}
+	return m
+}
+
+// executeMultiFileJob loads every file of the folder matching the pattern that isn't in the
+// job's manifest, in name or mtime order. Each file (or archive entry) is streamed with its
+// name in a column and reported to the Master once sent, which records it and runs the
+// post-load action at the end
+func executeMultiFileJob(conn net.Conn, jobID, logID uint, jobName string, src *filesync.FileSource, connect fileConnector, dir, pattern string, opts filesync.StreamOptions, cfg multiFileConfig, compression compressionConfig, credits *batchCredits) {
+	files, err := (*src).ListFileInfos(dir, pattern)
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("Failed to list files")
+		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
+		return
+	}
+	filesync.SortFiles(files, cfg.order)
+	archiveDir := filesync.ResolveArchiveDir(dir, cfg.archivePath)
+
+	var pending []filesync.FileInfo
+	for _, f := range files {
+		if cfg.processed[processedKey(f.Path, f.Size, f.ModTime.Unix())] ||
+			filesync.IsPostActionOutput(f.Path, cfg.postAction, archiveDir, cfg.renameSuffix) {
+			continue
+		}
+		pending = append(pending, f)
+	}
+	logger.Logger.Info().
+		Str("job", jobName).
+		Int("matching", len(files)).
+		Int("pending", len(pending)).
+		Msg("Starting multi-file load")
+
+	totalRecords := 0
+	for _, f := range pending {
+		open, checksum := filesync.ChecksumOpen(resumableOpen(src, connect, f.Path), f.Size)
+		units, _, cleanup, err := expandFile(f.Path, opts.Format, open, compression)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("file", f.Path).Msg("Failed to open file")
+			sendDataResponse(conn, jobID, logID, nil, 0, fmt.Sprintf("%s: %v", f.Path, err), false)
+			return
+		}
+		for _, unit := range units {
+			records, err := streamFileUnit(conn, jobID, logID, jobName, unit, opts, cfg.nameColumn, credits)
+			if err != nil {
+				cleanup()
+				sendDataResponse(conn, jobID, logID, nil, 0, fmt.Sprintf("%s: %v", unit.name, err), false)
+				return
+			}
+			totalRecords += records
+			reportFileLoaded(conn, jobID, logID, f, unit.entry, checksum, records)
+		}
+		if len(units) == 0 {
+			// Archive without matching entries, recorded so it isn't downloaded again
+			logger.Logger.Warn().Str("file", f.Path).Msg("No archive entry matches the inner pattern")
+			reportFileLoaded(conn, jobID, logID, f, "", checksum, 0)
+		}
+		cleanup()
+	}
+
+	logger.Logger.Info().
+		Str("job", jobName).
+		Int("files", len(pending)).
+		Int("total_records", totalRecords).
+		Msg("Multi-file load completed")
+	sendDataResponse(conn, jobID, logID, nil, 0, "", false)
+}
+
+// reportFileLoaded tells the Master a file (or archive entry) was sent, for the job's manifest.
+// The checksum is of the source file, complete once an archive is downloaded
+func reportFileLoaded(conn net.Conn, jobID, logID uint, f filesync.FileInfo, entry string, checksum func() (string, error), records int) {
+	sum, err := checksum()
+	if err != nil {
+		logger.Logger.Warn().Err(err).Str("file", f.Path).Msg("Failed to finish file checksum")
+	}
+	loaded := AgentMessage{
+		Type:      "FILE_LOADED",
+		AgentName: AgentName,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"job_id":       jobID,
+			"log_id":       logID,
+			"path":         f.Path,
+			"entry":        entry,
+			"size":         f.Size,
+			"mtime":        f.ModTime.Unix(),
+			"checksum":     sum,
+			"record_count": records,
+		},
+	}
+	if err := sendMessage(conn, loaded); err != nil {
+		logger.Logger.Error().Err(err).Str("file", f.Path).Msg("Failed to report loaded file")
+	}
+}
+
+// executeFilePostActions archives, renames or deletes the files of a finished multi-file run
+// and reports where they went
+func executeFilePostActions(conn net.Conn, msg AgentMessage) {
+	jobID, logID := uint(0), uint(0)
+	if id, ok := msg.Data["job_id"].(float64); ok {
+		jobID = uint(id)
+	}
+	if id, ok := msg.Data["log_id"].(float64); ok {
+		logID = uint(id)
+	}
+	sourceType, _ := msg.Data["source_type"].(string)
+	action, _ := msg.Data["post_action"].(string)
+	archivePath, _ := msg.Data["archive_path"].(string)
+	suffix, _ := msg.Data["rename_suffix"].(string)
+
+	var connect fileConnector
+	dir := ""
+	if sourceType == "minio" {
+		minioConfig := parseMinIOConfig(msg)
+		connect = minioConnector(minioConfig)
+		dir, _ = splitObjectPath(minioConfig.ObjectPath)
+	} else {
+		connect, dir = ftpSourceConnector(msg, sourceType)
+	}
+	archiveDir := filesync.ResolveArchiveDir(dir, archivePath)
+
+	var results []interface{}
+	src, err := connect()
+	if err != nil {
+		logger.Logger.Error().Err(err).Uint("job_id", jobID).Msg("Failed to connect for post-load actions")
+	} else {
+		defer src.Close()
+	}
+	files, _ := msg.Data["files"].([]interface{})
+	for _, f := range files {
+		filePath, _ := f.(string)
+		result := map[string]interface{}{"path": filePath}
+		if err != nil {
+			result["error"] = err.Error()
+		} else if movedTo, aerr := filesync.ApplyPostAction(src, filePath, action, archiveDir, suffix); aerr != nil {
+			result["error"] = aerr.Error()
+			logger.Logger.Warn().Err(aerr).Str("file", filePath).Str("action", action).Msg("Post-load action failed")
+		} else {
+			result["moved_to"] = movedTo
+		}
+		results = append(results, result)
+	}
+
+	response := AgentMessage{
+		Type:      "FILE_POST_ACTIONS_RESULT",
+		AgentName: AgentName,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"job_id":  jobID,
+			"log_id":  logID,
+			"results": results,
+		},
+	}
+	if err := sendMessage(conn, response); err != nil {
+		logger.Logger.Error().Err(err).Msg("Failed to send post-load action results")
+	}
+}
@@ -14,10 +14,12 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -507,6 +509,12 @@ func listenForResponses(conn net.Conn) {
 			continue
 		}
 
+		// Credit grants arrive once per written batch, too often to log
+		if msg.Type == "CREDIT" {
+			grantCredits(msg)
+			continue
+		}
+
 		logger.Logger.Info().
 			Str("type", msg.Type).
 			Interface("data", msg.Data).
@@ -527,6 +535,17 @@ func listenForResponses(conn net.Conn) {
 			// Handle immediate job execution command from master
 			go executeRunJobCommand(conn, msg)
 
+		case "FILE_POST_ACTIONS":
+			// Archive, rename or delete the files of a multi-file run the Master finished loading
+			go executeFilePostActions(conn, msg)
+
+		case "ABORT_JOB":
+			// Stop extracting (and writing, in direct mode) an aborted job
+			if id, ok := msg.Data["job_id"].(float64); ok {
+				markJobAborted(uint(id))
+				logger.Logger.Info().Uint("job_id", uint(id)).Msg("Job aborted by Master")
+			}
+
 		case "RUN_QUERY":
 			// Handle direct SQL query execution from test console
 			go executeRunQuery(conn, msg)
@@ -585,6 +604,9 @@ func executeRunJobCommand(conn net.Conn, msg AgentMessage) {
 		Str("source_type", sourceType).
 		Msg("Processing RUN_JOB command")
 
+	// A new run starts fresh, even if an earlier run of the job was aborted
+	clearJobAborted(jobID)
+
 	// Route based on source type
 	switch sourceType {
 	case "ftp", "sftp":
@@ -682,28 +704,104 @@ func executeDatabaseSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint,
 		}
 	}
 
+	// Agent direct write: write the batches to the target here, only report results to the Master
+	var direct *directWriter
+	if directCfg, ok := msg.Data["direct_write"].(map[string]interface{}); ok {
+		direct, err = newDirectWriter(directCfg, jobID, logID)
+		if err != nil {
+			logger.Logger.Error().Err(err).Msg("Failed to prepare direct write")
+			sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
+			return
+		}
+		defer direct.Close()
+		if targetTable == "" {
+			targetTable = direct.table
+		}
+	}
+
+	// Flow control: hold at most the credit window of batches unwritten on the Master
+	var credits *batchCredits
+	if direct == nil {
+		if credits = openCredits(msg, jobID, logID, targetTable); credits != nil {
+			defer credits.close()
+		}
+	}
+
 	// Batch configuration
 	batchSize := 25000
 	totalRecords := 0
 
 	// Execute the query with batching
 	logger.Logger.Info().Str("job", jobName).Msg("Starting high-performance CSV batch query execution")
-	err = dbConn.ExecuteQueryWithCsvBatch(query, batchSize, func(csvData string, columns []string) error {
+	typesSent := false
+	// Partition segments extract concurrently but hand their batches on one at a time, so
+	// the direct writer, the counts and the first batch's column types stay consistent
+	var sinkMu sync.Mutex
+	sink := func(csvData string, columns []string, columnTypes []database.ColumnSpec) error {
+		sinkMu.Lock()
+		defer sinkMu.Unlock()
+		if isJobAborted(jobID) {
+			return fmt.Errorf("Aborted by user")
+		}
+
 		count := strings.Count(csvData, "\n")
 		totalRecords += count
 
+		if direct != nil {
+			written, rejected, err := direct.writeBatch(csvData, columns, columnTypes)
+			if err != nil {
+				return err
+			}
+			logger.Logger.Info().
+				Str("job", jobName).
+				Int("batch_size", count).
+				Int("written", written).
+				Int("total_so_far", totalRecords).
+				Msg("Wrote batch to target directly")
+			sendDirectResponse(conn, jobID, logID, targetTable, count, written, rejected, direct.takeEvents(), "", true)
+			return nil
+		}
+
+		// Column types only need to travel with the first batch
+		if typesSent {
+			columnTypes = nil
+		}
+		typesSent = true
+
 		logger.Logger.Info().
 			Str("job", jobName).
 			Int("batch_size", count).
 			Int("total_so_far", totalRecords).
 			Msg("Sending partial CSV batch")
 
-		sendCsvDataResponseExtended(conn, jobID, logID, csvData, columns, count, "", true, targetTable)
+		// Pauses the extraction while the Master still has the window's batches to write
+		var creditWait time.Duration
+		credited := credits
+		if credits != nil {
+			wait, ok, err := credits.acquire()
+			if err != nil {
+				return err
+			}
+			creditWait = wait
+			if !ok {
+				credited = nil // Sent after a stall, the Master doesn't grant it back
+			}
+		}
+		sendCsvDataResponseExtended(conn, jobID, logID, csvData, columns, columnTypes, count, "", true, targetTable, credited, creditWait)
 		return nil
-	})
+	}
+	if partition := parsePartitionConfig(msg); partition != nil {
+		err = runPartitionedExtraction(conn, dbConn, dbCfg, query, targetTable, jobID, logID, jobName, *partition, batchSize, sink)
+	} else {
+		err = dbConn.ExecuteQueryWithTypedCsvBatch(query, batchSize, sink)
+	}
 
 	if err != nil {
 		logger.Logger.Error().Err(err).Msg("Failed to execute batch query")
+		if direct != nil {
+			sendDirectResponse(conn, jobID, logID, targetTable, 0, 0, nil, direct.takeEvents(), err.Error(), false)
+			return
+		}
 		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
 		return
 	}
@@ -722,7 +820,15 @@ func executeDatabaseSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint,
 	}
 
 	// Send final completion response
-	sendCsvDataResponseExtended(conn, jobID, logID, "", nil, 0, "", false, targetTable)
+	if direct != nil {
+		errMsg := ""
+		if err := direct.finish(); err != nil {
+			errMsg = err.Error()
+		}
+		sendDirectResponse(conn, jobID, logID, targetTable, 0, 0, nil, direct.takeEvents(), errMsg, false)
+		return
+	}
+	sendCsvDataResponseExtended(conn, jobID, logID, "", nil, nil, 0, "", false, targetTable, nil, 0)
 }
 
 // executeJavaScriptJob handles execution of arbitrary JavaScript schemas (for Data Integration)
@@ -976,6 +1082,10 @@ func executeMongoDBSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, j
 	// Batch configuration
 	batchSize := 5000
 	totalRecords := 0
+	credits := openCredits(msg, jobID, logID, "")
+	if credits != nil {
+		defer credits.close()
+	}
 
 	// Define callback function for partial batches
 	processBatch := func(batch []map[string]interface{}) error {
@@ -988,8 +1098,7 @@ func executeMongoDBSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, j
 			Int("total_so_far", totalRecords).
 			Msg("Sending MongoDB batch")
 
-		sendDataResponse(conn, jobID, logID, batch, count, "", true)
-		return nil
+		return sendCreditedBatch(conn, credits, jobID, logID, batch)
 	}
 
 	// Execute MongoDB find query with streaming batch processing
@@ -1079,6 +1188,10 @@ func executeRedisSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, job
 	// Batch configuration
 	batchSize := 5000
 	totalRecords := 0
+	credits := openCredits(msg, jobID, logID, "")
+	if credits != nil {
+		defer credits.close()
+	}
 
 	// Define callback function for partial batches
 	processBatch := func(batch []map[string]interface{}) error {
@@ -1091,8 +1204,7 @@ func executeRedisSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, job
 			Int("total_so_far", totalRecords).
 			Msg("Sending Redis batch")
 
-		sendDataResponse(conn, jobID, logID, batch, count, "", true)
-		return nil
+		return sendCreditedBatch(conn, credits, jobID, logID, batch)
 	}
 
 	// Scan keys with streaming batch processing
@@ -1122,30 +1234,7 @@ func executeMinIOSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, job
 		Msg("Starting MinIO sync job")
 
 	// Extract MinIO config from message
-	minioConfig := filesync.MinIOConfig{}
-	if cfg, ok := msg.Data["minio_config"].(map[string]interface{}); ok {
-		if endpoint, ok := cfg["endpoint"].(string); ok {
-			minioConfig.Endpoint = endpoint
-		}
-		if accessKey, ok := cfg["access_key"].(string); ok {
-			minioConfig.AccessKeyID = accessKey
-		}
-		if secretKey, ok := cfg["secret_key"].(string); ok {
-			minioConfig.SecretAccessKey = secretKey
-		}
-		if bucket, ok := cfg["bucket"].(string); ok {
-			minioConfig.BucketName = bucket
-		}
-		if objectPath, ok := cfg["object_path"].(string); ok {
-			minioConfig.ObjectPath = objectPath
-		}
-		if useSSL, ok := cfg["use_ssl"].(bool); ok {
-			minioConfig.UseSSL = useSSL
-		}
-		if region, ok := cfg["region"].(string); ok {
-			minioConfig.Region = region
-		}
-	}
+	minioConfig := parseMinIOConfig(msg)
 
 	// Get file config for parsing
 	fileFormat := "csv" // default
@@ -1203,8 +1292,52 @@ func executeMinIOSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, job
 		Str("pattern", filePattern).
 		Msg("Finding and reading object from MinIO")
 
+	compression := parseCompressionConfig(msg)
+	credits := openCredits(msg, jobID, logID, "")
+	if credits != nil {
+		defer credits.close()
+	}
+	opts, err := fileStreamOptions(msg, fileFormat, hasHeader, string(delimiter))
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("Invalid file format settings")
+		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
+		return
+	}
+
+	// Multi-file: every matching object not loaded yet
+	if multi := parseMultiFileConfig(msg); multi != nil {
+		prefix, pattern := splitObjectPath(filePattern)
+		src := client.Files()
+		executeMultiFileJob(conn, jobID, logID, jobName, &src, minioConnector(minioConfig), prefix, pattern, opts, *multi, compression, credits)
+		return
+	}
+
+	objectKey, err := client.FindObject("", filePattern)
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("Failed to find object in MinIO")
+		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
+		return
+	}
+	objectName := path.Base(objectKey)
+	open := func(offset int64) (io.ReadCloser, error) {
+		return client.OpenObject(objectKey, offset)
+	}
+
+	// Compressed objects are decompressed, archives split into their entries
+	units, found, cleanup, err := expandFile(objectKey, fileFormat, open, compression)
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("object", objectKey).Msg("Failed to open object")
+		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
+		return
+	}
+	defer cleanup()
+	if filesync.IsStreamFormat(fileFormat) || found != filesync.CompressionNone {
+		streamFileJob(conn, jobID, logID, jobName, units, opts, compression.nameColumn, credits)
+		return
+	}
+
 	// Read the object
-	data, objectName, err := client.FindAndReadObject("", filePattern)
+	data, err := client.ReadObject(objectKey)
 	if err != nil {
 		logger.Logger.Error().Err(err).Msg("Failed to read object from MinIO")
 		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
@@ -1252,23 +1385,19 @@ func executeMinIOSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, job
 		Int("total_records", len(records)).
 		Msg("MinIO object parsed successfully")
 
-	// Send records in batches
+	// Send records in batches, then the completion
 	batchSize := 5000
 	for i := 0; i < len(records); i += batchSize {
 		end := i + batchSize
 		if end > len(records) {
 			end = len(records)
 		}
-		batch := records[i:end]
-		isPartial := end < len(records)
-
-		sendDataResponse(conn, jobID, logID, batch, len(batch), "", isPartial)
-	}
-
-	// Send final completion if needed
-	if len(records) == 0 || len(records)%batchSize == 0 {
-		sendDataResponse(conn, jobID, logID, nil, 0, "", false)
+		if err := sendCreditedBatch(conn, credits, jobID, logID, records[i:end]); err != nil {
+			sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
+			return
+		}
 	}
+	sendDataResponse(conn, jobID, logID, nil, 0, "", false)
 }
 
 // executeMinIOMirrorJob handles MinIO to MinIO object-level sync (like mc mirror)
@@ -1532,6 +1661,8 @@ func executeAPISyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, jobNa
 			}
 		}
 	}
+	apiConfig.OAuth2 = parseAPIOAuth2(msg)
+	pagination := parseAPIPagination(msg)
 
 	// Validate URL
 	if apiConfig.URL == "" {
@@ -1546,103 +1677,55 @@ func executeAPISyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, jobNa
 		Str("method", apiConfig.Method).
 		Str("auth_type", apiConfig.AuthType).
 		Bool("has_body", apiConfig.Body != "").
+		Str("pagination", pagination.Strategy).
+		Str("record_path", pagination.RecordPath).
 		Msg("Fetching data from API")
 
-	// Create API client and fetch data
+	// Each page is sent as a batch as soon as it is fetched
+	credits := openCredits(msg, jobID, logID, "")
+	if credits != nil {
+		defer credits.close()
+	}
 	client := filesync.NewAPIClient()
-	data, err := client.FetchAPI(apiConfig)
+	totalRecords := 0
+	pages, truncated, err := client.FetchPages(apiConfig, pagination, func(page filesync.APIPage) error {
+		if isJobAborted(jobID) {
+			return fmt.Errorf("Aborted by user")
+		}
+		logger.Logger.Info().
+			Str("job", jobName).
+			Int("page", page.Number).
+			Int("records", len(page.Records)).
+			Msg("API page received")
+		if len(page.Records) == 0 {
+			return nil
+		}
+		totalRecords += len(page.Records)
+		return sendCreditedBatch(conn, credits, jobID, logID, page.Records)
+	})
 	if err != nil {
 		logger.Logger.Error().Err(err).Msg("Failed to fetch API data")
 		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
 		return
 	}
-
-	logger.Logger.Info().
-		Int("response_size", len(data)).
-		Msg("API response received, parsing JSON")
-
-	// Parse JSON response
-	records, err := filesync.ParseAPIResponse(data)
-	if err != nil {
-		logger.Logger.Error().Err(err).Msg("Failed to parse API response")
-		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
-		return
+	if truncated {
+		logger.Logger.Warn().
+			Str("job", jobName).
+			Int("max_pages", pages).
+			Msg("API pagination stopped at the maximum number of pages")
 	}
 
 	logger.Logger.Info().
 		Str("job", jobName).
-		Int("total_records", len(records)).
-		Msg("API data parsed successfully")
-
-	// Send records in batches
-	batchSize := 5000
-	for i := 0; i < len(records); i += batchSize {
-		end := i + batchSize
-		if end > len(records) {
-			end = len(records)
-		}
-		batch := records[i:end]
-		isPartial := end < len(records)
-
-		sendDataResponse(conn, jobID, logID, batch, len(batch), "", isPartial)
-	}
-
-	// Send final completion if needed
-	if len(records) == 0 {
-		sendDataResponse(conn, jobID, logID, nil, 0, "", false)
-	} else if len(records)%batchSize == 0 {
-		sendDataResponse(conn, jobID, logID, nil, 0, "", false)
-	}
+		Int("pages", pages).
+		Int("total_records", totalRecords).
+		Msg("API data fetched successfully")
+	sendDataResponse(conn, jobID, logID, nil, 0, "", false)
 }
 
 // executeFileSyncJob handles FTP/SFTP file sync jobs
 func executeFileSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, jobName, sourceType string) {
-	// Get FTP config
-	ftpConfig := filesync.FTPConfig{}
-	privateKey := ""
-
-	// Debug: Log raw ftp_config
-	logger.Logger.Debug().Interface("ftp_config_raw", msg.Data["ftp_config"]).Msg("Received ftp_config")
-
-	if cfg, ok := msg.Data["ftp_config"].(map[string]interface{}); ok {
-		if host, ok := cfg["host"].(string); ok {
-			ftpConfig.Host = host
-		}
-		// Port can be string or float64 (from JSON)
-		if port, ok := cfg["port"].(string); ok {
-			ftpConfig.Port = port
-		} else if portNum, ok := cfg["port"].(float64); ok {
-			ftpConfig.Port = fmt.Sprintf("%.0f", portNum)
-		}
-		if user, ok := cfg["user"].(string); ok {
-			ftpConfig.User = user
-		}
-		if password, ok := cfg["password"].(string); ok {
-			ftpConfig.Password = password
-		}
-		if key, ok := cfg["private_key"].(string); ok {
-			privateKey = key
-		}
-		if path, ok := cfg["path"].(string); ok {
-			ftpConfig.Path = path
-		}
-		if passive, ok := cfg["passive"].(bool); ok {
-			ftpConfig.Passive = passive
-		}
-
-		// Debug log extracted values
-		logger.Logger.Info().
-			Str("host", ftpConfig.Host).
-			Str("port", ftpConfig.Port).
-			Str("user", ftpConfig.User).
-			Bool("has_password", ftpConfig.Password != "").
-			Int("password_len", len(ftpConfig.Password)).
-			Bool("has_private_key", privateKey != "").
-			Int("private_key_len", len(privateKey)).
-			Msg("Extracted FTP config values")
-	} else {
-		logger.Logger.Error().Msg("ftp_config is not a valid map or is missing!")
-	}
+	connect, dir := ftpSourceConnector(msg, sourceType)
 
 	// Get file config
 	fileFormat := "csv"
@@ -1666,109 +1749,67 @@ func executeFileSyncJob(conn net.Conn, msg AgentMessage, jobID, logID uint, jobN
 	}
 
 	logger.Logger.Info().
-		Str("host", ftpConfig.Host).
-		Str("path", ftpConfig.Path).
+		Str("path", dir).
 		Str("pattern", filePattern).
 		Str("format", fileFormat).
-		Bool("has_private_key", privateKey != "").
-		Bool("has_password", ftpConfig.Password != "").
+		Str("source_type", sourceType).
 		Msg("Starting file sync job")
 
-	// Read file from FTP/SFTP
-	var fileData []byte
-	var fileName string
-	var err error
-
-	if sourceType == "sftp" {
-		sftpConfig := filesync.SFTPConfig{
-			Host:       ftpConfig.Host,
-			Port:       ftpConfig.Port,
-			User:       ftpConfig.User,
-			Password:   ftpConfig.Password,
-			PrivateKey: privateKey,
-			Path:       ftpConfig.Path,
-		}
-		if sftpConfig.Port == "" || sftpConfig.Port == "21" {
-			sftpConfig.Port = "22" // Default SFTP port
-		}
-
-		client, err := filesync.NewSFTPClient(sftpConfig)
-		if err != nil {
-			logger.Logger.Error().Err(err).Msg("Failed to connect to SFTP server")
-			sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
-			return
-		}
-		defer client.Close()
-
-		fileData, fileName, err = client.FindAndReadFile(ftpConfig.Path, filePattern)
-		if err != nil {
-			logger.Logger.Error().Err(err).Msg("Failed to read file from SFTP")
-			sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
-			return
-		}
-	} else {
-		// FTP
-		if ftpConfig.Port == "" {
-			ftpConfig.Port = "21"
-		}
-
-		client, err := filesync.NewFTPClient(ftpConfig)
-		if err != nil {
-			logger.Logger.Error().Err(err).Msg("Failed to connect to FTP server")
-			sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
-			return
-		}
-		defer client.Close()
-
-		fileData, fileName, err = client.FindAndReadFile(ftpConfig.Path, filePattern)
-		if err != nil {
-			logger.Logger.Error().Err(err).Msg("Failed to read file from FTP")
-			sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
-			return
-		}
+	src, err := connect()
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("source_type", sourceType).Msg("Failed to connect to file server")
+		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
+		return
 	}
+	defer func() { src.Close() }()
 
-	logger.Logger.Info().
-		Str("file", fileName).
-		Int("size_bytes", len(fileData)).
-		Msg("File downloaded, parsing...")
-
-	// Parse file
-	records, err := filesync.ParseFile(fileData, fileFormat, hasHeader, delimiter)
+	compression := parseCompressionConfig(msg)
+	credits := openCredits(msg, jobID, logID, "")
+	if credits != nil {
+		defer credits.close()
+	}
+	opts, err := fileStreamOptions(msg, fileFormat, hasHeader, delimiter)
 	if err != nil {
-		logger.Logger.Error().Err(err).Msg("Failed to parse file")
+		logger.Logger.Error().Err(err).Msg("Invalid file format settings")
 		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
 		return
 	}
 
-	logger.Logger.Info().
-		Str("job", jobName).
-		Int("total_records", len(records)).
-		Msg("File parsed successfully")
-
-	// Send records in batches
-	batchSize := 5000
-	for i := 0; i < len(records); i += batchSize {
-		end := i + batchSize
-		if end > len(records) {
-			end = len(records)
-		}
-		batch := records[i:end]
-		isPartial := end < len(records)
+	// Multi-file: every matching file not loaded yet
+	if multi := parseMultiFileConfig(msg); multi != nil {
+		executeMultiFileJob(conn, jobID, logID, jobName, &src, connect, dir, filePattern, opts, *multi, compression, credits)
+		return
+	}
 
-		sendDataResponse(conn, jobID, logID, batch, len(batch), "", isPartial)
+	// Locate the file, downloads are opened at a byte offset so a broken one resumes
+	filePath, err := src.FindFile(dir, filePattern)
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("source_type", sourceType).Msg("Failed to find file")
+		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
+		return
 	}
+	open := resumableOpen(&src, connect, filePath)
 
-	// Send final completion if we sent data
-	if len(records) > 0 && len(records)%batchSize == 0 {
-		sendDataResponse(conn, jobID, logID, nil, 0, "", false)
-	} else if len(records) == 0 {
-		sendDataResponse(conn, jobID, logID, nil, 0, "", false)
+	// Compressed files are decompressed, archives split into their entries
+	units, _, cleanup, err := expandFile(filePath, fileFormat, open, compression)
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("file", filePath).Msg("Failed to open file")
+		sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
+		return
 	}
+	defer cleanup()
+
+	streamFileJob(conn, jobID, logID, jobName, units, opts, compression.nameColumn, credits)
 }
 
 // sendDataResponse sends data back to master after job execution
 func sendDataResponse(conn net.Conn, jobID, logID uint, records []map[string]interface{}, recordCount int, errorMsg string, isPartial bool) {
+	sendDataResponseExtended(conn, jobID, logID, records, recordCount, errorMsg, isPartial, nil, 0)
+}
+
+// sendDataResponseExtended sends records back to master, credits marks a batch sent against
+// a flow control credit (nil without flow control)
+func sendDataResponseExtended(conn net.Conn, jobID, logID uint, records []map[string]interface{}, recordCount int, errorMsg string, isPartial bool, credits *batchCredits, creditWait time.Duration) {
 	status := "completed"
 	if errorMsg != "" {
 		status = "failed"
@@ -1797,6 +1838,10 @@ func sendDataResponse(conn net.Conn, jobID, logID uint, records []map[string]int
 			"partial":      isPartial,
 		},
 	}
+	if credits != nil {
+		response.Data["credit"] = true
+		response.Data["credit_wait_ms"] = creditWait.Milliseconds()
+	}
 
 	if err := sendMessage(conn, response); err != nil {
 		logger.Logger.Error().Err(err).Msg("Failed to send data response")
@@ -1811,7 +1856,9 @@ func sendDataResponse(conn net.Conn, jobID, logID uint, records []map[string]int
 }
 
 // sendCsvDataResponseExtended sends data back to master in raw CSV format with optional target table
-func sendCsvDataResponseExtended(conn net.Conn, jobID, logID uint, csvRecords string, columns []string, recordCount int, errorMsg string, isPartial bool, targetTable string) {
+// columnTypes describes the source columns and is only sent with the first batch of a run.
+// credits marks a batch sent against a flow control credit (nil without flow control)
+func sendCsvDataResponseExtended(conn net.Conn, jobID, logID uint, csvRecords string, columns []string, columnTypes []database.ColumnSpec, recordCount int, errorMsg string, isPartial bool, targetTable string, credits *batchCredits, creditWait time.Duration) {
 	status := "completed"
 	if errorMsg != "" {
 		status = "failed"
@@ -1842,6 +1889,13 @@ func sendCsvDataResponseExtended(conn net.Conn, jobID, logID uint, csvRecords st
 			"target_table": targetTable,
 		},
 	}
+	if len(columnTypes) > 0 {
+		response.Data["csv_column_types"] = columnTypes
+	}
+	if credits != nil {
+		response.Data["credit"] = true
+		response.Data["credit_wait_ms"] = creditWait.Milliseconds()
+	}
 
 	if err := sendMessage(conn, response); err != nil {
 		logger.Logger.Error().Err(err).Msg("Failed to send CSV data response")
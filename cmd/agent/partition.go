@@ -0,0 +1,212 @@
+package main
+
+import (
+	"dsp-platform/internal/database"
+	"dsp-platform/internal/logger"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// partitionConfig is the partition section of a rule's RUN_JOB schema
+type partitionConfig struct {
+	column      string
+	count       int
+	bounds      string
+	parallelism int
+	retries     int
+	idempotent  bool // Target writes are keyed, a segment may be extracted again after sending batches
+}
+
+// parsePartitionConfig reads the partition section of the schema, nil when the rule is
+// extracted with a single query
+func parsePartitionConfig(msg AgentMessage) *partitionConfig {
+	schema, _ := msg.Data["schema"].(map[string]interface{})
+	section, ok := schema["partition"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	p := &partitionConfig{parallelism: 1}
+	if v, ok := section["column"].(string); ok {
+		p.column = strings.TrimSpace(v)
+	}
+	if v, ok := section["count"].(float64); ok {
+		p.count = int(v)
+	}
+	if v, ok := section["bounds"].(string); ok {
+		p.bounds = v
+	}
+	if v, ok := section["parallelism"].(float64); ok && v >= 1 {
+		p.parallelism = int(v)
+	}
+	if v, ok := section["retries"].(float64); ok && v > 0 {
+		p.retries = int(v)
+	}
+	if v, ok := section["idempotent"].(bool); ok {
+		p.idempotent = v
+	}
+	if p.column == "" {
+		return nil
+	}
+	return p
+}
+
+// sinkError is a failure to hand a batch on (direct write, abort), which extracting the
+// segment again wouldn't fix
+type sinkError struct{ err error }
+
+func (e sinkError) Error() string { return e.err.Error() }
+
+// errPartitionCancelled stops the remaining segments once another segment failed for good
+var errPartitionCancelled = errors.New("cancelled after another segment failed")
+
+// runPartitionedExtraction splits the query into partition ranges and extracts them on a
+// bounded pool of source connections, each streaming its batches into sink. A failed segment
+// is extracted again up to the configured retries; the run fails when one runs out of them
+func runPartitionedExtraction(conn net.Conn, dbConn *database.Connection, dbCfg database.Config, query, targetTable string, jobID, logID uint, jobName string, cfg partitionConfig, batchSize int, sink func(string, []string, []database.ColumnSpec) error) error {
+	partitions, err := dbConn.PlanPartitions(query, cfg.column, cfg.count, cfg.bounds)
+	if err != nil {
+		return fmt.Errorf("partition planning failed: %w", err)
+	}
+	workers := cfg.parallelism
+	if workers > len(partitions) {
+		workers = len(partitions)
+	}
+	logger.Logger.Info().
+		Str("job", jobName).
+		Str("column", cfg.column).
+		Int("segments", len(partitions)).
+		Int("parallelism", workers).
+		Msg("Starting partitioned extraction")
+
+	segments := make(chan database.Partition, len(partitions))
+	for _, p := range partitions {
+		segments <- p
+	}
+	close(segments)
+
+	var (
+		failed   int32 // Set once a segment failed for good, the others stop
+		firstErr error
+		errMu    sync.Mutex
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each worker extracts on its own source connection
+			var source *database.Connection
+			defer func() {
+				if source != nil {
+					source.Close()
+				}
+			}()
+
+			for p := range segments {
+				if atomic.LoadInt32(&failed) == 1 {
+					return
+				}
+				if err := extractSegment(conn, &source, dbCfg, query, targetTable, jobID, logID, cfg, p, batchSize, &failed, sink); err != nil {
+					if atomic.CompareAndSwapInt32(&failed, 0, 1) {
+						errMu.Lock()
+						firstErr = fmt.Errorf("segment %d %s: %w", p.Index, p, err)
+						errMu.Unlock()
+					}
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// extractSegment extracts one partition range with retries, reporting its progress to the master
+func extractSegment(conn net.Conn, source **database.Connection, dbCfg database.Config, query, targetTable string, jobID, logID uint, cfg partitionConfig, p database.Partition, batchSize int, failed *int32, sink func(string, []string, []database.ColumnSpec) error) error {
+	for attempt := 1; ; attempt++ {
+		sendSegmentStatus(conn, jobID, logID, targetTable, p, "running", attempt, 0, "")
+
+		records := 0
+		err := func() error {
+			if *source == nil {
+				c, err := database.Connect(dbCfg)
+				if err != nil {
+					return err
+				}
+				*source = c
+			}
+			segmentQuery, args := (*source).PartitionQuery(query, cfg.column, p)
+			return (*source).ExecuteQueryWithTypedCsvBatchArgs(segmentQuery, args, batchSize, func(csvData string, columns []string, columnTypes []database.ColumnSpec) error {
+				if atomic.LoadInt32(failed) == 1 {
+					return sinkError{errPartitionCancelled}
+				}
+				if err := sink(csvData, columns, columnTypes); err != nil {
+					return sinkError{err}
+				}
+				records += strings.Count(csvData, "\n")
+				return nil
+			})
+		}()
+		if err == nil {
+			sendSegmentStatus(conn, jobID, logID, targetTable, p, "completed", attempt, records, "")
+			return nil
+		}
+
+		var sinkErr sinkError
+		if errors.As(err, &sinkErr) && errors.Is(sinkErr.err, errPartitionCancelled) {
+			sendSegmentStatus(conn, jobID, logID, targetTable, p, "failed", attempt, records, sinkErr.err.Error())
+			return sinkErr.err
+		}
+		retryable := !errors.As(err, &sinkErr) && attempt <= cfg.retries && !isJobAborted(jobID)
+		if retryable && records > 0 && !cfg.idempotent {
+			retryable = false
+			err = fmt.Errorf("%w (not retried: %d rows were already sent and the target has no unique key)", err, records)
+		}
+		if !retryable {
+			sendSegmentStatus(conn, jobID, logID, targetTable, p, "failed", attempt, records, err.Error())
+			return err
+		}
+
+		logger.Logger.Warn().
+			Err(err).
+			Uint("job_id", jobID).
+			Int("segment", p.Index).
+			Int("attempt", attempt).
+			Msg("Partition segment failed, retrying")
+		sendSegmentStatus(conn, jobID, logID, targetTable, p, "retrying", attempt, records, err.Error())
+
+		// The connection may be what broke: reconnect for the next attempt
+		(*source).Close()
+		*source = nil
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+}
+
+// sendSegmentStatus reports the progress of a partition segment to the master
+func sendSegmentStatus(conn net.Conn, jobID, logID uint, targetTable string, p database.Partition, status string, attempt, records int, errMsg string) {
+	msg := AgentMessage{
+		Type:      "SEGMENT_STATUS",
+		AgentName: AgentName,
+		Status:    status,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"job_id":       jobID,
+			"log_id":       logID,
+			"target_table": targetTable,
+			"segment":      p.Index,
+			"range":        p.String(),
+			"status":       status,
+			"attempt":      attempt,
+			"record_count": records,
+			"error":        errMsg,
+		},
+	}
+	if err := sendMessage(conn, msg); err != nil {
+		logger.Logger.Error().Err(err).Int("segment", p.Index).Msg("Failed to send segment status")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"dsp-platform/internal/filesync"
+	"dsp-platform/internal/logger"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// fileStreamBatchSize is the number of records per batch of a streamed file
+const fileStreamBatchSize = 5000
+
+// fileStreamRetries is how many times a broken download is resumed at the last batch's offset
+const fileStreamRetries = 3
+
+// defaultFileNameColumn receives the entry names of archives when no column is configured
+const defaultFileNameColumn = "source_file"
+
+// fileStreamOptions reads the parsing settings of a file format from a command: the record
+// path of XML and the column layout of fixed-width text
+func fileStreamOptions(msg AgentMessage, format string, hasHeader bool, delimiter string) (filesync.StreamOptions, error) {
+	opts := filesync.StreamOptions{Format: format, HasHeader: hasHeader, Delimiter: delimiter}
+	fileCfg, _ := msg.Data["file_config"].(map[string]interface{})
+	opts.RecordPath, _ = fileCfg["record_path"].(string)
+	if f := strings.ToLower(format); f == "fixed_width" || f == "fixed" {
+		layout, _ := fileCfg["fixed_width_layout"].(string)
+		columns, err := filesync.ParseFixedWidthLayout(layout)
+		if err != nil {
+			return opts, err
+		}
+		opts.Columns = columns
+	}
+	return opts, nil
+}
+
+// streamFileJob loads the units of a source file (see expandFile) one after the other and
+// sends the final completion
+func streamFileJob(conn net.Conn, jobID, logID uint, jobName string, units []fileUnit, opts filesync.StreamOptions, nameColumn string, credits *batchCredits) {
+	if nameColumn == "" && len(units) > 0 && units[0].entry != "" {
+		nameColumn = defaultFileNameColumn
+	}
+	totalRecords := 0
+	for _, unit := range units {
+		count, err := streamFileUnit(conn, jobID, logID, jobName, unit, opts, nameColumn, credits)
+		if err != nil {
+			if unit.entry != "" {
+				err = fmt.Errorf("%s: %w", unit.name, err)
+			}
+			sendDataResponse(conn, jobID, logID, nil, 0, err.Error(), false)
+			return
+		}
+		totalRecords += count
+	}
+
+	logger.Logger.Info().
+		Str("job", jobName).
+		Int("files", len(units)).
+		Int("total_records", totalRecords).
+		Msg("File loaded successfully")
+	sendDataResponse(conn, jobID, logID, nil, 0, "", false)
+}
+
+// streamFileUnit parses a file while downloading it and sends each batch to the Master as soon
+// as it is full, so memory use doesn't depend on the file size. Excel workbooks can't be
+// parsed while downloading and are read whole. Batches are sent against the run's credits
+// (nil without flow control). Returns the records sent
+func streamFileUnit(conn net.Conn, jobID, logID uint, jobName string, unit fileUnit, opts filesync.StreamOptions, nameColumn string, credits *batchCredits) (int, error) {
+	opts.BatchSize = fileStreamBatchSize
+	opts.Retries = fileStreamRetries
+
+	logger.Logger.Info().
+		Str("job", jobName).
+		Str("file", unit.name).
+		Str("format", opts.Format).
+		Msg("Streaming file in batches")
+
+	totalRecords := 0
+	send := func(records []map[string]interface{}) error {
+		if isJobAborted(jobID) {
+			return fmt.Errorf("Aborted by user")
+		}
+		if nameColumn != "" {
+			for _, record := range records {
+				record[nameColumn] = unit.name
+			}
+		}
+		totalRecords += len(records)
+		logger.Logger.Info().
+			Str("job", jobName).
+			Int("batch_size", len(records)).
+			Int("total_so_far", totalRecords).
+			Msg("Sending streamed file batch")
+		return sendCreditedBatch(conn, credits, jobID, logID, records)
+	}
+
+	if !filesync.IsStreamFormat(opts.Format) {
+		body, err := unit.open(0)
+		if err != nil {
+			return 0, err
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return 0, err
+		}
+		records, err := filesync.ParseFile(data, opts.Format, opts.HasHeader, opts.Delimiter)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("file", unit.name).Msg("Failed to parse file")
+			return 0, err
+		}
+		for i := 0; i < len(records); i += fileStreamBatchSize {
+			end := i + fileStreamBatchSize
+			if end > len(records) {
+				end = len(records)
+			}
+			if err := send(records[i:end]); err != nil {
+				return totalRecords, err
+			}
+		}
+		return totalRecords, nil
+	}
+
+	offset, err := filesync.StreamRecords(unit.open, opts, 0, func(batch filesync.StreamBatch) error {
+		return send(batch.Records)
+	})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("file", unit.name).Int64("offset", offset).Msg("Failed to stream file")
+		return totalRecords, err
+	}
+	logger.Logger.Info().
+		Str("job", jobName).
+		Str("file", unit.name).
+		Int("records", totalRecords).
+		Int64("bytes", offset).
+		Msg("File streamed successfully")
+	return totalRecords, nil
+}
@@ -168,6 +168,11 @@ func initDatabase() (*gorm.DB, error) {
 		&core.Network{},
 		&core.Job{},
 		&core.JobLog{},
+		&core.JobDestination{},
+		&core.JobDestinationRun{},
+		&core.JobLogSegment{},
+		&core.ProcessedFile{},
+		&core.RejectedRow{},
 		&core.AuditLog{},
 		&core.Settings{},
 		&core.AgentToken{},
@@ -271,6 +276,9 @@ func setupRouter(handler *server.Handler) *gin.Engine {
 		api.POST("/jobs", auth.RequireRole("admin"), handler.CreateJob)
 		api.GET("/jobs/:id", handler.GetJob)
 		api.GET("/jobs/:id/logs", handler.GetJobLogs)
+		api.GET("/jobs/:id/logs/:logId/segments", handler.GetJobLogSegments)
+		api.GET("/jobs/:id/processed-files", handler.GetProcessedFiles)
+		api.DELETE("/jobs/:id/processed-files/:fileId", auth.RequireRole("admin"), handler.DeleteProcessedFile)
 		api.GET("/notifications", handler.GetRecentJobLogs)
 		api.PUT("/jobs/:id", auth.RequireRole("admin"), handler.UpdateJob)
 		api.DELETE("/jobs/:id", auth.RequireRole("admin"), handler.DeleteJob)
@@ -282,6 +290,11 @@ func setupRouter(handler *server.Handler) *gin.Engine {
 		api.POST("/jobs/signal-global", auth.RequireRole("admin"), handler.SignalUpdateGlobal)
 		api.POST("/schemas/:id/run-jobs", auth.RequireRole("admin"), handler.StartSchemaJobs)
 		api.GET("/jobs/:id/compare", auth.RequireRole("admin"), handler.GetCompareResult)
+		api.GET("/jobs/:id/destinations", handler.GetJobDestinations)
+		api.POST("/jobs/:id/destinations", auth.RequireRole("admin"), handler.CreateJobDestination)
+		api.PUT("/jobs/:id/destinations/:destId", auth.RequireRole("admin"), handler.UpdateJobDestination)
+		api.DELETE("/jobs/:id/destinations/:destId", auth.RequireRole("admin"), handler.DeleteJobDestination)
+		api.GET("/jobs/:id/destination-runs", handler.GetJobDestinationRuns)
 
 		// Agent config endpoint
 		api.GET("/jobs/agent/:name", handler.GetAgentJobs)
@@ -301,6 +314,11 @@ func setupRouter(handler *server.Handler) *gin.Engine {
 		// Audit Logs (Viewable by admin only usually, or maybe all? Let's restrict to admin for now based on Sidebar)
 		api.GET("/audit-logs", auth.RequireRole("admin"), handler.GetAuditLogs)
 
+		// Quarantine (rows rejected by the target database)
+		api.GET("/quarantine", auth.RequireRole("admin"), handler.GetRejectedRows)
+		api.GET("/quarantine/download", auth.RequireRole("admin"), handler.DownloadRejectedRows)
+		api.POST("/quarantine/replay", auth.RequireRole("admin"), handler.ReplayRejectedRows)
+
 		// User Management
 		api.GET("/users", auth.RequireRole("admin"), handler.GetUsers) // Only admin can list users
 		api.POST("/users", auth.RequireRole("admin"), handler.CreateUser)
@@ -34,6 +34,26 @@ type Schema struct {
 	UniqueKeyColumn string `json:"unique_key_column"`
 	HasHeader       bool   `json:"has_header" gorm:"default:true"`
 	Delimiter       string `json:"delimiter" gorm:"default:','"`
+
+	// Multi-file ingestion (FTP/SFTP/MinIO): file_mode multi loads every file matching
+	// FilePattern in FileOrder, each once (see ProcessedFile), then applies FilePostAction
+	FileMode         string `json:"file_mode" gorm:"default:'single'"`      // single, multi
+	FileOrder        string `json:"file_order" gorm:"default:'name'"`       // name, mtime
+	FilePostAction   string `json:"file_post_action" gorm:"default:'none'"` // none, archive, rename, delete
+	FileArchivePath  string `json:"file_archive_path"`                      // Relative to the source folder unless absolute
+	FileRenameSuffix string `json:"file_rename_suffix"`                     // Default .processed
+	FileNameColumn   string `json:"file_name_column"`                       // Column receiving the file name (multi default: source_file)
+
+	// Compressed sources: gzip files are decompressed while streaming, zip and tar archives
+	// load each entry matching FileInnerPattern as a separate file
+	FileCompression  string `json:"file_compression" gorm:"default:'auto'"` // auto (extension, then magic bytes), none, gzip, zip, tar, tar.gz
+	FileInnerPattern string `json:"file_inner_pattern"`                     // Archive entries to load, e.g. "*.csv" (default: all)
+
+	// Format settings: the record elements of xml files (e.g. "rows/row", default: children
+	// of the root) and the column layout of fixed_width files, as JSON
+	// [{"name":"id","start":1,"width":8},...] (see filesync.ParseFixedWidthLayout)
+	FileRecordPath       string `json:"file_record_path"`
+	FileFixedWidthLayout string `json:"file_fixed_width_layout" gorm:"type:text"`
 }
 
 // SchemaRule represents a single table extraction/sync rule within a Schema
@@ -48,6 +68,45 @@ type SchemaRule struct {
 	UploadPreQuery   string `json:"upload_pre_query" gorm:"type:text"`
 	UploadPostQuery  string `json:"upload_post_query" gorm:"type:text"`
 	Notes            string `json:"notes" gorm:"type:text"`
+
+	// Schema evolution: what to do when a source column is incompatible with the target column
+	SchemaChangePolicy string `json:"schema_change_policy" gorm:"default:'fail'"` // fail, ignore, text
+
+	// Column mapping applied on the master before writing (JSON, see internal/mapping):
+	// {"columns":[{"source","target","cast","default","expr"}],"constants":{},"drop_unmapped":false}
+	Mapping string `json:"mapping" gorm:"type:text"`
+
+	// Load mode: direct writes into the live table; staging_swap loads a per-run staging
+	// table and swaps it with the live table once row counts check out (replaces Truncate)
+	LoadMode string `json:"load_mode" gorm:"default:'direct'"`
+
+	// Write mode: insert (append every row), upsert (insert, or upsert on the schema
+	// UniqueKeyColumn) or scd2, which keeps every version of a row with valid_from, valid_to,
+	// is_current and row_hash columns
+	WriteMode string `json:"write_mode" gorm:"default:'upsert'"`
+
+	// Ordered unique key of the target table, comma-separated for composite keys
+	// (e.g. "kode_wilayah, periode, nik"). Overrides Schema.UniqueKeyColumn when set
+	UniqueKey string `json:"unique_key"`
+
+	// In-batch deduplication on the unique key: which duplicate wins (last, first or max
+	// of DedupeOrderColumn)
+	DedupeStrategy    string `json:"dedupe_strategy" gorm:"default:'last'"`
+	DedupeOrderColumn string `json:"dedupe_order_column"`
+
+	// Skip the end-of-run resync of the target table's serial / identity / AUTO_INCREMENT
+	// sequences (by default they are moved past the synced IDs)
+	SkipSequenceReset bool `json:"skip_sequence_reset" gorm:"default:false"`
+
+	// Partitioned extraction: the source query is split on PartitionColumn (numeric or date/time)
+	// into PartitionCount ranges from its MIN/MAX, or at the explicit PartitionBounds
+	// (comma-separated, sorted on planning). Ranges run concurrently on PartitionParallelism
+	// source connections and a failed range is extracted again up to PartitionRetries times
+	PartitionColumn      string `json:"partition_column"`
+	PartitionCount       int    `json:"partition_count" gorm:"default:0"`
+	PartitionBounds      string `json:"partition_bounds" gorm:"type:text"`
+	PartitionParallelism int    `json:"partition_parallelism" gorm:"default:4"`
+	PartitionRetries     int    `json:"partition_retries" gorm:"default:2"`
 }
 
 // Network represents a data source (Tenant Agent) or data target
@@ -89,11 +148,32 @@ type Network struct {
 	APIURL       string `json:"api_url"`                         // API endpoint URL
 	APIMethod    string `json:"api_method" gorm:"default:'GET'"` // GET, POST
 	APIHeaders   string `json:"api_headers" gorm:"type:text"`    // JSON string of headers {"key": "value"}
-	APIAuthType  string `json:"api_auth_type"`                   // none, bearer, basic, api_key
+	APIAuthType  string `json:"api_auth_type"`                   // none, bearer, basic, api_key, oauth2
 	APIAuthKey   string `json:"api_auth_key"`                    // Header name for API key (e.g., X-API-Key)
 	APIAuthValue string `json:"api_auth_value"`                  // Token/key value
 	APIBody      string `json:"api_body" gorm:"type:text"`       // Request body for POST
 
+	// OAuth2 client credentials (APIAuthType=oauth2), tokens are cached by the agent
+	APIOAuthTokenURL     string `json:"api_oauth_token_url"`
+	APIOAuthClientID     string `json:"api_oauth_client_id"`
+	APIOAuthClientSecret string `json:"api_oauth_client_secret"`
+	APIOAuthScope        string `json:"api_oauth_scope"`    // Space separated scopes
+	APIOAuthAudience     string `json:"api_oauth_audience"` // Optional audience parameter
+
+	// API pagination and record extraction (see filesync.APIPagination)
+	APIPagination       string `json:"api_pagination" gorm:"default:'none'"` // none, page, offset, cursor, link_header, next_url
+	APIPageParam        string `json:"api_page_param"`                       // Page number/offset query parameter
+	APISizeParam        string `json:"api_size_param"`                       // Page size query parameter (e.g. limit)
+	APIPageSize         int    `json:"api_page_size"`                        // Records per page, a shorter page is the last
+	APIStartPage        int    `json:"api_start_page"`                       // First page number (default 1)
+	APICursorPath       string `json:"api_cursor_path"`                      // JSON path of the next cursor or next URL
+	APICursorParam      string `json:"api_cursor_param"`                     // Query parameter receiving the cursor
+	APIHasMorePath      string `json:"api_has_more_path"`                    // JSON path of a has-more flag
+	APIMaxPages         int    `json:"api_max_pages"`                        // 0 = 1000
+	APIFollowOtherHosts bool   `json:"api_follow_other_hosts"`               // Follow next_url/link_header pages on other hosts (they receive the credentials)
+	APIRecordPath       string `json:"api_record_path"`                      // JSON path of the records, e.g. $.result.items
+	APIIncrementalParam string `json:"api_incremental_param"`                // Query parameter receiving the job checkpoint (incremental jobs)
+
 	// MongoDB Configuration (for agent to use when SourceType=mongodb)
 	MongoHost       string `json:"mongo_host"`
 	MongoPort       string `json:"mongo_port" gorm:"default:'27017'"`
@@ -121,7 +201,7 @@ type Network struct {
 
 	// ===== TARGET CONFIGURATION (for 1:1 Source-Target Pair) =====
 
-	// Target Source Type: database, ftp, sftp, api, minio
+	// Target Source Type: database, ftp, sftp, api, minio, mongodb
 	TargetSourceType string `json:"target_source_type" gorm:"default:'database'"`
 
 	// Target Database Configuration
@@ -133,6 +213,10 @@ type Network struct {
 	TargetDBName     string `json:"target_db_name"`
 	TargetDBSSLMode  string `json:"target_db_sslmode" gorm:"default:'disable'"`
 
+	// Agent direct write: the agent writes database-source batches to the target database
+	// itself and only reports progress and results (for agents next to the target)
+	AgentDirectWrite bool `json:"agent_direct_write" gorm:"default:false"`
+
 	// Target FTP/SFTP Configuration
 	TargetFTPHost       string `json:"target_ftp_host"`
 	TargetFTPPort       string `json:"target_ftp_port" gorm:"default:'21'"`
@@ -141,6 +225,12 @@ type Network struct {
 	TargetFTPPrivateKey string `json:"target_ftp_private_key" gorm:"type:text"`
 	TargetFTPPath       string `json:"target_ftp_path"`
 
+	// File output of FTP/SFTP targets: one file per run and table
+	TargetFileFormat       string `json:"target_file_format" gorm:"default:'csv'"` // csv, jsonl, xlsx
+	TargetFileNameTemplate string `json:"target_file_name_template"`               // e.g. "{table}_{date}.{ext}" (see filesync.RenderFileName)
+	TargetFileDoneMarker   bool   `json:"target_file_done_marker" gorm:"default:false"`
+	TargetFileChecksum     bool   `json:"target_file_checksum" gorm:"default:false"`
+
 	// Target API Configuration
 	TargetAPIURL       string `json:"target_api_url"`
 	TargetAPIMethod    string `json:"target_api_method" gorm:"default:'POST'"`
@@ -148,17 +238,52 @@ type Network struct {
 	TargetAPIAuthType  string `json:"target_api_auth_type"`
 	TargetAPIAuthKey   string `json:"target_api_auth_key"`
 	TargetAPIAuthValue string `json:"target_api_auth_value"`
-	TargetAPIBody      string `json:"target_api_body" gorm:"type:text"`
+	TargetAPIBody      string `json:"target_api_body" gorm:"type:text"` // Per-record body template, {{column}} placeholders (see filesync.RenderRecordTemplate)
+
+	// Target API OAuth2 client credentials (TargetAPIAuthType=oauth2)
+	TargetAPIOAuthTokenURL     string `json:"target_api_oauth_token_url"`
+	TargetAPIOAuthClientID     string `json:"target_api_oauth_client_id"`
+	TargetAPIOAuthClientSecret string `json:"target_api_oauth_client_secret"`
+	TargetAPIOAuthScope        string `json:"target_api_oauth_scope"`
+	TargetAPIOAuthAudience     string `json:"target_api_oauth_audience"`
+
+	// API target batching: records per request (1 = one request per record) and the
+	// envelope of batched requests, {{$records}} is replaced by the array of record bodies
+	TargetAPIBatchSize     int    `json:"target_api_batch_size" gorm:"default:1"`
+	TargetAPIBatchTemplate string `json:"target_api_batch_template" gorm:"type:text"`
+
+	// Retry a refused batch record by record so only the refused records are quarantined.
+	// Off by default: an API that applied part of the batch would receive those records twice
+	TargetAPIIsolateRejects bool `json:"target_api_isolate_rejects" gorm:"default:false"`
+
+	// Target MongoDB Configuration (target table = collection)
+	TargetMongoHost     string `json:"target_mongo_host"`
+	TargetMongoPort     string `json:"target_mongo_port" gorm:"default:'27017'"`
+	TargetMongoUser     string `json:"target_mongo_user"`
+	TargetMongoPassword string `json:"target_mongo_password"`
+	TargetMongoDatabase string `json:"target_mongo_database"`
+	TargetMongoAuthDB   string `json:"target_mongo_auth_db" gorm:"default:'admin'"`
 
 	// Target MinIO/S3 Configuration
 	TargetMinIOEndpoint     string `json:"target_minio_endpoint"`
 	TargetMinIOAccessKey    string `json:"target_minio_access_key"`
 	TargetMinIOSecretKey    string `json:"target_minio_secret_key"`
 	TargetMinIOBucket       string `json:"target_minio_bucket"`
-	TargetMinIOObjectPath   string `json:"target_minio_object_path"` // Object key to write
+	TargetMinIOObjectPath   string `json:"target_minio_object_path"` // Object key template, e.g. "{table}/{yyyy}/{mm}/{dd}/run-{run_id}-part-{part}.{ext}" (see filesync.RenderObjectKey)
 	TargetMinIOUseSSL       bool   `json:"target_minio_use_ssl" gorm:"default:false"`
 	TargetMinIORegion       string `json:"target_minio_region" gorm:"default:'us-east-1'"`
-	TargetMinIOExportFormat string `json:"target_minio_export_format" gorm:"default:'csv'"` // csv, json, parquet
+	TargetMinIOExportFormat string `json:"target_minio_export_format" gorm:"default:'csv'"` // csv, json, jsonl, parquet
+
+	// Parquet output of MinIO targets: codec, rows per row group and rows per part file
+	TargetMinIOCompression  string `json:"target_minio_compression" gorm:"default:'snappy'"` // snappy, zstd, gzip, none
+	TargetMinIORowGroupSize int    `json:"target_minio_row_group_size" gorm:"default:100000"`
+	TargetMinIOPartRows     int    `json:"target_minio_part_rows" gorm:"default:1000000"`
+
+	// Object layout of MinIO targets: Hive partition columns (comma separated, taken out of
+	// the records), part size limit (0 = rows only) and the run manifest key template
+	TargetMinIOPartitionColumns string `json:"target_minio_partition_columns"`
+	TargetMinIOPartBytes        int64  `json:"target_minio_part_bytes" gorm:"default:0"`
+	TargetMinIOManifestPath     string `json:"target_minio_manifest_path"` // Default "{table}/_manifests/run-{run_id}.json"
 
 	// Nodes Redesign Fields
 	Notes           string  `json:"notes" gorm:"type:text"`
@@ -219,11 +344,87 @@ type Job struct {
 	TargetConn string `json:"target_conn"`
 	User       string `json:"user_display"` // Display name of the user who ran it
 
+	// Quarantine: fail the run when more rows than this are rejected by the target (0 = no limit)
+	MaxRejectedRows int `json:"max_rejected_rows" gorm:"default:0"`
+
+	// Fan-out: what a failing extra destination does to the run.
+	// fail = the run fails, continue = only the job's own target decides the run status
+	DestinationFailurePolicy string `json:"destination_failure_policy" gorm:"default:'fail'"`
+
 	// Relations
 	Schema  *Schema `json:"schema,omitempty" gorm:"foreignKey:SchemaID"` // Optional relation
 	Network Network `json:"network" gorm:"foreignKey:NetworkID"`
 }
 
+// JobDestination is an extra target of a job: every batch the job extracts is also written
+// to the target configured on NetworkID, so one extraction feeds several destinations
+type JobDestination struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	JobID       uint      `json:"job_id" gorm:"not null;index"`
+	NetworkID   uint      `json:"network_id" gorm:"not null"` // Network whose target settings receive the copy
+	Name        string    `json:"name"`
+	TargetTable string    `json:"target_table"` // Empty = same table, {table} = the job's table name (e.g. "archive_{table}")
+	WriteMode   string    `json:"write_mode"`   // Empty = the rule's mode, or insert, upsert, scd2
+	UniqueKey   string    `json:"unique_key"`   // Overrides the rule's unique key
+	Enabled     bool      `json:"enabled"`      // Defaults to true on create (see CreateJobDestination)
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	CreatedBy   uint      `json:"created_by"`
+	UpdatedBy   uint      `json:"updated_by"`
+
+	// Relations
+	Network Network `json:"network,omitempty" gorm:"foreignKey:NetworkID"`
+}
+
+// JobDestinationRun is the outcome of one destination table in one run
+type JobDestinationRun struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	JobID           uint      `json:"job_id" gorm:"index"`
+	JobLogID        uint      `json:"job_log_id" gorm:"index"`
+	DestinationID   uint      `json:"destination_id" gorm:"index"`
+	NetworkID       uint      `json:"network_id"`
+	TargetTable     string    `json:"target_table"`
+	Status          string    `json:"status"` // completed/failed
+	RecordsReceived int       `json:"records_received"`
+	RecordsWritten  int       `json:"records_written"`
+	RecordsRejected int       `json:"records_rejected"`
+	ErrorMessage    string    `json:"error_message,omitempty" gorm:"type:text"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// JobLogSegment is the progress of one partition range of a run's table (partitioned extraction)
+type JobLogSegment struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	JobID        uint      `json:"job_id" gorm:"index"`
+	JobLogID     uint      `json:"job_log_id" gorm:"index"`
+	TargetTable  string    `json:"target_table"`
+	Segment      int       `json:"segment"`
+	Range        string    `json:"range"`  // e.g. [1000, 2000) or NULL
+	Status       string    `json:"status"` // running/retrying/completed/failed
+	Attempts     int       `json:"attempts"`
+	RecordCount  int       `json:"record_count"`
+	ErrorMessage string    `json:"error_message,omitempty" gorm:"type:text"`
+	StartedAt    time.Time `json:"started_at"`
+	CompletedAt  time.Time `json:"completed_at"`
+}
+
+// ProcessedFile is the manifest entry of a file a multi-file job loaded, so it isn't loaded again
+type ProcessedFile struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	JobID           uint      `json:"job_id" gorm:"index"`
+	JobLogID        uint      `json:"job_log_id" gorm:"index"`
+	Path            string    `json:"path"`
+	Entry           string    `json:"entry,omitempty"` // Entry of a zip/tar archive, loaded as its own file
+	Size            int64     `json:"size"`
+	ModTime         time.Time `json:"mod_time"`
+	Checksum        string    `json:"checksum"` // SHA-256 of the file content
+	RecordCount     int       `json:"record_count"`
+	Status          string    `json:"status"` // loading (run in progress), loaded, failed
+	MovedTo         string    `json:"moved_to"`
+	PostActionError string    `json:"post_action_error,omitempty" gorm:"type:text"`
+	ProcessedAt     time.Time `json:"processed_at"`
+}
+
 // User represents an authenticated user for the web console
 type User struct {
 	ID                 uint      `json:"id" gorm:"primaryKey"`
@@ -287,12 +488,30 @@ type JobLog struct {
 	RecordCount  int       `json:"record_count"`
 	ErrorMessage string    `json:"error_message,omitempty"`
 	SampleData   string    `json:"sample_data,omitempty" gorm:"type:text"` // JSON string of sample records
+	Events       string    `json:"events,omitempty" gorm:"type:text"`      // Timestamped lines of notable actions (DDL executed, ...)
+	RejectedRows int       `json:"rejected_rows" gorm:"default:0"`         // Rows quarantined because the target refused them
 	CreatedAt    time.Time `json:"created_at"`
 
 	// Relations
 	Job Job `json:"job,omitempty" gorm:"foreignKey:JobID"`
 }
 
+// RejectedRow is a dead-letter entry: a record the target database refused during a run,
+// kept with the error so it can be inspected, downloaded and replayed once the target is fixed
+type RejectedRow struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	NetworkID   uint       `json:"network_id" gorm:"index"`
+	JobID       uint       `json:"job_id" gorm:"index"`
+	JobLogID    uint       `json:"job_log_id" gorm:"index"`
+	TargetTable string     `json:"target_table"`
+	Record      string     `json:"record" gorm:"type:text"` // Original record (JSON)
+	Error       string     `json:"error" gorm:"type:text"`
+	Status      string     `json:"status" gorm:"default:'pending';index"` // pending/replayed
+	Attempts    int        `json:"attempts" gorm:"default:0"`             // Replay attempts
+	ReplayedAt  *time.Time `json:"replayed_at"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"index"`
+}
+
 // Settings represents global application settings
 type Settings struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"time"
@@ -296,9 +297,24 @@ func TestConnection(config Config) error {
 }
 
 // ExecuteQueryWithCsvBatch executes SQL query and processes results as CSV strings in batches.
-// Converts data directly to CSV strings to minimize JSON object memory overhead for huge datasets.
+// This is highly optimized for memory and CPU since it avoids creating maps for each row.
 func (c *Connection) ExecuteQueryWithCsvBatch(query string, batchSize int, callback func(string, []string) error) error {
-	rows, err := c.DB.Query(query)
+	return c.ExecuteQueryWithTypedCsvBatch(query, batchSize, func(csvData string, columns []string, _ []ColumnSpec) error {
+		return callback(csvData, columns)
+	})
+}
+
+// ExecuteQueryWithTypedCsvBatch is ExecuteQueryWithCsvBatch that also hands the callback
+// the normalized source column types, so the target can be created with matching types.
+// Values are formatted according to their column type to keep date/time precision
+func (c *Connection) ExecuteQueryWithTypedCsvBatch(query string, batchSize int, callback func(string, []string, []ColumnSpec) error) error {
+	return c.ExecuteQueryWithTypedCsvBatchArgs(query, nil, batchSize, callback)
+}
+
+// ExecuteQueryWithTypedCsvBatchArgs is ExecuteQueryWithTypedCsvBatch for a query with bind
+// arguments, such as the range filter of a partition
+func (c *Connection) ExecuteQueryWithTypedCsvBatchArgs(query string, args []interface{}, batchSize int, callback func(string, []string, []ColumnSpec) error) error {
+	rows, err := c.DB.Query(query, args...)
 	if err != nil {
 		return fmt.Errorf("query execution failed: %w", err)
 	}
@@ -309,6 +325,17 @@ func (c *Connection) ExecuteQueryWithCsvBatch(query string, batchSize int, callb
 		return fmt.Errorf("failed to get columns: %w", err)
 	}
 
+	var columnTypes []ColumnSpec
+	if cts, err := rows.ColumnTypes(); err == nil {
+		columnTypes = SourceColumnSpecs(c.Config.Driver, cts)
+	}
+	typeOf := func(i int) string {
+		if i < len(columnTypes) {
+			return columnTypes[i].Type
+		}
+		return ""
+	}
+
 	var batchData strings.Builder
 	// Rough estimation to prevent frequent resizing
 	batchData.Grow(batchSize * len(columns) * 15)
@@ -338,14 +365,7 @@ func (c *Connection) ExecuteQueryWithCsvBatch(query string, batchSize int, callb
 				continue
 			}
 
-			var v string
-			if b, ok := val.([]byte); ok {
-				v = string(b)
-			} else if t, ok := val.(time.Time); ok {
-				v = t.Format(time.RFC3339)
-			} else {
-				v = fmt.Sprintf("%v", val)
-			}
+			v := formatCsvValue(val, typeOf(i), c.Config.Driver)
 
 			// Escape CSV fields
 			if strings.ContainsAny(v, ",\"\n\r") {
@@ -361,7 +381,7 @@ func (c *Connection) ExecuteQueryWithCsvBatch(query string, batchSize int, callb
 		count++
 
 		if count >= batchSize {
-			if err := callback(batchData.String(), columns); err != nil {
+			if err := callback(batchData.String(), columns, columnTypes); err != nil {
 				return err
 			}
 			batchData.Reset()
@@ -371,7 +391,7 @@ func (c *Connection) ExecuteQueryWithCsvBatch(query string, batchSize int, callb
 	}
 
 	if count > 0 {
-		if err := callback(batchData.String(), columns); err != nil {
+		if err := callback(batchData.String(), columns, columnTypes); err != nil {
 			return err
 		}
 	}
@@ -382,3 +402,45 @@ func (c *Connection) ExecuteQueryWithCsvBatch(query string, batchSize int, callb
 
 	return nil
 }
+
+// formatCsvValue renders a scanned value as text according to its logical column type
+func formatCsvValue(val interface{}, logicalType, driver string) string {
+	switch v := val.(type) {
+	case time.Time:
+		switch logicalType {
+		case "date":
+			return v.Format("2006-01-02")
+		case "time":
+			return v.Format("15:04:05.999999")
+		case "timestamp":
+			return v.Format("2006-01-02 15:04:05.999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		switch logicalType {
+		case "binary":
+			// PostgreSQL bytea hex input format, the transport format of binary values: writers
+			// decode it for other target dialects (see DecodeBinaryColumns)
+			return "\\x" + hex.EncodeToString(v)
+		case "uuid":
+			if len(v) == 16 {
+				return formatUUIDBytes(v, driver)
+			}
+		}
+		return string(v)
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// formatUUIDBytes renders a 16 byte UUID. SQL Server stores the first three groups little-endian
+func formatUUIDBytes(b []byte, driver string) string {
+	u := make([]byte, 16)
+	copy(u, b)
+	if driver == "sqlserver" || driver == "mssql" {
+		u[0], u[1], u[2], u[3] = b[3], b[2], b[1], b[0]
+		u[4], u[5] = b[5], b[4]
+		u[6], u[7] = b[7], b[6]
+	}
+	h := hex.EncodeToString(u)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
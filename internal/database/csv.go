@@ -0,0 +1,85 @@
+package database
+
+import (
+	"bytes"
+	encoding_csv "encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// TimeLayouts are the text forms of dates and timestamps read back from batches and
+// source drivers, tried in order. Fractional seconds are optional in every layout
+var TimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// ParseCsvRecords turns a CSV batch into records, empty fields become NULL
+func ParseCsvRecords(csvData string, columns []string) ([]map[string]interface{}, error) {
+	reader := encoding_csv.NewReader(strings.NewReader(csvData))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var records []map[string]interface{}
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CSV data: %w", err)
+		}
+		rec := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			if i < len(fields) && fields[i] != "" {
+				rec[col] = fields[i]
+			} else {
+				rec[col] = nil
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// DropCsvColumns removes the given columns from a CSV batch (columns the schema sync ignored)
+func DropCsvColumns(csvData string, columns []string, drop map[string]bool) (string, []string, error) {
+	var keep []int
+	var kept []string
+	for i, col := range columns {
+		if !drop[col] {
+			keep = append(keep, i)
+			kept = append(kept, col)
+		}
+	}
+
+	reader := encoding_csv.NewReader(strings.NewReader(csvData))
+	reader.FieldsPerRecord = -1
+	var buf bytes.Buffer
+	writer := encoding_csv.NewWriter(&buf)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to parse CSV batch: %w", err)
+		}
+		out := make([]string, 0, len(keep))
+		for _, idx := range keep {
+			if idx < len(row) {
+				out = append(out, row[idx])
+			} else {
+				out = append(out, "")
+			}
+		}
+		writer.Write(out)
+	}
+	writer.Flush()
+	return buf.String(), kept, writer.Error()
+}
@@ -0,0 +1,266 @@
+package database
+
+import (
+	"bytes"
+	encoding_csv "encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dedupe strategies: which record wins when a batch holds the same key more than once
+const (
+	DedupeLast  = "last"  // Last occurrence wins (default)
+	DedupeFirst = "first" // First occurrence wins
+	DedupeMax   = "max"   // Highest value of the order column wins (ties: last occurrence)
+)
+
+// Dedupe configures in-batch deduplication on the unique key
+type Dedupe struct {
+	Strategy    string // last, first, max
+	OrderColumn string // Ordering column for the max strategy
+}
+
+// Validate checks the strategy and its order column
+func (d Dedupe) Validate() error {
+	switch d.Strategy {
+	case "", DedupeLast, DedupeFirst:
+	case DedupeMax:
+		if d.OrderColumn == "" {
+			return fmt.Errorf("dedupe strategy max requires an order column")
+		}
+	default:
+		return fmt.Errorf("invalid dedupe strategy %q (last, first or max)", d.Strategy)
+	}
+	return nil
+}
+
+// String describes the winner rule for logs
+func (d Dedupe) String() string {
+	switch d.Strategy {
+	case DedupeFirst:
+		return "first occurrence wins"
+	case DedupeMax:
+		return fmt.Sprintf("max %s wins", d.OrderColumn)
+	}
+	return "last occurrence wins"
+}
+
+// keep returns the indexes of the winning rows in their original order.
+// key returns the composite key of row i (ok is false for NULL keys, those rows are all kept)
+// and order returns the ordering value of row i for the max strategy
+func (d Dedupe) keep(n int, key func(i int) (string, bool), order func(i int) interface{}) []int {
+	winner := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		k, ok := key(i)
+		if !ok {
+			continue
+		}
+		prev, seen := winner[k]
+		switch {
+		case !seen:
+			winner[k] = i
+		case d.Strategy == DedupeFirst:
+		case d.Strategy == DedupeMax:
+			if compareOrderValues(order(i), order(prev)) >= 0 {
+				winner[k] = i
+			}
+		default:
+			winner[k] = i
+		}
+	}
+
+	kept := make([]int, 0, len(winner))
+	for i := 0; i < n; i++ {
+		if k, ok := key(i); ok && winner[k] != i {
+			continue
+		}
+		kept = append(kept, i)
+	}
+	return kept
+}
+
+// DedupeRecords collapses records sharing a key. Returns the kept records and the
+// number of duplicates dropped
+func DedupeRecords(records []map[string]interface{}, keyColumns []string, d Dedupe) ([]map[string]interface{}, int) {
+	if len(keyColumns) == 0 || len(records) < 2 {
+		return records, 0
+	}
+
+	kept := d.keep(len(records),
+		func(i int) (string, bool) { return recordKey(records[i], keyColumns) },
+		func(i int) interface{} { return records[i][d.OrderColumn] },
+	)
+	if len(kept) == len(records) {
+		return records, 0
+	}
+
+	out := make([]map[string]interface{}, len(kept))
+	for i, idx := range kept {
+		out[i] = records[idx]
+	}
+	return out, len(records) - len(out)
+}
+
+// dedupeCsvRows collapses parsed CSV rows sharing a key (empty fields are NULL)
+func dedupeCsvRows(rows [][]string, columns []string, keyColumns []string, d Dedupe) ([][]string, int) {
+	if len(keyColumns) == 0 || len(rows) < 2 {
+		return rows, 0
+	}
+
+	keyIdx := make([]int, len(keyColumns))
+	for i, key := range keyColumns {
+		keyIdx[i] = -1
+		for j, col := range columns {
+			if col == key {
+				keyIdx[i] = j
+			}
+		}
+	}
+	orderIdx := -1
+	for j, col := range columns {
+		if col == d.OrderColumn {
+			orderIdx = j
+		}
+	}
+
+	field := func(row []string, idx int) string {
+		if idx < 0 || idx >= len(row) {
+			return ""
+		}
+		return row[idx]
+	}
+
+	kept := d.keep(len(rows),
+		func(i int) (string, bool) {
+			parts := make([]string, len(keyIdx))
+			for j, idx := range keyIdx {
+				v := field(rows[i], idx)
+				if v == "" {
+					return "", false // NULL key, never conflicts
+				}
+				parts[j] = v
+			}
+			return strings.Join(parts, "\x1f"), true
+		},
+		func(i int) interface{} {
+			if v := field(rows[i], orderIdx); v != "" {
+				return v
+			}
+			return nil
+		},
+	)
+	if len(kept) == len(rows) {
+		return rows, 0
+	}
+
+	out := make([][]string, len(kept))
+	for i, idx := range kept {
+		out[i] = rows[idx]
+	}
+	return out, len(rows) - len(out)
+}
+
+// DedupeCsv collapses duplicate keys of a CSV batch. The batch is only rewritten when
+// duplicates were found. Returns the CSV and the number of duplicates dropped
+func DedupeCsv(csvData string, columns []string, keyColumns []string, d Dedupe) (string, int, error) {
+	if len(keyColumns) == 0 {
+		return csvData, 0, nil
+	}
+	if col := missingKeyColumn(columns, keyColumns); col != "" {
+		return "", 0, fmt.Errorf("unique key column %s is missing from the batch", col)
+	}
+
+	reader := encoding_csv.NewReader(strings.NewReader(csvData))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var rows [][]string
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to parse CSV data: %w", err)
+		}
+		rows = append(rows, row)
+	}
+
+	kept, dropped := dedupeCsvRows(rows, columns, keyColumns, d)
+	if dropped == 0 {
+		return csvData, 0, nil
+	}
+
+	var buf bytes.Buffer
+	writer := encoding_csv.NewWriter(&buf)
+	if err := writer.WriteAll(kept); err != nil {
+		return "", 0, err
+	}
+	return buf.String(), dropped, nil
+}
+
+// compareOrderValues compares two ordering values: numerically when both are numbers,
+// chronologically when both are timestamps, as strings otherwise. NULL sorts lowest
+func compareOrderValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	if fa, ok := orderNumber(a); ok {
+		if fb, ok := orderNumber(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	if ta, ok := orderTime(a); ok {
+		if tb, ok := orderTime(b); ok {
+			return ta.Compare(tb)
+		}
+	}
+	return strings.Compare(comparableValue(a), comparableValue(b))
+}
+
+// orderNumber reads a number from a JSON number or numeric string
+func orderNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// orderTime reads a timestamp from a time value or a formatted string
+func orderTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		s := strings.TrimSpace(t)
+		for _, layout := range TimeLayouts {
+			if parsed, err := time.Parse(layout, s); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
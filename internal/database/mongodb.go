@@ -2,10 +2,17 @@ package database
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -237,11 +244,6 @@ func TestMongoConnection(config MongoConfig) error {
 // UpsertMany inserts or updates multiple documents based on unique key field
 // If document with matching uniqueKeyField exists, it updates; otherwise inserts
 func (c *MongoConnection) UpsertMany(collectionName string, documents []map[string]interface{}, uniqueKeyField string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-	defer cancel()
-
-	collection := c.Database.Collection(collectionName)
-
 	// If no unique key specified, fall back to regular insert
 	if uniqueKeyField == "" {
 		if err := c.InsertMany(collectionName, documents); err != nil {
@@ -250,37 +252,256 @@ func (c *MongoConnection) UpsertMany(collectionName string, documents []map[stri
 		return len(documents), nil
 	}
 
-	upsertedCount := 0
-	opts := options.Update().SetUpsert(true)
+	count, _, err := c.UpsertBatch(collectionName, documents, ParseKeyColumns(uniqueKeyField))
+	return count, err
+}
+
+// mongoBulkChunk is the number of write models sent per bulk write
+const mongoBulkChunk = 1000
+
+// UpsertBatch writes documents with unordered bulk writes: upserts matched on the key
+// fields (dotted paths reach into nested documents), plain inserts without a key.
+// Documents refused by the server are returned as rejected, the others are still written
+func (c *MongoConnection) UpsertBatch(collectionName string, documents []map[string]interface{}, keyFields []string) (int, []RejectedRow, error) {
+	collection := c.Database.Collection(collectionName)
+	written := 0
+	var rejected []RejectedRow
+
+	for start := 0; start < len(documents); start += mongoBulkChunk {
+		end := min(start+mongoBulkChunk, len(documents))
+
+		var models []mongo.WriteModel
+		var modelDocs []map[string]interface{}
+		for _, doc := range documents[start:end] {
+			if len(keyFields) == 0 {
+				models = append(models, mongo.NewInsertOneModel().SetDocument(doc))
+				modelDocs = append(modelDocs, doc)
+				continue
+			}
 
-	for _, doc := range documents {
-		// Get the unique key value from document
-		keyValue, exists := doc[uniqueKeyField]
-		if !exists {
-			// Skip documents without the unique key
+			filter := bson.D{}
+			missing := ""
+			for _, key := range keyFields {
+				value, ok := nestedValue(doc, key)
+				if !ok || value == nil {
+					missing = key
+					break
+				}
+				filter = append(filter, bson.E{Key: key, Value: value})
+			}
+			if missing != "" {
+				rejected = append(rejected, RejectedRow{Record: doc, Error: fmt.Sprintf("unique key field %s is missing", missing)})
+				continue
+			}
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(filter).
+				SetUpdate(bson.M{"$set": doc}).
+				SetUpsert(true))
+			modelDocs = append(modelDocs, doc)
+		}
+		if len(models) == 0 {
 			continue
 		}
 
-		// Build filter based on unique key
-		filter := bson.M{uniqueKeyField: keyValue}
+		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+		_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		cancel()
 
-		// Build update document (using $set to update all fields)
-		update := bson.M{"$set": doc}
+		if err == nil {
+			written += len(models)
+			continue
+		}
 
-		result, err := collection.UpdateOne(ctx, filter, update, opts)
-		if err != nil {
-			// Log error but continue with other documents
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+			return written, rejected, fmt.Errorf("MongoDB bulk write failed: %w", err)
+		}
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Index >= 0 && writeErr.Index < len(modelDocs) {
+				rejected = append(rejected, RejectedRow{Record: modelDocs[writeErr.Index], Error: writeErr.Message})
+			}
+		}
+		written += len(models) - len(bulkErr.WriteErrors)
+	}
+
+	return written, rejected, nil
+}
+
+// EnsureUniqueIndex creates the unique index of the key fields if the collection doesn't
+// have it yet. Returns the index name
+func (c *MongoConnection) EnsureUniqueIndex(collectionName string, keyFields []string) (string, error) {
+	if len(keyFields) == 0 {
+		return "", nil
+	}
+
+	keys := bson.D{}
+	for _, key := range keyFields {
+		keys = append(keys, bson.E{Key: key, Value: 1})
+	}
+	name := "uq_" + strings.ReplaceAll(strings.Join(keyFields, "_"), ".", "_")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	// Creating an index that already exists with the same keys and options is a no-op
+	created, err := c.Database.Collection(collectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetUnique(true).SetName(name),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create unique index on %s(%s): %w", collectionName, strings.Join(keyFields, ", "), err)
+	}
+	return created, nil
+}
+
+// NestDocument turns dotted column names into nested documents: {"patient.nik": 1,
+// "patient.name": "x"} becomes {"patient": {"nik": 1, "name": "x"}}. A dotted column that
+// collides with a plain column of the same prefix ("patient" and "patient.nik") is an error,
+// MongoDB refusing to set both paths
+func NestDocument(record map[string]interface{}) (map[string]interface{}, error) {
+	nested := false
+	for key := range record {
+		if strings.Contains(key, ".") {
+			nested = true
+			break
+		}
+	}
+	if !nested {
+		return record, nil
+	}
+
+	// Plain columns first, so a collision names the plain column
+	doc := make(map[string]interface{}, len(record))
+	var dotted []string
+	for key, value := range record {
+		if strings.Contains(key, ".") {
+			dotted = append(dotted, key)
 			continue
 		}
+		doc[key] = value
+	}
+	sort.Strings(dotted)
+
+	for _, key := range dotted {
+		parts := strings.Split(key, ".")
+		current := doc
+		for i, part := range parts[:len(parts)-1] {
+			next, exists := current[part]
+			if !exists {
+				child := make(map[string]interface{})
+				current[part] = child
+				current = child
+				continue
+			}
+			child, isDoc := next.(map[string]interface{})
+			if !isDoc {
+				return nil, fmt.Errorf("column %s collides with column %s", key, strings.Join(parts[:i+1], "."))
+			}
+			current = child
+		}
+		last := parts[len(parts)-1]
+		if _, exists := current[last]; exists {
+			return nil, fmt.Errorf("column %s collides with a nested field of the same path", key)
+		}
+		current[last] = record[key]
+	}
+	return doc, nil
+}
 
-		// Count both inserted and modified as successful
-		if result.UpsertedCount > 0 || result.ModifiedCount > 0 {
-			upsertedCount++
-		} else if result.MatchedCount > 0 {
-			// Document matched but no changes needed (same data)
-			upsertedCount++
+// MongoDocument builds the document of a record: text values of typed columns (CSV batches)
+// are converted to the BSON type of their source column, then dotted columns are nested
+func MongoDocument(record map[string]interface{}, specs map[string]ColumnSpec) (map[string]interface{}, error) {
+	if len(specs) > 0 {
+		typed := make(map[string]interface{}, len(record))
+		for key, value := range record {
+			text, isText := value.(string)
+			spec, ok := specs[key]
+			if !isText || !ok {
+				typed[key] = value
+				continue
+			}
+			converted, err := mongoValue(text, spec.Type)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", key, err)
+			}
+			typed[key] = converted
 		}
+		record = typed
 	}
+	return NestDocument(record)
+}
 
-	return upsertedCount, nil
+// mongoValue converts the CSV text of a value to the BSON type of a logical column type
+func mongoValue(text, logicalType string) (interface{}, error) {
+	s := strings.TrimSpace(text)
+	switch logicalType {
+	case "boolean":
+		switch strings.ToLower(s) {
+		case "true", "t", "1":
+			return true, nil
+		case "false", "f", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean %q", text)
+	case "smallint", "integer", "bigint":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", text)
+		}
+		return n, nil
+	case "real", "double":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", text)
+		}
+		return f, nil
+	case "decimal":
+		d, err := primitive.ParseDecimal128(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid decimal %q", text)
+		}
+		return d, nil
+	case "date", "timestamp", "timestamptz":
+		// The text forms formatCsvValue writes
+		for _, layout := range TimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid %s %q", logicalType, text)
+	case "binary":
+		if strings.HasPrefix(s, "\\x") {
+			b, err := hex.DecodeString(s[2:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid binary value: %w", err)
+			}
+			return b, nil
+		}
+	case "json":
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return v, nil
+	}
+	return text, nil
+}
+
+// nestedValue reads a dotted path from a document
+func nestedValue(doc map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := doc[path]; ok {
+		return value, true
+	}
+	parts := strings.Split(path, ".")
+	var current interface{} = doc
+	for _, part := range parts {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
 }
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Partition is one range of a partitioned extraction: the rows whose partition column is
+// >= Lower and < Upper (nil = unbounded), or the rows where it is NULL
+type Partition struct {
+	Index int
+	Lower interface{}
+	Upper interface{}
+	Nulls bool
+}
+
+// String describes the range for logs and segment progress
+func (p Partition) String() string {
+	if p.Nulls {
+		return "NULL"
+	}
+	bound := func(v interface{}, open string) string {
+		switch val := v.(type) {
+		case nil:
+			return open
+		case time.Time:
+			return val.Format("2006-01-02 15:04:05")
+		}
+		return fmt.Sprintf("%v", v)
+	}
+	return fmt.Sprintf("[%s, %s)", bound(p.Lower, "-inf"), bound(p.Upper, "+inf"))
+}
+
+// sourceBindVar returns the bind variable n (1-based) of a source driver
+func sourceBindVar(driver string, n int) string {
+	switch driver {
+	case "mysql":
+		return "?"
+	case "oracle":
+		return fmt.Sprintf(":%d", n)
+	case "sqlserver", "mssql":
+		return fmt.Sprintf("@p%d", n)
+	default: // postgres
+		return fmt.Sprintf("$%d", n)
+	}
+}
+
+// PartitionQuery wraps a rule query with the range filter of a partition
+func (c *Connection) PartitionQuery(query, column string, p Partition) (string, []interface{}) {
+	base := fmt.Sprintf("SELECT * FROM (%s) dsp_part", strings.TrimRight(strings.TrimSpace(query), ";"))
+	if p.Nulls {
+		return fmt.Sprintf("%s WHERE %s IS NULL", base, column), nil
+	}
+
+	var conds []string
+	var args []interface{}
+	if p.Lower != nil {
+		args = append(args, p.Lower)
+		conds = append(conds, fmt.Sprintf("%s >= %s", column, sourceBindVar(c.Config.Driver, len(args))))
+	}
+	if p.Upper != nil {
+		args = append(args, p.Upper)
+		conds = append(conds, fmt.Sprintf("%s < %s", column, sourceBindVar(c.Config.Driver, len(args))))
+	}
+	if len(conds) == 0 {
+		return fmt.Sprintf("%s WHERE %s IS NOT NULL", base, column), nil
+	}
+	return fmt.Sprintf("%s WHERE %s", base, strings.Join(conds, " AND ")), args
+}
+
+// PlanPartitions splits the partition column of a query into ranges: at the explicit bounds
+// (see ParsePartitionBounds) or into count even ranges between the column's MIN and MAX.
+// The first and last ranges are open-ended and a last partition holds the NULL values, so
+// every row is extracted exactly once
+func (c *Connection) PlanPartitions(query, column string, count int, bounds string) ([]Partition, error) {
+	if !isValidTableName(column) {
+		return nil, fmt.Errorf("invalid partition column: %s", column)
+	}
+
+	var cuts []interface{}
+	if strings.TrimSpace(bounds) != "" {
+		var err error
+		if cuts, err = ParsePartitionBounds(bounds); err != nil {
+			return nil, err
+		}
+	} else {
+		if count < 2 {
+			return nil, fmt.Errorf("partition count must be at least 2")
+		}
+		var lo, hi interface{}
+		minMax := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM (%s) dsp_part", column, column, strings.TrimRight(strings.TrimSpace(query), ";"))
+		if err := c.DB.QueryRow(minMax).Scan(&lo, &hi); err != nil {
+			return nil, fmt.Errorf("failed to read partition column range: %w", err)
+		}
+		var err error
+		if cuts, err = partitionCuts(lo, hi, count); err != nil {
+			return nil, err
+		}
+	}
+
+	partitions := make([]Partition, 0, len(cuts)+2)
+	var lower interface{}
+	for _, cut := range cuts {
+		partitions = append(partitions, Partition{Index: len(partitions), Lower: lower, Upper: cut})
+		lower = cut
+	}
+	partitions = append(partitions, Partition{Index: len(partitions), Lower: lower})
+	partitions = append(partitions, Partition{Index: len(partitions), Nulls: true})
+	return partitions, nil
+}
+
+// ParsePartitionBounds reads explicit partition bounds (comma-separated) and sorts them.
+// Bounds that all read as integers, decimals or date/times are compared as such, others as
+// text; a repeated bound is an error
+func ParsePartitionBounds(bounds string) ([]interface{}, error) {
+	var texts []string
+	for _, b := range strings.Split(bounds, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			texts = append(texts, b)
+		}
+	}
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("partition bounds are empty")
+	}
+
+	cuts := make([]interface{}, len(texts))
+	var less func(a, b interface{}) bool
+	switch {
+	case allBounds(texts, cuts, func(s string) (interface{}, bool) { return partitionInt(s) }):
+		less = func(a, b interface{}) bool { return a.(int64) < b.(int64) }
+	case allBounds(texts, cuts, func(s string) (interface{}, bool) { return partitionFloat(s) }):
+		less = func(a, b interface{}) bool { return a.(float64) < b.(float64) }
+	case allBounds(texts, cuts, func(s string) (interface{}, bool) { return partitionTime(s) }):
+		less = func(a, b interface{}) bool { return a.(time.Time).Before(b.(time.Time)) }
+	default:
+		for i, t := range texts {
+			cuts[i] = t
+		}
+		less = func(a, b interface{}) bool { return a.(string) < b.(string) }
+	}
+
+	sort.SliceStable(cuts, func(i, j int) bool { return less(cuts[i], cuts[j]) })
+	for i := 1; i < len(cuts); i++ {
+		if !less(cuts[i-1], cuts[i]) {
+			return nil, fmt.Errorf("partition bound %v is repeated", cuts[i])
+		}
+	}
+	return cuts, nil
+}
+
+// allBounds converts every bound with parse into cuts, reporting whether all of them parsed
+func allBounds(texts []string, cuts []interface{}, parse func(string) (interface{}, bool)) bool {
+	for i, t := range texts {
+		v, ok := parse(t)
+		if !ok {
+			return false
+		}
+		cuts[i] = v
+	}
+	return true
+}
+
+// partitionCuts returns the inner boundaries splitting [lo, hi] into count even ranges
+func partitionCuts(lo, hi interface{}, count int) ([]interface{}, error) {
+	if lo == nil || hi == nil {
+		return nil, nil // Empty or all-NULL source: one open range plus the NULL range
+	}
+
+	if loInt, ok := partitionInt(lo); ok {
+		if hiInt, ok := partitionInt(hi); ok {
+			// Wide key ranges (snowflake or epoch-nanosecond IDs) overflow int64 arithmetic
+			span := new(big.Int).Sub(big.NewInt(hiInt), big.NewInt(loInt))
+			span.Add(span, big.NewInt(1))
+			if span.Cmp(big.NewInt(int64(count))) < 0 {
+				count = int(span.Int64())
+			}
+			var cuts []interface{}
+			for i := 1; i < count; i++ {
+				cut := new(big.Int).Mul(span, big.NewInt(int64(i)))
+				cut.Quo(cut, big.NewInt(int64(count)))
+				cut.Add(cut, big.NewInt(loInt))
+				if n := len(cuts); n > 0 && cut.Int64() <= cuts[n-1].(int64) {
+					return nil, fmt.Errorf("partition cuts of [%d, %d] are not increasing", loInt, hiInt)
+				}
+				cuts = append(cuts, cut.Int64())
+			}
+			return cuts, nil
+		}
+	}
+	if loFloat, ok := partitionFloat(lo); ok {
+		if hiFloat, ok := partitionFloat(hi); ok {
+			var cuts []interface{}
+			step := (hiFloat - loFloat) / float64(count)
+			for i := 1; i < count && step > 0; i++ {
+				cuts = append(cuts, loFloat+step*float64(i))
+			}
+			return cuts, nil
+		}
+	}
+	if loTime, ok := partitionTime(lo); ok {
+		if hiTime, ok := partitionTime(hi); ok {
+			var cuts []interface{}
+			step := hiTime.Sub(loTime) / time.Duration(count)
+			for i := 1; i < count && step > 0; i++ {
+				cuts = append(cuts, loTime.Add(step*time.Duration(i)))
+			}
+			return cuts, nil
+		}
+	}
+	return nil, fmt.Errorf("partition column must be numeric or date/time (got %T)", lo)
+}
+
+// partitionInt reads an integer MIN/MAX value
+func partitionInt(v interface{}) (int64, bool) {
+	switch val := v.(type) {
+	case int64:
+		return val, true
+	case int32:
+		return int64(val), true
+	case int:
+		return int64(val), true
+	case []byte:
+		n, err := strconv.ParseInt(strings.TrimSpace(string(val)), 10, 64)
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+		return n, err == nil
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return int64(val), true
+		}
+	}
+	return 0, false
+}
+
+// partitionFloat reads a decimal MIN/MAX value
+func partitionFloat(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case []byte:
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(val)), 64)
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// partitionTime reads a date/time MIN/MAX value
+func partitionTime(v interface{}) (time.Time, bool) {
+	var s string
+	switch val := v.(type) {
+	case time.Time:
+		return val, true
+	case []byte:
+		s = string(val)
+	case string:
+		s = val
+	default:
+		return time.Time{}, false
+	}
+	for _, layout := range TimeLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
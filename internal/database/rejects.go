@@ -0,0 +1,36 @@
+package database
+
+import "sync"
+
+// RejectedRow is a record the target database refused, with the error it returned
+type RejectedRow struct {
+	Record map[string]interface{}
+	Error  string
+}
+
+// rejectCollector gathers rejected rows, safe for the parallel writers. A nil collector
+// drops them (callers that only want the count)
+type rejectCollector struct {
+	mu   sync.Mutex
+	rows []RejectedRow
+}
+
+// add records a refused row
+func (c *rejectCollector) add(record map[string]interface{}, err error) {
+	if c == nil || err == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rows = append(c.rows, RejectedRow{Record: record, Error: err.Error()})
+}
+
+// list returns the collected rows
+func (c *rejectCollector) list() []RejectedRow {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rows
+}
@@ -0,0 +1,454 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Write modes for a rule's target table
+const (
+	WriteModeInsert = "insert" // Append rows, ignoring the unique key
+	WriteModeUpsert = "upsert" // Insert, or upsert on UniqueKeyColumn (default)
+	WriteModeSCD2   = "scd2"   // Keep every version of a row (slowly changing dimension type 2)
+)
+
+// ResolveWriteMode returns the write mode and unique key a writer applies: insert appends
+// every row, which is an upsert without a key
+func ResolveWriteMode(writeMode string, keyColumns []string) (string, []string) {
+	if writeMode == WriteModeInsert {
+		return WriteModeUpsert, nil
+	}
+	return writeMode, keyColumns
+}
+
+// History columns maintained on SCD2 targets
+const (
+	SCD2ValidFrom = "valid_from"
+	SCD2ValidTo   = "valid_to"
+	SCD2IsCurrent = "is_current"
+	SCD2RowHash   = "row_hash"
+)
+
+// scd2LookupChunk is the number of keys per current-version lookup or close statement
+const scd2LookupChunk = 1000
+
+// scd2Locks serializes SCD2 writes per target table, two batches carrying the same key
+// must not both see "no current version" and open two
+var scd2Locks sync.Map
+
+// SCD2Specs returns the history columns added to SCD2 targets
+func SCD2Specs() []ColumnSpec {
+	return []ColumnSpec{
+		{Name: SCD2ValidFrom, Type: "timestamptz"},
+		{Name: SCD2ValidTo, Type: "timestamptz"},
+		{Name: SCD2IsCurrent, Type: "boolean"},
+		{Name: SCD2RowHash, Type: "varchar", Length: 64},
+	}
+}
+
+// isSCD2Column reports whether a column is maintained by the SCD2 writer
+func isSCD2Column(name string) bool {
+	switch name {
+	case SCD2ValidFrom, SCD2ValidTo, SCD2IsCurrent, SCD2RowHash:
+		return true
+	}
+	return false
+}
+
+// scd2Version is an incoming row with its key and hash
+type scd2Version struct {
+	key    string
+	record map[string]interface{}
+	hash   string
+}
+
+// SCD2Batch writes a batch as SCD type 2: rows whose key has no current version are
+// inserted, rows whose hash differs from the current version close it (valid_to,
+// is_current = false) and open a new one, unchanged rows are skipped.
+// Returns the number of versions written
+func (tc *TargetConnection) SCD2Batch(tableName string, records []map[string]interface{}, keyColumns []string) (int, error) {
+	if len(keyColumns) == 0 {
+		return 0, fmt.Errorf("scd2 write mode requires a unique key")
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	var columns []string
+	for _, col := range recordColumns(records) {
+		if !isSCD2Column(col) {
+			columns = append(columns, col)
+		}
+	}
+
+	families, err := tc.keyFamilies(tableName, keyColumns)
+	if err != nil {
+		return 0, err
+	}
+
+	// Last occurrence of a key in the batch wins
+	byKey := make(map[string]*scd2Version, len(records))
+	var order []string
+	skipped := 0
+	for _, rec := range records {
+		values := make([]interface{}, len(keyColumns))
+		for i, col := range keyColumns {
+			values[i] = rec[col]
+		}
+		key, ok := scd2Key(values, families)
+		if !ok {
+			skipped++
+			continue
+		}
+		if _, ok := byKey[key]; !ok {
+			order = append(order, key)
+		}
+		byKey[key] = &scd2Version{key: key, record: rec, hash: scd2RowHash(rec, columns)}
+	}
+	if skipped > 0 {
+		log.Printf("⚠️ SCD2: skipped %d rows with a NULL key (%s) in %s", skipped, strings.Join(keyColumns, ", "), tableName)
+	}
+	if len(order) == 0 {
+		return 0, nil
+	}
+
+	lockKey := fmt.Sprintf("%s/%s:%s/%s/%s", tc.Config.Driver, tc.Config.Host, tc.Config.Port, tc.Config.DBName, tableName)
+	lock, _ := scd2Locks.LoadOrStore(lockKey, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	current, err := tc.currentSCD2Hashes(tableName, keyColumns, families, order, byKey)
+	if err != nil {
+		return 0, err
+	}
+
+	var changed []map[string]interface{}
+	var versions []*scd2Version
+	unchanged := 0
+	for _, key := range order {
+		v := byKey[key]
+		hash, exists := current[key]
+		switch {
+		case !exists:
+			versions = append(versions, v)
+		case hash != v.hash:
+			changed = append(changed, v.record)
+			versions = append(versions, v)
+		default:
+			unchanged++
+		}
+	}
+	if len(versions) == 0 {
+		log.Printf("SCD2: %d rows unchanged in %s", unchanged, tableName)
+		return 0, nil
+	}
+
+	now := time.Now().UTC()
+	txn, err := tc.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin scd2 transaction: %w", err)
+	}
+
+	// 1. Close the current versions of changed keys
+	for i := 0; i < len(changed); i += scd2LookupChunk {
+		end := i + scd2LookupChunk
+		if end > len(changed) {
+			end = len(changed)
+		}
+		match, keyArgs := tc.keyMatchClause(keyColumns, changed[i:end], 3)
+		args := append([]interface{}{now, false}, keyArgs...)
+		query := fmt.Sprintf("UPDATE %s SET %s = %s, %s = %s WHERE %s = %s AND %s",
+			tc.quoteIdent(tableName),
+			tc.quoteIdent(SCD2ValidTo), tc.bindVar(1),
+			tc.quoteIdent(SCD2IsCurrent), tc.bindVar(2),
+			tc.quoteIdent(SCD2IsCurrent), tc.boolLiteral(true),
+			match)
+		if _, err := txn.Exec(query, args...); err != nil {
+			txn.Rollback()
+			return 0, fmt.Errorf("failed to close changed versions: %w", err)
+		}
+	}
+
+	// 2. Open the new versions
+	insertCols := append(append([]string{}, columns...), SCD2ValidFrom, SCD2ValidTo, SCD2IsCurrent, SCD2RowHash)
+	rows := make([][]interface{}, len(versions))
+	for i, v := range versions {
+		row := make([]interface{}, 0, len(insertCols))
+		for _, col := range columns {
+			row = append(row, v.record[col])
+		}
+		rows[i] = append(row, now, nil, true, v.hash)
+	}
+	if err := tc.insertRowsTx(txn, tableName, insertCols, rows); err != nil {
+		txn.Rollback()
+		return 0, fmt.Errorf("failed to insert new versions: %w", err)
+	}
+
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit scd2 transaction: %w", err)
+	}
+
+	log.Printf("SCD2: %s — %d new, %d changed, %d unchanged", tableName, len(versions)-len(changed), len(changed), unchanged)
+	return len(versions), nil
+}
+
+// currentSCD2Hashes loads the row hash of the current version of each key
+func (tc *TargetConnection) currentSCD2Hashes(tableName string, keyColumns, families []string, keys []string, byKey map[string]*scd2Version) (map[string]string, error) {
+	selectCols := make([]string, 0, len(keyColumns)+1)
+	for _, col := range keyColumns {
+		selectCols = append(selectCols, tc.quoteIdent(col))
+	}
+	selectCols = append(selectCols, tc.quoteIdent(SCD2RowHash))
+
+	current := make(map[string]string, len(keys))
+	for i := 0; i < len(keys); i += scd2LookupChunk {
+		end := i + scd2LookupChunk
+		if end > len(keys) {
+			end = len(keys)
+		}
+		chunk := make([]map[string]interface{}, 0, end-i)
+		for _, key := range keys[i:end] {
+			chunk = append(chunk, byKey[key].record)
+		}
+
+		match, args := tc.keyMatchClause(keyColumns, chunk, 1)
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s AND %s",
+			strings.Join(selectCols, ", "), tc.quoteIdent(tableName),
+			tc.quoteIdent(SCD2IsCurrent), tc.boolLiteral(true), match)
+		rows, err := tc.DB.Query(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load current versions: %w", err)
+		}
+		for rows.Next() {
+			values := make([]interface{}, len(keyColumns))
+			dest := make([]interface{}, 0, len(keyColumns)+1)
+			for j := range values {
+				dest = append(dest, &values[j])
+			}
+			var hash sql.NullString
+			if err := rows.Scan(append(dest, &hash)...); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if key, ok := scd2Key(values, families); ok {
+				current[key] = hash.String
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return current, nil
+}
+
+// keyMatchClause matches records on their key: "k IN (...)" for a single column,
+// "((a = ? AND b = ?) OR ...)" for composite keys. Binds start at position start
+func (tc *TargetConnection) keyMatchClause(keyColumns []string, records []map[string]interface{}, start int) (string, []interface{}) {
+	args := make([]interface{}, 0, len(records)*len(keyColumns))
+	if len(keyColumns) == 1 {
+		for _, rec := range records {
+			args = append(args, rec[keyColumns[0]])
+		}
+		return fmt.Sprintf("%s IN (%s)", tc.quoteIdent(keyColumns[0]), tc.bindList(start, len(args))), args
+	}
+
+	groups := make([]string, len(records))
+	for i, rec := range records {
+		conds := make([]string, len(keyColumns))
+		for j, col := range keyColumns {
+			conds[j] = fmt.Sprintf("%s = %s", tc.quoteIdent(col), tc.bindVar(start+len(args)))
+			args = append(args, rec[col])
+		}
+		groups[i] = "(" + strings.Join(conds, " AND ") + ")"
+	}
+	return "(" + strings.Join(groups, " OR ") + ")", args
+}
+
+// insertRowsTx inserts rows inside a transaction: COPY on PostgreSQL, multi-row
+// VALUES on MySQL
+func (tc *TargetConnection) insertRowsTx(txn *sql.Tx, tableName string, columns []string, rows [][]interface{}) error {
+	if tc.Config.Driver == "postgres" {
+		stmt, err := txn.Prepare(pq.CopyIn(tableName, columns...))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, row := range rows {
+			if _, err := stmt.Exec(row...); err != nil {
+				return err
+			}
+		}
+		_, err = stmt.Exec()
+		return err
+	}
+
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = tc.quoteIdent(col)
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", tc.quoteIdent(tableName), strings.Join(quoted, ", "))
+
+	// Keep each statement under the dialect's parameter limit
+	chunk := 60000 / len(columns)
+	if chunk > 1000 {
+		chunk = 1000
+	}
+	if chunk < 1 {
+		chunk = 1
+	}
+
+	for i := 0; i < len(rows); i += chunk {
+		end := i + chunk
+		if end > len(rows) {
+			end = len(rows)
+		}
+		var sb strings.Builder
+		sb.WriteString(prefix)
+		args := make([]interface{}, 0, (end-i)*len(columns))
+		for j, row := range rows[i:end] {
+			if j > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString("(" + tc.bindList(len(args)+1, len(columns)) + ")")
+			args = append(args, row...)
+		}
+		if _, err := txn.Exec(sb.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindList returns n comma-separated bind placeholders starting at position start
+func (tc *TargetConnection) bindList(start, n int) string {
+	binds := make([]string, n)
+	for i := range binds {
+		binds[i] = tc.bindVar(start + i)
+	}
+	return strings.Join(binds, ", ")
+}
+
+// boolLiteral returns a boolean SQL literal for the target dialect
+func (tc *TargetConnection) boolLiteral(b bool) string {
+	if tc.Config.Driver == "postgres" {
+		return strings.ToUpper(strconv.FormatBool(b))
+	}
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// scd2RowHash hashes the non-null business columns of a row. Null columns are left out
+// so adding a new nullable column to the source doesn't open a version for every key
+func scd2RowHash(rec map[string]interface{}, columns []string) string {
+	h := sha256.New()
+	for _, col := range columns {
+		v, ok := rec[col]
+		if !ok || v == nil {
+			continue
+		}
+		h.Write([]byte(col))
+		h.Write([]byte{0x1f})
+		h.Write([]byte(comparableValue(v)))
+		h.Write([]byte{0x1e})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// keyFamilies reads the catalog type family of each key column (see columnFamily)
+func (tc *TargetConnection) keyFamilies(tableName string, keyColumns []string) ([]string, error) {
+	columns, err := tc.tableColumns(tableName)
+	if err != nil {
+		return nil, err
+	}
+	families := make([]string, len(keyColumns))
+	for i, key := range keyColumns {
+		for _, col := range columns {
+			if strings.EqualFold(col.Name, key) {
+				families[i], _ = columnFamily(col)
+				if col.DataType == "char" || col.DataType == "character" || col.DataType == "nchar" {
+					families[i] = "char"
+				}
+				break
+			}
+		}
+	}
+	return families, nil
+}
+
+// scd2Key builds the key of a current version lookup from the values of its key columns,
+// normalized by column type so incoming CSV text matches what the catalog returns
+// ("0123" and "123.00" as []byte for a numeric 123). ok is false when a key value is NULL
+func scd2Key(values []interface{}, families []string) (string, bool) {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			return "", false
+		}
+		parts[i] = normalizedKeyValue(v, families[i])
+	}
+	return strings.Join(parts, "\x1f"), true
+}
+
+// normalizedKeyValue formats a key value in the canonical form of its column family,
+// falling back to comparableValue when the value doesn't parse as that type
+func normalizedKeyValue(v interface{}, family string) string {
+	text := comparableValue(v)
+	switch family {
+	case "integer", "decimal", "float":
+		if r, ok := new(big.Rat).SetString(strings.TrimSpace(text)); ok {
+			return r.RatString()
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(strings.TrimSpace(text)); err == nil {
+			return strconv.FormatBool(b)
+		}
+	case "timestamp", "date":
+		if _, ok := v.(time.Time); !ok {
+			v = text // []byte from drivers that return timestamps as text
+		}
+		if t, ok := orderTime(v); ok {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+	case "uuid":
+		return strings.ToLower(text)
+	case "char":
+		// Fixed-length columns come back padded with blanks
+		return strings.TrimRight(text, " ")
+	}
+	return text
+}
+
+// comparableValue formats a key or column value so JSON, CSV and catalog values compare equal
+func comparableValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,872 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schema change policies applied when an incoming column is not compatible
+// with the existing target column
+const (
+	SchemaPolicyFail   = "fail"   // Abort the write with an error (default)
+	SchemaPolicyIgnore = "ignore" // Drop the column from the writes of this run
+	SchemaPolicyText   = "text"   // Convert the target column to a text type
+)
+
+// ColumnSpec describes a column the target table must be able to hold.
+// Type is a driver independent logical type: boolean, smallint, integer, bigint, decimal,
+// real, double, varchar, text, date, time, timestamp, timestamptz, uuid, json or binary.
+// Agents send these as the column type descriptor of query results
+type ColumnSpec struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Length    int    `json:"length,omitempty"`    // Max length for varchar (0 = unknown/unbounded)
+	Precision int    `json:"precision,omitempty"` // Total digits for decimal
+	Scale     int    `json:"scale,omitempty"`     // Fraction digits for decimal
+	NotNull   bool   `json:"not_null,omitempty"`  // Source column is declared NOT NULL
+	Inferred  bool   `json:"inferred,omitempty"`  // True when the type was guessed from values rather than declared by the source
+}
+
+// SchemaChanges reports what SyncTableSchema did to the target table
+type SchemaChanges struct {
+	Created    bool
+	Statements []string // DDL statements executed, in order
+	Ignored    []string // Incompatible columns that must be left out of the writes
+}
+
+// tableColumn is a column as reported by the target database catalog
+type tableColumn struct {
+	Name      string
+	DataType  string
+	Length    int // Character length, -1 for MAX/unbounded
+	Precision int
+	Scale     int
+}
+
+// schemaMu serializes DDL so concurrent batches of the same run don't race each other
+// adding the same column
+var schemaMu sync.Mutex
+
+// quoteIdent quotes a table or column name for the target SQL dialect
+func (tc *TargetConnection) quoteIdent(name string) string {
+	switch tc.Config.Driver {
+	case "mysql":
+		return "`" + name + "`"
+	default: // postgres
+		return `"` + name + `"`
+	}
+}
+
+// bindVar returns the n-th (1-based) bind placeholder for the target SQL dialect
+func (tc *TargetConnection) bindVar(n int) string {
+	switch tc.Config.Driver {
+	case "mysql":
+		return "?"
+	default: // postgres
+		return fmt.Sprintf("$%d", n)
+	}
+}
+
+// TableExists checks whether a table exists in the current schema of the target database
+func (tc *TargetConnection) TableExists(tableName string) (bool, error) {
+	var query string
+	switch tc.Config.Driver {
+	case "mysql":
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	default: // postgres
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+	}
+
+	var count int
+	if err := tc.DB.QueryRow(query, tableName).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check table existence: %w", err)
+	}
+	return count > 0, nil
+}
+
+// tableColumns reads the column definitions of an existing target table
+func (tc *TargetConnection) tableColumns(tableName string) ([]tableColumn, error) {
+	var query string
+	switch tc.Config.Driver {
+	case "mysql":
+		query = `SELECT column_name, data_type, COALESCE(character_maximum_length, 0),
+			COALESCE(numeric_precision, 0), COALESCE(numeric_scale, 0)
+			FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?
+			ORDER BY ordinal_position`
+	default: // postgres
+		query = `SELECT column_name, data_type, COALESCE(character_maximum_length, 0),
+			COALESCE(numeric_precision, 0), COALESCE(numeric_scale, 0)
+			FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1
+			ORDER BY ordinal_position`
+	}
+
+	rows, err := tc.DB.Query(query, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	var columns []tableColumn
+	for rows.Next() {
+		var col tableColumn
+		var length, precision, scale int64
+		if err := rows.Scan(&col.Name, &col.DataType, &length, &precision, &scale); err != nil {
+			return nil, fmt.Errorf("failed to scan column of %s: %w", tableName, err)
+		}
+		col.DataType = strings.ToLower(col.DataType)
+		col.Length = int(length)
+		col.Precision = int(precision)
+		col.Scale = int(scale)
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+// InferColumnSpecs derives column specs from JSON records, looking at every value
+// so mixed int/float columns become double and the longest string drives varchar length
+func InferColumnSpecs(records []map[string]interface{}) []ColumnSpec {
+	specs := make(map[string]*ColumnSpec)
+	for _, record := range records {
+		for name, value := range record {
+			spec, ok := specs[name]
+			if !ok {
+				spec = &ColumnSpec{Name: name, Inferred: true}
+				specs[name] = spec
+			}
+			mergeInferredType(spec, value)
+		}
+	}
+
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]ColumnSpec, 0, len(names))
+	for _, name := range names {
+		spec := specs[name]
+		if spec.Type == "" {
+			// Only nulls seen, nothing to go on
+			spec.Type = "text"
+		}
+		result = append(result, *spec)
+	}
+	return result
+}
+
+// mergeInferredType widens an inferred spec so it can also hold value
+func mergeInferredType(spec *ColumnSpec, value interface{}) {
+	var valueType string
+	switch v := value.(type) {
+	case nil:
+		return
+	case bool:
+		valueType = "boolean"
+	case int:
+		valueType = integerTypeFor(int64(v))
+	case int32:
+		valueType = integerTypeFor(int64(v))
+	case int64:
+		valueType = integerTypeFor(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			valueType = integerTypeFor(int64(v))
+		} else {
+			valueType = "double"
+		}
+	case float32:
+		valueType = "double"
+	case time.Time:
+		valueType = "timestamptz"
+	case string:
+		valueType = "varchar"
+		if l := len([]rune(v)); l > spec.Length {
+			spec.Length = l
+		}
+	default:
+		valueType = "text"
+	}
+
+	_, specInt := integerRank[spec.Type]
+	_, valueInt := integerRank[valueType]
+	switch {
+	case spec.Type == "" || spec.Type == valueType:
+		spec.Type = valueType
+	case specInt && valueInt:
+		if integerRank[valueType] > integerRank[spec.Type] {
+			spec.Type = valueType
+		}
+	case (specInt && valueType == "double") || (spec.Type == "double" && valueInt):
+		spec.Type = "double"
+	default:
+		spec.Type = "text"
+	}
+}
+
+// integerTypeFor is the narrowest integer type holding n, so inferred values only widen
+// existing integer columns they don't fit in
+func integerTypeFor(n int64) string {
+	switch {
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		return "smallint"
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		return "integer"
+	}
+	return "bigint"
+}
+
+// SpecCovers reports whether a column reconciled for known also accepts values of spec
+// without another schema sync: same type (or a narrower inferred integer) and no longer strings
+func SpecCovers(known, spec ColumnSpec) bool {
+	if spec.Length > known.Length {
+		return false
+	}
+	if known.Type == spec.Type {
+		return true
+	}
+	knownRank, knownInt := integerRank[known.Type]
+	rank, isInt := integerRank[spec.Type]
+	return knownInt && isInt && spec.Inferred && rank <= knownRank
+}
+
+// SourceColumnSpecs normalizes the column types reported by a source driver
+func SourceColumnSpecs(driver string, cts []*sql.ColumnType) []ColumnSpec {
+	specs := make([]ColumnSpec, len(cts))
+	for i, ct := range cts {
+		spec := ColumnSpec{Name: ct.Name()}
+		spec.Type = normalizeSourceType(strings.ToUpper(ct.DatabaseTypeName()))
+
+		if length, ok := ct.Length(); ok && length > 0 && length < 1<<31 {
+			spec.Length = int(length)
+		}
+		if precision, scale, ok := ct.DecimalSize(); ok && precision > 0 && precision < 1<<31 {
+			spec.Precision = int(precision)
+			spec.Scale = int(scale)
+		}
+		if nullable, ok := ct.Nullable(); ok && !nullable {
+			spec.NotNull = true
+		}
+
+		// Oracle reports integers as NUMBER(p,0)
+		if spec.Type == "decimal" && spec.Scale == 0 && spec.Precision > 0 {
+			switch {
+			case spec.Precision <= 4:
+				spec.Type = "smallint"
+			case spec.Precision <= 9:
+				spec.Type = "integer"
+			case spec.Precision <= 18:
+				spec.Type = "bigint"
+			}
+		}
+		if spec.Type != "varchar" {
+			spec.Length = 0
+		}
+		if spec.Type != "decimal" {
+			spec.Precision, spec.Scale = 0, 0
+		}
+		specs[i] = spec
+	}
+	return specs
+}
+
+// normalizeSourceType maps a driver's DatabaseTypeName to a logical column type
+func normalizeSourceType(dbType string) string {
+	dbType = strings.TrimPrefix(dbType, "UNSIGNED ")
+	switch dbType {
+	case "BOOL", "BOOLEAN", "BIT":
+		return "boolean"
+	case "INT2", "SMALLINT", "TINYINT", "YEAR":
+		return "smallint"
+	case "INT4", "INT", "INTEGER", "MEDIUMINT", "SERIAL":
+		return "integer"
+	case "INT8", "BIGINT", "BIGSERIAL":
+		return "bigint"
+	case "NUMERIC", "DECIMAL", "NUMBER", "MONEY", "SMALLMONEY":
+		return "decimal"
+	case "FLOAT4", "REAL", "BINARY_FLOAT", "IBFLOAT":
+		return "real"
+	case "FLOAT8", "FLOAT", "DOUBLE", "DOUBLE PRECISION", "BINARY_DOUBLE", "IBDOUBLE":
+		return "double"
+	case "VARCHAR", "NVARCHAR", "VARCHAR2", "NVARCHAR2", "CHAR", "NCHAR", "BPCHAR", "CHARACTER", "CHARACTER VARYING":
+		return "varchar"
+	case "TEXT", "NTEXT", "TINYTEXT", "MEDIUMTEXT", "LONGTEXT", "CLOB", "NCLOB", "LONG", "XML", "CITEXT":
+		return "text"
+	case "DATE":
+		return "date"
+	case "TIME", "TIMETZ":
+		return "time"
+	case "TIMESTAMP", "DATETIME", "DATETIME2", "SMALLDATETIME":
+		return "timestamp"
+	case "TIMESTAMPTZ", "DATETIMEOFFSET", "TIMESTAMP WITH TIME ZONE", "TIMESTAMP WITH LOCAL TIME ZONE", "TIMESTAMPTZ_DTY", "TIMESTAMPLTZ_DTY":
+		return "timestamptz"
+	case "UUID", "UNIQUEIDENTIFIER":
+		return "uuid"
+	case "JSON", "JSONB":
+		return "json"
+	case "BYTEA", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "IMAGE", "RAW", "LONG RAW":
+		return "binary"
+	}
+	return "text"
+}
+
+// TextColumnSpecs builds specs for columns whose types are unknown (e.g. CSV batches)
+func TextColumnSpecs(columns []string) []ColumnSpec {
+	specs := make([]ColumnSpec, len(columns))
+	for i, col := range columns {
+		specs[i] = ColumnSpec{Name: col, Type: "text", Inferred: true}
+	}
+	return specs
+}
+
+// columnDDLType maps a logical column type to the target dialect
+func (tc *TargetConnection) columnDDLType(spec ColumnSpec) string {
+	mysql := tc.Config.Driver == "mysql"
+	switch spec.Type {
+	case "boolean":
+		if mysql {
+			return "TINYINT(1)"
+		}
+		return "BOOLEAN"
+	case "smallint":
+		return "SMALLINT"
+	case "integer":
+		return "INT"
+	case "bigint":
+		return "BIGINT"
+	case "double":
+		if mysql {
+			return "DOUBLE"
+		}
+		return "DOUBLE PRECISION"
+	case "real":
+		if mysql {
+			return "FLOAT"
+		}
+		return "REAL"
+	case "decimal":
+		return tc.decimalType(spec.Precision, spec.Scale)
+	case "varchar":
+		if spec.Length > 0 && spec.Length <= 4000 {
+			return tc.varcharType(spec.Length)
+		}
+		return tc.textType()
+	case "date":
+		return "DATE"
+	case "time":
+		return "TIME"
+	case "timestamp":
+		if mysql {
+			return "DATETIME(6)"
+		}
+		return "TIMESTAMP"
+	case "timestamptz":
+		if mysql {
+			return "DATETIME(6)"
+		}
+		return "TIMESTAMPTZ"
+	case "uuid":
+		if mysql {
+			return "CHAR(36)"
+		}
+		return "UUID"
+	case "json":
+		if mysql {
+			return "JSON"
+		}
+		return "JSONB"
+	case "binary":
+		if mysql {
+			return "LONGBLOB"
+		}
+		return "BYTEA"
+	default:
+		return tc.textType()
+	}
+}
+
+// decimalType returns an exact numeric type, clamping precision to the dialect maximum
+func (tc *TargetConnection) decimalType(precision, scale int) string {
+	maxPrecision := 1000
+	if tc.Config.Driver == "mysql" {
+		maxPrecision = 65
+	}
+
+	if precision <= 0 {
+		if tc.Config.Driver == "mysql" {
+			return "DECIMAL(65,30)"
+		}
+		return "NUMERIC"
+	}
+	if precision > maxPrecision {
+		precision = maxPrecision
+	}
+	if scale > precision {
+		scale = precision
+	}
+	return fmt.Sprintf("DECIMAL(%d,%d)", precision, scale)
+}
+
+// varcharType returns a bounded string type for the target dialect
+func (tc *TargetConnection) varcharType(length int) string {
+	return fmt.Sprintf("VARCHAR(%d)", length)
+}
+
+// textType returns the unbounded string type for the target dialect
+func (tc *TargetConnection) textType() string {
+	if tc.Config.Driver == "mysql" {
+		return "LONGTEXT"
+	}
+	return "TEXT"
+}
+
+// createColumnType is the type used when a column is created by schema sync.
+// Inferred strings get an unbounded type and inferred integers a bigint, since one batch
+// says little about future lengths and values
+func (tc *TargetConnection) createColumnType(spec ColumnSpec) string {
+	if spec.Inferred && spec.Type == "varchar" {
+		return tc.textType()
+	}
+	if _, ok := integerRank[spec.Type]; ok && spec.Inferred {
+		return tc.columnDDLType(ColumnSpec{Type: "bigint"})
+	}
+	return tc.columnDDLType(spec)
+}
+
+// columnFamily classifies a catalog data type into a coarse family and, for integers,
+// a width rank so widening decisions don't depend on dialect spelling
+func columnFamily(col tableColumn) (family string, rank int) {
+	dt := col.DataType
+	switch {
+	case dt == "tinyint" || dt == "smallint":
+		return "integer", 1
+	case dt == "int" || dt == "integer" || dt == "mediumint":
+		return "integer", 2
+	case dt == "bigint":
+		return "integer", 3
+	case dt == "number":
+		// Oracle NUMBER with scale 0 and small precision behaves like an integer
+		if col.Scale == 0 && col.Precision > 0 {
+			switch {
+			case col.Precision <= 4:
+				return "integer", 1
+			case col.Precision <= 10:
+				return "integer", 2
+			case col.Precision <= 19:
+				return "integer", 3
+			}
+		}
+		return "decimal", 0
+	case dt == "numeric" || dt == "decimal" || dt == "money" || dt == "smallmoney":
+		return "decimal", 0
+	case dt == "real" || dt == "double precision" || dt == "double" || dt == "float" ||
+		dt == "binary_double" || dt == "binary_float":
+		return "float", 0
+	case dt == "boolean" || dt == "bit":
+		return "boolean", 0
+	case dt == "character varying" || dt == "varchar" || dt == "varchar2" || dt == "nvarchar" ||
+		dt == "nvarchar2" || dt == "character" || dt == "char" || dt == "nchar":
+		if col.Length < 0 {
+			return "text", 0
+		}
+		return "string", 0
+	case strings.Contains(dt, "text") || strings.Contains(dt, "clob") || dt == "long":
+		return "text", 0
+	case dt == "date":
+		return "date", 0
+	case strings.HasPrefix(dt, "time") && !strings.HasPrefix(dt, "timestamp"):
+		return "time", 0
+	case strings.HasPrefix(dt, "timestamp") || strings.HasPrefix(dt, "datetime") || dt == "smalldatetime":
+		return "timestamp", 0
+	case dt == "uuid" || dt == "uniqueidentifier":
+		return "uuid", 0
+	case dt == "json" || dt == "jsonb":
+		return "json", 0
+	case dt == "bytea" || strings.Contains(dt, "blob") || strings.Contains(dt, "binary") ||
+		dt == "raw" || dt == "long raw" || dt == "image":
+		return "binary", 0
+	}
+	return "other", 0
+}
+
+// planColumnChange decides how an existing column must change to accept spec.
+// It returns the widened type to ALTER to (empty = no change) or incompatible=true
+func (tc *TargetConnection) planColumnChange(existing tableColumn, spec ColumnSpec) (alterType string, incompatible bool) {
+	family, rank := columnFamily(existing)
+
+	// Anything can be written into an unbounded text column, and unknown
+	// types are left to the database to coerce
+	if family == "text" || family == "other" {
+		return "", false
+	}
+
+	if !spec.Inferred {
+		return tc.planDeclaredChange(existing, family, rank, spec)
+	}
+
+	switch spec.Type {
+	case "boolean":
+		switch family {
+		case "boolean", "integer", "string":
+			return "", false
+		}
+		return "", true
+	case "smallint", "integer", "bigint":
+		switch family {
+		case "integer":
+			// Only widen when the observed values exceed the column's range
+			if integerRank[spec.Type] > rank {
+				return tc.columnDDLType(ColumnSpec{Type: spec.Type}), false
+			}
+			return "", false
+		case "decimal", "float", "string":
+			return "", false
+		}
+		return "", true
+	case "double":
+		switch family {
+		case "decimal", "float", "string":
+			return "", false
+		}
+		return "", true
+	case "varchar", "text":
+		if family != "string" {
+			// Strings are handed to the database as literals; a value that doesn't
+			// parse is a data problem, not a schema one
+			return "", false
+		}
+		if spec.Type == "text" && !spec.Inferred {
+			return tc.textType(), false
+		}
+		if spec.Length > existing.Length && existing.Length > 0 {
+			newLen := existing.Length * 2
+			if newLen < spec.Length {
+				newLen = spec.Length
+			}
+			if newLen > 4000 {
+				return tc.textType(), false
+			}
+			return tc.varcharType(newLen), false
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// integerRank orders the logical integer types by width
+var integerRank = map[string]int{"smallint": 1, "integer": 2, "bigint": 3}
+
+// planDeclaredChange is planColumnChange for types declared by the source. Unlike inferred
+// types these are trusted, so a declared string no longer fits a numeric column
+func (tc *TargetConnection) planDeclaredChange(existing tableColumn, family string, rank int, spec ColumnSpec) (string, bool) {
+	switch spec.Type {
+	case "boolean":
+		switch family {
+		case "boolean", "integer", "string":
+			return "", false
+		}
+	case "smallint", "integer", "bigint":
+		switch family {
+		case "integer":
+			if integerRank[spec.Type] > rank {
+				return tc.columnDDLType(spec), false
+			}
+			return "", false
+		case "decimal", "float", "string":
+			return "", false
+		}
+	case "decimal":
+		switch family {
+		case "decimal":
+			if existing.Precision > 0 && spec.Precision > 0 &&
+				(spec.Precision-spec.Scale > existing.Precision-existing.Scale || spec.Scale > existing.Scale) {
+				scale := existing.Scale
+				if spec.Scale > scale {
+					scale = spec.Scale
+				}
+				intDigits := existing.Precision - existing.Scale
+				if spec.Precision-spec.Scale > intDigits {
+					intDigits = spec.Precision - spec.Scale
+				}
+				return tc.decimalType(intDigits+scale, scale), false
+			}
+			return "", false
+		case "integer":
+			if spec.Scale == 0 && spec.Precision > 0 && spec.Precision <= 18 {
+				if rank < 3 && spec.Precision > 9 {
+					return tc.columnDDLType(ColumnSpec{Type: "bigint"}), false
+				}
+				return "", false
+			}
+		case "float", "string":
+			return "", false
+		}
+	case "real", "double":
+		switch family {
+		case "float", "decimal", "string":
+			return "", false
+		}
+	case "varchar":
+		switch family {
+		case "string":
+			if spec.Length == 0 {
+				return tc.textType(), false
+			}
+			if existing.Length > 0 && spec.Length > existing.Length {
+				if spec.Length > 4000 {
+					return tc.textType(), false
+				}
+				return tc.varcharType(spec.Length), false
+			}
+			return "", false
+		case "uuid", "json":
+			return "", false
+		}
+	case "text":
+		switch family {
+		case "string":
+			return tc.textType(), false
+		case "json":
+			return "", false
+		}
+	case "date":
+		switch family {
+		case "date", "timestamp", "string":
+			return "", false
+		}
+	case "time":
+		switch family {
+		case "time", "string":
+			return "", false
+		}
+	case "timestamp", "timestamptz":
+		switch family {
+		case "timestamp", "string":
+			return "", false
+		case "date":
+			return tc.columnDDLType(spec), false
+		}
+	case "uuid":
+		switch family {
+		case "uuid":
+			return "", false
+		case "string":
+			if existing.Length >= 36 {
+				return "", false
+			}
+			return tc.varcharType(36), false
+		}
+	case "json":
+		switch family {
+		case "json":
+			return "", false
+		case "string":
+			return tc.textType(), false
+		}
+	case "binary":
+		if family == "binary" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return "", true
+}
+
+// alterColumnSQL builds the dialect specific statement to change a column type
+func (tc *TargetConnection) alterColumnSQL(tableName, column, newType string) string {
+	t, c := tc.quoteIdent(tableName), tc.quoteIdent(column)
+	switch tc.Config.Driver {
+	case "mysql":
+		return fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", t, c, newType)
+	default: // postgres
+		return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s", t, c, newType, c, newType)
+	}
+}
+
+// addColumnSQL builds the dialect specific statement to add a column
+func (tc *TargetConnection) addColumnSQL(tableName, column, colType string) string {
+	t, c := tc.quoteIdent(tableName), tc.quoteIdent(column)
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", t, c, colType)
+}
+
+// SyncTableSchema reconciles the target table with the incoming columns:
+// creates the table when missing, adds missing columns, widens compatible types
+// and applies policy to incompatible ones. Every executed statement is returned
+func (tc *TargetConnection) SyncTableSchema(tableName string, specs []ColumnSpec, policy string) (*SchemaChanges, error) {
+	if tableName == "" {
+		return nil, fmt.Errorf("table name is empty")
+	}
+	if !isValidTableName(tableName) {
+		return nil, fmt.Errorf("invalid table name: %s (only alphanumeric and underscore allowed)", tableName)
+	}
+	if policy == "" {
+		policy = SchemaPolicyFail
+	}
+
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+
+	changes := &SchemaChanges{}
+
+	exists, err := tc.TableExists(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		var defs []string
+		for _, spec := range specs {
+			if !isValidTableName(spec.Name) {
+				log.Printf("Skipping invalid column name: %s", spec.Name)
+				continue
+			}
+			def := fmt.Sprintf("%s %s", tc.quoteIdent(spec.Name), tc.createColumnType(spec))
+			// CSV batches carry empty strings as NULL, so string columns stay nullable
+			if spec.NotNull && spec.Type != "varchar" && spec.Type != "text" {
+				def += " NOT NULL"
+			}
+			defs = append(defs, def)
+		}
+		if len(defs) == 0 {
+			return nil, fmt.Errorf("no valid columns to create table %s", tableName)
+		}
+
+		createSQL := fmt.Sprintf("CREATE TABLE %s (%s)", tc.quoteIdent(tableName), strings.Join(defs, ", "))
+		if tc.Config.Driver == "postgres" || tc.Config.Driver == "mysql" {
+			createSQL = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", tc.quoteIdent(tableName), strings.Join(defs, ", "))
+		}
+		log.Printf("Creating table: %s", createSQL)
+		if _, err := tc.DB.Exec(createSQL); err != nil {
+			return nil, fmt.Errorf("failed to create table: %w", err)
+		}
+		changes.Created = true
+		changes.Statements = append(changes.Statements, createSQL)
+		return changes, nil
+	}
+
+	existing, err := tc.tableColumns(tableName)
+	if err != nil {
+		return nil, err
+	}
+	// Match case-insensitively: MySQL folds identifiers
+	byName := make(map[string]tableColumn, len(existing))
+	for _, col := range existing {
+		byName[strings.ToLower(col.Name)] = col
+	}
+
+	var statements []string
+	var conflicts []string
+	for _, spec := range specs {
+		if !isValidTableName(spec.Name) {
+			log.Printf("Skipping invalid column name: %s", spec.Name)
+			continue
+		}
+
+		col, ok := byName[strings.ToLower(spec.Name)]
+		if !ok {
+			statements = append(statements, tc.addColumnSQL(tableName, spec.Name, tc.createColumnType(spec)))
+			continue
+		}
+
+		alterType, incompatible := tc.planColumnChange(col, spec)
+		if incompatible {
+			desc := fmt.Sprintf("%s (%s -> %s)", col.Name, col.DataType, spec.Type)
+			switch policy {
+			case SchemaPolicyIgnore:
+				changes.Ignored = append(changes.Ignored, spec.Name)
+			case SchemaPolicyText:
+				statements = append(statements, tc.alterColumnSQL(tableName, col.Name, tc.textType()))
+			default:
+				conflicts = append(conflicts, desc)
+			}
+			continue
+		}
+		if alterType != "" {
+			statements = append(statements, tc.alterColumnSQL(tableName, col.Name, alterType))
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("incompatible column changes on %s: %s", tableName, strings.Join(conflicts, ", "))
+	}
+
+	for _, stmt := range statements {
+		log.Printf("Schema sync: %s", stmt)
+		if _, err := tc.DB.Exec(stmt); err != nil {
+			return changes, fmt.Errorf("schema change failed (%s): %w", stmt, err)
+		}
+		changes.Statements = append(changes.Statements, stmt)
+	}
+
+	return changes, nil
+}
+
+// EnsureUniqueKey creates a unique index on the key columns unless a unique index or
+// primary key on exactly those columns exists. Upserts (ON CONFLICT, ON DUPLICATE KEY)
+// need it to detect existing rows. Returns the DDL executed, if any
+func (tc *TargetConnection) EnsureUniqueKey(tableName string, keyColumns []string) (string, error) {
+	if len(keyColumns) == 0 {
+		return "", nil
+	}
+	for _, col := range keyColumns {
+		if !isValidTableName(col) {
+			return "", fmt.Errorf("invalid key column name: %s", col)
+		}
+	}
+
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+
+	indexes, err := tc.tableIndexes(tableName)
+	if err != nil {
+		return "", err
+	}
+	for _, idx := range indexes {
+		if (idx.Unique || idx.Primary) && sameColumnSet(idx.Columns, keyColumns) {
+			return "", nil
+		}
+	}
+
+	quoted := make([]string, len(keyColumns))
+	for i, col := range keyColumns {
+		quoted[i] = tc.quoteIdent(col)
+	}
+	suffix := "_" + strings.Join(keyColumns, "_") + "_key"
+	if len(suffix) > 32 {
+		// Long composite keys: a hash of the columns keeps the name short and unique
+		suffix = fmt.Sprintf("_uk%08x", crc32.ChecksumIEEE([]byte(strings.Join(keyColumns, ","))))
+	}
+	indexName := suffixedName(tableName, suffix)
+
+	stmt := fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s)", tc.quoteIdent(indexName), tc.quoteIdent(tableName), strings.Join(quoted, ", "))
+	log.Printf("Schema sync: %s", stmt)
+	if _, err := tc.DB.Exec(stmt); err != nil {
+		return "", fmt.Errorf("failed to create unique index on %s (%s): %w", tableName, strings.Join(keyColumns, ", "), err)
+	}
+	return stmt, nil
+}
+
+// sameColumnSet reports whether two column lists hold the same columns (case-insensitive)
+func sameColumnSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]bool, len(a))
+	for _, col := range a {
+		seen[strings.ToLower(col)] = true
+	}
+	for _, col := range b {
+		if !seen[strings.ToLower(col)] {
+			return false
+		}
+	}
+	return true
+}
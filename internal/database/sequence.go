@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// SequenceReset is the outcome of resynchronising one sequence of a table
+type SequenceReset struct {
+	Column   string
+	Sequence string // Sequence name (AUTO_INCREMENT for MySQL)
+	Next     int64  // Next value the target will hand out
+	Err      error
+}
+
+// String describes the reset for logs
+func (r SequenceReset) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s (%s): %v", r.Column, r.Sequence, r.Err)
+	}
+	return fmt.Sprintf("%s (%s) -> next %d", r.Column, r.Sequence, r.Next)
+}
+
+// sequenceColumn is a column backed by a sequence or identity generator
+type sequenceColumn struct {
+	name     string
+	sequence string
+}
+
+// ResyncSequences moves every serial, identity or AUTO_INCREMENT generator of a table
+// past MAX of its column, so the target application can insert again after rows were
+// synced with explicit IDs. Tables without generated columns return no resets
+func (tc *TargetConnection) ResyncSequences(tableName string) ([]SequenceReset, error) {
+	if !isValidTableName(tableName) {
+		return nil, fmt.Errorf("invalid table name: %s", tableName)
+	}
+
+	columns, err := tc.sequenceColumns(tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect sequences of %s: %w", tableName, err)
+	}
+
+	resets := make([]SequenceReset, 0, len(columns))
+	var failed []string
+	for _, col := range columns {
+		reset := SequenceReset{Column: col.name, Sequence: col.sequence}
+		reset.Next, reset.Err = tc.resyncSequence(tableName, col)
+		if reset.Err != nil {
+			failed = append(failed, col.name)
+			log.Printf("Failed to reset sequence %s of %s: %v", col.sequence, tableName, reset.Err)
+		} else {
+			log.Printf("Reset sequence %s of %s.%s, next value %d", col.sequence, tableName, col.name, reset.Next)
+		}
+		resets = append(resets, reset)
+	}
+
+	if len(failed) > 0 {
+		return resets, fmt.Errorf("failed to reset sequences of %s: %s", tableName, strings.Join(failed, ", "))
+	}
+	return resets, nil
+}
+
+// sequenceColumns lists the generated columns of a table from the target catalog
+func (tc *TargetConnection) sequenceColumns(tableName string) ([]sequenceColumn, error) {
+	var query string
+	switch tc.Config.Driver {
+	case "postgres":
+		// Serial defaults and identity columns, resolved to their owned sequence
+		query = `SELECT column_name, pg_get_serial_sequence($1, column_name)
+			FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $2
+			  AND (column_default LIKE 'nextval(%' OR is_identity = 'YES')
+			ORDER BY ordinal_position`
+	case "mysql":
+		query = `SELECT column_name, 'AUTO_INCREMENT' FROM information_schema.columns
+			WHERE table_schema = DATABASE() AND table_name = ? AND extra LIKE '%auto_increment%'`
+	default:
+		return nil, nil
+	}
+
+	var rows *sql.Rows
+	var err error
+	if tc.Config.Driver == "postgres" {
+		rows, err = tc.DB.Query(query, tc.quoteIdent(tableName), tableName)
+	} else {
+		rows, err = tc.DB.Query(query, tableName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []sequenceColumn
+	for rows.Next() {
+		var name string
+		var sequence sql.NullString
+		if err := rows.Scan(&name, &sequence); err != nil {
+			return nil, err
+		}
+		if !sequence.Valid || sequence.String == "" {
+			continue // Default calls nextval() on a sequence the column doesn't own
+		}
+		columns = append(columns, sequenceColumn{name: name, sequence: sequence.String})
+	}
+	return columns, rows.Err()
+}
+
+// resyncSequence moves one generator past MAX of its column and returns its next value
+func (tc *TargetConnection) resyncSequence(tableName string, col sequenceColumn) (int64, error) {
+	table := tc.quoteIdent(tableName)
+	column := tc.quoteIdent(col.name)
+
+	switch tc.Config.Driver {
+	case "postgres":
+		// is_called=false: the next nextval() returns exactly MAX + 1 (1 on an empty table)
+		var next int64
+		err := tc.DB.QueryRow(fmt.Sprintf(
+			"SELECT setval($1::regclass, COALESCE(MAX(%s), 0) + 1, false) FROM %s", column, table,
+		), col.sequence).Scan(&next)
+		return next, err
+
+	case "mysql":
+		var next int64
+		if err := tc.DB.QueryRow(fmt.Sprintf("SELECT COALESCE(MAX(%s), 0) + 1 FROM %s", column, table)).Scan(&next); err != nil {
+			return 0, err
+		}
+		// InnoDB never lowers AUTO_INCREMENT below MAX + 1, so this only ever moves it forward
+		_, err := tc.DB.Exec(fmt.Sprintf("ALTER TABLE %s AUTO_INCREMENT = %d", table, next))
+		return next, err
+	}
+	return 0, fmt.Errorf("sequence reset not supported for driver %s", tc.Config.Driver)
+}
+
+// ResetAllSequences resyncs the sequences of all specified tables
+func (tc *TargetConnection) ResetAllSequences(tables []string) error {
+	var errors []string
+	for _, table := range tables {
+		if _, err := tc.ResyncSequences(table); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("some sequences failed to reset: %s", strings.Join(errors, "; "))
+	}
+	return nil
+}
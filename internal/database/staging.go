@@ -0,0 +1,297 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Load modes for a rule's target table
+const (
+	LoadModeDirect      = "direct"       // Write straight into the live table (default)
+	LoadModeStagingSwap = "staging_swap" // Write into a per-run staging table and swap it in at the end
+)
+
+// indexDef is an index read from the catalog so it can be recreated on a staging table
+type indexDef struct {
+	Name    string
+	Unique  bool
+	Primary bool
+	Columns []string
+}
+
+// StagingTableName returns the per-run staging table name of a live table
+func StagingTableName(liveTable string, runID uint) string {
+	return suffixedName(liveTable, fmt.Sprintf("_stg%d", runID))
+}
+
+// suffixedName appends suffix to name, trimming name so the result stays a valid identifier
+func suffixedName(name, suffix string) string {
+	if limit := 63 - len(suffix); len(name) > limit {
+		name = name[:limit]
+	}
+	return name + suffix
+}
+
+// CountRows returns the number of rows in a table
+func (tc *TargetConnection) CountRows(tableName string) (int64, error) {
+	var count int64
+	err := tc.DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", tc.quoteIdent(tableName))).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rows of %s: %w", tableName, err)
+	}
+	return count, nil
+}
+
+// DropTableIfExists drops a table, doing nothing when it doesn't exist
+func (tc *TargetConnection) DropTableIfExists(tableName string) (string, error) {
+	if !isValidTableName(tableName) {
+		return "", fmt.Errorf("invalid table name: %s", tableName)
+	}
+
+	stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s", tc.quoteIdent(tableName))
+	if _, err := tc.DB.Exec(stmt); err != nil {
+		return "", fmt.Errorf("failed to drop %s: %w", tableName, err)
+	}
+	return stmt, nil
+}
+
+// PrepareStagingTable (re)creates staging as an empty copy of the live table, with the
+// same columns, defaults and indexes. When the live table doesn't exist yet nothing is
+// created and the staging table is built from the first batch by SyncTableSchema
+func (tc *TargetConnection) PrepareStagingTable(liveTable, stagingTable string) ([]string, error) {
+	if !isValidTableName(liveTable) || !isValidTableName(stagingTable) {
+		return nil, fmt.Errorf("invalid table name: %s / %s", liveTable, stagingTable)
+	}
+
+	var statements []string
+	drop, err := tc.DropTableIfExists(stagingTable)
+	if err != nil {
+		return nil, err
+	}
+	if drop != "" {
+		statements = append(statements, drop)
+	}
+
+	exists, err := tc.TableExists(liveTable)
+	if err != nil {
+		return statements, err
+	}
+	if !exists {
+		return statements, nil
+	}
+
+	live, stg := tc.quoteIdent(liveTable), tc.quoteIdent(stagingTable)
+	create := fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING ALL)", stg, live)
+	if tc.Config.Driver == "mysql" {
+		create = fmt.Sprintf("CREATE TABLE %s LIKE %s", stg, live)
+	}
+
+	log.Printf("Staging: %s", create)
+	if _, err := tc.DB.Exec(create); err != nil {
+		return statements, fmt.Errorf("failed to prepare staging table (%s): %w", create, err)
+	}
+	return append(statements, create), nil
+}
+
+// tableIndexes reads the indexes of a table. Partial and expression indexes are left out
+func (tc *TargetConnection) tableIndexes(tableName string) ([]indexDef, error) {
+	var query string
+	switch tc.Config.Driver {
+	case "postgres":
+		query = `SELECT i.relname,
+				CASE WHEN ix.indisunique THEN 1 ELSE 0 END,
+				CASE WHEN ix.indisprimary THEN 1 ELSE 0 END,
+				a.attname
+			FROM pg_index ix
+			JOIN pg_class i ON i.oid = ix.indexrelid
+			JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, ord) ON true
+			JOIN pg_attribute a ON a.attrelid = ix.indrelid AND a.attnum = k.attnum
+			WHERE ix.indrelid = to_regclass(quote_ident($1)) AND ix.indpred IS NULL AND ix.indexprs IS NULL
+			ORDER BY i.relname, k.ord`
+	case "mysql":
+		query = `SELECT index_name,
+				CASE WHEN non_unique = 0 THEN 1 ELSE 0 END,
+				CASE WHEN index_name = 'PRIMARY' THEN 1 ELSE 0 END,
+				column_name
+			FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = ? AND column_name IS NOT NULL
+			ORDER BY index_name, seq_in_index`
+	default:
+		return nil, nil
+	}
+
+	rows, err := tc.DB.Query(query, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexes of %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	var indexes []indexDef
+	for rows.Next() {
+		var name, column string
+		var unique, primary int
+		if err := rows.Scan(&name, &unique, &primary, &column); err != nil {
+			return nil, err
+		}
+		if n := len(indexes); n > 0 && indexes[n-1].Name == name {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, column)
+			continue
+		}
+		indexes = append(indexes, indexDef{Name: name, Unique: unique == 1, Primary: primary == 1, Columns: []string{column}})
+	}
+	return indexes, rows.Err()
+}
+
+// grantStatements returns GRANT statements that give newTable the privileges granted on table
+func (tc *TargetConnection) grantStatements(tableName, newTable string) ([]string, error) {
+	var query string
+	switch tc.Config.Driver {
+	case "mysql":
+		// MySQL table privileges are bound to the name, the swapped table inherits them
+		return nil, nil
+	default: // postgres
+		query = `SELECT privilege_type, grantee FROM information_schema.role_table_grants
+			WHERE table_schema = current_schema() AND table_name = $1 AND grantee <> current_user`
+	}
+
+	rows, err := tc.DB.Query(query, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read grants of %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	var statements []string
+	for rows.Next() {
+		var privilege, grantee string
+		if err := rows.Scan(&privilege, &grantee); err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(grantee, "PUBLIC") {
+			grantee = tc.quoteIdent(grantee)
+		}
+		statements = append(statements, fmt.Sprintf("GRANT %s ON %s TO %s", privilege, tc.quoteIdent(newTable), grantee))
+	}
+	return statements, rows.Err()
+}
+
+// ownedSequenceStatements returns statements moving serial sequences owned by the live
+// table's columns to the staging table, so dropping the old table doesn't drop them (PostgreSQL)
+func (tc *TargetConnection) ownedSequenceStatements(liveTable, stagingTable string) ([]string, error) {
+	if tc.Config.Driver != "postgres" {
+		return nil, nil
+	}
+
+	rows, err := tc.DB.Query(`SELECT a.attname, pg_get_serial_sequence(quote_ident($1), a.attname)
+		FROM pg_attribute a
+		WHERE a.attrelid = to_regclass(quote_ident($1)) AND a.attnum > 0 AND NOT a.attisdropped
+			AND a.attidentity = '' AND pg_get_serial_sequence(quote_ident($1), a.attname) IS NOT NULL`, liveTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sequences of %s: %w", liveTable, err)
+	}
+	defer rows.Close()
+
+	var statements []string
+	for rows.Next() {
+		var column, sequence string
+		if err := rows.Scan(&column, &sequence); err != nil {
+			return nil, err
+		}
+		statements = append(statements, fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s.%s",
+			sequence, tc.quoteIdent(stagingTable), tc.quoteIdent(column)))
+	}
+	return statements, rows.Err()
+}
+
+// SwapResult is the outcome of a staging swap
+type SwapResult struct {
+	Statements []string // Executed statements
+	Warning    error    // Cleanup failure after the swap succeeded, e.g. the old table was left behind
+}
+
+// SwapStagingTable replaces the live table with the staging table and drops the old one.
+// Grants and serial sequence ownership are carried over. PostgreSQL swaps in one
+// transaction, MySQL uses a single atomic RENAME TABLE and drops the old table afterwards.
+// An error means the live table was left as it was; a failure after the swap only sets
+// the result's Warning
+func (tc *TargetConnection) SwapStagingTable(liveTable, stagingTable string, runID uint) (SwapResult, error) {
+	var result SwapResult
+	if !isValidTableName(liveTable) || !isValidTableName(stagingTable) {
+		return result, fmt.Errorf("invalid table name: %s / %s", liveTable, stagingTable)
+	}
+
+	exists, err := tc.TableExists(liveTable)
+	if err != nil {
+		return result, err
+	}
+
+	live, stg := tc.quoteIdent(liveTable), tc.quoteIdent(stagingTable)
+	oldTable := suffixedName(liveTable, fmt.Sprintf("_old%d", runID))
+	old := tc.quoteIdent(oldTable)
+
+	var statements []string
+	if exists {
+		grants, err := tc.grantStatements(liveTable, stagingTable)
+		if err != nil {
+			return result, err
+		}
+		sequences, err := tc.ownedSequenceStatements(liveTable, stagingTable)
+		if err != nil {
+			return result, err
+		}
+		statements = append(statements, grants...)
+		statements = append(statements, sequences...)
+	}
+
+	if tc.Config.Driver == "mysql" {
+		// DDL commits implicitly: the RENAME swaps both tables atomically, so once it
+		// succeeded the swap is done and dropping the old table is cleanup only
+		rename := fmt.Sprintf("RENAME TABLE %s TO %s", stg, live)
+		if exists {
+			rename = fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s", live, old, stg, live)
+		}
+		log.Printf("Staging swap: %s", rename)
+		if _, err := tc.DB.Exec(rename); err != nil {
+			return result, fmt.Errorf("staging swap failed (%s): %w", rename, err)
+		}
+		result.Statements = append(statements, rename)
+
+		if exists {
+			drop := fmt.Sprintf("DROP TABLE %s", old)
+			log.Printf("Staging swap: %s", drop)
+			if _, err := tc.DB.Exec(drop); err != nil {
+				result.Warning = fmt.Errorf("failed to drop old table %s: %w", oldTable, err)
+			} else {
+				result.Statements = append(result.Statements, drop)
+			}
+		}
+		return result, nil
+	}
+
+	// PostgreSQL DDL is transactional, a failing statement rolls the whole swap back
+	if exists {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", live, old))
+	}
+	statements = append(statements, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", stg, live))
+	if exists {
+		// No CASCADE: dependent views or foreign keys abort the swap instead of being dropped
+		statements = append(statements, fmt.Sprintf("DROP TABLE %s", old))
+	}
+
+	tx, err := tc.DB.Begin()
+	if err != nil {
+		return result, fmt.Errorf("failed to begin swap transaction: %w", err)
+	}
+	for _, stmt := range statements {
+		log.Printf("Staging swap: %s", stmt)
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return result, fmt.Errorf("staging swap failed (%s): %w", stmt, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("failed to commit swap: %w", err)
+	}
+	result.Statements = statements
+	return result, nil
+}
@@ -3,9 +3,12 @@ package database
 import (
 	"database/sql"
 	encoding_csv "encoding/csv"
+	"encoding/hex"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -112,152 +115,15 @@ func (tc *TargetConnection) Close() error {
 	return nil
 }
 
-// ResetSequence resets the sequence for a table's primary key column to MAX(id) + 1
-// This prevents unique constraint violations when the application inserts new records
-// after syncing data with explicit IDs
-func (tc *TargetConnection) ResetSequence(tableName string, primaryKeyColumn string) error {
-	if tc.Config.Driver != "postgres" {
-		// Only PostgreSQL needs sequence reset, MySQL auto-increment handles this automatically
-		log.Printf("Sequence reset not needed for driver: %s", tc.Config.Driver)
-		return nil
-	}
-
-	if !isValidTableName(tableName) || !isValidTableName(primaryKeyColumn) {
-		return fmt.Errorf("invalid table or column name")
-	}
-
-	// Try common sequence naming conventions
-	sequenceNames := []string{
-		fmt.Sprintf("%s_%s_seq", tableName, primaryKeyColumn), // Standard: table_column_seq
-		fmt.Sprintf("%s_id_seq", tableName),                   // Common: table_id_seq
-		fmt.Sprintf("%s_pkey_seq", tableName),                 // Alternative: table_pkey_seq
-	}
-
-	var lastErr error
-	for _, seqName := range sequenceNames {
-		// Check if sequence exists
-		var exists bool
-		checkQuery := "SELECT EXISTS (SELECT FROM pg_sequences WHERE schemaname = 'public' AND sequencename = $1)"
-		err := tc.DB.QueryRow(checkQuery, seqName).Scan(&exists)
-		if err != nil {
-			lastErr = err
-			continue
-		}
-
-		if !exists {
-			continue
-		}
-
-		// Reset sequence to MAX(primary_key) + 1
-		resetQuery := fmt.Sprintf(
-			`SELECT setval('%s', COALESCE((SELECT MAX("%s") FROM "%s"), 0) + 1, false)`,
-			seqName, primaryKeyColumn, tableName,
-		)
-
-		_, err = tc.DB.Exec(resetQuery)
-		if err != nil {
-			lastErr = err
-			log.Printf("Failed to reset sequence %s: %v", seqName, err)
-			continue
-		}
-
-		log.Printf("Successfully reset sequence %s for table %s", seqName, tableName)
-		return nil
-	}
-
-	// If no known sequence found, try to find it from pg_attribute
-	findSeqQuery := `
-		SELECT pg_get_serial_sequence($1, $2)
-	`
-	var seqName sql.NullString
-	err := tc.DB.QueryRow(findSeqQuery, tableName, primaryKeyColumn).Scan(&seqName)
-	if err == nil && seqName.Valid && seqName.String != "" {
-		resetQuery := fmt.Sprintf(
-			`SELECT setval('%s', COALESCE((SELECT MAX("%s") FROM "%s"), 0) + 1, false)`,
-			seqName.String, primaryKeyColumn, tableName,
-		)
-		_, err = tc.DB.Exec(resetQuery)
-		if err == nil {
-			log.Printf("Successfully reset sequence %s for table %s (auto-detected)", seqName.String, tableName)
-			return nil
-		}
-		lastErr = err
-	}
-
-	if lastErr != nil {
-		return fmt.Errorf("failed to reset sequence for %s.%s: %w", tableName, primaryKeyColumn, lastErr)
-	}
-
-	log.Printf("No sequence found for table %s column %s (might not be a serial/identity column)", tableName, primaryKeyColumn)
-	return nil
-}
-
-// ResetAllSequences resets sequences for all specified tables
-func (tc *TargetConnection) ResetAllSequences(tables []string, primaryKeyColumn string) error {
-	if primaryKeyColumn == "" {
-		primaryKeyColumn = "id" // Default to 'id'
-	}
-
-	var errors []string
-	for _, table := range tables {
-		if err := tc.ResetSequence(table, primaryKeyColumn); err != nil {
-			errors = append(errors, fmt.Sprintf("%s: %v", table, err))
-		}
-	}
-
-	if len(errors) > 0 {
-		return fmt.Errorf("some sequences failed to reset: %s", strings.Join(errors, "; "))
-	}
-
-	return nil
-}
-
-// EnsureTable creates target table if not exists based on data structure
-func (tc *TargetConnection) EnsureTable(tableName string, sampleRecord map[string]interface{}) error {
-	if tableName == "" {
-		return fmt.Errorf("table name is empty")
-	}
-
-	// Validate table name to prevent SQL injection (allow only alphanumeric and underscore)
-	if !isValidTableName(tableName) {
-		return fmt.Errorf("invalid table name: %s (only alphanumeric and underscore allowed)", tableName)
-	}
-
-	// Check if table exists using parameterized query
-	var exists bool
-	query := "SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = $1)"
-	err := tc.DB.QueryRow(query, tableName).Scan(&exists)
-	if err != nil {
-		return fmt.Errorf("failed to check table existence: %w", err)
-	}
-
-	if exists {
-		log.Printf("Table %s already exists", tableName)
-		return nil
-	}
-
-	// Build CREATE TABLE statement from sample record
-	var columns []string
-	for colName, value := range sampleRecord {
-		// Validate column name as well
-		if !isValidTableName(colName) {
-			log.Printf("Skipping invalid column name: %s", colName)
-			continue
-		}
-		colType := inferPostgresType(value)
-		columns = append(columns, fmt.Sprintf("\"%s\" %s", colName, colType))
-	}
-
-	createSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS \"%s\" (%s)", tableName, strings.Join(columns, ", "))
-	log.Printf("Creating table: %s", createSQL)
-
-	_, err = tc.DB.Exec(createSQL)
-	if err != nil {
-		return fmt.Errorf("failed to create table: %w", err)
+// EnsureTable creates target table if not exists based on data structure,
+// adds any columns of sampleRecord the table doesn't have yet and creates
+// the unique index of the key columns (if any)
+func (tc *TargetConnection) EnsureTable(tableName string, sampleRecord map[string]interface{}, keyColumns []string) error {
+	if _, err := tc.SyncTableSchema(tableName, InferColumnSpecs([]map[string]interface{}{sampleRecord}), SchemaPolicyFail); err != nil {
+		return err
 	}
-
-	log.Printf("Table %s created successfully", tableName)
-	return nil
+	_, err := tc.EnsureUniqueKey(tableName, keyColumns)
+	return err
 }
 
 // isValidTableName checks if table/column name contains only safe characters
@@ -270,45 +136,39 @@ func isValidTableName(name string) bool {
 	return len(name) > 0 && len(name) <= 63 // PostgreSQL max identifier length
 }
 
-// inferPostgresType infers PostgreSQL column type from Go value
-func inferPostgresType(value interface{}) string {
-	switch value.(type) {
-	case int, int32, int64, float64:
-		if _, ok := value.(float64); ok {
-			// Check if it's actually an integer
-			f := value.(float64)
-			if f == float64(int64(f)) {
-				return "BIGINT"
+// recordColumns returns the sorted union of column names across records
+func recordColumns(records []map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var columns []string
+	for _, record := range records {
+		for col := range record {
+			if !seen[col] {
+				seen[col] = true
+				columns = append(columns, col)
 			}
-			return "DOUBLE PRECISION"
 		}
-		return "BIGINT"
-	case bool:
-		return "BOOLEAN"
-	case nil:
-		return "TEXT"
-	default:
-		return "TEXT"
 	}
+	sort.Strings(columns)
+	return columns
 }
 
 // InsertBatch inserts records into target table with conflict handling
 // Supports: PostgreSQL, MySQL, Oracle
 // Optimized with batch multi-row VALUES for better performance
-func (tc *TargetConnection) InsertBatch(tableName string, records []map[string]interface{}) (int, error) {
+// Rows the target refuses (after the per-record fallback) are returned as rejected
+func (tc *TargetConnection) InsertBatch(tableName string, records []map[string]interface{}) (int, []RejectedRow, error) {
 	if len(records) == 0 {
-		return 0, nil
+		return 0, nil, nil
 	}
 
-	// Get column names from first record
-	var columns []string
-	for col := range records[0] {
-		columns = append(columns, col)
-	}
+	// Column union across the batch — later records may carry columns the first one lacks
+	columns := recordColumns(records)
+	rejects := &rejectCollector{}
 
 	// Oracle doesn't support multi-row VALUES, use per-record insert
 	if tc.Config.Driver == "oracle" {
-		return tc.insertBatchOracle(tableName, records, columns)
+		count, err := tc.insertBatchOracle(tableName, records, columns, rejects)
+		return count, rejects.list(), err
 	}
 
 	insertedCount := 0
@@ -336,7 +196,7 @@ func (tc *TargetConnection) InsertBatch(tableName string, records []map[string]i
 			log.Printf("Batch insert error (batch %d-%d): %v", i, end, err)
 			// Fallback to per-record for this batch if batch fails
 			for _, record := range batch {
-				if c := tc.insertSingleRecord(tableName, record, columns); c > 0 {
+				if c := tc.insertSingleRecord(tableName, record, columns, rejects); c > 0 {
 					insertedCount += c
 				}
 			}
@@ -347,7 +207,7 @@ func (tc *TargetConnection) InsertBatch(tableName string, records []map[string]i
 	}
 
 	log.Printf("Inserted %d records to %s (driver: %s)", insertedCount, tableName, tc.Config.Driver)
-	return insertedCount, nil
+	return insertedCount, rejects.list(), nil
 }
 
 // insertBatchPostgres inserts multiple records using PostgreSQL COPY protocol
@@ -449,12 +309,14 @@ func (tc *TargetConnection) insertBatchMySQL(tableName string, records []map[str
 		valueRows = append(valueRows, fmt.Sprintf("(%s)", strings.Join(placeholders, ", ")))
 	}
 
-	// MySQL: INSERT IGNORE to skip duplicates
+	// MySQL: skip duplicates with a no-op update. Unlike INSERT IGNORE this still fails on
+	// bad values (truncation, out of range), so those rows reach the rejects
 	insertSQL := fmt.Sprintf(
-		"INSERT IGNORE INTO `%s` (`%s`) VALUES %s",
+		"INSERT INTO `%s` (`%s`) VALUES %s ON DUPLICATE KEY UPDATE `%s` = `%s`",
 		tableName,
 		strings.Join(columns, "`, `"),
 		strings.Join(valueRows, ", "),
+		columns[0], columns[0],
 	)
 
 	result, err := tc.DB.Exec(insertSQL, allValues...)
@@ -467,7 +329,7 @@ func (tc *TargetConnection) insertBatchMySQL(tableName string, records []map[str
 }
 
 // insertBatchOracle inserts records one by one for Oracle (no multi-row VALUES support)
-func (tc *TargetConnection) insertBatchOracle(tableName string, records []map[string]interface{}, columns []string) (int, error) {
+func (tc *TargetConnection) insertBatchOracle(tableName string, records []map[string]interface{}, columns []string, rejects *rejectCollector) (int, error) {
 	insertedCount := 0
 
 	for _, record := range records {
@@ -489,6 +351,7 @@ func (tc *TargetConnection) insertBatchOracle(tableName string, records []map[st
 		result, err := tc.DB.Exec(insertSQL, values...)
 		if err != nil {
 			log.Printf("Oracle insert error (skipping): %v", err)
+			rejects.add(record, err)
 			continue
 		}
 
@@ -500,7 +363,7 @@ func (tc *TargetConnection) insertBatchOracle(tableName string, records []map[st
 }
 
 // insertSingleRecord inserts a single record (fallback for batch errors)
-func (tc *TargetConnection) insertSingleRecord(tableName string, record map[string]interface{}, columns []string) int {
+func (tc *TargetConnection) insertSingleRecord(tableName string, record map[string]interface{}, columns []string, rejects *rejectCollector) int {
 	var values []interface{}
 	var placeholders []string
 	var insertSQL string
@@ -519,10 +382,11 @@ func (tc *TargetConnection) insertSingleRecord(tableName string, record map[stri
 	switch tc.Config.Driver {
 	case "mysql":
 		insertSQL = fmt.Sprintf(
-			"INSERT IGNORE INTO `%s` (`%s`) VALUES (%s)",
+			"INSERT INTO `%s` (`%s`) VALUES (%s) ON DUPLICATE KEY UPDATE `%s` = `%s`",
 			tableName,
 			strings.Join(columns, "`, `"),
 			strings.Join(placeholders, ", "),
+			columns[0], columns[0],
 		)
 	default: // postgres
 		insertSQL = fmt.Sprintf(
@@ -536,6 +400,7 @@ func (tc *TargetConnection) insertSingleRecord(tableName string, record map[stri
 	result, err := tc.DB.Exec(insertSQL, values...)
 	if err != nil {
 		log.Printf("Single insert error (driver: %s, skipping): %v", tc.Config.Driver, err)
+		rejects.add(record, err)
 		return 0
 	}
 
@@ -543,68 +408,137 @@ func (tc *TargetConnection) insertSingleRecord(tableName string, record map[stri
 	return int(affected)
 }
 
-// UpsertBatch inserts or updates records based on unique key column
+// ParseKeyColumns splits an ordered unique key definition such as "kode_wilayah, periode, nik"
+func ParseKeyColumns(def string) []string {
+	var keys []string
+	for _, col := range strings.Split(def, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			keys = append(keys, col)
+		}
+	}
+	return keys
+}
+
+// containsColumn reports whether col is one of cols
+func containsColumn(cols []string, col string) bool {
+	for _, c := range cols {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// missingKeyColumn returns the first key column the batch doesn't carry, if any
+func missingKeyColumn(columns, keyColumns []string) string {
+	for _, key := range keyColumns {
+		if !containsColumn(columns, key) {
+			return key
+		}
+	}
+	return ""
+}
+
+// recordKey builds the composite key of a record. ok is false when a key column is NULL,
+// such rows never conflict with each other
+func recordKey(record map[string]interface{}, keyColumns []string) (string, bool) {
+	parts := make([]string, len(keyColumns))
+	for i, col := range keyColumns {
+		v := record[col]
+		if v == nil {
+			return "", false
+		}
+		parts[i] = comparableValue(v)
+	}
+	return strings.Join(parts, "\x1f"), true
+}
+
+// dedupeByKey keeps the last record of each key so one statement never touches a row twice.
+// Batches are normally deduplicated upstream with the rule's strategy, this is the safety net
+func dedupeByKey(records []map[string]interface{}, keyColumns []string) []map[string]interface{} {
+	out, dropped := DedupeRecords(records, keyColumns, Dedupe{Strategy: DedupeLast})
+	if dropped > 0 {
+		log.Printf("Deduplicated batch on (%s): %d → %d records", strings.Join(keyColumns, ", "), len(records), len(out))
+	}
+	return out
+}
+
+// pgConflictClause builds the ON CONFLICT clause for a composite key
+func pgConflictClause(columns, keyColumns []string) string {
+	updateClauses := make([]string, 0, len(columns))
+	for _, col := range columns {
+		if !containsColumn(keyColumns, col) {
+			updateClauses = append(updateClauses, `"`+col+`" = EXCLUDED."`+col+`"`)
+		}
+	}
+	target := `"` + strings.Join(keyColumns, `", "`) + `"`
+	if len(updateClauses) == 0 {
+		return fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", target)
+	}
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", target, strings.Join(updateClauses, ", "))
+}
+
+// UpsertBatch inserts or updates records based on an ordered (composite) unique key
 // Supports: PostgreSQL, MySQL, Oracle
-func (tc *TargetConnection) UpsertBatch(tableName string, records []map[string]interface{}, uniqueKeyColumn string) (int, error) {
+// Rows the target refuses (after the per-record fallback) are returned as rejected
+func (tc *TargetConnection) UpsertBatch(tableName string, records []map[string]interface{}, keyColumns []string) (int, []RejectedRow, error) {
 	if len(records) == 0 {
-		return 0, nil
+		return 0, nil, nil
 	}
 
 	// If no unique key specified, fall back to regular insert
-	if uniqueKeyColumn == "" {
+	if len(keyColumns) == 0 {
 		return tc.InsertBatch(tableName, records)
 	}
 
-	// Get column names from first record
-	var columns []string
-	for col := range records[0] {
-		columns = append(columns, col)
+	// Column union across the batch — later records may carry columns the first one lacks
+	columns := recordColumns(records)
+	if col := missingKeyColumn(columns, keyColumns); col != "" {
+		return 0, nil, fmt.Errorf("unique key column %s is missing from the batch", col)
 	}
 
+	// Duplicate keys in one statement fail on PostgreSQL and are order-dependent elsewhere
+	records = dedupeByKey(records, keyColumns)
+
 	upsertedCount := 0
+	rejects := &rejectCollector{}
 
 	switch tc.Config.Driver {
 	case "postgres":
 		// Use parallel path for large datasets (>10K rows) for ~3-4x throughput
 		if len(records) > 10000 {
-			upsertedCount = tc.upsertPostgresParallel(tableName, records, columns, uniqueKeyColumn)
+			upsertedCount = tc.upsertPostgresParallel(tableName, records, columns, keyColumns, rejects)
 		} else {
-			upsertedCount = tc.upsertPostgres(tableName, records, columns, uniqueKeyColumn)
+			upsertedCount = tc.upsertPostgres(tableName, records, columns, keyColumns, rejects)
 		}
 	case "mysql":
-		upsertedCount = tc.upsertMySQL(tableName, records, columns, uniqueKeyColumn)
+		upsertedCount = tc.upsertMySQL(tableName, records, columns, keyColumns, rejects)
 	case "oracle":
-		upsertedCount = tc.upsertOracle(tableName, records, columns, uniqueKeyColumn)
+		upsertedCount = tc.upsertOracle(tableName, records, columns, keyColumns, rejects)
 	default:
 		// Fall back to PostgreSQL syntax for unknown drivers
 		if len(records) > 10000 {
-			upsertedCount = tc.upsertPostgresParallel(tableName, records, columns, uniqueKeyColumn)
+			upsertedCount = tc.upsertPostgresParallel(tableName, records, columns, keyColumns, rejects)
 		} else {
-			upsertedCount = tc.upsertPostgres(tableName, records, columns, uniqueKeyColumn)
+			upsertedCount = tc.upsertPostgres(tableName, records, columns, keyColumns, rejects)
 		}
 	}
 
-	log.Printf("Upserted %d records to %s (driver: %s, unique key: %s)", upsertedCount, tableName, tc.Config.Driver, uniqueKeyColumn)
-	return upsertedCount, nil
+	log.Printf("Upserted %d records to %s (driver: %s, unique key: %s)", upsertedCount, tableName, tc.Config.Driver, strings.Join(keyColumns, ", "))
+	return upsertedCount, rejects.list(), nil
 }
 
 // upsertPostgres handles PostgreSQL upsert using ON CONFLICT DO UPDATE
 // Optimized with transaction wrapping, pre-allocated slices, and strconv for placeholders
-func (tc *TargetConnection) upsertPostgres(tableName string, records []map[string]interface{}, columns []string, uniqueKeyColumn string) int {
+func (tc *TargetConnection) upsertPostgres(tableName string, records []map[string]interface{}, columns []string, keyColumns []string, rejects *rejectCollector) int {
 	if len(records) == 0 {
 		return 0
 	}
 
 	numCols := len(columns)
 
-	// Build update set clause once (exclude the unique key)
-	updateClauses := make([]string, 0, numCols)
-	for _, col := range columns {
-		if col != uniqueKeyColumn {
-			updateClauses = append(updateClauses, `"`+col+`" = EXCLUDED."`+col+`"`)
-		}
-	}
-	updateClause := strings.Join(updateClauses, ", ")
+	// Build conflict clause once (the key columns are not updated)
+	conflictClause := pgConflictClause(columns, keyColumns)
 
 	colsJoined := `"` + strings.Join(columns, `", "`) + `"`
 
@@ -643,12 +577,11 @@ func (tc *TargetConnection) upsertPostgres(tableName string, records []map[strin
 		}
 
 		upsertSQL := fmt.Sprintf(
-			`INSERT INTO "%s" (%s) OVERRIDING SYSTEM VALUE VALUES %s ON CONFLICT ("%s") DO UPDATE SET %s`,
+			`INSERT INTO "%s" (%s) OVERRIDING SYSTEM VALUE VALUES %s %s`,
 			tableName,
 			colsJoined,
 			strings.Join(valueRows, ","),
-			uniqueKeyColumn,
-			updateClause,
+			conflictClause,
 		)
 
 		var result sql.Result
@@ -659,14 +592,16 @@ func (tc *TargetConnection) upsertPostgres(tableName string, records []map[strin
 		}
 		if err != nil {
 			log.Printf("PostgreSQL batch upsert error (batch %d-%d, skipping): %v", i, end, err)
-			// On transaction error, abort txn and fallback to per-record
+			// On transaction error, abort txn and fallback to per-record. The rollback also
+			// discards the earlier batches, so they are retried and counted again too
+			retry := batch
 			if txn != nil {
 				txn.Rollback()
 				txn = nil
+				retry = records[:end]
+				upsertedCount = 0
 			}
-			for _, record := range batch {
-				upsertedCount += tc.upsertPostgresSingle(tableName, record, columns, uniqueKeyColumn, updateClause)
-			}
+			upsertedCount += tc.upsertRecordsSingle(tableName, retry, columns, conflictClause, rejects)
 			continue
 		}
 
@@ -677,7 +612,8 @@ func (tc *TargetConnection) upsertPostgres(tableName string, records []map[strin
 	// Commit transaction
 	if txn != nil {
 		if err := txn.Commit(); err != nil {
-			log.Printf("Failed to commit upsert transaction: %v", err)
+			log.Printf("Failed to commit upsert transaction, retrying per record: %v", err)
+			upsertedCount = tc.upsertRecordsSingle(tableName, records, columns, conflictClause, rejects)
 		}
 	}
 
@@ -685,7 +621,7 @@ func (tc *TargetConnection) upsertPostgres(tableName string, records []map[strin
 }
 
 // upsertPostgresSingle handles single record upsert (fallback for batch errors)
-func (tc *TargetConnection) upsertPostgresSingle(tableName string, record map[string]interface{}, columns []string, uniqueKeyColumn string, updateClause string) int {
+func (tc *TargetConnection) upsertPostgresSingle(tableName string, record map[string]interface{}, columns []string, conflictClause string, rejects *rejectCollector) int {
 	var values []interface{}
 	var placeholders []string
 
@@ -695,17 +631,17 @@ func (tc *TargetConnection) upsertPostgresSingle(tableName string, record map[st
 	}
 
 	upsertSQL := fmt.Sprintf(
-		"INSERT INTO \"%s\" (\"%s\") OVERRIDING SYSTEM VALUE VALUES (%s) ON CONFLICT (\"%s\") DO UPDATE SET %s",
+		"INSERT INTO \"%s\" (\"%s\") OVERRIDING SYSTEM VALUE VALUES (%s) %s",
 		tableName,
 		strings.Join(columns, "\", \""),
 		strings.Join(placeholders, ", "),
-		uniqueKeyColumn,
-		updateClause,
+		conflictClause,
 	)
 
 	result, err := tc.DB.Exec(upsertSQL, values...)
 	if err != nil {
 		log.Printf("PostgreSQL single upsert error (skipping): %v", err)
+		rejects.add(record, err)
 		return 0
 	}
 
@@ -715,20 +651,24 @@ func (tc *TargetConnection) upsertPostgresSingle(tableName string, record map[st
 
 // upsertMySQL handles MySQL upsert using ON DUPLICATE KEY UPDATE
 // Optimized with pre-allocated slices for better performance
-func (tc *TargetConnection) upsertMySQL(tableName string, records []map[string]interface{}, columns []string, uniqueKeyColumn string) int {
+func (tc *TargetConnection) upsertMySQL(tableName string, records []map[string]interface{}, columns []string, keyColumns []string, rejects *rejectCollector) int {
 	if len(records) == 0 {
 		return 0
 	}
 
 	numCols := len(columns)
 
-	// Build update set clause for MySQL (exclude the unique key)
+	// Build update set clause for MySQL (exclude the key columns)
 	updateClauses := make([]string, 0, numCols)
 	for _, col := range columns {
-		if col != uniqueKeyColumn {
+		if !containsColumn(keyColumns, col) {
 			updateClauses = append(updateClauses, "`"+col+"` = VALUES(`"+col+"`)")
 		}
 	}
+	if len(updateClauses) == 0 {
+		// Every column is part of the key: keep the existing row
+		updateClauses = append(updateClauses, "`"+keyColumns[0]+"` = `"+keyColumns[0]+"`")
+	}
 	updateClause := strings.Join(updateClauses, ", ")
 
 	// Pre-build single row placeholder: (?,?,?)
@@ -775,7 +715,7 @@ func (tc *TargetConnection) upsertMySQL(tableName string, records []map[string]i
 		if err != nil {
 			log.Printf("MySQL batch upsert error (batch %d-%d, skipping): %v", i, end, err)
 			for _, record := range batch {
-				upsertedCount += tc.upsertMySQLSingle(tableName, record, columns, updateClause)
+				upsertedCount += tc.upsertMySQLSingle(tableName, record, columns, updateClause, rejects)
 			}
 			continue
 		}
@@ -788,7 +728,7 @@ func (tc *TargetConnection) upsertMySQL(tableName string, records []map[string]i
 }
 
 // upsertMySQLSingle handles single record upsert (fallback for batch errors)
-func (tc *TargetConnection) upsertMySQLSingle(tableName string, record map[string]interface{}, columns []string, updateClause string) int {
+func (tc *TargetConnection) upsertMySQLSingle(tableName string, record map[string]interface{}, columns []string, updateClause string, rejects *rejectCollector) int {
 	var values []interface{}
 	var placeholders []string
 
@@ -808,6 +748,7 @@ func (tc *TargetConnection) upsertMySQLSingle(tableName string, record map[strin
 	result, err := tc.DB.Exec(upsertSQL, values...)
 	if err != nil {
 		log.Printf("MySQL single upsert error (skipping): %v", err)
+		rejects.add(record, err)
 		return 0
 	}
 
@@ -816,9 +757,15 @@ func (tc *TargetConnection) upsertMySQLSingle(tableName string, record map[strin
 }
 
 // upsertOracle handles Oracle upsert using MERGE INTO
-func (tc *TargetConnection) upsertOracle(tableName string, records []map[string]interface{}, columns []string, uniqueKeyColumn string) int {
+func (tc *TargetConnection) upsertOracle(tableName string, records []map[string]interface{}, columns []string, keyColumns []string, rejects *rejectCollector) int {
 	upsertedCount := 0
 
+	// Match on every key column
+	onClauses := make([]string, len(keyColumns))
+	for i, key := range keyColumns {
+		onClauses[i] = fmt.Sprintf("tgt.\"%s\" = src.\"%s\"", key, key)
+	}
+
 	for _, record := range records {
 		var values []interface{}
 		var selectClauses []string
@@ -832,22 +779,26 @@ func (tc *TargetConnection) upsertOracle(tableName string, records []map[string]
 			insertCols = append(insertCols, fmt.Sprintf("\"%s\"", col))
 			insertVals = append(insertVals, fmt.Sprintf("src.\"%s\"", col))
 
-			if col != uniqueKeyColumn {
+			if !containsColumn(keyColumns, col) {
 				updateClauses = append(updateClauses, fmt.Sprintf("tgt.\"%s\" = src.\"%s\"", col, col))
 			}
 		}
 
-		// Oracle MERGE syntax
+		// Oracle MERGE syntax (no WHEN MATCHED branch when every column is part of the key)
+		matchedClause := ""
+		if len(updateClauses) > 0 {
+			matchedClause = "WHEN MATCHED THEN UPDATE SET " + strings.Join(updateClauses, ", ")
+		}
 		mergeSQL := fmt.Sprintf(`
 			MERGE INTO "%s" tgt
 			USING (SELECT %s FROM DUAL) src
-			ON (tgt."%s" = src."%s")
-			WHEN MATCHED THEN UPDATE SET %s
+			ON (%s)
+			%s
 			WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)`,
 			tableName,
 			strings.Join(selectClauses, ", "),
-			uniqueKeyColumn, uniqueKeyColumn,
-			strings.Join(updateClauses, ", "),
+			strings.Join(onClauses, " AND "),
+			matchedClause,
 			strings.Join(insertCols, ", "),
 			strings.Join(insertVals, ", "),
 		)
@@ -855,6 +806,7 @@ func (tc *TargetConnection) upsertOracle(tableName string, records []map[string]
 		result, err := tc.DB.Exec(mergeSQL, values...)
 		if err != nil {
 			log.Printf("Oracle upsert error (skipping): %v", err)
+			rejects.add(record, err)
 			continue
 		}
 
@@ -876,96 +828,109 @@ func (tc *TargetConnection) InsertCsvBatch(tableName string, csvData string, col
 }
 
 // UpsertCsvBatch inserts/updates CSV string payload via Temp Table and Merge
-func (tc *TargetConnection) UpsertCsvBatch(tableName string, csvData string, columns []string, uniqueKeyColumn string) (int, error) {
+// Rows the target refuses are returned as rejected
+func (tc *TargetConnection) UpsertCsvBatch(tableName string, csvData string, columns []string, keyColumns []string) (int, []RejectedRow, error) {
 	if tc.Config.Driver != "postgres" {
-		return 0, fmt.Errorf("CSV streaming not supported for driver %s", tc.Config.Driver)
-	}
-
-	if uniqueKeyColumn == "" {
-		return tc.InsertCsvBatch(tableName, csvData, columns)
+		return 0, nil, fmt.Errorf("CSV streaming not supported for driver %s", tc.Config.Driver)
 	}
 
-	txn, err := tc.DB.Begin()
-	if err != nil {
-		return 0, fmt.Errorf("failed to begin upsert transaction: %w", err)
-	}
-
-	// 1. Create temporary staging table
-	tempTable := fmt.Sprintf("stg_%s_%d", tableName, time.Now().UnixNano())
-	createTempSQL := fmt.Sprintf(`CREATE TEMP TABLE "%s" (LIKE "%s" INCLUDING DEFAULTS) ON COMMIT DROP`, tempTable, tableName)
-	if _, err := txn.Exec(createTempSQL); err != nil {
-		txn.Rollback()
-		return 0, fmt.Errorf("failed to create temp table: %w", err)
+	if len(keyColumns) == 0 {
+		count, err := tc.InsertCsvBatch(tableName, csvData, columns)
+		return count, nil, err
 	}
 
-	// 2. COPY data into temp table
-	stmt, err := txn.Prepare(pq.CopyIn(tempTable, columns...))
+	rows, err := parseCsvRowsByKey(csvData, columns, keyColumns)
 	if err != nil {
-		txn.Rollback()
-		return 0, fmt.Errorf("failed to prepare COPY for temp table: %w", err)
+		return 0, nil, err
 	}
 
-	reader := encoding_csv.NewReader(strings.NewReader(csvData))
-	reader.FieldsPerRecord = len(columns)
-	reader.LazyQuotes = true
+	rejects := &rejectCollector{}
+	colsJoined := `"` + strings.Join(columns, `", "`) + `"`
+	count := tc.upsertShardOrRows(tableName, rows, columns, keyColumns, pgConflictClause(columns, keyColumns), colsJoined, 0, rejects)
+	return count, rejects.list(), nil
+}
 
-	records, err := reader.ReadAll()
-	if err != nil {
-		stmt.Close()
-		txn.Rollback()
-		return 0, fmt.Errorf("failed to parse CSV data: %w", err)
+// upsertShardOrRows merges a shard through COPY, falling back to the record path when
+// the shard fails so the rows the target refuses are isolated and rejected one by one
+func (tc *TargetConnection) upsertShardOrRows(tableName string, rows [][]string, columns []string, keyColumns []string, conflictClause, colsJoined string, shardIdx int, rejects *rejectCollector) int {
+	affected, err := tc.processUpsertShard(tableName, rows, columns, conflictClause, colsJoined, shardIdx)
+	if err == nil {
+		return affected
 	}
+	log.Printf("⚠️ Shard %d failed (%v), retrying its %d rows through the record path", shardIdx, err, len(rows))
+	return tc.upsertPostgres(tableName, csvRowsToRecords(rows, columns), columns, keyColumns, rejects)
+}
 
-	for _, record := range records {
-		args := make([]interface{}, len(columns))
-		for i, v := range record {
-			if v == "" {
-				args[i] = nil
+// csvRowsToRecords turns parsed CSV rows into records, empty fields become NULL
+func csvRowsToRecords(rows [][]string, columns []string) []map[string]interface{} {
+	records := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		rec := make(map[string]interface{}, len(columns))
+		for j, col := range columns {
+			if j < len(row) && row[j] != "" {
+				rec[col] = row[j]
 			} else {
-				args[i] = v
+				rec[col] = nil
 			}
 		}
-		if _, err := stmt.Exec(args...); err != nil {
-			stmt.Close()
-			txn.Rollback()
-			return 0, fmt.Errorf("COPY execute failed: %w", err)
-		}
+		records[i] = rec
 	}
-	if _, err := stmt.Exec(); err != nil { // flush
-		stmt.Close()
-		txn.Rollback()
-		return 0, fmt.Errorf("COPY flush failed: %w", err)
+	return records
+}
+
+// BinaryColumns returns the binary columns of a CSV batch that the target can't read as
+// PostgreSQL hex text: their values must be decoded (see DecodeBinaryColumns)
+func (tc *TargetConnection) BinaryColumns(specs []ColumnSpec) []string {
+	if tc.Config.Driver == "postgres" {
+		return nil
 	}
-	stmt.Close()
+	var columns []string
+	for _, spec := range specs {
+		if spec.Type == "binary" {
+			columns = append(columns, spec.Name)
+		}
+	}
+	return columns
+}
 
-	// 3. Merge from temp table to main table
-	numCols := len(columns)
-	updateClauses := make([]string, 0, numCols)
-	for _, col := range columns {
-		if col != uniqueKeyColumn {
-			updateClauses = append(updateClauses, `"`+col+`" = EXCLUDED."`+col+`"`)
+// DecodeBinaryColumns turns the \x hex text of binary values back into bytes
+func DecodeBinaryColumns(records []map[string]interface{}, columns []string) error {
+	for _, rec := range records {
+		for _, col := range columns {
+			s, ok := rec[col].(string)
+			if !ok || !strings.HasPrefix(s, "\\x") {
+				continue
+			}
+			b, err := hex.DecodeString(s[2:])
+			if err != nil {
+				return fmt.Errorf("invalid binary value in column %s: %w", col, err)
+			}
+			rec[col] = b
 		}
 	}
-	updateClause := strings.Join(updateClauses, ", ")
-	colsJoined := `"` + strings.Join(columns, `", "`) + `"`
+	return nil
+}
 
-	upsertSQL := fmt.Sprintf(
-		`INSERT INTO "%s" (%s) SELECT %s FROM "%s" ON CONFLICT ("%s") DO UPDATE SET %s`,
-		tableName, colsJoined, colsJoined, tempTable, uniqueKeyColumn, updateClause,
-	)
+// parseCsvRowsByKey parses a CSV batch and keeps the last row of each key
+func parseCsvRowsByKey(csvData string, columns []string, keyColumns []string) ([][]string, error) {
+	if col := missingKeyColumn(columns, keyColumns); col != "" {
+		return nil, fmt.Errorf("unique key column %s is missing from the batch", col)
+	}
 
-	result, err := txn.Exec(upsertSQL)
+	reader := encoding_csv.NewReader(strings.NewReader(csvData))
+	reader.FieldsPerRecord = len(columns)
+	reader.LazyQuotes = true
+
+	rows, err := reader.ReadAll()
 	if err != nil {
-		txn.Rollback()
-		return 0, fmt.Errorf("failed to merge from temp table: %w", err)
+		return nil, fmt.Errorf("failed to parse CSV data: %w", err)
 	}
 
-	if err := txn.Commit(); err != nil {
-		return 0, fmt.Errorf("failed to commit merge txn: %w", err)
+	kept, dropped := dedupeCsvRows(rows, columns, keyColumns, Dedupe{Strategy: DedupeLast})
+	if dropped > 0 {
+		log.Printf("Deduplicated CSV batch on (%s): %d → %d rows", strings.Join(keyColumns, ", "), len(rows), len(kept))
 	}
-
-	affected, _ := result.RowsAffected()
-	return int(affected), nil
+	return kept, nil
 }
 
 // copyCsvToTable performs a direct COPY FROM payload to table
@@ -1060,100 +1025,100 @@ func (tc *TargetConnection) ResetTuning() {
 
 // UpsertCsvBatchParallel performs parallel COPY+merge upsert by splitting CSV data into N shards
 // Each shard: Create temp table → COPY shard data → INSERT...SELECT ON CONFLICT → commit
+// Rows are sharded by key so no two shards touch the same target row
 // This achieves ~3-4x throughput vs serial UpsertCsvBatch
-func (tc *TargetConnection) UpsertCsvBatchParallel(tableName string, csvData string, columns []string, uniqueKeyColumn string) (int, error) {
+// Rows the target refuses are returned as rejected
+func (tc *TargetConnection) UpsertCsvBatchParallel(tableName string, csvData string, columns []string, keyColumns []string) (int, []RejectedRow, error) {
 	if tc.Config.Driver != "postgres" {
-		return 0, fmt.Errorf("parallel CSV upsert only supported for PostgreSQL")
+		return 0, nil, fmt.Errorf("parallel CSV upsert only supported for PostgreSQL")
 	}
 
-	if uniqueKeyColumn == "" {
-		return tc.InsertCsvBatch(tableName, csvData, columns)
+	if len(keyColumns) == 0 {
+		count, err := tc.InsertCsvBatch(tableName, csvData, columns)
+		return count, nil, err
 	}
 
-	// Parse CSV data into lines
-	lines := strings.Split(strings.TrimRight(csvData, "\n"), "\n")
-	if len(lines) == 0 {
-		return 0, nil
+	rows, err := parseCsvRowsByKey(csvData, columns, keyColumns)
+	if err != nil {
+		return 0, nil, err
 	}
+	if len(rows) == 0 {
+		return 0, nil, nil
+	}
+
+	conflictClause := pgConflictClause(columns, keyColumns)
+	colsJoined := `"` + strings.Join(columns, `", "`) + `"`
+	rejects := &rejectCollector{}
 
 	// For small datasets, use serial path (overhead of parallel not worth it)
-	if len(lines) < 5000 {
-		return tc.UpsertCsvBatch(tableName, csvData, columns, uniqueKeyColumn)
+	if len(rows) < 5000 {
+		count := tc.upsertShardOrRows(tableName, rows, columns, keyColumns, conflictClause, colsJoined, 0, rejects)
+		return count, rejects.list(), nil
 	}
 
 	// Apply bulk tuning
 	tc.TuneForBulkOps()
 	defer tc.ResetTuning()
 
-	// Split lines into N shards
+	// Split rows into N shards by key hash
 	numShards := ParallelWriters
-	if len(lines) < numShards*1000 {
-		numShards = 1 + len(lines)/1000
-		if numShards < 1 {
-			numShards = 1
-		}
+	if len(rows) < numShards*1000 {
+		numShards = 1 + len(rows)/1000
 	}
 
-	shardSize := (len(lines) + numShards - 1) / numShards
-	shards := make([]string, 0, numShards)
-	for i := 0; i < len(lines); i += shardSize {
-		end := i + shardSize
-		if end > len(lines) {
-			end = len(lines)
+	keyIdx := make([]int, 0, len(keyColumns))
+	for _, key := range keyColumns {
+		for j, col := range columns {
+			if col == key {
+				keyIdx = append(keyIdx, j)
+			}
 		}
-		shards = append(shards, strings.Join(lines[i:end], "\n")+"\n")
 	}
 
-	log.Printf("⚡ Parallel upsert: %d total rows → %d shards (shard size ~%d)", len(lines), len(shards), shardSize)
-
-	// Build update clause once
-	updateClauses := make([]string, 0, len(columns))
-	for _, col := range columns {
-		if col != uniqueKeyColumn {
-			updateClauses = append(updateClauses, `"`+col+`" = EXCLUDED."`+col+`"`)
+	shards := make([][][]string, numShards)
+	for _, row := range rows {
+		h := fnv.New32a()
+		for _, idx := range keyIdx {
+			h.Write([]byte(row[idx]))
+			h.Write([]byte{0x1f})
 		}
+		shard := int(h.Sum32() % uint32(numShards))
+		shards[shard] = append(shards[shard], row)
 	}
-	updateClause := strings.Join(updateClauses, ", ")
-	colsJoined := `"` + strings.Join(columns, `", "`) + `"`
 
-	// Process shards in parallel
+	log.Printf("⚡ Parallel upsert: %d total rows → %d shards (shard size ~%d)", len(rows), numShards, len(rows)/numShards)
+
+	// Process shards in parallel (failed shards fall back to the record path)
 	var totalAffected int64
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(shards))
 
-	for shardIdx, shardData := range shards {
+	for shardIdx, shardRows := range shards {
+		if len(shardRows) == 0 {
+			continue
+		}
 		wg.Add(1)
-		go func(idx int, data string) {
+		go func(idx int, data [][]string) {
 			defer wg.Done()
 
-			affected, err := tc.processUpsertShard(tableName, data, columns, uniqueKeyColumn, updateClause, colsJoined, idx)
-			if err != nil {
-				errChan <- fmt.Errorf("shard %d: %w", idx, err)
-				return
-			}
+			affected := tc.upsertShardOrRows(tableName, data, columns, keyColumns, conflictClause, colsJoined, idx, rejects)
 			atomic.AddInt64(&totalAffected, int64(affected))
-		}(shardIdx, shardData)
+		}(shardIdx, shardRows)
 	}
 
 	wg.Wait()
-	close(errChan)
 
-	// Collect errors
-	var errs []string
-	for err := range errChan {
-		errs = append(errs, err.Error())
-	}
-	if len(errs) > 0 {
-		log.Printf("⚠️ Parallel upsert had %d shard errors: %s", len(errs), strings.Join(errs, "; "))
+	rejected := rejects.list()
+	if len(rejected) > 0 {
+		log.Printf("⚠️ Parallel upsert rejected %d rows", len(rejected))
 	}
 
 	result := int(atomic.LoadInt64(&totalAffected))
 	log.Printf("⚡ Parallel upsert complete: %d rows affected (table: %s)", result, tableName)
-	return result, nil
+	return result, rejected, nil
 }
 
 // processUpsertShard handles a single shard: temp table → COPY → merge
-func (tc *TargetConnection) processUpsertShard(tableName, csvData string, columns []string, uniqueKeyColumn, updateClause, colsJoined string, shardIdx int) (int, error) {
+func (tc *TargetConnection) processUpsertShard(tableName string, records [][]string, columns []string, conflictClause, colsJoined string, shardIdx int) (int, error) {
 	txn, err := tc.DB.Begin()
 	if err != nil {
 		return 0, fmt.Errorf("failed to begin shard transaction: %w", err)
@@ -1174,17 +1139,6 @@ func (tc *TargetConnection) processUpsertShard(tableName, csvData string, column
 		return 0, fmt.Errorf("failed to prepare COPY: %w", err)
 	}
 
-	reader := encoding_csv.NewReader(strings.NewReader(csvData))
-	reader.FieldsPerRecord = len(columns)
-	reader.LazyQuotes = true
-
-	records, err := reader.ReadAll()
-	if err != nil {
-		stmt.Close()
-		txn.Rollback()
-		return 0, fmt.Errorf("failed to parse CSV: %w", err)
-	}
-
 	for _, record := range records {
 		args := make([]interface{}, len(columns))
 		for i, v := range record {
@@ -1209,8 +1163,8 @@ func (tc *TargetConnection) processUpsertShard(tableName, csvData string, column
 
 	// 3. Merge from temp table to main table
 	upsertSQL := fmt.Sprintf(
-		`INSERT INTO "%s" (%s) SELECT %s FROM "%s" ON CONFLICT ("%s") DO UPDATE SET %s`,
-		tableName, colsJoined, colsJoined, tempTable, uniqueKeyColumn, updateClause,
+		`INSERT INTO "%s" (%s) SELECT %s FROM "%s" %s`,
+		tableName, colsJoined, colsJoined, tempTable, conflictClause,
 	)
 
 	result, err := txn.Exec(upsertSQL)
@@ -1230,21 +1184,13 @@ func (tc *TargetConnection) processUpsertShard(tableName, csvData string, column
 
 // upsertPostgresParallel splits records into N chunks and upserts each in a separate goroutine
 // This is the parallel version of upsertPostgres for JSON record path
-func (tc *TargetConnection) upsertPostgresParallel(tableName string, records []map[string]interface{}, columns []string, uniqueKeyColumn string) int {
+func (tc *TargetConnection) upsertPostgresParallel(tableName string, records []map[string]interface{}, columns []string, keyColumns []string, rejects *rejectCollector) int {
 	if len(records) == 0 {
 		return 0
 	}
 
-	numCols := len(columns)
-
-	// Build update clause once
-	updateClauses := make([]string, 0, numCols)
-	for _, col := range columns {
-		if col != uniqueKeyColumn {
-			updateClauses = append(updateClauses, `"`+col+`" = EXCLUDED."`+col+`"`)
-		}
-	}
-	updateClause := strings.Join(updateClauses, ", ")
+	// Build conflict clause once
+	conflictClause := pgConflictClause(columns, keyColumns)
 	colsJoined := `"` + strings.Join(columns, `", "`) + `"`
 
 	// Apply bulk tuning
@@ -1269,7 +1215,7 @@ func (tc *TargetConnection) upsertPostgresParallel(tableName string, records []m
 		go func(chunkRecords []map[string]interface{}, chunkIdx int) {
 			defer wg.Done()
 
-			affected := tc.upsertChunk(tableName, chunkRecords, columns, uniqueKeyColumn, updateClause, colsJoined, chunkIdx)
+			affected := tc.upsertChunk(tableName, chunkRecords, columns, conflictClause, colsJoined, chunkIdx, rejects)
 			atomic.AddInt64(&totalAffected, int64(affected))
 		}(chunk, i/chunkSize)
 	}
@@ -1282,7 +1228,7 @@ func (tc *TargetConnection) upsertPostgresParallel(tableName string, records []m
 }
 
 // upsertChunk processes a single chunk of records in a transaction with batched INSERT ON CONFLICT
-func (tc *TargetConnection) upsertChunk(tableName string, records []map[string]interface{}, columns []string, uniqueKeyColumn, updateClause, colsJoined string, chunkIdx int) int {
+func (tc *TargetConnection) upsertChunk(tableName string, records []map[string]interface{}, columns []string, conflictClause, colsJoined string, chunkIdx int, rejects *rejectCollector) int {
 	numCols := len(columns)
 	batchSize := DefaultBatchSize
 	upsertedCount := 0
@@ -1315,19 +1261,17 @@ func (tc *TargetConnection) upsertChunk(tableName string, records []map[string]i
 		}
 
 		upsertSQL := fmt.Sprintf(
-			`INSERT INTO "%s" (%s) OVERRIDING SYSTEM VALUE VALUES %s ON CONFLICT ("%s") DO UPDATE SET %s`,
-			tableName, colsJoined, strings.Join(valueRows, ","), uniqueKeyColumn, updateClause,
+			`INSERT INTO "%s" (%s) OVERRIDING SYSTEM VALUE VALUES %s %s`,
+			tableName, colsJoined, strings.Join(valueRows, ","), conflictClause,
 		)
 
 		result, err := txn.Exec(upsertSQL, allValues...)
 		if err != nil {
 			log.Printf("Chunk %d batch %d-%d error: %v — rolling back chunk", chunkIdx, i, end, err)
 			txn.Rollback()
-			// Fallback: process this chunk serially without transaction
-			for _, record := range records[i:] {
-				upsertedCount += tc.upsertPostgresSingle(tableName, record, columns, uniqueKeyColumn, updateClause)
-			}
-			return upsertedCount
+			// Fallback: process the whole chunk serially without transaction, the
+			// rollback discarded the batches already applied as well
+			return tc.upsertRecordsSingle(tableName, records, columns, conflictClause, rejects)
 		}
 
 		affected, _ := result.RowsAffected()
@@ -1335,8 +1279,18 @@ func (tc *TargetConnection) upsertChunk(tableName string, records []map[string]i
 	}
 
 	if err := txn.Commit(); err != nil {
-		log.Printf("Chunk %d: failed to commit: %v", chunkIdx, err)
+		log.Printf("Chunk %d: failed to commit, retrying per record: %v", chunkIdx, err)
+		return tc.upsertRecordsSingle(tableName, records, columns, conflictClause, rejects)
 	}
 
 	return upsertedCount
 }
+
+// upsertRecordsSingle upserts records one by one outside a transaction
+func (tc *TargetConnection) upsertRecordsSingle(tableName string, records []map[string]interface{}, columns []string, conflictClause string, rejects *rejectCollector) int {
+	upsertedCount := 0
+	for _, record := range records {
+		upsertedCount += tc.upsertPostgresSingle(tableName, record, columns, conflictClause, rejects)
+	}
+	return upsertedCount
+}
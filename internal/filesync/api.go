@@ -7,6 +7,7 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -34,11 +35,12 @@ type APIConfig struct {
 	URL       string            // API endpoint URL
 	Method    string            // GET, POST
 	Headers   map[string]string // Custom headers
-	AuthType  string            // none, bearer, basic, api_key
+	AuthType  string            // none, bearer, basic, api_key, oauth2
 	AuthKey   string            // Header name for API key (e.g., X-API-Key)
 	AuthValue string            // Token/key/password value
 	Body      string            // Request body for POST
 	Username  string            // Username for basic auth (optional)
+	OAuth2    OAuth2Config      // Client credentials for oauth2 auth
 }
 
 // APIClient wraps HTTP client functionality for API calls
@@ -82,21 +84,45 @@ func isRetryable(statusCode int) bool {
 	return statusCode >= 500 || statusCode == 429
 }
 
-// FetchAPI makes an HTTP request and returns the response body
-func (c *APIClient) FetchAPI(config APIConfig) ([]byte, error) {
-	// Default method
-	if config.Method == "" {
-		config.Method = "GET"
+// maxRetryAfter caps how long a Retry-After header can make a request wait
+const maxRetryAfter = 5 * time.Minute
+
+// APIStatusError is a non-2xx API response
+type APIStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIStatusError) Error() string {
+	return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// ParseRetryAfter reads a Retry-After header (delay in seconds or an HTTP date)
+func ParseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
 	}
-	config.Method = strings.ToUpper(config.Method)
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
 
+// newRequest builds the HTTP request of config with its headers and authentication
+func newRequest(config APIConfig) (*http.Request, error) {
 	// Create request body if provided
 	var bodyReader io.Reader
 	if config.Body != "" && (config.Method == "POST" || config.Method == "PUT" || config.Method == "PATCH") {
 		bodyReader = bytes.NewBufferString(config.Body)
 	}
 
-	// Create request
 	req, err := http.NewRequest(config.Method, config.URL, bodyReader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
@@ -133,50 +159,55 @@ func (c *APIClient) FetchAPI(config APIConfig) ([]byte, error) {
 		}
 		req.Header.Set(headerName, config.AuthValue)
 	}
+	return req, nil
+}
+
+// FetchAPI makes an HTTP request and returns the response body. Failed requests are retried
+// with exponential backoff; a 429 with Retry-After waits as long as the server asks
+func (c *APIClient) FetchAPI(config APIConfig) ([]byte, error) {
+	return c.fetchWith(config, nil)
+}
+
+// fetchWith runs the retry loop of FetchAPI, handing the successful response to onSuccess
+func (c *APIClient) fetchWith(config APIConfig, onSuccess func(*http.Response)) ([]byte, error) {
+	// Default method
+	if config.Method == "" {
+		config.Method = "GET"
+	}
+	config.Method = strings.ToUpper(config.Method)
 
 	var lastErr error
-	var respBody []byte
+	var retryAfter time.Duration
+	oauth2 := strings.EqualFold(config.AuthType, AuthOAuth2)
+	refresh, refreshed := false, false
 
 	// Retry loop with exponential backoff
 	for attempt := 0; attempt <= c.retryConfig.MaxRetries; attempt++ {
-		// Wait before retry (skip on first attempt)
-		if attempt > 0 {
+		// Wait before retry (skip on first attempt and after a token refresh)
+		if attempt > 0 && !refresh {
 			delay := c.calculateBackoff(attempt - 1)
+			if retryAfter > 0 {
+				delay = retryAfter
+			}
 			fmt.Printf("⏳ API request failed, retrying in %v (attempt %d/%d)...\n", delay, attempt, c.retryConfig.MaxRetries)
 			time.Sleep(delay)
+		}
+		retryAfter = 0
 
-			// Recreate body reader for retry (since it was consumed)
-			if config.Body != "" && (config.Method == "POST" || config.Method == "PUT" || config.Method == "PATCH") {
-				bodyReader = bytes.NewBufferString(config.Body)
-				req, err = http.NewRequest(config.Method, config.URL, bodyReader)
-				if err != nil {
-					return nil, fmt.Errorf("failed to create request: %w", err)
-				}
-				// Re-set headers
-				req.Header.Set("Accept", "application/json")
-				req.Header.Set("Content-Type", "application/json")
-				for key, value := range config.Headers {
-					req.Header.Set(key, value)
-				}
-				// Re-apply authentication
-				switch strings.ToLower(config.AuthType) {
-				case "bearer":
-					req.Header.Set("Authorization", "Bearer "+config.AuthValue)
-				case "basic":
-					auth := config.AuthValue
-					if config.Username != "" {
-						auth = config.Username + ":" + config.AuthValue
-					}
-					encoded := base64.StdEncoding.EncodeToString([]byte(auth))
-					req.Header.Set("Authorization", "Basic "+encoded)
-				case "api_key":
-					headerName := config.AuthKey
-					if headerName == "" {
-						headerName = "X-API-Key"
-					}
-					req.Header.Set(headerName, config.AuthValue)
-				}
+		// Body readers are consumed by each attempt, build a fresh request
+		req, err := newRequest(config)
+		if err != nil {
+			return nil, err
+		}
+		if oauth2 {
+			// A failed request drops the cached token, so the next attempt asks for one anyway
+			token, err := c.accessToken(config.OAuth2, refresh)
+			refresh = false
+			if err != nil {
+				lastErr = fmt.Errorf("oauth2 token request failed: %w", err)
+				continue
 			}
+			req.Header.Set("Authorization", "Bearer "+token)
 		}
 
 		// Execute request
@@ -187,7 +218,7 @@ func (c *APIClient) FetchAPI(config APIConfig) ([]byte, error) {
 		}
 
 		// Read response body
-		respBody, err = io.ReadAll(resp.Body)
+		respBody, err := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if err != nil {
 			lastErr = fmt.Errorf("failed to read response: %w", err)
@@ -197,17 +228,32 @@ func (c *APIClient) FetchAPI(config APIConfig) ([]byte, error) {
 		// Check status code
 		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 			// Success!
+			if onSuccess != nil {
+				onSuccess(resp)
+			}
 			return respBody, nil
 		}
 
+		statusErr := &APIStatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+
+		// A cached token may have been revoked before its expiry, get a new one once
+		if oauth2 && resp.StatusCode == http.StatusUnauthorized && !refreshed {
+			refresh, refreshed = true, true
+			attempt--
+			continue
+		}
+
 		// Check if error is retryable
 		if isRetryable(resp.StatusCode) {
-			lastErr = fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(respBody))
+			if d, ok := ParseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				retryAfter = min(d, maxRetryAfter)
+			}
+			lastErr = statusErr
 			continue
 		}
 
 		// Non-retryable error (4xx except 429)
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(respBody))
+		return nil, statusErr
 	}
 
 	// All retries exhausted
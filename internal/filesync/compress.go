@@ -0,0 +1,298 @@
+package filesync
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Compressions of source files
+const (
+	CompressionAuto  = "auto" // Detected by extension, then magic bytes
+	CompressionNone  = "none"
+	CompressionGzip  = "gzip"
+	CompressionZip   = "zip"
+	CompressionTar   = "tar"
+	CompressionTarGz = "tar.gz"
+)
+
+// IsArchive reports whether a compression holds several files
+func IsArchive(compression string) bool {
+	return compression == CompressionZip || compression == CompressionTar || compression == CompressionTarGz
+}
+
+// DetectCompression tells how a source file is compressed from its name, or from its first
+// bytes when the extension says nothing. Excel workbooks are zip files too, so zip magic
+// bytes only count for other formats
+func DetectCompression(name, format string, open OpenFunc) (string, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return CompressionTarGz, nil
+	case strings.HasSuffix(lower, ".gz"):
+		return CompressionGzip, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return CompressionZip, nil
+	case strings.HasSuffix(lower, ".tar"):
+		return CompressionTar, nil
+	}
+
+	head, err := readHead(open, nil)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		// A gzip stream may hold a tar archive, look at the decompressed header
+		inner, err := readHead(open, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) })
+		if err != nil {
+			return "", fmt.Errorf("invalid gzip file: %w", err)
+		}
+		if isTarHeader(inner) {
+			return CompressionTarGz, nil
+		}
+		return CompressionGzip, nil
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		if f := strings.ToLower(format); f == "xlsx" || f == "excel" {
+			return CompressionNone, nil
+		}
+		return CompressionZip, nil
+	case isTarHeader(head):
+		return CompressionTar, nil
+	}
+	return CompressionNone, nil
+}
+
+// readHead reads the first 512 bytes of a file (a tar header block), through a decompressor if given
+func readHead(open OpenFunc, wrap func(io.Reader) (io.Reader, error)) ([]byte, error) {
+	body, err := open(0)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var r io.Reader = body
+	if wrap != nil {
+		if r, err = wrap(body); err != nil {
+			return nil, err
+		}
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// isTarHeader checks the ustar magic of a tar header block
+func isTarHeader(head []byte) bool {
+	return len(head) >= 262 && string(head[257:262]) == "ustar"
+}
+
+// GunzipOpen opens a gzip file decompressed. A resumed stream downloads the file again and
+// skips the decompressed bytes already read, gzip having no random access
+func GunzipOpen(open OpenFunc) OpenFunc {
+	return func(offset int64) (io.ReadCloser, error) {
+		body, err := open(0)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			body.Close()
+			return nil, fmt.Errorf("invalid gzip file: %w", err)
+		}
+		return skipTo(&readCloser{Reader: zr, closers: []io.Closer{zr, body}}, offset)
+	}
+}
+
+// readCloser closes a decompressor with the stream it reads
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	var first error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// skipTo discards the first offset bytes of a decompressed stream
+func skipTo(rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("failed to skip to byte %d: %w", offset, err)
+		}
+	}
+	return rc, nil
+}
+
+// ArchiveEntry is a file inside a zip or tar archive
+type ArchiveEntry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Archive is a zip or tar archive downloaded to a temporary file, its entries being read
+// from there (zip keeps its index at the end, and each entry is streamed separately)
+type Archive struct {
+	compression string
+	file        *os.File
+	size        int64
+	zip         *zip.Reader
+	Entries     []ArchiveEntry
+}
+
+// OpenArchive downloads an archive to a temporary file (see spoolFile) and lists the entries
+// matching the inner pattern (matched against the entry path, or its base name; "" matches
+// everything)
+func OpenArchive(open OpenFunc, compression, innerPattern string, retries int) (*Archive, error) {
+	file, size, err := spoolFile(open, retries)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{compression: compression, file: file, size: size}
+	if err := a.list(innerPattern); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// spoolFile downloads a file to a temporary file, for formats that need random access.
+// A broken download resumes where it stopped, up to retries times
+func spoolFile(open OpenFunc, retries int) (*os.File, int64, error) {
+	file, err := os.CreateTemp("", "dsp-download-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	var size int64
+	for attempt := 0; ; attempt++ {
+		body, err := open(size)
+		if err == nil {
+			var n int64
+			n, err = io.Copy(file, body)
+			body.Close()
+			size += n
+			if err == nil {
+				return file, size, nil
+			}
+		}
+		if attempt >= retries {
+			file.Close()
+			os.Remove(file.Name())
+			return nil, 0, fmt.Errorf("failed to download file: %w", err)
+		}
+	}
+}
+
+func (a *Archive) list(innerPattern string) error {
+	matches := func(name string) bool {
+		if strings.HasPrefix(name, "__MACOSX/") {
+			return false
+		}
+		return matchFileName(innerPattern, name) || matchFileName(innerPattern, path.Base(name))
+	}
+
+	if a.compression == CompressionZip {
+		zr, err := zip.NewReader(a.file, a.size)
+		if err != nil {
+			return fmt.Errorf("invalid zip archive: %w", err)
+		}
+		a.zip = zr
+		for _, f := range zr.File {
+			if !f.FileInfo().IsDir() && matches(f.Name) {
+				a.Entries = append(a.Entries, ArchiveEntry{Name: f.Name, Size: int64(f.UncompressedSize64), ModTime: f.Modified})
+			}
+		}
+		return nil
+	}
+
+	tr, closer, err := a.tarReader()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg && matches(hdr.Name) {
+			a.Entries = append(a.Entries, ArchiveEntry{Name: hdr.Name, Size: hdr.Size, ModTime: hdr.ModTime})
+		}
+	}
+}
+
+// tarReader reads the tar archive from its start
+func (a *Archive) tarReader() (*tar.Reader, io.Closer, error) {
+	var r io.Reader = io.NewSectionReader(a.file, 0, a.size)
+	closer := io.NopCloser(nil)
+	if a.compression == CompressionTarGz {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzip file: %w", err)
+		}
+		r, closer = zr, zr
+	}
+	return tar.NewReader(r), closer, nil
+}
+
+// OpenEntry returns the opener of an entry, for StreamRecords
+func (a *Archive) OpenEntry(name string) OpenFunc {
+	return func(offset int64) (io.ReadCloser, error) {
+		if a.zip != nil {
+			for _, f := range a.zip.File {
+				if f.Name == name {
+					rc, err := f.Open()
+					if err != nil {
+						return nil, err
+					}
+					return skipTo(rc, offset)
+				}
+			}
+			return nil, fmt.Errorf("entry %s not found in archive", name)
+		}
+
+		tr, closer, err := a.tarReader()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				closer.Close()
+				if err == io.EOF {
+					return nil, fmt.Errorf("entry %s not found in archive", name)
+				}
+				return nil, err
+			}
+			if hdr.Name == name {
+				return skipTo(&readCloser{Reader: tr, closers: []io.Closer{closer}}, offset)
+			}
+		}
+	}
+}
+
+// Close removes the temporary file
+func (a *Archive) Close() error {
+	a.file.Close()
+	return os.Remove(a.file.Name())
+}
@@ -0,0 +1,355 @@
+package filesync
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Export file formats for file targets
+const (
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+	ExportXLSX  = "xlsx"
+)
+
+// DefaultFileNameTemplate names export files when the target doesn't set a template
+const DefaultFileNameTemplate = "{table}_{datetime}.{ext}"
+
+// NormalizeExportFormat validates an export format (empty = csv)
+func NormalizeExportFormat(format string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", ExportCSV:
+		return ExportCSV, nil
+	case ExportJSONL, "json", "ndjson":
+		return ExportJSONL, nil
+	case ExportXLSX, "excel":
+		return ExportXLSX, nil
+	}
+	return "", fmt.Errorf("unsupported file format %q (csv, jsonl or xlsx)", format)
+}
+
+// FileNameVars are the values of the file-name template tokens
+type FileNameVars struct {
+	Table string
+	JobID uint
+	Job   string // Job name
+	RunID uint   // Job log ID of the run
+	Ext   string
+	Time  time.Time
+}
+
+// RenderFileName expands a file-name template. Tokens: {table}, {job}, {job_id}, {run_id},
+// {date} (20060102), {time} (150405), {datetime} (20060102_150405), {yyyy}, {mm}, {dd},
+// {hh}, {ext}. Path separators in values are replaced so a token can't escape the directory
+func RenderFileName(template string, vars FileNameVars) string {
+	if strings.TrimSpace(template) == "" {
+		template = DefaultFileNameTemplate
+	}
+	safe := func(v string) string {
+		return strings.NewReplacer("/", "_", "\\", "_", " ", "_").Replace(v)
+	}
+	t := vars.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	replacer := strings.NewReplacer(
+		"{table}", safe(vars.Table),
+		"{job}", safe(vars.Job),
+		"{job_id}", strconv.FormatUint(uint64(vars.JobID), 10),
+		"{run_id}", strconv.FormatUint(uint64(vars.RunID), 10),
+		"{datetime}", t.Format("20060102_150405"),
+		"{date}", t.Format("20060102"),
+		"{time}", t.Format("150405"),
+		"{yyyy}", t.Format("2006"),
+		"{mm}", t.Format("01"),
+		"{dd}", t.Format("02"),
+		"{hh}", t.Format("15"),
+		"{ext}", vars.Ext,
+	)
+	return replacer.Replace(template)
+}
+
+// RecordEncoder writes records to an export file in a given format
+type RecordEncoder interface {
+	WriteRecord(record map[string]interface{}) error
+	Close() error // Flushes the file; doesn't close the underlying writer
+}
+
+// NewRecordEncoder creates an encoder for format. columns fixes the column order
+// (CSV header, XLSX first row); sheet names the XLSX worksheet
+func NewRecordEncoder(format string, w io.Writer, columns []string, sheet string) (RecordEncoder, error) {
+	switch format {
+	case ExportCSV:
+		enc := &csvEncoder{w: csv.NewWriter(w), columns: columns}
+		if err := enc.w.Write(columns); err != nil {
+			return nil, fmt.Errorf("failed to write CSV header: %w", err)
+		}
+		return enc, nil
+	case ExportJSONL:
+		return &jsonlEncoder{enc: json.NewEncoder(w)}, nil
+	case ExportXLSX:
+		return newXlsxEncoder(w, columns, sheet)
+	}
+	return nil, fmt.Errorf("unsupported file format %q", format)
+}
+
+// csvEncoder writes CSV with a header row, NULL as an empty field
+type csvEncoder struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (e *csvEncoder) WriteRecord(record map[string]interface{}) error {
+	row := make([]string, len(e.columns))
+	for i, col := range e.columns {
+		row[i] = FormatExportValue(record[col])
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonlEncoder writes one JSON object per line
+type jsonlEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlEncoder) WriteRecord(record map[string]interface{}) error {
+	return e.enc.Encode(record)
+}
+
+func (e *jsonlEncoder) Close() error { return nil }
+
+// xlsxEncoder streams rows into a single worksheet, the workbook is written on Close
+type xlsxEncoder struct {
+	w       io.Writer
+	file    *excelize.File
+	stream  *excelize.StreamWriter
+	columns []string
+	row     int
+}
+
+// maxSheetNameLen is the Excel limit for worksheet names
+const maxSheetNameLen = 31
+
+func newXlsxEncoder(w io.Writer, columns []string, sheet string) (*xlsxEncoder, error) {
+	file := excelize.NewFile()
+	sheet = strings.NewReplacer(":", "_", "\\", "_", "/", "_", "?", "_", "*", "_", "[", "_", "]", "_").Replace(sheet)
+	if len(sheet) > maxSheetNameLen {
+		sheet = sheet[:maxSheetNameLen]
+	}
+	if sheet == "" {
+		sheet = "Sheet1"
+	}
+	if sheet != "Sheet1" {
+		if err := file.SetSheetName("Sheet1", sheet); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	stream, err := file.NewStreamWriter(sheet)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create XLSX stream: %w", err)
+	}
+
+	enc := &xlsxEncoder{w: w, file: file, stream: stream, columns: columns, row: 1}
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = col
+	}
+	if err := enc.writeRow(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return enc, nil
+}
+
+func (e *xlsxEncoder) writeRow(values []interface{}) error {
+	cell, err := excelize.CoordinatesToCellName(1, e.row)
+	if err != nil {
+		return err
+	}
+	e.row++
+	return e.stream.SetRow(cell, values)
+}
+
+func (e *xlsxEncoder) WriteRecord(record map[string]interface{}) error {
+	values := make([]interface{}, len(e.columns))
+	for i, col := range e.columns {
+		switch v := record[col].(type) {
+		case nil:
+		case json.Number:
+			values[i] = xlsxNumber(v)
+		case float64, int, int64, bool:
+			values[i] = v
+		default:
+			values[i] = FormatExportValue(v)
+		}
+	}
+	return e.writeRow(values)
+}
+
+// xlsxNumber returns the cell value of a number. Excel stores numbers as doubles, so
+// integers beyond 2^53 and decimals that don't survive the round trip are written as text
+func xlsxNumber(n json.Number) interface{} {
+	const maxExact = 1 << 53
+	if i, err := n.Int64(); err == nil {
+		if i >= -maxExact && i <= maxExact {
+			return i
+		}
+		return n.String()
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return n.String()
+	}
+	// The double must read back as the same decimal ("1.50" and 1.5 match, 0.1 too)
+	exact, ok := new(big.Rat).SetString(n.String())
+	shown, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	if !ok || shown == nil || exact.Cmp(shown) != 0 {
+		return n.String()
+	}
+	return f
+}
+
+func (e *xlsxEncoder) Close() error {
+	defer e.file.Close()
+	if err := e.stream.Flush(); err != nil {
+		return err
+	}
+	_, err := e.file.WriteTo(e.w)
+	return err
+}
+
+// FormatExportValue renders a value as text for CSV cells (NULL = empty)
+func FormatExportValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(val)
+		return string(data)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// RemoteWriter is a file server a target writes export files to (FTP or SFTP)
+type RemoteWriter interface {
+	WriteFile(remotePath string, r io.Reader) error
+	Rename(from, to string) error
+	Remove(remotePath string) error
+	MkdirAll(dir string) error
+	Close() error
+}
+
+// replacedName is the hidden name an existing file is moved to while a rename replaces it
+func replacedName(remotePath string) string {
+	return path.Join(path.Dir(remotePath), fmt.Sprintf(".%s.replaced-%d", path.Base(remotePath), time.Now().UnixNano()))
+}
+
+// UploadOptions controls the pickup files written next to an upload
+type UploadOptions struct {
+	DoneMarker bool   // Write <file>.done once the file is in place
+	Checksum   bool   // Write <file>.sha256 (sha256sum format)
+	SHA256     string // Hex digest of the content (computed by the caller)
+}
+
+// UploadAtomic uploads content under a temporary name and renames it into place, so
+// readers never see a partial file. The checksum is written before the rename (and removed
+// again when the rename fails) and the done marker after it. Returns the paths written
+func UploadAtomic(w RemoteWriter, remotePath string, content io.Reader, opts UploadOptions) ([]string, error) {
+	if err := w.MkdirAll(path.Dir(remotePath)); err != nil {
+		return nil, fmt.Errorf("failed to create remote directory: %w", err)
+	}
+
+	tmpPath := path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".part")
+	if err := w.WriteFile(tmpPath, content); err != nil {
+		w.Remove(tmpPath)
+		return nil, err
+	}
+
+	var written []string
+	if opts.Checksum && opts.SHA256 != "" {
+		sumPath := remotePath + ".sha256"
+		line := fmt.Sprintf("%s  %s\n", opts.SHA256, path.Base(remotePath))
+		if err := w.WriteFile(sumPath, strings.NewReader(line)); err != nil {
+			w.Remove(tmpPath)
+			return nil, fmt.Errorf("failed to write checksum file: %w", err)
+		}
+		written = append(written, sumPath)
+	}
+
+	if err := w.Rename(tmpPath, remotePath); err != nil {
+		w.Remove(tmpPath)
+		for _, p := range written {
+			w.Remove(p)
+		}
+		return nil, err
+	}
+	written = append([]string{remotePath}, written...)
+
+	if opts.DoneMarker {
+		donePath := remotePath + ".done"
+		if err := w.WriteFile(donePath, strings.NewReader("")); err != nil {
+			return written, fmt.Errorf("failed to write done marker: %w", err)
+		}
+		written = append(written, donePath)
+	}
+	return written, nil
+}
+
+// SHA256Writer hashes everything written through it
+type SHA256Writer struct {
+	w    io.Writer
+	hash hash.Hash
+	n    int64
+}
+
+// NewSHA256Writer wraps w
+func NewSHA256Writer(w io.Writer) *SHA256Writer {
+	return &SHA256Writer{w: w, hash: sha256.New()}
+}
+
+func (s *SHA256Writer) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.hash.Write(p[:n])
+	s.n += int64(n)
+	return n, err
+}
+
+// Sum returns the hex digest of the bytes written so far
+func (s *SHA256Writer) Sum() string {
+	return hex.EncodeToString(s.hash.Sum(nil))
+}
+
+// Size returns the number of bytes written so far
+func (s *SHA256Writer) Size() int64 {
+	return s.n
+}
@@ -0,0 +1,409 @@
+package filesync
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// FixedWidthColumn is a column of a fixed-width text file. Start is the 1-based character
+// position, 0 to start right after the previous column
+type FixedWidthColumn struct {
+	Name  string `json:"name"`
+	Start int    `json:"start"`
+	Width int    `json:"width"`
+}
+
+// ParseFixedWidthLayout reads a column layout stored as JSON,
+// e.g. [{"name":"id","start":1,"width":8},{"name":"amount","width":12}]
+func ParseFixedWidthLayout(layout string) ([]FixedWidthColumn, error) {
+	if strings.TrimSpace(layout) == "" {
+		return nil, fmt.Errorf("fixed-width format needs a column layout")
+	}
+	var columns []FixedWidthColumn
+	if err := json.Unmarshal([]byte(layout), &columns); err != nil {
+		return nil, fmt.Errorf("invalid fixed-width layout: %w", err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("fixed-width layout has no columns")
+	}
+
+	seen := make(map[string]bool, len(columns))
+	next := 1
+	for i, col := range columns {
+		if col.Name == "" {
+			return nil, fmt.Errorf("fixed-width column %d has no name", i+1)
+		}
+		if seen[col.Name] {
+			return nil, fmt.Errorf("duplicate fixed-width column %s", col.Name)
+		}
+		seen[col.Name] = true
+		if col.Width < 1 {
+			return nil, fmt.Errorf("fixed-width column %s needs a positive width", col.Name)
+		}
+		if col.Start < 0 {
+			return nil, fmt.Errorf("fixed-width column %s has a negative start", col.Name)
+		}
+		if col.Start == 0 {
+			columns[i].Start = next
+		}
+		next = columns[i].Start + col.Width
+	}
+	return columns, nil
+}
+
+// fixedWidthLine cuts a line into the columns of the layout. The header line is skipped
+func (s *recordStream) fixedWidthLine(line []byte, lineNum int) (map[string]interface{}, error) {
+	content := strings.TrimRight(string(line), "\r\n")
+	if lineNum == 1 {
+		if s.opts.HasHeader {
+			return nil, nil
+		}
+		content = strings.TrimPrefix(content, "\ufeff")
+	}
+	if strings.TrimSpace(content) == "" {
+		return nil, nil
+	}
+	if len(s.opts.Columns) == 0 {
+		return nil, fmt.Errorf("fixed-width format needs a column layout")
+	}
+
+	runes := []rune(content)
+	record := make(map[string]interface{}, len(s.opts.Columns))
+	for _, col := range s.opts.Columns {
+		start := col.Start - 1
+		end := start + col.Width
+		if start > len(runes) {
+			start = len(runes)
+		}
+		if end > len(runes) {
+			end = len(runes) // Short lines leave the last columns empty
+		}
+		record[col.Name] = inferType(strings.TrimSpace(string(runes[start:end])))
+	}
+	return record, nil
+}
+
+// readXML streams the record elements of an XML document. RecordPath names them by the
+// path of their parent elements ("rows/row" matches any <row> in a <rows>, a leading "/"
+// anchors the path at the root element); empty, the children of the root element are the
+// records. Attributes and child elements are flattened into the record, nested names joined
+// by dots (<addr city="x"><zip>1</zip></addr> gives addr.city and addr.zip) and repeated
+// children collected in a list. The XML is always read from its start, a resumed stream
+// skips the records before the last batch
+func (s *recordStream) readXML(r io.Reader) error {
+	resumeAfter := s.committed
+	anchored := strings.HasPrefix(s.opts.RecordPath, "/")
+	var recordPath []string
+	for _, part := range strings.Split(strings.Trim(s.opts.RecordPath, "/"), "/") {
+		if part != "" {
+			recordPath = append(recordPath, part)
+		}
+	}
+
+	dec := xml.NewDecoder(r)
+	var stack []string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse XML at byte %d: %w", dec.InputOffset(), err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			if !isXMLRecord(stack, recordPath, anchored) {
+				continue
+			}
+			record := make(map[string]interface{})
+			if err := flattenXML(dec, t, "", record); err != nil {
+				return fmt.Errorf("failed to parse XML at byte %d: %w", dec.InputOffset(), err)
+			}
+			stack = stack[:len(stack)-1]
+			end := dec.InputOffset()
+			if end <= resumeAfter {
+				continue // Sent before the download broke
+			}
+			if err := s.add(record, end); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return s.flush(dec.InputOffset())
+}
+
+// isXMLRecord reports whether the element at the top of the stack is a record element
+func isXMLRecord(stack, recordPath []string, anchored bool) bool {
+	if len(recordPath) == 0 {
+		return len(stack) == 2
+	}
+	if len(stack) < len(recordPath) || (anchored && len(stack) != len(recordPath)) {
+		return false
+	}
+	tail := stack[len(stack)-len(recordPath):]
+	for i, name := range recordPath {
+		if tail[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// flattenXML reads an element up to its end into the record, under key ("" for the record
+// element itself, whose text goes to "value")
+func flattenXML(dec *xml.Decoder, start xml.StartElement, key string, record map[string]interface{}) error {
+	for _, attr := range start.Attr {
+		addXMLValue(record, joinXMLKey(key, attr.Name.Local), attr.Value)
+	}
+	var text strings.Builder
+	hasChildren := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			hasChildren = true
+			if err := flattenXML(dec, t, joinXMLKey(key, t.Name.Local), record); err != nil {
+				return err
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			switch {
+			case value != "":
+				if key == "" {
+					addXMLValue(record, "value", value)
+				} else {
+					addXMLValue(record, key, value)
+				}
+			case key != "" && !hasChildren && len(start.Attr) == 0:
+				addXMLValue(record, key, "") // Empty element
+			}
+			return nil
+		}
+	}
+}
+
+func joinXMLKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// addXMLValue sets a flattened value, repeated keys becoming a list
+func addXMLValue(record map[string]interface{}, key, raw string) {
+	var value interface{}
+	if raw != "" {
+		value = inferType(raw)
+	}
+	existing, ok := record[key]
+	if !ok {
+		record[key] = value
+		return
+	}
+	if list, ok := existing.([]interface{}); ok {
+		record[key] = append(list, value)
+		return
+	}
+	record[key] = []interface{}{existing, value}
+}
+
+// readParquet reads a Parquet file by batches of rows. The footer holding the schema is at
+// the end, so the file is downloaded to a temporary file first. Column types are kept:
+// integers, floats, booleans, timestamps, exact decimals (json.Number). Nested columns are
+// named by their dotted path, repeated ones give lists
+func (s *recordStream) readParquet() error {
+	file, size, err := spoolFile(s.open, s.opts.Retries)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	pf, err := parquet.OpenFile(file, size)
+	if err != nil {
+		return fmt.Errorf("invalid parquet file: %w", err)
+	}
+	schema := pf.Schema()
+	paths := schema.Columns()
+	leaves := make([]parquet.LeafColumn, len(paths))
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		leaves[i], _ = schema.Lookup(p...)
+		names[i] = strings.Join(p, ".")
+	}
+
+	reader := parquet.NewReader(pf)
+	defer reader.Close()
+	next := s.committed
+	if next > 0 {
+		if err := reader.SeekToRow(next); err != nil {
+			return fmt.Errorf("failed to seek to row %d: %w", next, err)
+		}
+	}
+	rows := make([]parquet.Row, s.opts.BatchSize)
+	for {
+		n, err := reader.ReadRows(rows)
+		for _, row := range rows[:n] {
+			next++
+			if aerr := s.add(parquetRecord(row, leaves, names), next); aerr != nil {
+				return aerr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read parquet row %d: %w", next, err)
+		}
+	}
+	return s.flush(next)
+}
+
+// parquetRecord converts a row to a record
+func parquetRecord(row parquet.Row, leaves []parquet.LeafColumn, names []string) map[string]interface{} {
+	record := make(map[string]interface{}, len(names))
+	for _, v := range row {
+		c := v.Column()
+		if leaves[c].MaxRepetitionLevel > 0 {
+			list, _ := record[names[c]].([]interface{})
+			if list == nil {
+				list = []interface{}{}
+			}
+			if !v.IsNull() {
+				list = append(list, parquetRecordValue(v, leaves[c].Node))
+			}
+			record[names[c]] = list
+			continue
+		}
+		record[names[c]] = parquetRecordValue(v, leaves[c].Node)
+	}
+	return record
+}
+
+// parquetRecordValue converts a value by its logical type, the reverse of parquetValue
+func parquetRecordValue(v parquet.Value, node parquet.Node) interface{} {
+	if v.IsNull() {
+		return nil
+	}
+	if lt := node.Type().LogicalType(); lt != nil {
+		switch {
+		case lt.Decimal != nil:
+			return parquetDecimal(v, int(lt.Decimal.Scale))
+		case lt.Date != nil:
+			return time.Unix(int64(v.Int32())*86400, 0).UTC().Format("2006-01-02")
+		case lt.Timestamp != nil:
+			t := time.Unix(0, parquetNanos(v.Int64(), lt.Timestamp.Unit)).UTC()
+			if lt.Timestamp.IsAdjustedToUTC {
+				return t
+			}
+			return t.Format("2006-01-02 15:04:05.999999999") // Local wall clock
+		case lt.Time != nil:
+			nanos := int64(v.Int32()) * int64(time.Millisecond)
+			if v.Kind() == parquet.Int64 {
+				nanos = parquetNanos(v.Int64(), lt.Time.Unit)
+			}
+			return time.Unix(0, nanos).UTC().Format("15:04:05.999999999")
+		case lt.Integer != nil && !lt.Integer.IsSigned:
+			if lt.Integer.BitWidth == 64 {
+				return uint64(v.Int64())
+			}
+			return int64(uint32(v.Int32()))
+		case lt.UUID != nil:
+			b := v.ByteArray()
+			if len(b) == 16 {
+				return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+			}
+		case lt.UTF8 != nil, lt.Enum != nil, lt.Json != nil:
+			return string(v.ByteArray())
+		}
+	}
+
+	switch v.Kind() {
+	case parquet.Boolean:
+		return v.Boolean()
+	case parquet.Int32:
+		return int64(v.Int32())
+	case parquet.Int64:
+		return v.Int64()
+	case parquet.Int96:
+		// Legacy timestamp: nanoseconds of the day and Julian day
+		i := v.Int96()
+		nanos := int64(i[1])<<32 | int64(i[0])
+		days := int64(i[2]) - 2440588 // Julian day of the Unix epoch
+		return time.Unix(days*86400, nanos).UTC()
+	case parquet.Float:
+		return float64(v.Float())
+	case parquet.Double:
+		return v.Double()
+	}
+	b := v.ByteArray()
+	if utf8.Valid(b) {
+		return string(b) // Byte arrays written without the string annotation
+	}
+	return append([]byte(nil), b...) // Base64 encoded in JSON
+}
+
+// parquetNanos converts a time value of a unit to nanoseconds
+func parquetNanos(value int64, unit format.TimeUnit) int64 {
+	switch {
+	case unit.Millis != nil:
+		return value * int64(time.Millisecond)
+	case unit.Micros != nil:
+		return value * int64(time.Microsecond)
+	}
+	return value
+}
+
+// parquetDecimal formats the unscaled value of a decimal exactly
+func parquetDecimal(v parquet.Value, scale int) json.Number {
+	unscaled := new(big.Int)
+	switch v.Kind() {
+	case parquet.Int32:
+		unscaled.SetInt64(int64(v.Int32()))
+	case parquet.Int64:
+		unscaled.SetInt64(v.Int64())
+	default:
+		// Big-endian two's complement
+		b := v.ByteArray()
+		unscaled.SetBytes(b)
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+		}
+	}
+
+	digits := new(big.Int).Abs(unscaled).String()
+	sign := ""
+	if unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	if scale <= 0 {
+		return json.Number(sign + digits)
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return json.Number(sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:])
+}
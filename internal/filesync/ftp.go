@@ -94,35 +94,103 @@ func (c *FTPClient) ReadFile(remotePath string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// FindAndReadFile finds a file matching the pattern and reads it
-func (c *FTPClient) FindAndReadFile(remotePath, pattern string) ([]byte, string, error) {
-	// If pattern is a specific filename (no wildcards), try to read it directly
+// OpenFile streams a file from the FTP server, starting at a byte offset (resumed downloads)
+func (c *FTPClient) OpenFile(remotePath string, offset int64) (io.ReadCloser, error) {
+	resp, err := c.conn.RetrFrom(remotePath, uint64(offset))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve file: %w", err)
+	}
+	return resp, nil
+}
+
+// FindFile returns the path of the file matching the pattern
+func (c *FTPClient) FindFile(remotePath, pattern string) (string, error) {
+	// If pattern is a specific filename (no wildcards), use it directly
 	if !strings.Contains(pattern, "*") && !strings.Contains(pattern, "?") {
-		fullPath := path.Join(remotePath, pattern)
-		data, err := c.ReadFile(fullPath)
-		if err != nil {
-			return nil, "", err
-		}
-		return data, pattern, nil
+		return path.Join(remotePath, pattern), nil
 	}
 
 	// List files and find matching ones
 	files, err := c.ListFiles(remotePath, pattern)
 	if err != nil {
-		return nil, "", err
+		return "", err
 	}
 
 	if len(files) == 0 {
-		return nil, "", fmt.Errorf("no files matching pattern '%s' found in '%s'", pattern, remotePath)
+		return "", fmt.Errorf("no files matching pattern '%s' found in '%s'", pattern, remotePath)
 	}
 
-	// Read the first matching file (or most recent - could be enhanced)
-	data, err := c.ReadFile(files[0])
+	// The first matching file (or most recent - could be enhanced)
+	return files[0], nil
+}
+
+// FindAndReadFile finds a file matching the pattern and reads it
+func (c *FTPClient) FindAndReadFile(remotePath, pattern string) ([]byte, string, error) {
+	filePath, err := c.FindFile(remotePath, pattern)
 	if err != nil {
 		return nil, "", err
 	}
 
-	return data, filepath.Base(files[0]), nil
+	data, err := c.ReadFile(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, filepath.Base(filePath), nil
+}
+
+// WriteFile uploads content to a remote file, replacing it
+func (c *FTPClient) WriteFile(remotePath string, r io.Reader) error {
+	if err := c.conn.Stor(remotePath, r); err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+// Rename moves a remote file, replacing an existing destination
+func (c *FTPClient) Rename(from, to string) error {
+	err := c.conn.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+	// Many servers refuse to overwrite on RNTO. Only then (the destination exists) the old
+	// file is moved aside and restored if the retry fails, so it is never lost
+	if _, sizeErr := c.conn.FileSize(to); sizeErr != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	backup := replacedName(to)
+	if err := c.conn.Rename(to, backup); err != nil {
+		return fmt.Errorf("failed to move existing file aside: %w", err)
+	}
+	if err := c.conn.Rename(from, to); err != nil {
+		c.conn.Rename(backup, to)
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	c.conn.Delete(backup)
+	return nil
+}
+
+// Remove deletes a remote file
+func (c *FTPClient) Remove(remotePath string) error {
+	return c.conn.Delete(remotePath)
+}
+
+// MkdirAll creates a remote directory and its parents (existing ones are fine)
+func (c *FTPClient) MkdirAll(dir string) error {
+	dir = path.Clean(dir)
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	current := ""
+	if strings.HasPrefix(dir, "/") {
+		current = "/"
+	}
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		current = path.Join(current, part)
+		// MKD fails when the directory exists, that's expected
+		c.conn.MakeDir(current)
+	}
+	return nil
 }
 
 // Close closes the FTP connection
@@ -156,38 +156,58 @@ func (c *MinIOClient) ReadObject(objectKey string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// FindAndReadObject finds an object matching the pattern and reads it
-func (c *MinIOClient) FindAndReadObject(prefix string, pattern string) ([]byte, string, error) {
-	// If pattern is a specific filename (no wildcards), try to read it directly
+// OpenObject streams an object from MinIO, starting at a byte offset (resumed downloads)
+func (c *MinIOClient) OpenObject(objectKey string, offset int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if offset > 0 {
+		if err := opts.SetRange(offset, 0); err != nil {
+			return nil, fmt.Errorf("invalid offset %d: %w", offset, err)
+		}
+	}
+
+	// No timeout: large objects stream for as long as the batches take to process
+	obj, err := c.client.GetObject(context.Background(), c.bucketName, objectKey, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	return obj, nil
+}
+
+// FindObject returns the key of the object matching the pattern
+func (c *MinIOClient) FindObject(prefix string, pattern string) (string, error) {
+	// If pattern is a specific filename (no wildcards), use it directly
 	if !strings.Contains(pattern, "*") && !strings.Contains(pattern, "?") {
-		objectKey := path.Join(prefix, pattern)
 		// Remove leading slash if present
-		objectKey = strings.TrimPrefix(objectKey, "/")
-
-		data, err := c.ReadObject(objectKey)
-		if err != nil {
-			return nil, "", err
-		}
-		return data, pattern, nil
+		return strings.TrimPrefix(path.Join(prefix, pattern), "/"), nil
 	}
 
 	// List objects and find matching ones
 	objects, err := c.ListObjects(prefix, pattern)
 	if err != nil {
-		return nil, "", err
+		return "", err
 	}
 
 	if len(objects) == 0 {
-		return nil, "", fmt.Errorf("no objects matching pattern '%s' found in prefix '%s'", pattern, prefix)
+		return "", fmt.Errorf("no objects matching pattern '%s' found in prefix '%s'", pattern, prefix)
 	}
 
-	// Read the first matching object
-	data, err := c.ReadObject(objects[0].Key)
+	// The first matching object
+	return objects[0].Key, nil
+}
+
+// FindAndReadObject finds an object matching the pattern and reads it
+func (c *MinIOClient) FindAndReadObject(prefix string, pattern string) ([]byte, string, error) {
+	objectKey, err := c.FindObject(prefix, pattern)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := c.ReadObject(objectKey)
 	if err != nil {
 		return nil, "", err
 	}
 
-	return data, path.Base(objects[0].Key), nil
+	return data, path.Base(objectKey), nil
 }
 
 // WriteObject writes data to an object in MinIO
@@ -261,12 +281,32 @@ func (c *MinIOClient) WriteRecordsAsJSON(objectKey string, records []map[string]
 	return c.WriteObject(objectKey, data, "application/json")
 }
 
-// WriteRecordsAsParquet converts records to Parquet format and writes to MinIO
-// Note: For full Parquet support, consider using github.com/parquet-go/parquet-go
-// This is a simplified implementation that writes JSON (can be enhanced later)
-func (c *MinIOClient) WriteRecordsAsParquet(objectKey string, records []map[string]interface{}) error {
-	// TODO: Implement proper Parquet encoding using parquet-go library
-	// For now, we'll write as JSON-lines format which is more portable
+// WriteRecordsAsParquet converts records to Parquet format and writes to MinIO.
+// The schema is inferred from the record values
+func (c *MinIOClient) WriteRecordsAsParquet(objectKey string, records []map[string]interface{}, opts ParquetOptions) error {
+	if len(records) == 0 {
+		return fmt.Errorf("no records to write")
+	}
+
+	var buf bytes.Buffer
+	enc, err := NewParquetEncoder(&buf, InferParquetColumns(records), opts)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := enc.WriteRecord(record); err != nil {
+			return fmt.Errorf("failed to encode record: %w", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to finish parquet file: %w", err)
+	}
+
+	return c.WriteObject(objectKey, buf.Bytes(), GetContentTypeForFormat(ExportParquet))
+}
+
+// WriteRecordsAsJSONL converts records to JSON Lines format and writes to MinIO
+func (c *MinIOClient) WriteRecordsAsJSONL(objectKey string, records []map[string]interface{}) error {
 	if len(records) == 0 {
 		return fmt.Errorf("no records to write")
 	}
@@ -280,9 +320,7 @@ func (c *MinIOClient) WriteRecordsAsParquet(objectKey string, records []map[stri
 		}
 	}
 
-	// Use .jsonl extension for JSON lines format
-	// When proper Parquet support is added, this will write actual Parquet files
-	return c.WriteObject(objectKey, buf.Bytes(), "application/x-ndjson")
+	return c.WriteObject(objectKey, buf.Bytes(), GetContentTypeForFormat(ExportJSONL))
 }
 
 // WriteRecords writes records to MinIO in the specified format
@@ -292,11 +330,89 @@ func (c *MinIOClient) WriteRecords(objectKey string, records []map[string]interf
 		return c.WriteRecordsAsCSV(objectKey, records)
 	case "json":
 		return c.WriteRecordsAsJSON(objectKey, records)
-	case "parquet", "jsonl":
-		return c.WriteRecordsAsParquet(objectKey, records)
+	case "jsonl":
+		return c.WriteRecordsAsJSONL(objectKey, records)
+	case "parquet":
+		return c.WriteRecordsAsParquet(objectKey, records, ParquetOptions{})
 	default:
-		return fmt.Errorf("unsupported export format: %s (supported: csv, json, parquet)", format)
+		return fmt.Errorf("unsupported export format: %s (supported: csv, json, jsonl, parquet)", format)
+	}
+}
+
+// minioStreamPartSize is the multipart chunk of streamed uploads. Without it the client
+// sizes chunks for the largest possible object and buffers 512MB per upload
+const minioStreamPartSize = 16 * 1024 * 1024
+
+// ObjectWriter streams an object of unknown size to MinIO as a multipart upload.
+// The object only appears once Close returns without error
+type ObjectWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+	size int64
+	err  error // First write error, the upload is broken once set
+}
+
+// NewObjectWriter starts the upload of an object, fed by the writes of the returned writer
+func (c *MinIOClient) NewObjectWriter(objectKey, contentType string) *ObjectWriter {
+	reader, writer := io.Pipe()
+	w := &ObjectWriter{pipe: writer, done: make(chan error, 1)}
+
+	go func() {
+		_, err := c.client.PutObject(context.Background(), c.bucketName, objectKey, reader, -1, minio.PutObjectOptions{
+			ContentType: contentType,
+			PartSize:    minioStreamPartSize,
+		})
+		// Unblock the writer if the upload stopped early
+		reader.CloseWithError(err)
+		if err != nil {
+			err = fmt.Errorf("failed to write object %s: %w", objectKey, err)
+		}
+		w.done <- err
+	}()
+	return w
+}
+
+// Write sends data to the upload
+func (w *ObjectWriter) Write(p []byte) (int, error) {
+	n, err := w.pipe.Write(p)
+	w.size += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
 	}
+	return n, err
+}
+
+// Err returns the first write error of the upload
+func (w *ObjectWriter) Err() error {
+	return w.err
+}
+
+// Size returns the number of bytes written
+func (w *ObjectWriter) Size() int64 {
+	return w.size
+}
+
+// Close completes the upload and waits for the object to be stored
+func (w *ObjectWriter) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
+
+// Abort cancels the upload, no object is created
+func (w *ObjectWriter) Abort(cause error) {
+	w.pipe.CloseWithError(cause)
+	<-w.done
+}
+
+// RemoveObject deletes an object from the bucket
+func (c *MinIOClient) RemoveObject(objectKey string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := c.client.RemoveObject(ctx, c.bucketName, objectKey, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to remove object %s: %w", objectKey, err)
+	}
+	return nil
 }
 
 // GetContentTypeForFormat returns the appropriate content type for a format
@@ -307,9 +423,11 @@ func GetContentTypeForFormat(format string) string {
 	case "json":
 		return "application/json"
 	case "parquet":
-		return "application/octet-stream"
+		return "application/vnd.apache.parquet"
 	case "jsonl":
 		return "application/x-ndjson"
+	case "xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
 	default:
 		return "application/octet-stream"
 	}
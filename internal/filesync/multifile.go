@@ -0,0 +1,273 @@
+package filesync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/minio/minio-go/v7"
+)
+
+// Post-load actions of multi-file sources
+const (
+	PostActionNone    = "none"
+	PostActionArchive = "archive" // Move to the archive folder
+	PostActionRename  = "rename"  // Append a suffix to the name
+	PostActionDelete  = "delete"
+)
+
+// defaultRenameSuffix is appended by the rename action when no suffix is configured
+const defaultRenameSuffix = ".processed"
+
+// FileInfo describes a remote file of a multi-file source
+type FileInfo struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// FileSource is a location files are ingested from (FTP, SFTP or a MinIO bucket)
+type FileSource interface {
+	ListFileInfos(dir, pattern string) ([]FileInfo, error)
+	FindFile(dir, pattern string) (string, error)
+	OpenFile(filePath string, offset int64) (io.ReadCloser, error)
+	Rename(from, to string) error
+	Remove(filePath string) error
+	MkdirAll(dir string) error
+	Close() error
+}
+
+// matchFileName applies the pattern of a source to a file name ("" matches everything)
+func matchFileName(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := filepath.Match(pattern, name)
+	return err == nil && matched
+}
+
+// ListFileInfos lists the files in the remote directory matching the pattern, with size and mtime
+func (c *FTPClient) ListFileInfos(remotePath, pattern string) ([]FileInfo, error) {
+	entries, err := c.conn.List(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory: %w", err)
+	}
+
+	var files []FileInfo
+	for _, entry := range entries {
+		if entry.Type == ftp.EntryTypeFile && matchFileName(pattern, entry.Name) {
+			files = append(files, FileInfo{
+				Path:    path.Join(remotePath, entry.Name),
+				Size:    int64(entry.Size),
+				ModTime: entry.Time,
+			})
+		}
+	}
+	return files, nil
+}
+
+// ListFileInfos lists the files in the remote directory matching the pattern, with size and mtime
+func (c *SFTPClient) ListFileInfos(remotePath, pattern string) ([]FileInfo, error) {
+	entries, err := c.sftpConn.ReadDir(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory: %w", err)
+	}
+
+	var files []FileInfo
+	for _, entry := range entries {
+		if !entry.IsDir() && matchFileName(pattern, entry.Name()) {
+			files = append(files, FileInfo{
+				Path:    path.Join(remotePath, entry.Name()),
+				Size:    entry.Size(),
+				ModTime: entry.ModTime(),
+			})
+		}
+	}
+	return files, nil
+}
+
+// minioFiles serves a MinIO bucket as a FileSource, object keys being the file paths
+type minioFiles struct {
+	*MinIOClient
+}
+
+// Files returns the bucket as a FileSource
+func (c *MinIOClient) Files() FileSource {
+	return minioFiles{c}
+}
+
+func (m minioFiles) ListFileInfos(prefix, pattern string) ([]FileInfo, error) {
+	objects, err := m.ListObjects(prefix, pattern)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]FileInfo, 0, len(objects))
+	for _, obj := range objects {
+		files = append(files, FileInfo{Path: obj.Key, Size: obj.Size, ModTime: obj.LastModified})
+	}
+	return files, nil
+}
+
+func (m minioFiles) FindFile(prefix, pattern string) (string, error) {
+	return m.FindObject(prefix, pattern)
+}
+
+func (m minioFiles) OpenFile(objectKey string, offset int64) (io.ReadCloser, error) {
+	return m.OpenObject(objectKey, offset)
+}
+
+// Rename copies the object to its new key and removes the original
+func (m minioFiles) Rename(from, to string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	dst := minio.CopyDestOptions{Bucket: m.bucketName, Object: to}
+	src := minio.CopySrcOptions{Bucket: m.bucketName, Object: from}
+	if _, err := m.client.CopyObject(ctx, dst, src); err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+	return m.RemoveObject(from)
+}
+
+func (m minioFiles) Remove(objectKey string) error {
+	return m.RemoveObject(objectKey)
+}
+
+// MkdirAll is a no-op, buckets have no directories
+func (m minioFiles) MkdirAll(string) error {
+	return nil
+}
+
+// SortFiles orders files by name, or by modification time (oldest first) for order "mtime"
+func SortFiles(files []FileInfo, order string) {
+	sort.SliceStable(files, func(i, j int) bool {
+		if order == "mtime" && !files[i].ModTime.Equal(files[j].ModTime) {
+			return files[i].ModTime.Before(files[j].ModTime)
+		}
+		return files[i].Path < files[j].Path
+	})
+}
+
+// ResolveArchiveDir resolves a relative archive folder against the directory (or prefix) the
+// files are listed from
+func ResolveArchiveDir(sourceDir, archiveDir string) string {
+	if archiveDir == "" || path.IsAbs(archiveDir) {
+		return archiveDir
+	}
+	return path.Join(sourceDir, archiveDir)
+}
+
+// IsPostActionOutput reports whether a listed file is the result of a post-load action
+// (archived or renamed before), which must not be loaded again
+func IsPostActionOutput(filePath, action, archiveDir, suffix string) bool {
+	switch action {
+	case PostActionArchive:
+		return archiveDir != "" && strings.HasPrefix(filePath, strings.TrimSuffix(archiveDir, "/")+"/")
+	case PostActionRename:
+		if suffix == "" {
+			suffix = defaultRenameSuffix
+		}
+		return strings.HasSuffix(filePath, suffix)
+	}
+	return false
+}
+
+// ApplyPostAction archives, renames or deletes a loaded file. archiveDir is already resolved
+// (see ResolveArchiveDir). Returns the file's new path ("" when deleted or left in place)
+func ApplyPostAction(src FileSource, filePath, action, archiveDir, suffix string) (string, error) {
+	switch action {
+	case "", PostActionNone:
+		return "", nil
+	case PostActionArchive:
+		if archiveDir == "" {
+			return "", fmt.Errorf("no archive folder configured")
+		}
+		if err := src.MkdirAll(archiveDir); err != nil {
+			return "", fmt.Errorf("failed to create archive folder: %w", err)
+		}
+		to := path.Join(archiveDir, path.Base(filePath))
+		if err := src.Rename(filePath, to); err != nil {
+			return "", err
+		}
+		return to, nil
+	case PostActionRename:
+		if suffix == "" {
+			suffix = defaultRenameSuffix
+		}
+		to := filePath + suffix
+		if err := src.Rename(filePath, to); err != nil {
+			return "", err
+		}
+		return to, nil
+	case PostActionDelete:
+		return "", src.Remove(filePath)
+	default:
+		return "", fmt.Errorf("unknown post-load action %q", action)
+	}
+}
+
+// ChecksumOpen wraps the opener of a streamed file to compute its SHA-256 while it downloads.
+// Resumed downloads re-read bytes already hashed, those are skipped. The returned sum reads
+// whatever the parser left unread (e.g. after a JSON array) before finishing the checksum
+func ChecksumOpen(open OpenFunc, size int64) (OpenFunc, func() (string, error)) {
+	h := sha256.New()
+	var hashed int64
+	wrapped := func(offset int64) (io.ReadCloser, error) {
+		if offset > hashed {
+			return nil, fmt.Errorf("checksum: resume at byte %d past the %d bytes hashed", offset, hashed)
+		}
+		body, err := open(offset)
+		if err != nil {
+			return nil, err
+		}
+		return &hashingReader{ReadCloser: body, h: h, hashed: &hashed, pos: offset}, nil
+	}
+	sum := func() (string, error) {
+		if hashed < size {
+			body, err := wrapped(hashed)
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(io.Discard, body)
+			body.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	return wrapped, sum
+}
+
+// hashingReader feeds the bytes past the hashed position to the file checksum
+type hashingReader struct {
+	io.ReadCloser
+	h      hash.Hash
+	hashed *int64
+	pos    int64
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		end := r.pos + int64(n)
+		if end > *r.hashed {
+			skip := *r.hashed - r.pos
+			if skip < 0 {
+				skip = 0
+			}
+			r.h.Write(p[skip:n])
+			*r.hashed = end
+		}
+		r.pos = end
+	}
+	return n, err
+}
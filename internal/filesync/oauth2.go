@@ -0,0 +1,164 @@
+package filesync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthOAuth2 is the OAuth2 client-credentials auth type of API sources and targets
+const AuthOAuth2 = "oauth2"
+
+// OAuth2Config holds the client-credentials settings of an API
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scope        string // Space separated, optional
+	Audience     string // Optional (Auth0 and similar providers)
+}
+
+// oauth2ExpiryMargin renews cached tokens this long before they expire
+const oauth2ExpiryMargin = 30 * time.Second
+
+// oauth2DefaultLifetime is assumed for tokens returned without expires_in
+const oauth2DefaultLifetime = 5 * time.Minute
+
+// oauth2Token is a cached access token
+type oauth2Token struct {
+	value   string
+	expires time.Time
+}
+
+// oauth2Entry is the cached token of a client and scope. Its mutex is held while a token is
+// requested, so callers of one API wait for a single request and other APIs aren't blocked
+type oauth2Entry struct {
+	mu    sync.Mutex
+	token oauth2Token
+}
+
+// oauth2Tokens caches access tokens per client and scope for the whole process, so every
+// job of an API shares its token until it expires
+var oauth2Tokens = struct {
+	sync.Mutex
+	entries map[string]*oauth2Entry
+}{entries: make(map[string]*oauth2Entry)}
+
+// ValidateOAuth2Config checks the settings needed to request a token
+func ValidateOAuth2Config(cfg OAuth2Config) error {
+	if cfg.TokenURL == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return fmt.Errorf("oauth2 auth needs a token URL, client ID and client secret")
+	}
+	if u, err := url.Parse(cfg.TokenURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid oauth2 token URL %q", cfg.TokenURL)
+	}
+	return nil
+}
+
+// cacheKey identifies the token of a client. The secret is part of it (hashed, so it doesn't
+// sit in the cache in clear), a rotated or mistyped secret never reuses another one's token
+func (cfg OAuth2Config) cacheKey() string {
+	secret := sha256.Sum256([]byte(cfg.ClientSecret))
+	return strings.Join([]string{cfg.TokenURL, cfg.ClientID, hex.EncodeToString(secret[:]), cfg.Scope, cfg.Audience}, "|")
+}
+
+// accessToken returns the cached token of cfg, requesting a new one when it is missing,
+// about to expire, or refresh is set (the API refused the cached one)
+func (c *APIClient) accessToken(cfg OAuth2Config, refresh bool) (string, error) {
+	key := cfg.cacheKey()
+	oauth2Tokens.Lock()
+	entry, ok := oauth2Tokens.entries[key]
+	if !ok {
+		entry = &oauth2Entry{}
+		oauth2Tokens.entries[key] = entry
+	}
+	oauth2Tokens.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.token.value != "" && !refresh && time.Now().Before(entry.token.expires.Add(-oauth2ExpiryMargin)) {
+		return entry.token.value, nil
+	}
+	token, err := c.requestToken(cfg)
+	if err != nil {
+		entry.token = oauth2Token{}
+		return "", err
+	}
+	entry.token = token
+	return token.value, nil
+}
+
+// RequestOAuth2Token requests a new token, to test the settings of an API
+func (c *APIClient) RequestOAuth2Token(cfg OAuth2Config) (time.Duration, error) {
+	if err := ValidateOAuth2Config(cfg); err != nil {
+		return 0, err
+	}
+	token, err := c.requestToken(cfg)
+	if err != nil {
+		return 0, err
+	}
+	return time.Until(token.expires).Round(time.Second), nil
+}
+
+// requestToken runs the client-credentials grant, the client authenticating with its
+// credentials in the form body
+func (c *APIClient) requestToken(cfg OAuth2Config) (oauth2Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", cfg.ClientID)
+	form.Set("client_secret", cfg.ClientSecret)
+	if cfg.Scope != "" {
+		form.Set("scope", cfg.Scope)
+	}
+	if cfg.Audience != "" {
+		form.Set("audience", cfg.Audience)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var payload struct {
+		AccessToken      string      `json:"access_token"`
+		TokenType        string      `json:"token_type"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}
+	json.Unmarshal(body, &payload)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || payload.AccessToken == "" {
+		if payload.Error != "" {
+			return oauth2Token{}, fmt.Errorf("token endpoint returned status %d: %s %s", resp.StatusCode, payload.Error, payload.ErrorDescription)
+		}
+		return oauth2Token{}, fmt.Errorf("token endpoint returned status %d without an access token", resp.StatusCode)
+	}
+	if payload.TokenType != "" && !strings.EqualFold(payload.TokenType, "bearer") {
+		return oauth2Token{}, fmt.Errorf("unsupported token type %q", payload.TokenType)
+	}
+
+	lifetime := oauth2DefaultLifetime
+	if seconds, err := payload.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		lifetime = time.Duration(seconds) * time.Second
+	}
+	return oauth2Token{value: payload.AccessToken, expires: time.Now().Add(lifetime)}, nil
+}
@@ -0,0 +1,139 @@
+package filesync
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Object layout defaults of MinIO/S3 targets
+const (
+	DefaultObjectKeyTemplate = "{table}/{partition}/run-{run_id}-part-{part}.{ext}"
+	DefaultManifestTemplate  = "{table}/_manifests/run-{run_id}.json"
+	HiveDefaultPartition     = "__HIVE_DEFAULT_PARTITION__" // Directory of null partition values
+)
+
+// ObjectKeyTemplate returns the object key template of a target object path. An empty path
+// uses DefaultObjectKeyTemplate; a plain path without tokens (the former fixed object key)
+// becomes the prefix of the default layout, without its extension. {part} is added before
+// the extension when missing, so rolled parts never overwrite each other
+func ObjectKeyTemplate(objectPath string) string {
+	objectPath = strings.Trim(strings.TrimSpace(objectPath), "/")
+	switch {
+	case objectPath == "":
+		return DefaultObjectKeyTemplate
+	case !strings.Contains(objectPath, "{"):
+		return path.Join(strings.TrimSuffix(objectPath, path.Ext(objectPath)), DefaultObjectKeyTemplate)
+	case !strings.Contains(objectPath, "{part}"):
+		ext := path.Ext(objectPath)
+		return strings.TrimSuffix(objectPath, ext) + "-{part}" + ext
+	}
+	return objectPath
+}
+
+// ManifestTemplate returns the manifest key template of a target: the configured template,
+// or DefaultManifestTemplate under the prefix of a plain object path
+func ManifestTemplate(manifestPath, objectPath string) string {
+	if manifestPath = strings.Trim(strings.TrimSpace(manifestPath), "/"); manifestPath != "" {
+		return manifestPath
+	}
+	objectPath = strings.Trim(strings.TrimSpace(objectPath), "/")
+	if objectPath != "" && !strings.Contains(objectPath, "{") {
+		return path.Join(strings.TrimSuffix(objectPath, path.Ext(objectPath)), DefaultManifestTemplate)
+	}
+	return DefaultManifestTemplate
+}
+
+// RenderObjectKey expands an object key template: the RenderFileName tokens plus {part}
+// (zero padded part number) and {partition} (Hive path such as region=west/year=2024).
+// Partitioned keys without a {partition} token get it as the last directory. Empty path
+// segments are dropped
+func RenderObjectKey(template string, vars FileNameVars, part int, partition string) string {
+	if partition != "" && !strings.Contains(template, "{partition}") {
+		dir, file := path.Split(template)
+		template = dir + "{partition}/" + file
+	}
+	key := strings.NewReplacer(
+		"{part}", fmt.Sprintf("%05d", part),
+		"{partition}", partition,
+	).Replace(template)
+	key = RenderFileName(key, vars)
+
+	segments := strings.Split(key, "/")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if segment != "" {
+			kept = append(kept, segment)
+		}
+	}
+	return strings.Join(kept, "/")
+}
+
+// SuffixObjectKey inserts a suffix before the extension of an object key or key template,
+// e.g. to keep apart the objects of batches that render the same key
+func SuffixObjectKey(key, suffix string) string {
+	dir, file := path.Split(key)
+	ext := path.Ext(file)
+	return dir + strings.TrimSuffix(file, ext) + "_" + suffix + ext
+}
+
+// HivePartitionPath builds the Hive-style directory of partition values (col=value/...).
+// Null or empty values go to HiveDefaultPartition
+func HivePartitionPath(columns []string, values []interface{}) string {
+	dirs := make([]string, len(columns))
+	for i, col := range columns {
+		value := HiveDefaultPartition
+		if i < len(values) {
+			if v := FormatExportValue(values[i]); v != "" {
+				value = escapeHivePathValue(v)
+			}
+		}
+		dirs[i] = escapeHivePathValue(col) + "=" + value
+	}
+	return strings.Join(dirs, "/")
+}
+
+// escapeHivePathValue percent-encodes the characters Hive escapes in partition directories
+func escapeHivePathValue(v string) string {
+	var b strings.Builder
+	for _, r := range v {
+		if r < 0x20 || r == 0x7F || strings.ContainsRune("\"#%'*/:=?\\{[]^", r) {
+			fmt.Fprintf(&b, "%%%02X", r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ManifestPart is a part object listed in a run manifest
+type ManifestPart struct {
+	Key       string            `json:"key"`
+	Rows      int64             `json:"rows"`
+	Bytes     int64             `json:"bytes"`
+	Partition map[string]string `json:"partition,omitempty"`
+}
+
+// ObjectManifest lists the part objects a run wrote. It is written last, so its presence
+// marks the run output as complete
+type ObjectManifest struct {
+	JobID      uint           `json:"job_id"`
+	RunID      uint           `json:"run_id"`
+	Table      string         `json:"table"`
+	Format     string         `json:"format"`
+	Rows       int64          `json:"rows"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Parts      []ManifestPart `json:"parts"`
+}
+
+// WriteManifest writes a run manifest as JSON
+func (c *MinIOClient) WriteManifest(objectKey string, manifest ObjectManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	return c.WriteObject(objectKey, data, "application/json")
+}
@@ -0,0 +1,418 @@
+package filesync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Pagination strategies of API sources
+const (
+	PaginationNone    = "none"
+	PaginationPage    = "page"        // Page number query parameter
+	PaginationOffset  = "offset"      // Record offset query parameter
+	PaginationCursor  = "cursor"      // Token read from the response, sent back as a query parameter
+	PaginationLink    = "link_header" // RFC 8288 Link header with rel="next"
+	PaginationNextURL = "next_url"    // URL of the next page read from the response
+)
+
+// DefaultAPIMaxPages bounds the pages of a run when no maximum is configured
+const DefaultAPIMaxPages = 1000
+
+// APIPagination configures how FetchPages walks a paginated API
+type APIPagination struct {
+	Strategy    string // none, page, offset, cursor, link_header, next_url
+	PageParam   string // page/offset: query parameter of the page number or offset (default page/offset)
+	SizeParam   string // Query parameter of the page size (e.g. limit, per_page), "" to not send it
+	PageSize    int    // Records per page; a shorter page is the last one
+	StartPage   int    // page: first page number (default 1)
+	CursorPath  string // cursor: JSON path of the next token; next_url: JSON path of the next URL
+	CursorParam string // cursor: query parameter receiving the token (default cursor)
+	HasMorePath string // JSON path of a boolean, pagination stops when it is false
+	MaxPages    int    // 0 = DefaultAPIMaxPages
+
+	// next_url/link_header: follow next pages on another scheme or host. They are fetched
+	// with the API's credentials, so by default only the host of the API URL is followed
+	FollowOtherHosts bool
+
+	RecordPath string // JSON path of the records (e.g. $.result.items), "" for array, object or data wrapper
+
+	IncrementalParam string // Query parameter receiving Checkpoint, omitted while it is empty
+	Checkpoint       string
+}
+
+// ValidateAPIPagination checks the pagination settings of an API source
+func ValidateAPIPagination(p APIPagination) error {
+	switch p.Strategy {
+	case "", PaginationNone, PaginationPage, PaginationOffset, PaginationLink:
+	case PaginationCursor, PaginationNextURL:
+		if p.CursorPath == "" {
+			return fmt.Errorf("%s pagination needs a cursor path (JSON path of the next page)", p.Strategy)
+		}
+	default:
+		return fmt.Errorf("invalid pagination %q (none, page, offset, cursor, link_header or next_url)", p.Strategy)
+	}
+	if p.PageSize < 0 || p.MaxPages < 0 {
+		return fmt.Errorf("page size and max pages can't be negative")
+	}
+	for _, path := range []string{p.RecordPath, p.CursorPath, p.HasMorePath} {
+		if _, err := parseJSONPath(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// APIPage is a page of records fetched by FetchPages
+type APIPage struct {
+	Number  int // 1-based
+	URL     string
+	Records []map[string]interface{}
+}
+
+// FetchPages requests the pages of an API one after the other and hands each page's records
+// to fn, which may stop the walk by returning an error. Pagination ends on an empty page, a
+// page shorter than PageSize, a missing next cursor/URL/link, HasMorePath false, or after
+// MaxPages. Returns the number of pages fetched and whether MaxPages cut the walk short
+func (c *APIClient) FetchPages(config APIConfig, p APIPagination, fn func(APIPage) error) (int, bool, error) {
+	recordPath, err := parseJSONPath(p.RecordPath)
+	if err != nil {
+		return 0, false, err
+	}
+	maxPages := p.MaxPages
+	if maxPages <= 0 {
+		maxPages = DefaultAPIMaxPages
+	}
+
+	base, err := url.Parse(config.URL)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid API URL: %w", err)
+	}
+	query := base.Query()
+	if p.IncrementalParam != "" && p.Checkpoint != "" {
+		query.Set(p.IncrementalParam, p.Checkpoint)
+	}
+	pageParam := p.PageParam
+	position := 0 // Page number or record offset
+	switch p.Strategy {
+	case PaginationPage:
+		if pageParam == "" {
+			pageParam = "page"
+		}
+		position = p.StartPage
+		if position == 0 {
+			position = 1
+		}
+		query.Set(pageParam, strconv.Itoa(position))
+	case PaginationOffset:
+		if pageParam == "" {
+			pageParam = "offset"
+		}
+		query.Set(pageParam, "0")
+	}
+	if p.SizeParam != "" && p.PageSize > 0 {
+		query.Set(p.SizeParam, strconv.Itoa(p.PageSize))
+	}
+	base.RawQuery = query.Encode()
+	pageURL := base.String()
+
+	seen := map[string]bool{}
+	for page := 1; page <= maxPages; page++ {
+		seen[pageURL] = true
+		request := config
+		request.URL = pageURL
+		body, header, err := c.fetch(request)
+		if err != nil {
+			return page - 1, false, fmt.Errorf("page %d: %w", page, err)
+		}
+
+		var doc interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return page - 1, false, fmt.Errorf("page %d: failed to parse JSON: %w", page, err)
+		}
+		records, err := selectRecords(doc, recordPath)
+		if err != nil {
+			return page - 1, false, fmt.Errorf("page %d: %w", page, err)
+		}
+		if err := fn(APIPage{Number: page, URL: pageURL, Records: records}); err != nil {
+			return page, false, err
+		}
+
+		// Stop conditions common to all strategies
+		if p.Strategy == "" || p.Strategy == PaginationNone {
+			return page, false, nil
+		}
+		if p.HasMorePath != "" {
+			if more, ok := lookupJSONPath(doc, p.HasMorePath); ok && !truthy(more) {
+				return page, false, nil
+			}
+		}
+		if (p.Strategy == PaginationPage || p.Strategy == PaginationOffset) &&
+			(len(records) == 0 || (p.PageSize > 0 && len(records) < p.PageSize)) {
+			return page, false, nil
+		}
+
+		next := ""
+		switch p.Strategy {
+		case PaginationPage, PaginationOffset:
+			if p.Strategy == PaginationPage {
+				position++
+			} else {
+				position += len(records)
+			}
+			u, _ := url.Parse(pageURL)
+			q := u.Query()
+			q.Set(pageParam, strconv.Itoa(position))
+			u.RawQuery = q.Encode()
+			next = u.String()
+		case PaginationCursor:
+			token, ok := lookupJSONPath(doc, p.CursorPath)
+			if !ok || token == nil || fmt.Sprint(token) == "" {
+				return page, false, nil
+			}
+			cursorParam := p.CursorParam
+			if cursorParam == "" {
+				cursorParam = "cursor"
+			}
+			u, _ := url.Parse(base.String())
+			q := u.Query()
+			q.Set(cursorParam, fmt.Sprint(token))
+			u.RawQuery = q.Encode()
+			next = u.String()
+		case PaginationNextURL:
+			value, ok := lookupJSONPath(doc, p.CursorPath)
+			if next, _ = value.(string); !ok || next == "" {
+				return page, false, nil
+			}
+		case PaginationLink:
+			if next = nextLink(header.Values("Link")); next == "" {
+				return page, false, nil
+			}
+		}
+
+		// Relative next URLs resolve against the current page
+		current, _ := url.Parse(pageURL)
+		resolved, err := current.Parse(next)
+		if err != nil {
+			return page, false, fmt.Errorf("page %d: invalid next page URL %q: %w", page, next, err)
+		}
+		if !p.FollowOtherHosts && (resolved.Scheme != base.Scheme || resolved.Host != base.Host) {
+			return page, false, fmt.Errorf("page %d: next page URL %s is on another host than the API URL, not sending the credentials there", page, resolved.Redacted())
+		}
+		pageURL = resolved.String()
+		if seen[pageURL] {
+			return page, false, nil // The API points back at a page already read
+		}
+	}
+	return maxPages, true, nil
+}
+
+// linkNextPattern matches the rel="next" target of a Link header
+var linkNextPattern = regexp.MustCompile(`<([^>]*)>[^,]*;\s*rel="?([^",]*\s)?next(\s[^",]*)?"?`)
+
+// nextLink returns the URL of the next page from Link headers
+func nextLink(values []string) string {
+	for _, value := range values {
+		if m := linkNextPattern.FindStringSubmatch(value); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// selectRecords turns the value at the record path into records. Without a path the response
+// is read like ParseAPIResponse: an array, a single object, or a data wrapper
+func selectRecords(doc interface{}, path []jsonPathStep) ([]map[string]interface{}, error) {
+	value := doc
+	if path == nil {
+		if obj, ok := doc.(map[string]interface{}); ok {
+			if data, ok := obj["data"].([]interface{}); ok {
+				value = data
+			}
+		}
+	} else {
+		var ok bool
+		if value, ok = evalJSONPath(doc, path); !ok {
+			return nil, nil // No records on this page
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return []map[string]interface{}{v}, nil
+	case []interface{}:
+		records := make([]map[string]interface{}, 0, len(v))
+		for i, item := range v {
+			switch r := item.(type) {
+			case map[string]interface{}:
+				records = append(records, r)
+			case nil:
+			default:
+				return nil, fmt.Errorf("record %d is not an object", i)
+			}
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("records path doesn't select objects")
+}
+
+// truthy reads a has-more flag (boolean, number or string)
+func truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return b
+	case json.Number:
+		return b.String() != "0"
+	case string:
+		parsed, err := strconv.ParseBool(b)
+		return err == nil && parsed
+	}
+	return true
+}
+
+// jsonPathStep is a step of a JSON path: an object key, an array index, or a wildcard
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses the JSONPath subset used for API responses: $.result.items,
+// result.items, $['odd key'][0], data[*].records. A nil path is the empty path
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	path = strings.TrimSpace(path)
+	if path == "" || path == "$" {
+		return nil, nil
+	}
+	rest := strings.TrimPrefix(path, "$")
+	var steps []jsonPathStep
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: missing ]", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			default:
+				n, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid JSON path %q: bad index %q", path, inner)
+				}
+				steps = append(steps, jsonPathStep{index: n, isIndex: true})
+			}
+			continue
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		key := rest[:end]
+		rest = rest[end:]
+		if key == "" {
+			continue
+		}
+		if key == "*" {
+			steps = append(steps, jsonPathStep{wildcard: true})
+		} else {
+			steps = append(steps, jsonPathStep{key: key})
+		}
+	}
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	return steps, nil
+}
+
+// evalJSONPath walks a decoded JSON document. Wildcards collect their matches in a list,
+// nested lists of a wildcard are flattened (data[*].items gives the items of every element)
+func evalJSONPath(doc interface{}, steps []jsonPathStep) (interface{}, bool) {
+	current := doc
+	for i, step := range steps {
+		switch {
+		case step.wildcard:
+			var items []interface{}
+			switch v := current.(type) {
+			case []interface{}:
+				items = v
+			case map[string]interface{}:
+				for _, item := range v {
+					items = append(items, item)
+				}
+			default:
+				return nil, false
+			}
+			var out []interface{}
+			for _, item := range items {
+				value, ok := evalJSONPath(item, steps[i+1:])
+				if !ok {
+					continue
+				}
+				if list, isList := value.([]interface{}); isList {
+					out = append(out, list...)
+				} else {
+					out = append(out, value)
+				}
+			}
+			return out, true
+		case step.isIndex:
+			list, ok := current.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			index := step.index
+			if index < 0 {
+				index += len(list)
+			}
+			if index < 0 || index >= len(list) {
+				return nil, false
+			}
+			current = list[index]
+		default:
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if current, ok = obj[step.key]; !ok {
+				return nil, false
+			}
+		}
+	}
+	return current, true
+}
+
+// lookupJSONPath evaluates a JSON path given as text
+func lookupJSONPath(doc interface{}, path string) (interface{}, bool) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false
+	}
+	return evalJSONPath(doc, steps)
+}
+
+// fetch performs the request of FetchAPI, also returning the response headers
+func (c *APIClient) fetch(config APIConfig) ([]byte, http.Header, error) {
+	var header http.Header
+	body, err := c.fetchWith(config, func(resp *http.Response) { header = resp.Header })
+	return body, header, err
+}
@@ -0,0 +1,447 @@
+package filesync
+
+import (
+	"dsp-platform/internal/database"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+)
+
+// ExportParquet is the Apache Parquet export format
+const ExportParquet = "parquet"
+
+// Parquet writer defaults
+const (
+	DefaultParquetRowGroupSize = 100000  // Rows per row group (bounds writer memory)
+	DefaultParquetPartRows     = 1000000 // Rows per part file of streamed runs
+	parquetWriteBatch          = 1000    // Rows handed to the writer at once
+)
+
+// ParquetColumn is a column of a Parquet file. Type is the logical type name used for
+// target tables (boolean, smallint, integer, bigint, decimal, real, double, varchar,
+// text, date, time, timestamp, timestamptz, uuid, json, binary)
+type ParquetColumn struct {
+	Name      string
+	Type      string
+	Precision int
+	Scale     int
+}
+
+// ParquetOptions configures Parquet encoding
+type ParquetOptions struct {
+	Compression  string // snappy (default), zstd, gzip, none
+	RowGroupSize int64  // Rows per row group, 0 = DefaultParquetRowGroupSize
+}
+
+// parquetCodec returns the compression codec of a name
+func parquetCodec(name string) (compress.Codec, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "snappy":
+		return &parquet.Snappy, nil
+	case "zstd":
+		return &parquet.Zstd, nil
+	case "gzip":
+		return &parquet.Gzip, nil
+	case "none", "uncompressed":
+		return &parquet.Uncompressed, nil
+	}
+	return nil, fmt.Errorf("unsupported parquet compression %q (snappy, zstd, gzip or none)", name)
+}
+
+// ValidateParquetOptions checks the compression codec and row group size
+func ValidateParquetOptions(opts ParquetOptions) error {
+	if _, err := parquetCodec(opts.Compression); err != nil {
+		return err
+	}
+	if opts.RowGroupSize < 0 {
+		return fmt.Errorf("parquet row group size must be positive")
+	}
+	return nil
+}
+
+// parquetNode returns the optional schema node of a logical column type
+func parquetNode(col ParquetColumn) parquet.Node {
+	var node parquet.Node
+	switch col.Type {
+	case "boolean":
+		node = parquet.Leaf(parquet.BooleanType)
+	case "smallint", "integer":
+		node = parquet.Int(32)
+	case "bigint":
+		node = parquet.Int(64)
+	case "decimal":
+		if col.Precision > 0 && col.Precision <= 18 {
+			node = parquet.Decimal(col.Scale, col.Precision, parquet.Int64Type)
+		} else {
+			node = parquet.String() // Unbounded or too wide for int64, keep exact text
+		}
+	case "real", "double":
+		node = parquet.Leaf(parquet.DoubleType)
+	case "date":
+		node = parquet.Date()
+	case "timestamp":
+		node = parquet.TimestampAdjusted(parquet.Microsecond, false)
+	case "timestamptz":
+		node = parquet.Timestamp(parquet.Microsecond)
+	case "binary":
+		node = parquet.Leaf(parquet.ByteArrayType)
+	default: // varchar, text, time, uuid, json
+		node = parquet.String()
+	}
+	return parquet.Optional(node)
+}
+
+// ParquetEncoder streams records into one Parquet file. It implements RecordEncoder
+type ParquetEncoder struct {
+	writer  *parquet.Writer
+	columns []ParquetColumn // In schema column order
+	index   map[string]int
+	pending []parquet.Row
+	rows    int64
+}
+
+// NewParquetEncoder creates an encoder writing to w with a schema built from columns
+func NewParquetEncoder(w io.Writer, columns []ParquetColumn, opts ParquetOptions) (*ParquetEncoder, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("parquet schema needs at least one column")
+	}
+	codec, err := parquetCodec(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	rowGroup := opts.RowGroupSize
+	if rowGroup <= 0 {
+		rowGroup = DefaultParquetRowGroupSize
+	}
+
+	group := parquet.Group{}
+	byName := make(map[string]ParquetColumn, len(columns))
+	for _, col := range columns {
+		if _, dup := byName[col.Name]; dup {
+			return nil, fmt.Errorf("duplicate parquet column %s", col.Name)
+		}
+		byName[col.Name] = col
+		group[col.Name] = parquetNode(col)
+	}
+	schema := parquet.NewSchema("record", group)
+
+	// Row values are laid out in schema column order
+	enc := &ParquetEncoder{index: make(map[string]int, len(columns))}
+	for i, path := range schema.Columns() {
+		name := path[0]
+		enc.columns = append(enc.columns, byName[name])
+		enc.index[name] = i
+	}
+
+	config, err := parquet.NewWriterConfig(schema, parquet.Compression(codec), parquet.MaxRowsPerRowGroup(rowGroup))
+	if err != nil {
+		return nil, err
+	}
+	enc.writer = parquet.NewWriter(w, config)
+	return enc, nil
+}
+
+// HasColumns reports whether every key of the record is a column of the schema
+func (e *ParquetEncoder) HasColumns(record map[string]interface{}) bool {
+	for name := range record {
+		if _, ok := e.index[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteRecord converts a record to a row. Keys that aren't columns are ignored
+func (e *ParquetEncoder) WriteRecord(record map[string]interface{}) error {
+	row := make(parquet.Row, len(e.columns))
+	for i, col := range e.columns {
+		value, err := parquetValue(record[col.Name], col)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		if value.IsNull() {
+			row[i] = parquet.NullValue().Level(0, 0, i)
+		} else {
+			row[i] = value.Level(0, 1, i)
+		}
+	}
+	e.pending = append(e.pending, row)
+	e.rows++
+	if len(e.pending) >= parquetWriteBatch {
+		return e.flushRows()
+	}
+	return nil
+}
+
+// flushRows hands buffered rows to the parquet writer
+func (e *ParquetEncoder) flushRows() error {
+	if len(e.pending) == 0 {
+		return nil
+	}
+	if _, err := e.writer.WriteRows(e.pending); err != nil {
+		return fmt.Errorf("failed to write parquet rows: %w", err)
+	}
+	e.pending = e.pending[:0]
+	return nil
+}
+
+// Rows returns the number of records written
+func (e *ParquetEncoder) Rows() int64 {
+	return e.rows
+}
+
+// Close writes the remaining rows and the file footer
+func (e *ParquetEncoder) Close() error {
+	if err := e.flushRows(); err != nil {
+		return err
+	}
+	return e.writer.Close()
+}
+
+// parquetValue converts a record value to the physical value of a column
+func parquetValue(v interface{}, col ParquetColumn) (parquet.Value, error) {
+	if v == nil {
+		return parquet.NullValue(), nil
+	}
+	if s, ok := v.(string); ok && s == "" && col.Type != "varchar" && col.Type != "text" {
+		return parquet.NullValue(), nil // Empty CSV field of a typed column
+	}
+
+	switch col.Type {
+	case "boolean":
+		switch b := v.(type) {
+		case bool:
+			return parquet.BooleanValue(b), nil
+		case string:
+			parsed, err := strconv.ParseBool(strings.TrimSpace(b))
+			if err != nil {
+				return parquet.Value{}, fmt.Errorf("invalid boolean %q", b)
+			}
+			return parquet.BooleanValue(parsed), nil
+		case float64:
+			return parquet.BooleanValue(b != 0), nil
+		}
+	case "smallint", "integer", "bigint":
+		n, err := parquetInt(v)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		if col.Type == "bigint" {
+			return parquet.Int64Value(n), nil
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return parquet.Value{}, fmt.Errorf("integer %d out of range for a %s column", n, col.Type)
+		}
+		return parquet.Int32Value(int32(n)), nil
+	case "decimal":
+		if col.Precision > 0 && col.Precision <= 18 {
+			unscaled, err := parquetUnscaled(v, col.Scale)
+			if err != nil {
+				return parquet.Value{}, err
+			}
+			return parquet.Int64Value(unscaled), nil
+		}
+	case "real", "double":
+		f, err := parquetFloat(v)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.DoubleValue(f), nil
+	case "date":
+		t, err := parquetTime(v)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+		return parquet.Int32Value(int32(days)), nil
+	case "timestamp", "timestamptz":
+		t, err := parquetTime(v)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		if col.Type == "timestamp" {
+			// Local wall clock, stored as if it were UTC
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		}
+		return parquet.Int64Value(t.UnixMicro()), nil
+	case "binary":
+		switch b := v.(type) {
+		case []byte:
+			return parquet.ByteArrayValue(b), nil
+		case string:
+			// Binary columns travel base64 encoded in JSON
+			if decoded, err := base64.StdEncoding.DecodeString(b); err == nil {
+				return parquet.ByteArrayValue(decoded), nil
+			}
+			return parquet.ByteArrayValue([]byte(b)), nil
+		}
+	}
+	return parquet.ByteArrayValue([]byte(FormatExportValue(v))), nil
+}
+
+// parquetInt reads an integer from a JSON number or numeric string. Fractional and out of
+// range values are errors rather than truncated
+func parquetInt(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case float64:
+		if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+			return 0, fmt.Errorf("invalid integer %v", n)
+		}
+		return int64(n), nil
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case json.Number:
+		return n.Int64()
+	case bool:
+		if n {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		parsed, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+		if err != nil {
+			// Integral values may arrive as "12.0"
+			f, ferr := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			if ferr != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return 0, fmt.Errorf("invalid integer %q", n)
+			}
+			return int64(f), nil
+		}
+		return parsed, nil
+	}
+	return 0, fmt.Errorf("invalid integer %v", v)
+}
+
+// parquetFloat reads a float from a JSON number or numeric string
+func parquetFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", n)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("invalid number %v", v)
+}
+
+// parquetUnscaled converts a decimal to its unscaled integer (value * 10^scale, rounded)
+func parquetUnscaled(v interface{}, scale int) (int64, error) {
+	text := FormatExportValue(v)
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(text))
+	if !ok {
+		return 0, fmt.Errorf("invalid decimal %q", text)
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+
+	// Round half away from zero
+	num, den := r.Num(), r.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(rem.Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("decimal %q out of range", text)
+	}
+	return quo.Int64(), nil
+}
+
+// parquetTime reads a timestamp from a time value or a formatted string
+func parquetTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		s := strings.TrimSpace(t)
+		for _, layout := range database.TimeLayouts {
+			if parsed, err := time.Parse(layout, s); err == nil {
+				return parsed, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", t)
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %v", v)
+}
+
+// InferParquetColumns derives Parquet columns from record values, for callers that
+// don't know the source column types
+func InferParquetColumns(records []map[string]interface{}) []ParquetColumn {
+	types := make(map[string]string)
+	for _, record := range records {
+		for name, value := range record {
+			current, seen := types[name]
+			var valueType string
+			switch v := value.(type) {
+			case nil:
+				if !seen {
+					types[name] = ""
+				}
+				continue
+			case bool:
+				valueType = "boolean"
+			case float64:
+				if v == float64(int64(v)) {
+					valueType = "bigint"
+				} else {
+					valueType = "double"
+				}
+			case int, int64:
+				valueType = "bigint"
+			case time.Time:
+				valueType = "timestamptz"
+			default:
+				valueType = "text"
+			}
+			switch {
+			case current == "" || current == valueType:
+				types[name] = valueType
+			case (current == "bigint" && valueType == "double") || (current == "double" && valueType == "bigint"):
+				types[name] = "double"
+			default:
+				types[name] = "text"
+			}
+		}
+	}
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	columns := make([]ParquetColumn, len(names))
+	for i, name := range names {
+		typ := types[name]
+		if typ == "" {
+			typ = "text"
+		}
+		columns[i] = ParquetColumn{Name: name, Type: typ}
+	}
+	return columns
+}
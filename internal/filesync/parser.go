@@ -206,11 +206,26 @@ func ParseFile(data []byte, format string, hasHeader bool, delimiter string) ([]
 		return ParseExcel(data, hasHeader)
 	case "json":
 		return ParseJSON(data)
+	case "jsonl", "ndjson", "xml", "fixed_width", "fixed", "parquet":
+		return parseStream(data, StreamOptions{Format: format, HasHeader: hasHeader})
 	default:
 		return nil, fmt.Errorf("unsupported file format: %s", format)
 	}
 }
 
+// parseStream parses file data held in memory with the streaming parsers (see StreamRecords)
+func parseStream(data []byte, opts StreamOptions) ([]map[string]interface{}, error) {
+	open := func(offset int64) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data[offset:])), nil
+	}
+	var records []map[string]interface{}
+	_, err := StreamRecords(open, opts, 0, func(batch StreamBatch) error {
+		records = append(records, batch.Records...)
+		return nil
+	})
+	return records, err
+}
+
 // ParseTextLines parses plain text file line by line
 func ParseTextLines(data []byte) ([]map[string]interface{}, error) {
 	lines := strings.Split(string(data), "\n")
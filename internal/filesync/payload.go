@@ -0,0 +1,107 @@
+package filesync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// BatchRecordsToken is replaced by the JSON array of rendered records in a batch template
+const BatchRecordsToken = "{{$records}}"
+
+// RenderRecordTemplate renders the API payload of one record. The template is JSON with
+// {{column}} placeholders: outside a string a placeholder becomes the JSON value of the
+// field (missing = null), inside a string its text ("Patient/{{id}}"). {{$name}} reads
+// vars instead of the record. An empty template sends the record as is
+func RenderRecordTemplate(template string, record map[string]interface{}, vars map[string]interface{}) ([]byte, error) {
+	if strings.TrimSpace(template) == "" {
+		return json.Marshal(record)
+	}
+
+	var out bytes.Buffer
+	inString, escaped := false, false
+	for i := 0; i < len(template); {
+		c := template[i]
+		if c == '{' && strings.HasPrefix(template[i:], "{{") {
+			end := strings.Index(template[i+2:], "}}")
+			if end < 0 {
+				return nil, fmt.Errorf("body template: unclosed placeholder at offset %d", i)
+			}
+			name := strings.TrimSpace(template[i+2 : i+2+end])
+			var value interface{}
+			if strings.HasPrefix(name, "$") {
+				value = vars[name[1:]]
+			} else {
+				value = record[name]
+			}
+			if err := writePlaceholder(&out, value, inString); err != nil {
+				return nil, fmt.Errorf("body template: %s: %w", name, err)
+			}
+			i += end + 4
+			continue
+		}
+
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		}
+		out.WriteByte(c)
+		i++
+	}
+
+	if !json.Valid(out.Bytes()) {
+		return nil, fmt.Errorf("body template does not produce valid JSON: %s", truncateBody(out.String()))
+	}
+	return out.Bytes(), nil
+}
+
+// writePlaceholder writes a placeholder value as JSON, or as escaped text inside a string
+func writePlaceholder(out *bytes.Buffer, value interface{}, inString bool) error {
+	if !inString {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		out.Write(data)
+		return nil
+	}
+	data, err := json.Marshal(FormatExportValue(value))
+	if err != nil {
+		return err
+	}
+	out.Write(data[1 : len(data)-1]) // Escaped text without the surrounding quotes
+	return nil
+}
+
+// RenderBatchTemplate wraps rendered records into a batch payload. The template holds
+// {{$records}} where the JSON array goes (e.g. {"entry": {{$records}}}); an empty
+// template sends the bare array
+func RenderBatchTemplate(template string, items [][]byte) ([]byte, error) {
+	array := append([]byte("["), bytes.Join(items, []byte(","))...)
+	array = append(array, ']')
+	if strings.TrimSpace(template) == "" {
+		return array, nil
+	}
+	if !strings.Contains(template, BatchRecordsToken) {
+		return nil, fmt.Errorf("batch template must contain %s", BatchRecordsToken)
+	}
+
+	out := []byte(strings.Replace(template, BatchRecordsToken, string(array), 1))
+	if !json.Valid(out) {
+		return nil, fmt.Errorf("batch template does not produce valid JSON")
+	}
+	return out, nil
+}
+
+// truncateBody shortens a payload for error messages
+func truncateBody(body string) string {
+	if len(body) > 200 {
+		return body[:200] + "..."
+	}
+	return body
+}
@@ -147,35 +147,111 @@ func (c *SFTPClient) ReadFile(remotePath string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// FindAndReadFile finds a file matching the pattern and reads it
-func (c *SFTPClient) FindAndReadFile(remotePath, pattern string) ([]byte, string, error) {
-	// If pattern is a specific filename (no wildcards), try to read it directly
-	if !strings.Contains(pattern, "*") && !strings.Contains(pattern, "?") {
-		fullPath := path.Join(remotePath, pattern)
-		data, err := c.ReadFile(fullPath)
-		if err != nil {
-			return nil, "", err
+// OpenFile streams a file from the SFTP server, starting at a byte offset (resumed downloads)
+func (c *SFTPClient) OpenFile(remotePath string, offset int64) (io.ReadCloser, error) {
+	file, err := c.sftpConn.Open(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to seek to offset %d: %w", offset, err)
 		}
-		return data, pattern, nil
+	}
+	return file, nil
+}
+
+// FindFile returns the path of the file matching the pattern
+func (c *SFTPClient) FindFile(remotePath, pattern string) (string, error) {
+	// If pattern is a specific filename (no wildcards), use it directly
+	if !strings.Contains(pattern, "*") && !strings.Contains(pattern, "?") {
+		return path.Join(remotePath, pattern), nil
 	}
 
 	// List files and find matching ones
 	files, err := c.ListFiles(remotePath, pattern)
 	if err != nil {
-		return nil, "", err
+		return "", err
 	}
 
 	if len(files) == 0 {
-		return nil, "", fmt.Errorf("no files matching pattern '%s' found in '%s'", pattern, remotePath)
+		return "", fmt.Errorf("no files matching pattern '%s' found in '%s'", pattern, remotePath)
+	}
+
+	// The first matching file
+	return files[0], nil
+}
+
+// FindAndReadFile finds a file matching the pattern and reads it
+func (c *SFTPClient) FindAndReadFile(remotePath, pattern string) ([]byte, string, error) {
+	filePath, err := c.FindFile(remotePath, pattern)
+	if err != nil {
+		return nil, "", err
 	}
 
-	// Read the first matching file
-	data, err := c.ReadFile(files[0])
+	data, err := c.ReadFile(filePath)
 	if err != nil {
 		return nil, "", err
 	}
 
-	return data, filepath.Base(files[0]), nil
+	return data, filepath.Base(filePath), nil
+}
+
+// WriteFile uploads content to a remote file, replacing it
+func (c *SFTPClient) WriteFile(remotePath string, r io.Reader) error {
+	file, err := c.sftpConn.Create(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+// Rename moves a remote file, replacing an existing destination. Uses the atomic
+// posix-rename extension when the server supports it
+func (c *SFTPClient) Rename(from, to string) error {
+	if err := c.sftpConn.PosixRename(from, to); err == nil {
+		return nil
+	}
+	// Plain SFTP rename refuses to overwrite: move the old file aside and restore it if
+	// the rename fails, so it is never lost
+	if _, err := c.sftpConn.Stat(to); err != nil {
+		if err := c.sftpConn.Rename(from, to); err != nil {
+			return fmt.Errorf("failed to rename file: %w", err)
+		}
+		return nil
+	}
+	backup := replacedName(to)
+	if err := c.sftpConn.Rename(to, backup); err != nil {
+		return fmt.Errorf("failed to move existing file aside: %w", err)
+	}
+	if err := c.sftpConn.Rename(from, to); err != nil {
+		c.sftpConn.Rename(backup, to)
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	c.sftpConn.Remove(backup)
+	return nil
+}
+
+// Remove deletes a remote file
+func (c *SFTPClient) Remove(remotePath string) error {
+	return c.sftpConn.Remove(remotePath)
+}
+
+// MkdirAll creates a remote directory and its parents (existing ones are fine)
+func (c *SFTPClient) MkdirAll(dir string) error {
+	dir = path.Clean(dir)
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	return c.sftpConn.MkdirAll(dir)
 }
 
 // Close closes the SFTP and SSH connections
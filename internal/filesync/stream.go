@@ -0,0 +1,460 @@
+package filesync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// StreamOptions configures StreamRecords
+type StreamOptions struct {
+	Format     string // csv, txt/text (delimited, or line by line), json (array or object), jsonl/ndjson, xml, fixed_width, parquet
+	HasHeader  bool
+	Delimiter  string
+	BatchSize  int
+	Retries    int                // Times a broken download is reopened at the offset of the last batch
+	RecordPath string             // xml: path of the record elements (see readXML)
+	Columns    []FixedWidthColumn // fixed_width: column layout
+}
+
+// StreamBatch is a batch of parsed records and the byte offset the next batch starts at.
+// Reading the file again from Offset continues right after the batch. Parquet files are
+// read by row, their offset is the number of the next row
+type StreamBatch struct {
+	Records []map[string]interface{}
+	Offset  int64
+}
+
+// OpenFunc opens the file being streamed at a byte offset
+type OpenFunc func(offset int64) (io.ReadCloser, error)
+
+// IsStreamFormat reports whether a file format is parsed while downloading. Excel needs the
+// whole file (zip archive) and is still read into memory
+func IsStreamFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case "csv", "txt", "text", "json", "jsonl", "ndjson", "xml", "fixed_width", "fixed", "parquet":
+		return true
+	}
+	return false
+}
+
+// errBatchHandler wraps errors of the batch callback, which are never retried
+type errBatchHandler struct{ err error }
+
+func (e errBatchHandler) Error() string { return e.err.Error() }
+func (e errBatchHandler) Unwrap() error { return e.err }
+
+// sourceReader remembers the error of the download, to tell a broken connection from bad content
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// recordStream is the state of a file stream kept across resumed downloads
+type recordStream struct {
+	open      OpenFunc
+	opts      StreamOptions
+	fn        func(StreamBatch) error
+	delimiter rune
+	lines     bool     // txt that isn't delimited: one record per line
+	headers   []string // CSV column names
+	committed int64    // Offset after the last batch handed to fn
+	lineNum   int      // Lines before committed, for line records
+	batch     []map[string]interface{}
+}
+
+// StreamRecords downloads and parses a file in batches of opts.BatchSize records, starting at
+// a byte offset (0 for the whole file), so memory stays bounded whatever the file size.
+// A download that breaks is reopened after the last batch, up to opts.Retries times.
+// Returns the offset after the last record
+func StreamRecords(open OpenFunc, opts StreamOptions, offset int64, fn func(StreamBatch) error) (int64, error) {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 5000
+	}
+	s := &recordStream{open: open, opts: opts, fn: fn, delimiter: ',', committed: offset}
+	if len(opts.Delimiter) > 0 {
+		s.delimiter = rune(opts.Delimiter[0])
+	}
+
+	format := strings.ToLower(opts.Format)
+	if format == "parquet" {
+		err := s.readParquet()
+		var handlerErr errBatchHandler
+		if errors.As(err, &handlerErr) {
+			return s.committed, handlerErr.err
+		}
+		return s.committed, err
+	}
+	if (format == "csv" || format == "txt" || format == "text") && opts.HasHeader && offset > 0 {
+		if err := s.readHeader(); err != nil {
+			return s.committed, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := s.readFrom(format)
+		if err == nil {
+			return s.committed, nil
+		}
+		if err == errRestartAsLines {
+			s.batch = nil
+			attempt--
+			continue
+		}
+		var handlerErr errBatchHandler
+		if errors.As(err, &handlerErr) {
+			return s.committed, handlerErr.err
+		}
+		var broken *brokenDownload
+		if !errors.As(err, &broken) || attempt >= opts.Retries {
+			return s.committed, err
+		}
+		// Records parsed after the last batch are read again from its offset
+		s.batch = nil
+	}
+}
+
+// errRestartAsLines restarts a txt stream that isn't delimited in line by line mode
+var errRestartAsLines = errors.New("text is not delimited")
+
+// brokenDownload is a read error of the download itself, retried from the last batch
+type brokenDownload struct{ err error }
+
+func (e *brokenDownload) Error() string {
+	return fmt.Sprintf("download interrupted: %v", e.err)
+}
+
+// readFrom opens the file at the committed offset and parses it to the end. XML needs its
+// enclosing elements and is read again from the start
+func (s *recordStream) readFrom(format string) error {
+	at := s.committed
+	if format == "xml" {
+		at = 0
+	}
+	body, err := s.open(at)
+	if err != nil {
+		return &brokenDownload{err}
+	}
+	defer body.Close()
+	src := &sourceReader{r: body}
+
+	switch {
+	case s.lines:
+		err = s.readLines(src, textLine)
+	case format == "csv":
+		err = s.readCSV(src)
+	case format == "txt" || format == "text":
+		err = s.readCSV(src)
+		var handlerErr errBatchHandler
+		if err != nil && src.err == nil && s.committed == 0 && !errors.As(err, &handlerErr) {
+			// Not delimited text: read it again as one record per line
+			s.lines = true
+			s.headers = nil
+			return errRestartAsLines
+		}
+	case format == "json":
+		err = s.readJSON(src)
+	case format == "jsonl" || format == "ndjson":
+		err = s.readLines(src, jsonLine)
+	case format == "xml":
+		err = s.readXML(src)
+	case format == "fixed_width" || format == "fixed":
+		err = s.readLines(src, s.fixedWidthLine)
+	default:
+		return fmt.Errorf("unsupported stream format: %s", format)
+	}
+	if err != nil && src.err != nil {
+		var handlerErr errBatchHandler
+		if !errors.As(err, &handlerErr) {
+			return &brokenDownload{src.err}
+		}
+	}
+	return err
+}
+
+// add appends a record and hands the batch on once full. offset is where the next record starts
+func (s *recordStream) add(record map[string]interface{}, offset int64) error {
+	s.batch = append(s.batch, record)
+	if len(s.batch) < s.opts.BatchSize {
+		return nil
+	}
+	return s.flush(offset)
+}
+
+// flush hands the pending records to the callback
+func (s *recordStream) flush(offset int64) error {
+	if len(s.batch) == 0 {
+		s.committed = offset
+		return nil
+	}
+	if err := s.fn(StreamBatch{Records: s.batch, Offset: offset}); err != nil {
+		return errBatchHandler{err}
+	}
+	s.batch = nil
+	s.committed = offset
+	return nil
+}
+
+// newCSVReader matches the settings of ParseCSV
+func (s *recordStream) newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = s.delimiter
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	return reader
+}
+
+// readHeader reads the CSV header from the start of the file, for streams resumed past it
+func (s *recordStream) readHeader() error {
+	body, err := s.open(0)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	record, err := s.newCSVReader(body).Read()
+	if err != nil {
+		return fmt.Errorf("error reading CSV header: %w", err)
+	}
+	s.setHeaders(record)
+	return nil
+}
+
+func (s *recordStream) setHeaders(record []string) {
+	s.headers = make([]string, len(record))
+	for i, h := range record {
+		s.headers[i] = strings.TrimSpace(h)
+	}
+}
+
+// readCSV parses delimited records
+func (s *recordStream) readCSV(r io.Reader) error {
+	base := s.committed
+	headerPending := base == 0 && s.opts.HasHeader
+	reader := s.newCSVReader(r)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading CSV at byte %d: %w", base+reader.InputOffset(), err)
+		}
+
+		if headerPending {
+			s.setHeaders(record)
+			headerPending = false
+			continue
+		}
+		// If no header, generate column names
+		if s.headers == nil {
+			s.headers = make([]string, len(record))
+			for i := range record {
+				s.headers[i] = fmt.Sprintf("column_%d", i+1)
+			}
+		}
+
+		row := make(map[string]interface{}, len(record))
+		for i, value := range record {
+			if i < len(s.headers) {
+				row[s.headers[i]] = inferType(strings.TrimSpace(value))
+			}
+		}
+		if err := s.add(row, base+reader.InputOffset()); err != nil {
+			return err
+		}
+	}
+	return s.flush(base + reader.InputOffset())
+}
+
+// lineParser turns a line (with its line break) into a record, nil to skip the line
+type lineParser func(line []byte, lineNum int) (map[string]interface{}, error)
+
+// textLine is a record of a plain text line, like ParseTextLines
+func textLine(line []byte, lineNum int) (map[string]interface{}, error) {
+	content := bytes.TrimSpace(line)
+	if len(content) == 0 {
+		return nil, nil
+	}
+	return map[string]interface{}{
+		"line_number": lineNum,
+		"content":     string(content),
+	}, nil
+}
+
+// jsonLine decodes a JSON Lines record
+func jsonLine(line []byte, lineNum int) (map[string]interface{}, error) {
+	content := bytes.TrimSpace(line)
+	if len(content) == 0 {
+		return nil, nil
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, fmt.Errorf("invalid JSON on line %d: %w", lineNum, err)
+	}
+	return record, nil
+}
+
+// readLines parses a file line by line
+func (s *recordStream) readLines(r io.Reader, parse lineParser) error {
+	offset := s.committed
+	lineNum := s.lineNum
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			offset += int64(len(line))
+			lineNum++
+			record, perr := parse(line, lineNum)
+			if perr != nil {
+				return perr
+			}
+			if record != nil {
+				full := len(s.batch)+1 >= s.opts.BatchSize
+				if aerr := s.add(record, offset); aerr != nil {
+					return aerr
+				}
+				if full {
+					s.lineNum = lineNum
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.flush(offset); err != nil {
+		return err
+	}
+	s.lineNum = lineNum
+	return nil
+}
+
+// readJSON streams the elements of a JSON array, or of the "data" array of an object (common
+// API export format). Any other object is a single record. Resumed streams continue inside
+// the array
+func (s *recordStream) readJSON(r io.Reader) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	skipped, first, err := skipJSONSpace(reader)
+	if err != nil {
+		if err == io.EOF {
+			return nil // Empty file
+		}
+		return err
+	}
+
+	base := s.committed + skipped
+	if s.committed > 0 {
+		// Resumed after an element: drop its separator and read the rest as an array
+		if first == ',' {
+			reader.ReadByte()
+			base++
+		}
+		dec := json.NewDecoder(io.MultiReader(strings.NewReader("["), reader))
+		return s.readJSONArray(dec, base-1)
+	}
+
+	dec := json.NewDecoder(reader)
+	if first == '[' {
+		return s.readJSONArray(dec, base)
+	}
+	if first != '{' {
+		return fmt.Errorf("failed to parse JSON: unsupported format")
+	}
+
+	// Object: stream its "data" array, or decode it whole as a single record
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	single := make(map[string]interface{})
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		key, _ := tok.(string)
+		if key == "data" {
+			if peekJSONArray(dec) {
+				return s.readJSONArray(dec, base)
+			}
+		}
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		single[key] = value
+	}
+	if err := s.add(single, base+dec.InputOffset()); err != nil {
+		return err
+	}
+	return s.flush(base + dec.InputOffset())
+}
+
+// readJSONArray decodes the array elements the decoder is positioned at. base is the file
+// offset of the decoder's input start
+func (s *recordStream) readJSONArray(dec *json.Decoder, base int64) error {
+	if _, err := dec.Token(); err != nil { // [
+		return fmt.Errorf("failed to parse JSON array: %w", err)
+	}
+	for dec.More() {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			return fmt.Errorf("failed to parse JSON element at byte %d: %w", base+dec.InputOffset(), err)
+		}
+		if err := s.add(record, base+dec.InputOffset()); err != nil {
+			return err
+		}
+	}
+	return s.flush(base + dec.InputOffset())
+}
+
+// peekJSONArray reports whether the next value of an object is an array, from the bytes the
+// decoder already buffered. When they don't reach it the value is decoded whole
+func peekJSONArray(dec *json.Decoder) bool {
+	buffered, _ := io.ReadAll(dec.Buffered())
+	for _, b := range buffered {
+		switch b {
+		case ' ', '\t', '\r', '\n', ':':
+			continue
+		}
+		return b == '['
+	}
+	return false
+}
+
+// skipJSONSpace skips whitespace (and a UTF-8 BOM), returning the bytes skipped and the next byte
+func skipJSONSpace(r *bufio.Reader) (int64, byte, error) {
+	var skipped int64
+	if bom, err := r.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		r.Discard(3)
+		skipped = 3
+	}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return skipped, 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			skipped++
+			continue
+		}
+		r.UnreadByte()
+		return skipped, b, nil
+	}
+}
@@ -0,0 +1,167 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// castTypes maps accepted cast names to logical column types
+var castTypes = map[string]string{
+	"varchar":   "varchar",
+	"string":    "varchar",
+	"text":      "text",
+	"integer":   "integer",
+	"int":       "integer",
+	"bigint":    "bigint",
+	"double":    "double",
+	"float":     "double",
+	"decimal":   "decimal",
+	"numeric":   "decimal",
+	"boolean":   "boolean",
+	"bool":      "boolean",
+	"date":      "date",
+	"timestamp": "timestamp",
+	"datetime":  "timestamp",
+}
+
+// dateLayouts are tried in order when a string is cast to date or timestamp
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02/01/2006 15:04:05",
+	"02/01/2006",
+	"20060102",
+}
+
+// floatToInt converts a whole number to int64. Fractions and values outside the int64
+// range fail instead of being rounded or wrapped
+func floatToInt(f float64, v interface{}) (interface{}, error) {
+	if f != math.Trunc(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("cannot cast %v to integer: not a whole number", v)
+	}
+	// 2^63 is the first double above the int64 range, -2^63 still fits
+	if f >= math.Ldexp(1, 63) || f < -math.Ldexp(1, 63) {
+		return nil, fmt.Errorf("cannot cast %v to integer: out of range", v)
+	}
+	return int64(f), nil
+}
+
+// castValue converts a value to the Go type matching a cast. Nulls stay null
+func castValue(v interface{}, cast string) (interface{}, error) {
+	if isNull(v) {
+		return nil, nil
+	}
+
+	switch castTypes[strings.ToLower(cast)] {
+	case "varchar", "text":
+		return formatValue(v), nil
+	case "integer", "bigint":
+		switch n := v.(type) {
+		case float64:
+			return floatToInt(n, v)
+		case int:
+			return int64(n), nil
+		case int64:
+			return n, nil
+		case bool:
+			if n {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+		s := strings.TrimSpace(formatValue(v))
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot cast %q to integer", s)
+		}
+		return floatToInt(f, s)
+	case "double":
+		switch n := v.(type) {
+		case float64:
+			return n, nil
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		}
+		s := strings.TrimSpace(formatValue(v))
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot cast %q to double", s)
+		}
+		return f, nil
+	case "decimal":
+		// Keep decimals as text so no precision is lost on the way to the database
+		s := strings.TrimSpace(formatValue(v))
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("cannot cast %q to decimal", s)
+		}
+		return s, nil
+	case "boolean":
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case float64:
+			return b != 0, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(formatValue(v))) {
+		case "true", "t", "1", "yes", "y":
+			return true, nil
+		case "false", "f", "0", "no", "n":
+			return false, nil
+		}
+		return nil, fmt.Errorf("cannot cast %q to boolean", formatValue(v))
+	case "date", "timestamp":
+		t, ok := v.(time.Time)
+		if !ok {
+			s := strings.TrimSpace(formatValue(v))
+			var err error
+			for _, layout := range dateLayouts {
+				if t, err = time.Parse(layout, s); err == nil {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				return nil, fmt.Errorf("cannot cast %q to %s", s, cast)
+			}
+		}
+		if castTypes[strings.ToLower(cast)] == "date" {
+			return t.Format("2006-01-02"), nil
+		}
+		return t, nil
+	}
+	return v, nil
+}
+
+// formatValue renders a value as text the way target databases parse it
+func formatValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case time.Time:
+		return t.Format("2006-01-02 15:04:05.999999Z07:00")
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	case map[string]interface{}, []interface{}:
+		// Nested JSON values are kept as JSON text
+		if b, err := json.Marshal(t); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("%v", v)
+}
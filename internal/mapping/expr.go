@@ -0,0 +1,244 @@
+package mapping
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// node is a compiled mapping expression
+type node interface {
+	eval(rec map[string]interface{}) (interface{}, error)
+}
+
+// fieldNode reads a source field
+type fieldNode struct{ name string }
+
+// literalNode is a quoted string or number
+type literalNode struct{ value interface{} }
+
+// callNode is a function call
+type callNode struct {
+	name string
+	args []node
+}
+
+// functionArity lists supported functions with their min and max argument counts (-1 = variadic)
+var functionArity = map[string][2]int{
+	"concat":     {1, -1},
+	"trim":       {1, 1},
+	"upper":      {1, 1},
+	"lower":      {1, 1},
+	"coalesce":   {1, -1},
+	"date_parse": {2, 2},
+}
+
+func (n *fieldNode) eval(rec map[string]interface{}) (interface{}, error) {
+	return lookup(rec, n.name), nil
+}
+
+func (n *literalNode) eval(rec map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n *callNode) eval(rec map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(rec)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	switch n.name {
+	case "concat":
+		var sb strings.Builder
+		for _, a := range args {
+			if a != nil {
+				sb.WriteString(formatValue(a))
+			}
+		}
+		return sb.String(), nil
+	case "trim", "upper", "lower":
+		if args[0] == nil {
+			return nil, nil
+		}
+		s := formatValue(args[0])
+		switch n.name {
+		case "trim":
+			return strings.TrimSpace(s), nil
+		case "upper":
+			return strings.ToUpper(s), nil
+		}
+		return strings.ToLower(s), nil
+	case "coalesce":
+		for _, a := range args {
+			if !isNull(a) {
+				return a, nil
+			}
+		}
+		return nil, nil
+	case "date_parse":
+		if isNull(args[0]) {
+			return nil, nil
+		}
+		if t, ok := args[0].(time.Time); ok {
+			return t, nil
+		}
+		layout := toGoLayout(formatValue(args[1]))
+		t, err := time.Parse(layout, strings.TrimSpace(formatValue(args[0])))
+		if err != nil {
+			return nil, fmt.Errorf("date_parse: %q does not match %q", formatValue(args[0]), formatValue(args[1]))
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown function %s", n.name)
+}
+
+// toGoLayout converts a YYYY/MM/DD HH:mm:ss style pattern into a Go time layout.
+// Patterns already written as Go layouts (containing 2006) are used as is
+func toGoLayout(pattern string) string {
+	if strings.Contains(pattern, "2006") {
+		return pattern
+	}
+	return strings.NewReplacer(
+		"YYYY", "2006",
+		"YY", "06",
+		"MM", "01",
+		"DD", "02",
+		"HH", "15",
+		"mm", "04",
+		"ss", "05",
+		"SSS", "000",
+	).Replace(pattern)
+}
+
+// exprParser is a small recursive descent parser for mapping expressions:
+// expr := call | field | 'string' | number ; call := name '(' expr {',' expr} ')'
+type exprParser struct {
+	src string
+	pos int
+}
+
+// parseExpr compiles an expression string
+func parseExpr(src string) (node, error) {
+	p := &exprParser{src: src}
+	n, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", src, err)
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q", src, p.src[p.pos:])
+	}
+	return n, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) parse() (node, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case c == '\'':
+		return p.parseString()
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", p.src[start:p.pos])
+		}
+		return &literalNode{value: f}, nil
+	case isIdentChar(c):
+		start := p.pos
+		for p.pos < len(p.src) && (isIdentChar(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '(' {
+			return p.parseCall(strings.ToLower(name))
+		}
+		return &fieldNode{name: name}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", string(c))
+}
+
+func (p *exprParser) parseString() (node, error) {
+	p.pos++ // opening quote
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '\'' {
+			// '' is an escaped quote
+			if p.pos+1 < len(p.src) && p.src[p.pos+1] == '\'' {
+				sb.WriteByte('\'')
+				p.pos += 2
+				continue
+			}
+			p.pos++
+			return &literalNode{value: sb.String()}, nil
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+	return nil, fmt.Errorf("unterminated string")
+}
+
+func (p *exprParser) parseCall(name string) (node, error) {
+	arity, ok := functionArity[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	p.pos++ // (
+
+	call := &callNode{name: name}
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == ')' {
+		p.pos++
+	} else {
+		for {
+			arg, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			p.skipSpace()
+			if p.pos >= len(p.src) {
+				return nil, fmt.Errorf("missing ) in %s", name)
+			}
+			if p.src[p.pos] == ',' {
+				p.pos++
+				continue
+			}
+			if p.src[p.pos] == ')' {
+				p.pos++
+				break
+			}
+			return nil, fmt.Errorf("unexpected %q in %s", string(p.src[p.pos]), name)
+		}
+	}
+
+	if len(call.args) < arity[0] || (arity[1] >= 0 && len(call.args) > arity[1]) {
+		return nil, fmt.Errorf("%s: wrong number of arguments (%d)", name, len(call.args))
+	}
+	return call, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,355 @@
+package mapping
+
+import (
+	"bytes"
+	"dsp-platform/internal/database"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Column maps one target column from a source field or an expression
+type Column struct {
+	Source  string `json:"source,omitempty"`  // Source field, dotted paths reach into nested objects (default: Target)
+	Target  string `json:"target"`            // Target column name
+	Cast    string `json:"cast,omitempty"`    // varchar, text, integer, bigint, double, decimal, boolean, date, timestamp
+	Default string `json:"default,omitempty"` // Used when the value is missing or null, supports tokens
+	Expr    string `json:"expr,omitempty"`    // e.g. concat(first, ' ', last), trim(x), upper(x), lower(x), date_parse(x, 'DD/MM/YYYY')
+
+	expr node
+}
+
+// Mapping is the declarative column mapping of a SchemaRule
+type Mapping struct {
+	Columns      []Column          `json:"columns"`
+	Constants    map[string]string `json:"constants,omitempty"` // Target column -> value, supports tokens
+	DropUnmapped bool              `json:"drop_unmapped"`       // Only keep mapped and constant columns
+
+	consumed map[string]bool // Source fields renamed to another target
+}
+
+// Context carries the values of the {{...}} tokens
+// Supported tokens: {{now}}, {{agent}}, {{job_id}}, {{run_id}}, {{table}}
+type Context struct {
+	Now   time.Time
+	Agent string
+	JobID uint
+	RunID uint
+	Table string
+}
+
+// Parse decodes and compiles a mapping. Returns nil for an empty definition
+func Parse(raw string) (*Mapping, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var m Mapping
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		return nil, fmt.Errorf("invalid mapping JSON: %w", err)
+	}
+
+	m.consumed = make(map[string]bool)
+	targets := make(map[string]bool)
+	for i := range m.Columns {
+		col := &m.Columns[i]
+		if col.Target == "" {
+			return nil, fmt.Errorf("mapping column %d has no target", i+1)
+		}
+		if targets[col.Target] {
+			return nil, fmt.Errorf("target column %s is mapped twice", col.Target)
+		}
+		targets[col.Target] = true
+
+		if col.Cast != "" {
+			if _, ok := castTypes[strings.ToLower(col.Cast)]; !ok {
+				return nil, fmt.Errorf("column %s: unsupported cast %q", col.Target, col.Cast)
+			}
+		}
+		if col.Expr != "" {
+			node, err := parseExpr(col.Expr)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", col.Target, err)
+			}
+			col.expr = node
+		} else if col.Source == "" {
+			col.Source = col.Target
+		}
+		if col.Source != "" && col.Source != col.Target {
+			m.consumed[col.Source] = true
+		}
+	}
+	for name := range m.Constants {
+		if targets[name] {
+			return nil, fmt.Errorf("constant column %s is also mapped", name)
+		}
+	}
+
+	return &m, nil
+}
+
+// ApplyRecord maps a single JSON record
+func (m *Mapping) ApplyRecord(rec map[string]interface{}, ctx Context) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(rec)+len(m.Constants))
+	if !m.DropUnmapped {
+		for k, v := range rec {
+			if !m.consumed[k] {
+				out[k] = v
+			}
+		}
+	}
+
+	for _, col := range m.Columns {
+		var value interface{}
+		if col.expr != nil {
+			v, err := col.expr.eval(rec)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", col.Target, err)
+			}
+			value = v
+		} else {
+			value = lookup(rec, col.Source)
+		}
+
+		if isNull(value) && col.Default != "" {
+			value = expandTokens(col.Default, ctx)
+		}
+		if col.Cast != "" {
+			v, err := castValue(value, col.Cast)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", col.Target, err)
+			}
+			value = v
+		}
+		out[col.Target] = value
+	}
+
+	for name, raw := range m.Constants {
+		out[name] = expandTokens(raw, ctx)
+	}
+	return out, nil
+}
+
+// ApplyRecords maps a batch of JSON records
+func (m *Mapping) ApplyRecords(records []interface{}, ctx Context) ([]interface{}, error) {
+	out := make([]interface{}, 0, len(records))
+	for i, r := range records {
+		rec, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		mapped, err := m.ApplyRecord(rec, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		out = append(out, mapped)
+	}
+	return out, nil
+}
+
+// OutputColumns returns the columns a CSV batch has after mapping, in order:
+// kept source columns, mapped columns, then constants
+func (m *Mapping) OutputColumns(columns []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	if !m.DropUnmapped {
+		for _, c := range columns {
+			if !m.consumed[c] {
+				out = append(out, c)
+				seen[c] = true
+			}
+		}
+	}
+	for _, col := range m.Columns {
+		if !seen[col.Target] {
+			out = append(out, col.Target)
+			seen[col.Target] = true
+		}
+	}
+	for _, name := range m.constantNames() {
+		if !seen[name] {
+			out = append(out, name)
+			seen[name] = true
+		}
+	}
+	return out
+}
+
+// ApplyCSV maps a CSV batch. Empty fields are treated as NULL, as on the write path
+func (m *Mapping) ApplyCSV(csvData string, columns []string, ctx Context) (string, []string, error) {
+	outColumns := m.OutputColumns(columns)
+
+	reader := csv.NewReader(strings.NewReader(csvData))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	row := make(map[string]interface{}, len(columns))
+	line := 0
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to parse CSV batch: %w", err)
+		}
+		line++
+
+		for k := range row {
+			delete(row, k)
+		}
+		for i, c := range columns {
+			if i < len(fields) && fields[i] != "" {
+				row[c] = fields[i]
+			} else {
+				row[c] = nil
+			}
+		}
+
+		mapped, err := m.ApplyRecord(row, ctx)
+		if err != nil {
+			return "", nil, fmt.Errorf("row %d: %w", line, err)
+		}
+
+		out := make([]string, len(outColumns))
+		for i, c := range outColumns {
+			out[i] = formatValue(mapped[c])
+		}
+		writer.Write(out)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", nil, err
+	}
+	return buf.String(), outColumns, nil
+}
+
+// OutputSpecs maps the column specs of a batch so the target table is created with
+// the cast types. in describes the source columns (as sent by the agent or inferred)
+func (m *Mapping) OutputSpecs(in []database.ColumnSpec) []database.ColumnSpec {
+	byName := make(map[string]database.ColumnSpec, len(in))
+	for _, spec := range in {
+		byName[spec.Name] = spec
+	}
+
+	var out []database.ColumnSpec
+	seen := make(map[string]bool)
+	if !m.DropUnmapped {
+		for _, spec := range in {
+			if !m.consumed[spec.Name] {
+				out = append(out, spec)
+				seen[spec.Name] = true
+			}
+		}
+	}
+
+	for _, col := range m.Columns {
+		spec := database.ColumnSpec{Name: col.Target, Type: "text", Inferred: true}
+		switch {
+		case col.Cast != "":
+			spec = database.ColumnSpec{Name: col.Target, Type: castTypes[strings.ToLower(col.Cast)]}
+		case col.expr != nil:
+			if call, ok := col.expr.(*callNode); ok && call.name == "date_parse" {
+				spec.Type, spec.Inferred = "timestamp", false
+			}
+		default:
+			if src, ok := byName[col.Source]; ok {
+				spec = src
+				spec.Name = col.Target
+				spec.NotNull = spec.NotNull && col.Default == ""
+			}
+		}
+
+		if seen[col.Target] {
+			for i := range out {
+				if out[i].Name == col.Target {
+					out[i] = spec
+				}
+			}
+			continue
+		}
+		out = append(out, spec)
+		seen[col.Target] = true
+	}
+
+	for _, name := range m.constantNames() {
+		if seen[name] {
+			continue
+		}
+		spec := database.ColumnSpec{Name: name, Type: "text", Inferred: true}
+		switch strings.TrimSpace(m.Constants[name]) {
+		case "{{now}}":
+			spec = database.ColumnSpec{Name: name, Type: "timestamptz"}
+		case "{{job_id}}", "{{run_id}}":
+			spec = database.ColumnSpec{Name: name, Type: "bigint"}
+		}
+		out = append(out, spec)
+		seen[name] = true
+	}
+	return out
+}
+
+// constantNames returns constant column names in a stable order
+func (m *Mapping) constantNames() []string {
+	names := make([]string, 0, len(m.Constants))
+	for name := range m.Constants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup reads a field, following dotted paths into nested objects when there's no exact match
+func lookup(rec map[string]interface{}, field string) interface{} {
+	if v, ok := rec[field]; ok {
+		return v
+	}
+	if !strings.Contains(field, ".") {
+		return nil
+	}
+	var cur interface{} = rec
+	for _, part := range strings.Split(field, ".") {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = obj[part]
+	}
+	return cur
+}
+
+// expandTokens substitutes {{...}} tokens. A value that is exactly {{now}} stays a time
+func expandTokens(raw string, ctx Context) interface{} {
+	now := ctx.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if strings.TrimSpace(raw) == "{{now}}" {
+		return now
+	}
+	if !strings.Contains(raw, "{{") {
+		return raw
+	}
+	return strings.NewReplacer(
+		"{{now}}", now.Format(time.RFC3339),
+		"{{agent}}", ctx.Agent,
+		"{{job_id}}", fmt.Sprintf("%d", ctx.JobID),
+		"{{run_id}}", fmt.Sprintf("%d", ctx.RunID),
+		"{{table}}", ctx.Table,
+	).Replace(raw)
+}
+
+// isNull reports a missing, nil or empty value
+func isNull(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	s, ok := v.(string)
+	return ok && s == ""
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"dsp-platform/internal/core"
+	"dsp-platform/internal/filesync"
+	"fmt"
+	"strings"
+)
+
+// apiPagination is the pagination of a network's API source
+func apiPagination(n core.Network) filesync.APIPagination {
+	return filesync.APIPagination{
+		Strategy:         n.APIPagination,
+		PageParam:        n.APIPageParam,
+		SizeParam:        n.APISizeParam,
+		PageSize:         n.APIPageSize,
+		StartPage:        n.APIStartPage,
+		CursorPath:       n.APICursorPath,
+		CursorParam:      n.APICursorParam,
+		HasMorePath:      n.APIHasMorePath,
+		MaxPages:         n.APIMaxPages,
+		FollowOtherHosts: n.APIFollowOtherHosts,
+		RecordPath:       n.APIRecordPath,
+		IncrementalParam: n.APIIncrementalParam,
+	}
+}
+
+// apiOAuth2 is the OAuth2 client of a network's API source
+func apiOAuth2(n core.Network) filesync.OAuth2Config {
+	return filesync.OAuth2Config{
+		TokenURL:     n.APIOAuthTokenURL,
+		ClientID:     n.APIOAuthClientID,
+		ClientSecret: n.APIOAuthClientSecret,
+		Scope:        n.APIOAuthScope,
+		Audience:     n.APIOAuthAudience,
+	}
+}
+
+// targetAPIOAuth2 is the OAuth2 client of a network's API target
+func targetAPIOAuth2(n core.Network) filesync.OAuth2Config {
+	return filesync.OAuth2Config{
+		TokenURL:     n.TargetAPIOAuthTokenURL,
+		ClientID:     n.TargetAPIOAuthClientID,
+		ClientSecret: n.TargetAPIOAuthClientSecret,
+		Scope:        n.TargetAPIOAuthScope,
+		Audience:     n.TargetAPIOAuthAudience,
+	}
+}
+
+// validateNetworkSource checks the API pagination and OAuth2 settings before a network is saved
+func validateNetworkSource(n core.Network) error {
+	if n.TargetSourceType == "api" && strings.EqualFold(n.TargetAPIAuthType, filesync.AuthOAuth2) {
+		if err := filesync.ValidateOAuth2Config(targetAPIOAuth2(n)); err != nil {
+			return fmt.Errorf("target API: %w", err)
+		}
+	}
+	if n.SourceType != "api" {
+		return nil
+	}
+	if strings.EqualFold(n.APIAuthType, filesync.AuthOAuth2) {
+		if err := filesync.ValidateOAuth2Config(apiOAuth2(n)); err != nil {
+			return err
+		}
+	}
+	return filesync.ValidateAPIPagination(apiPagination(n))
+}
+
+// apiConfigCommand is the api_config section of agent commands for API sources. Incremental
+// jobs pass their checkpoint, sent in the incremental parameter
+func apiConfigCommand(job core.Job) map[string]interface{} {
+	n := job.Network
+	checkpoint := ""
+	if job.Incremental {
+		checkpoint = job.LastCheckpoint
+	}
+	return map[string]interface{}{
+		"url":                 n.APIURL,
+		"method":              n.APIMethod,
+		"headers":             n.APIHeaders,
+		"auth_type":           n.APIAuthType,
+		"auth_key":            n.APIAuthKey,
+		"auth_value":          n.APIAuthValue,
+		"body":                n.APIBody,
+		"oauth_token_url":     n.APIOAuthTokenURL,
+		"oauth_client_id":     n.APIOAuthClientID,
+		"oauth_client_secret": n.APIOAuthClientSecret,
+		"oauth_scope":         n.APIOAuthScope,
+		"oauth_audience":      n.APIOAuthAudience,
+		"pagination":          n.APIPagination,
+		"page_param":          n.APIPageParam,
+		"size_param":          n.APISizeParam,
+		"page_size":           n.APIPageSize,
+		"start_page":          n.APIStartPage,
+		"cursor_path":         n.APICursorPath,
+		"cursor_param":        n.APICursorParam,
+		"has_more_path":       n.APIHasMorePath,
+		"max_pages":           n.APIMaxPages,
+		"follow_other_hosts":  n.APIFollowOtherHosts,
+		"record_path":         n.APIRecordPath,
+		"incremental_param":   n.APIIncrementalParam,
+		"checkpoint":          checkpoint,
+	}
+}
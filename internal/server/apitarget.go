@@ -0,0 +1,191 @@
+package server
+
+import (
+	"dsp-platform/internal/core"
+	"dsp-platform/internal/database"
+	"dsp-platform/internal/filesync"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// apiTarget pushes records to a network's REST API target
+type apiTarget struct {
+	client     *filesync.APIClient
+	config     filesync.APIConfig
+	recordTmpl string
+	batchTmpl  string
+	batchSize  int
+	isolate    bool                   // Retry refused batches record by record
+	vars       map[string]interface{} // {{$name}} values of the body templates
+}
+
+// apiTargetFor builds the API target of a network
+func (al *AgentListener) apiTargetFor(networkID, jobID uint, logID float64, table string) (*apiTarget, error) {
+	var network core.Network
+	if err := al.handler.db.First(&network, networkID).Error; err != nil {
+		return nil, fmt.Errorf("network %d not found: %w", networkID, err)
+	}
+	if network.TargetAPIURL == "" {
+		return nil, fmt.Errorf("API target URL is not configured")
+	}
+
+	config := filesync.APIConfig{
+		URL:       network.TargetAPIURL,
+		Method:    network.TargetAPIMethod,
+		AuthType:  network.TargetAPIAuthType,
+		AuthKey:   network.TargetAPIAuthKey,
+		AuthValue: network.TargetAPIAuthValue,
+		OAuth2:    targetAPIOAuth2(network),
+	}
+	if config.Method == "" {
+		config.Method = "POST"
+	}
+	if network.TargetAPIHeaders != "" {
+		if err := json.Unmarshal([]byte(network.TargetAPIHeaders), &config.Headers); err != nil {
+			return nil, fmt.Errorf("invalid target API headers: %w", err)
+		}
+	}
+
+	batchSize := network.TargetAPIBatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	return &apiTarget{
+		client:     filesync.NewAPIClient(),
+		config:     config,
+		recordTmpl: network.TargetAPIBody,
+		batchTmpl:  network.TargetAPIBatchTemplate,
+		batchSize:  batchSize,
+		isolate:    network.TargetAPIIsolateRejects,
+		vars: map[string]interface{}{
+			"job_id": jobID,
+			"run_id": uint(logID),
+			"table":  table,
+			"now":    time.Now().Format(time.RFC3339),
+		},
+	}, nil
+}
+
+// apiTargetOf returns the API target of a batch, built once per run
+func (al *AgentListener) apiTargetOf(work insertWork) (*apiTarget, error) {
+	run := work.run
+	if run == nil {
+		return al.apiTargetFor(work.networkID, work.jobID, work.logID, work.tableName)
+	}
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.api == nil {
+		target, err := al.apiTargetFor(run.networkID, run.jobID, run.logID, run.table)
+		if err != nil {
+			return nil, err
+		}
+		run.api = target
+	}
+	return run.api, nil
+}
+
+// send pushes records in batches. Records the API refuses (4xx) are returned as rejected: the
+// whole refused batch, or only its refused records when isolate retries it record by record.
+// When the API stays unreachable after retries the unsent records are rejected too and the
+// error is returned
+func (t *apiTarget) send(records []map[string]interface{}) (int, []database.RejectedRow, error) {
+	sent := 0
+	var rejected []database.RejectedRow
+
+	for start := 0; start < len(records); start += t.batchSize {
+		end := min(start+t.batchSize, len(records))
+		chunk := records[start:end]
+
+		// Records that can't be rendered never reach the API
+		var bodies [][]byte
+		var pending []map[string]interface{}
+		for _, rec := range chunk {
+			body, err := filesync.RenderRecordTemplate(t.recordTmpl, rec, t.vars)
+			if err != nil {
+				rejected = append(rejected, database.RejectedRow{Record: rec, Error: err.Error()})
+				continue
+			}
+			bodies = append(bodies, body)
+			pending = append(pending, rec)
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		err := t.post(bodies)
+		if err == nil {
+			sent += len(pending)
+			continue
+		}
+
+		var statusErr *filesync.APIStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode == 429 || statusErr.StatusCode >= 500 {
+			// Endpoint unavailable: keep everything not sent yet for replay and stop
+			for _, rec := range pending {
+				rejected = append(rejected, database.RejectedRow{Record: rec, Error: err.Error()})
+			}
+			for _, rec := range records[end:] {
+				rejected = append(rejected, database.RejectedRow{Record: rec, Error: "not sent: " + err.Error()})
+			}
+			return sent, rejected, err
+		}
+
+		// The API refused the batch: quarantine all of it, it may have applied a part
+		if len(pending) == 1 || !t.isolate {
+			for _, rec := range pending {
+				rejected = append(rejected, database.RejectedRow{Record: rec, Error: err.Error()})
+			}
+			continue
+		}
+
+		// Isolate the offending records
+		for i, rec := range pending {
+			if err := t.post(bodies[i : i+1]); err != nil {
+				rejected = append(rejected, database.RejectedRow{Record: rec, Error: err.Error()})
+				continue
+			}
+			sent++
+		}
+	}
+	return sent, rejected, nil
+}
+
+// post sends one request: a single record body, or the batch envelope of several
+func (t *apiTarget) post(bodies [][]byte) error {
+	payload := bodies[0]
+	if t.batchSize > 1 {
+		var err error
+		if payload, err = filesync.RenderBatchTemplate(t.batchTmpl, bodies); err != nil {
+			return err
+		}
+	}
+
+	config := t.config
+	config.Body = string(payload)
+	_, err := t.client.FetchAPI(config)
+	return err
+}
+
+// writeAPIBatch sends a batch to the network's API target
+func (al *AgentListener) writeAPIBatch(work insertWork) (int, []database.RejectedRow, error) {
+	records, _, err := al.workRecords(work)
+	if err != nil || len(records) == 0 {
+		return 0, nil, err
+	}
+
+	target, err := al.apiTargetOf(work)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sent, rejected, err := target.send(records)
+	if err != nil {
+		log.Printf("⚠️ API target %s unavailable after %d records: %v", target.config.URL, sent, err)
+		al.appendJobLogEvent(work.logID, "API target unavailable after %d records, %d kept for replay: %v", sent, len(rejected), err)
+	}
+	return sent, rejected, err
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"dsp-platform/internal/core"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// batchCreditWindow is the number of batches an agent may have outstanding per job run and
+// table. The agent sends a batch only while it holds a credit and pauses extraction otherwise;
+// the master returns the credit once the batch is written
+const batchCreditWindow = 8
+
+// batchCredit is returned to the agent once every write of a batch is done (the job's own
+// target and its fan-out destinations)
+type batchCredit struct {
+	remaining int32
+	agentName string
+	jobID     uint
+	logID     float64
+	table     string // Target table as the agent sent it, echoed back in the grant
+}
+
+// agentFlow is the flow control state of an agent for the system status
+type agentFlow struct {
+	outstanding int           // Batches sent against credits and not written yet
+	creditWait  time.Duration // Total time the agent paused extraction waiting for credits
+	stalls      int           // Batches the agent had to wait for
+	lastStall   time.Time
+}
+
+// agentFlowStats is a snapshot of an agent's flow control
+type agentFlowStats struct {
+	Agent       string    `json:"agent"`
+	Outstanding int       `json:"outstanding_batches"`
+	CreditWait  int64     `json:"credit_stall_ms"`
+	Stalls      int       `json:"credit_stalls"`
+	LastStall   time.Time `json:"last_stall"`
+}
+
+// agentFlows tracks flow control per agent
+type agentFlows struct {
+	mu     sync.Mutex
+	agents map[string]*agentFlow
+}
+
+// get returns the flow state of an agent, callers hold mu
+func (f *agentFlows) get(agentName string) *agentFlow {
+	flow, ok := f.agents[agentName]
+	if !ok {
+		flow = &agentFlow{}
+		f.agents[agentName] = flow
+	}
+	return flow
+}
+
+// newBatchCredit tracks a batch the agent sent against a credit. writes is the number of
+// lane writes the batch fans out to; the credit returns when the last one is done
+func (al *AgentListener) newBatchCredit(agentName string, jobID uint, logID float64, table string, writes int, wait time.Duration) *batchCredit {
+	al.flows.mu.Lock()
+	flow := al.flows.get(agentName)
+	flow.outstanding++
+	if wait > 0 {
+		flow.creditWait += wait
+		flow.stalls++
+		flow.lastStall = time.Now()
+	}
+	al.flows.mu.Unlock()
+
+	return &batchCredit{
+		remaining: int32(writes),
+		agentName: agentName,
+		jobID:     jobID,
+		logID:     logID,
+		table:     table,
+	}
+}
+
+// releaseCredit marks one write of a batch done and grants the credit back to the agent
+// after the last one. Nil credits (agents without flow control) are ignored
+func (al *AgentListener) releaseCredit(credit *batchCredit) {
+	if credit == nil || atomic.AddInt32(&credit.remaining, -1) > 0 {
+		return
+	}
+
+	al.flows.mu.Lock()
+	if flow := al.flows.get(credit.agentName); flow.outstanding > 0 {
+		flow.outstanding--
+	}
+	al.flows.mu.Unlock()
+
+	grant := core.AgentMessage{
+		Type:      "CREDIT",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"job_id":       credit.jobID,
+			"log_id":       uint(credit.logID),
+			"target_table": credit.table,
+			"credits":      1,
+		},
+	}
+	if err := al.SendCommandToAgent(credit.agentName, grant); err != nil {
+		log.Printf("⚠️ Failed to grant batch credit to agent %s for job %d: %v", credit.agentName, credit.jobID, err)
+	}
+}
+
+// FlowStatus returns the write queue depth and the flow control state of the agents
+func (al *AgentListener) FlowStatus() map[string]interface{} {
+	al.flows.mu.Lock()
+	agents := make([]agentFlowStats, 0, len(al.flows.agents))
+	for name, flow := range al.flows.agents {
+		agents = append(agents, agentFlowStats{
+			Agent:       name,
+			Outstanding: flow.outstanding,
+			CreditWait:  flow.creditWait.Milliseconds(),
+			Stalls:      flow.stalls,
+			LastStall:   flow.lastStall,
+		})
+	}
+	al.flows.mu.Unlock()
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Agent < agents[j].Agent })
+
+	return map[string]interface{}{
+		"queue":         al.lanes.stats(),
+		"credit_window": batchCreditWindow,
+		"agents":        agents,
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"dsp-platform/internal/core"
+	"dsp-platform/internal/database"
+	"encoding/json"
+	"log"
+)
+
+// directWriteConfig returns the direct_write section of a RUN_JOB command when the network's
+// agent writes a table to the target database itself, nil when its batches go through the
+// master. rule is nil for single-query schemas, they take the settings of the schema rule
+// naming their table like master-written batches do
+func (al *AgentListener) directWriteConfig(job core.Job, rule *core.SchemaRule, table string, logID uint) map[string]interface{} {
+	if !job.Network.AgentDirectWrite || table == "" {
+		return nil
+	}
+	single := rule == nil
+	if single && job.Schema != nil {
+		var rules []core.SchemaRule
+		al.handler.db.Where("schema_id = ? AND target_table = ?", job.Schema.ID, table).Limit(1).Find(&rules)
+		if len(rules) > 0 {
+			rule = &rules[0]
+		}
+	}
+
+	reason := ""
+	var destinations int64
+	al.handler.db.Model(&core.JobDestination{}).Where("job_id = ? AND enabled = ?", job.ID, true).Count(&destinations)
+	switch {
+	case job.Network.SourceType != "" && job.Network.SourceType != "database":
+		reason = "only database sources are supported"
+	case job.Schema != nil && job.Schema.SourceType == "javascript":
+		reason = "only database sources are supported"
+	case !isDatabaseTarget(job.Network.TargetSourceType):
+		reason = "only database targets are supported"
+	case rule != nil && rule.LoadMode == database.LoadModeStagingSwap:
+		reason = "staging_swap loads are swapped by the master"
+	case destinations > 0:
+		reason = "the job fans out to extra destinations"
+	}
+
+	config := al.loadTargetDBConfigFromNetwork(job.NetworkID)
+	if config.Host == "" {
+		config = al.loadTargetDBConfig()
+	}
+	if reason == "" && config.Host == "" {
+		reason = "no target database is configured"
+	}
+	if reason != "" {
+		log.Printf("Agent direct write skipped for job %d table %s: %s", job.ID, table, reason)
+		al.appendJobLogEvent(float64(logID), "Agent direct write skipped for %s (%s), batches go through the master", table, reason)
+		return nil
+	}
+
+	var keyColumns []string
+	if job.Schema != nil {
+		keyColumns = database.ParseKeyColumns(job.Schema.UniqueKeyColumn)
+	}
+	direct := map[string]interface{}{
+		"target_db_config": map[string]interface{}{
+			"driver":   config.Driver,
+			"host":     config.Host,
+			"port":     config.Port,
+			"user":     config.User,
+			"password": config.Password,
+			"db_name":  config.DBName,
+			"sslmode":  config.SSLMode,
+		},
+		"target_table": table,
+		"max_rejected": job.MaxRejectedRows,
+	}
+	if rule != nil {
+		if rule.UniqueKey != "" {
+			keyColumns = database.ParseKeyColumns(rule.UniqueKey)
+		}
+		var writeMode string
+		writeMode, keyColumns = database.ResolveWriteMode(rule.WriteMode, keyColumns)
+		direct["write_mode"] = writeMode
+		direct["schema_policy"] = rule.SchemaChangePolicy
+		direct["mapping"] = rule.Mapping
+		direct["dedupe_strategy"] = rule.DedupeStrategy
+		direct["dedupe_order_column"] = rule.DedupeOrderColumn
+		direct["skip_sequences"] = rule.SkipSequenceReset
+		direct["upload_post"] = rule.UploadPostQuery
+		if !single {
+			// Pre-job queries of single-query schemas aren't run on the master path either
+			direct["truncate"] = truncateBeforeLoad(*rule)
+			direct["upload_pre"] = rule.UploadPreQuery
+		}
+	}
+	direct["key_columns"] = keyColumns
+
+	al.appendJobLogEvent(float64(logID), "Agent writes %s to the target database directly", table)
+	return direct
+}
+
+// recordDirectBatch records a batch the agent already wrote to the target: its counts go
+// to the run and job log like those of a master-written batch, its rejected rows to the
+// quarantine and the agent's events (DDL, dedupe, sequence resets) to the job log
+func (al *AgentListener) recordDirectBatch(data map[string]interface{}, work insertWork) {
+	if raw, ok := data["events"].([]interface{}); ok {
+		for _, e := range raw {
+			if line, ok := e.(string); ok && line != "" {
+				al.appendJobLogEvent(work.logID, "%s", line)
+			}
+		}
+	}
+
+	written := 0
+	if w, ok := data["written_count"].(float64); ok {
+		written = int(w)
+	}
+
+	var rejected []database.RejectedRow
+	if raw, ok := data["rejected_rows"]; ok && raw != nil {
+		if b, err := json.Marshal(raw); err == nil {
+			if err := json.Unmarshal(b, &rejected); err != nil {
+				log.Printf("⚠️ Ignoring malformed rejected_rows from agent for job %d: %v", work.jobID, err)
+			}
+		}
+	}
+	if len(rejected) > 0 {
+		al.quarantineRows(work, rejected)
+	}
+
+	if work.recordCount == 0 && written == 0 {
+		return
+	}
+	if work.run != nil {
+		work.run.recordBatch(work.recordCount, written, len(rejected), nil)
+	}
+
+	// Batches of a tracked run leave the final status to finalizeRun
+	isPartial := work.isPartial || work.run != nil
+	al.updateJobLog(work.logID, isPartial, work.status, work.recordCount, written, work.sampleData, work.errorMsg)
+	al.updateJobStatus(work.jobID, isPartial, work.status)
+	log.Printf("Job %d direct batch from agent: table=%s, records=%d, written=%d, rejected=%d",
+		work.jobID, work.tableName, work.recordCount, written, len(rejected))
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"dsp-platform/internal/auth"
+	"dsp-platform/internal/core"
+	"dsp-platform/internal/database"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Destination failure policies of a job (Job.DestinationFailurePolicy)
+const (
+	DestinationPolicyFail     = "fail"     // A failing destination fails the run (default)
+	DestinationPolicyContinue = "continue" // Destination failures are recorded, the job's own target decides
+)
+
+// validateDestinationPolicy checks the destination failure policy of a job ("" = fail)
+func validateDestinationPolicy(policy string) error {
+	switch policy {
+	case "", DestinationPolicyFail, DestinationPolicyContinue:
+		return nil
+	}
+	return fmt.Errorf("invalid destination_failure_policy %q (fail or continue)", policy)
+}
+
+// runDestination marks the run of a fan-out destination
+type runDestination struct {
+	id        uint
+	name      string
+	writeMode string
+}
+
+// destinationTable resolves the table a destination writes a job table to
+func destinationTable(dest core.JobDestination, table string) string {
+	if dest.TargetTable == "" {
+		return table
+	}
+	return strings.ReplaceAll(dest.TargetTable, "{table}", table)
+}
+
+// destinationWriteMode applies a destination's write mode and unique key over the rule's
+func destinationWriteMode(dest core.JobDestination, writeMode string, keyColumns []string) (string, []string) {
+	if dest.UniqueKey != "" {
+		keyColumns = database.ParseKeyColumns(dest.UniqueKey)
+	}
+	if dest.WriteMode == "" {
+		return writeMode, keyColumns
+	}
+	return database.ResolveWriteMode(dest.WriteMode, keyColumns)
+}
+
+// destinationName is the display name of a destination
+func destinationName(dest core.JobDestination) string {
+	if dest.Name != "" {
+		return dest.Name
+	}
+	if dest.Network.Name != "" {
+		return dest.Network.Name
+	}
+	return fmt.Sprintf("destination %d", dest.ID)
+}
+
+// destinationRuns returns the runs of a job's enabled destinations for a table, attached
+// to the job's own run so it finalizes them first
+func (al *AgentListener) destinationRuns(run *runState, destinations []core.JobDestination, writeMode, loadMode string) []*runState {
+	var runs []*runState
+	for _, dest := range destinations {
+		table := destinationTable(dest, run.table)
+		targetType := dest.Network.TargetSourceType
+		destLoadMode := loadMode
+		if !isDatabaseTarget(targetType) {
+			destLoadMode = ""
+		}
+
+		// Settings are fixed on the first batch, workers read them without locking
+		destRun := al.runStateFor(dest.NetworkID, run.jobID, run.logID, table, destLoadMode, func(r *runState) {
+			mode, keys := destinationWriteMode(dest, writeMode, run.keyColumns)
+			r.targetType = targetType
+			r.keyColumns = keys
+			r.maxRejected = run.maxRejected
+			r.skipSequences = run.skipSequences
+			r.destination = &runDestination{id: dest.ID, name: destinationName(dest), writeMode: mode}
+		})
+		if destRun == run {
+			log.Printf("⚠️ Destination %d of job %d writes to the job's own target %s, skipped", dest.ID, run.jobID, table)
+			continue
+		}
+
+		run.addDestination(destRun)
+		runs = append(runs, destRun)
+	}
+	return runs
+}
+
+// addDestination attaches a destination run once
+func (r *runState) addDestination(dest *runState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.fanout {
+		if existing == dest {
+			return
+		}
+	}
+	r.fanout = append(r.fanout, dest)
+}
+
+// destinationWork derives the batch of a destination run from the job's own batch. Records
+// are copied since target writers may add columns to them
+func (al *AgentListener) destinationWork(work insertWork, dest *runState, rawColumnTypes interface{}) insertWork {
+	destWork := work
+	destWork.run = dest
+	destWork.networkID = dest.networkID
+	destWork.tableName = dest.table
+	destWork.targetType = dest.targetType
+	destWork.keyColumns = dest.keyColumns
+	destWork.writeMode = dest.destination.writeMode
+	destWork.uploadPostQuery = "" // Post queries belong to the job's own target
+	destWork.checkpointColumn = ""
+
+	if work.records != nil {
+		destWork.records = make([]interface{}, len(work.records))
+		for i, r := range work.records {
+			if rec, ok := r.(map[string]interface{}); ok {
+				copied := make(map[string]interface{}, len(rec))
+				for k, v := range rec {
+					copied[k] = v
+				}
+				destWork.records[i] = copied
+			} else {
+				destWork.records[i] = r
+			}
+		}
+	}
+	if work.csvData != "" {
+		destWork.columnTypes = al.runColumnTypesFor(dest.networkID, dest.logID, dest.table, rawColumnTypes)
+	}
+	return destWork
+}
+
+// finalizeDestinations finalizes the destination runs of a job run with the agent's outcome
+// and returns the failures
+func (al *AgentListener) finalizeDestinations(run *runState, status, errorMsg string) []string {
+	run.mu.Lock()
+	destinations := append([]*runState(nil), run.fanout...)
+	run.mu.Unlock()
+
+	var failures []string
+	for _, dest := range destinations {
+		al.finalizeRun(dest, status, errorMsg, 0, "")
+		if dest.finalStatus == "failed" {
+			failures = append(failures, fmt.Sprintf("%s (%s): %s", dest.destination.name, dest.table, dest.finalError))
+		}
+	}
+	return failures
+}
+
+// recordDestinationRun stores the outcome of a destination run
+func (al *AgentListener) recordDestinationRun(run *runState, status, errorMsg string) {
+	run.mu.Lock()
+	entry := core.JobDestinationRun{
+		JobID:           run.jobID,
+		JobLogID:        uint(run.logID),
+		DestinationID:   run.destination.id,
+		NetworkID:       run.networkID,
+		TargetTable:     run.table,
+		Status:          status,
+		RecordsReceived: run.received,
+		RecordsWritten:  run.written,
+		RecordsRejected: run.rejected,
+		ErrorMessage:    errorMsg,
+	}
+	run.mu.Unlock()
+
+	if err := al.handler.db.Create(&entry).Error; err != nil {
+		log.Printf("⚠️ Failed to record destination run of %s: %v", run.table, err)
+	}
+	if status == "failed" {
+		al.appendJobLogEvent(run.logID, "Destination %s: %s failed: %s", run.destination.name, run.table, errorMsg)
+	} else {
+		al.appendJobLogEvent(run.logID, "Destination %s: wrote %d rows to %s (%d rejected)", run.destination.name, entry.RecordsWritten, run.table, entry.RecordsRejected)
+	}
+}
+
+// validateJobDestination checks a destination against its job
+func (h *Handler) validateJobDestination(job core.Job, dest core.JobDestination) error {
+	var network core.Network
+	if dest.NetworkID == 0 || h.db.Select("id").First(&network, dest.NetworkID).Error != nil {
+		return fmt.Errorf("network_id must reference an existing network")
+	}
+	switch dest.WriteMode {
+	case "", database.WriteModeInsert, database.WriteModeUpsert, database.WriteModeSCD2:
+	default:
+		return fmt.Errorf("invalid write_mode %q (insert, upsert or scd2)", dest.WriteMode)
+	}
+	if dest.NetworkID == job.NetworkID && (dest.TargetTable == "" || dest.TargetTable == "{table}") {
+		return fmt.Errorf("destination duplicates the job's own target, set a different network or target_table")
+	}
+
+	var duplicates int64
+	h.db.Model(&core.JobDestination{}).
+		Where("job_id = ? AND network_id = ? AND target_table = ? AND id <> ?", job.ID, dest.NetworkID, dest.TargetTable, dest.ID).
+		Count(&duplicates)
+	if duplicates > 0 {
+		return fmt.Errorf("the job already has a destination for this network and target_table")
+	}
+	return nil
+}
+
+// jobForDestinations loads the job of a destination request and checks the caller may modify it
+func (h *Handler) jobForDestinations(c *gin.Context) (core.Job, bool) {
+	var job core.Job
+	if err := h.db.First(&job, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return job, false
+	}
+	if !auth.CanModifyResource(c.GetString("role"), c.GetUint("user_id"), job.CreatedBy) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to modify this job"})
+		return job, false
+	}
+	return job, true
+}
+
+// auditJobDestination records a destination change in the audit log
+func (h *Handler) auditJobDestination(c *gin.Context, action string, dest core.JobDestination, details string) {
+	go func() {
+		h.db.Create(&core.AuditLog{
+			Username:  c.GetString("username"),
+			UserID:    c.GetUint("user_id"),
+			Action:    action,
+			Entity:    "JOB_DESTINATION",
+			EntityID:  fmt.Sprintf("%d", dest.ID),
+			Details:   details,
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			CreatedAt: time.Now(),
+		})
+	}()
+}
+
+// GetJobDestinations returns the extra destinations of a job
+func (h *Handler) GetJobDestinations(c *gin.Context) {
+	destinations := []core.JobDestination{}
+	if err := h.db.Preload("Network").Where("job_id = ?", c.Param("id")).Order("id asc").Find(&destinations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job destinations"})
+		return
+	}
+	c.JSON(http.StatusOK, destinations)
+}
+
+// CreateJobDestination adds an extra destination to a job
+func (h *Handler) CreateJobDestination(c *gin.Context) {
+	job, ok := h.jobForDestinations(c)
+	if !ok {
+		return
+	}
+
+	// Enabled unless the request says otherwise; a GORM default would turn an explicit false into true
+	dest := core.JobDestination{Enabled: true}
+	if err := c.ShouldBindJSON(&dest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dest.ID = 0
+	dest.JobID = job.ID
+	if err := h.validateJobDestination(job, dest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dest.CreatedBy = c.GetUint("user_id")
+	dest.UpdatedBy = c.GetUint("user_id")
+
+	if err := h.db.Create(&dest).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.auditJobDestination(c, "CREATE", dest, fmt.Sprintf("Added destination (network %d) to job '%s'", dest.NetworkID, job.Name))
+	c.JSON(http.StatusCreated, dest)
+}
+
+// UpdateJobDestination updates an extra destination of a job
+func (h *Handler) UpdateJobDestination(c *gin.Context) {
+	job, ok := h.jobForDestinations(c)
+	if !ok {
+		return
+	}
+
+	var dest core.JobDestination
+	if err := h.db.Where("job_id = ?", job.ID).First(&dest, c.Param("destId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Destination not found"})
+		return
+	}
+
+	id, createdBy := dest.ID, dest.CreatedBy
+	if err := c.ShouldBindJSON(&dest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dest.ID, dest.JobID, dest.CreatedBy = id, job.ID, createdBy
+	dest.UpdatedBy = c.GetUint("user_id")
+	dest.Network = core.Network{}
+	if err := h.validateJobDestination(job, dest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.db.Omit("Network").Save(&dest).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.auditJobDestination(c, "UPDATE", dest, fmt.Sprintf("Updated destination %d of job '%s'", dest.ID, job.Name))
+	c.JSON(http.StatusOK, dest)
+}
+
+// DeleteJobDestination removes an extra destination from a job
+func (h *Handler) DeleteJobDestination(c *gin.Context) {
+	job, ok := h.jobForDestinations(c)
+	if !ok {
+		return
+	}
+
+	var dest core.JobDestination
+	if err := h.db.Where("job_id = ?", job.ID).First(&dest, c.Param("destId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Destination not found"})
+		return
+	}
+	if err := h.db.Delete(&dest).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.auditJobDestination(c, "DELETE", dest, fmt.Sprintf("Removed destination %d from job '%s'", dest.ID, job.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "Destination deleted successfully"})
+}
+
+// GetJobDestinationRuns returns the per-destination outcomes of a job's runs,
+// optionally for one run (job_log_id)
+func (h *Handler) GetJobDestinationRuns(c *gin.Context) {
+	query := h.db.Where("job_id = ?", c.Param("id"))
+	if logID := c.Query("job_log_id"); logID != "" {
+		query = query.Where("job_log_id = ?", logID)
+	}
+
+	runs := []core.JobDestinationRun{}
+	if err := query.Order("id desc").Limit(200).Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch destination runs"})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"dsp-platform/internal/core"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ftpConfigCommand is the ftp_config section of agent commands for FTP/SFTP sources
+func ftpConfigCommand(n core.Network) map[string]interface{} {
+	return map[string]interface{}{
+		"host":        n.FTPHost,
+		"port":        n.FTPPort,
+		"user":        n.FTPUser,
+		"password":    n.FTPPassword,
+		"private_key": n.FTPPrivateKey,
+		"path":        n.FTPPath,
+		"passive":     n.FTPPassive,
+	}
+}
+
+// minioConfigCommand is the minio_config section of agent commands for MinIO sources
+func minioConfigCommand(n core.Network) map[string]interface{} {
+	return map[string]interface{}{
+		"endpoint":    n.MinIOEndpoint,
+		"access_key":  n.MinIOAccessKey,
+		"secret_key":  n.MinIOSecretKey,
+		"bucket":      n.MinIOBucket,
+		"object_path": n.MinIOObjectPath,
+		"use_ssl":     n.MinIOUseSSL,
+		"region":      n.MinIORegion,
+	}
+}
+
+// multiFileConfig adds the multi-file settings of a schema to a RUN_JOB file_config,
+// with the job's manifest so the agent skips the files already loaded
+func (al *AgentListener) multiFileConfig(jobID uint, schema *core.Schema, fileConfig map[string]interface{}) {
+	if schema == nil || schema.FileMode != "multi" {
+		return
+	}
+	nameColumn := schema.FileNameColumn
+	if nameColumn == "" {
+		nameColumn = "source_file"
+	}
+	fileConfig["mode"] = "multi"
+	fileConfig["order"] = schema.FileOrder
+	fileConfig["post_action"] = schema.FilePostAction
+	fileConfig["archive_path"] = schema.FileArchivePath
+	fileConfig["rename_suffix"] = schema.FileRenameSuffix
+	fileConfig["file_name_column"] = nameColumn
+
+	// Files of a run still in progress count as processed too, overlapping runs don't load
+	// them twice. Files left loading by a run that is gone are loaded again
+	var manifest []core.ProcessedFile
+	al.handler.db.Select("path", "size", "mod_time", "status", "job_log_id").
+		Where("job_id = ? AND status IN ?", jobID, []string{"loading", "loaded"}).Find(&manifest)
+	live := al.liveRunLogIDs()
+	processed := make([]map[string]interface{}, 0, len(manifest))
+	for _, f := range manifest {
+		if f.Status == "loading" && !live[f.JobLogID] {
+			continue
+		}
+		processed = append(processed, map[string]interface{}{
+			"path":  f.Path,
+			"size":  f.Size,
+			"mtime": f.ModTime.Unix(),
+		})
+	}
+	fileConfig["processed"] = processed
+}
+
+// handleFileLoaded records a file of a multi-file run in the job's manifest. It counts as
+// loaded once the run succeeds (see finishProcessedFiles)
+func (al *AgentListener) handleFileLoaded(msg core.AgentMessage) {
+	jobID, _ := msg.Data["job_id"].(float64)
+	logID, _ := msg.Data["log_id"].(float64)
+	filePath, _ := msg.Data["path"].(string)
+	size, _ := msg.Data["size"].(float64)
+	mtime, _ := msg.Data["mtime"].(float64)
+	entry, _ := msg.Data["entry"].(string)
+	checksum, _ := msg.Data["checksum"].(string)
+	records, _ := msg.Data["record_count"].(float64)
+	if jobID == 0 || filePath == "" {
+		return
+	}
+
+	file := core.ProcessedFile{
+		JobID:       uint(jobID),
+		JobLogID:    uint(logID),
+		Path:        filePath,
+		Entry:       entry,
+		Size:        int64(size),
+		ModTime:     time.Unix(int64(mtime), 0),
+		Checksum:    checksum,
+		RecordCount: int(records),
+		Status:      "loading",
+		ProcessedAt: time.Now(),
+	}
+	if err := al.handler.db.Create(&file).Error; err != nil {
+		log.Printf("⚠️ Failed to record processed file %s of job %d: %v", filePath, file.JobID, err)
+		return
+	}
+	if entry != "" {
+		al.appendJobLogEvent(logID, "Loaded %s from archive %s (%d records)", entry, filePath, file.RecordCount)
+		return
+	}
+	al.appendJobLogEvent(logID, "Loaded file %s (%d records, %d bytes)", filePath, file.RecordCount, file.Size)
+}
+
+// finishProcessedFiles settles the manifest entries of a finished run: a failed run's files
+// are loaded again next time, a successful run's files get their post-load action
+func (al *AgentListener) finishProcessedFiles(run *runState, status string) {
+	db := al.handler.db
+	var files []core.ProcessedFile
+	if err := db.Where("job_log_id = ? AND status = ?", uint(run.logID), "loading").Find(&files).Error; err != nil || len(files) == 0 {
+		return
+	}
+
+	if status == "failed" {
+		db.Model(&core.ProcessedFile{}).Where("job_log_id = ? AND status = ?", uint(run.logID), "loading").Update("status", "failed")
+		al.appendJobLogEvent(run.logID, "%d files will be loaded again by the next run", len(files))
+		return
+	}
+	db.Model(&core.ProcessedFile{}).Where("job_log_id = ? AND status = ?", uint(run.logID), "loading").Update("status", "loaded")
+
+	var job core.Job
+	if err := db.Preload("Schema").Preload("Network").First(&job, run.jobID).Error; err != nil || job.Schema == nil {
+		return
+	}
+	action := job.Schema.FilePostAction
+	if action == "" || action == "none" {
+		return
+	}
+
+	// Archives have an entry per loaded file, they are moved once
+	paths := make([]interface{}, 0, len(files))
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if !seen[f.Path] {
+			seen[f.Path] = true
+			paths = append(paths, f.Path)
+		}
+	}
+	agentName := job.Network.Name
+	if job.Network.AgentName != "" {
+		agentName = job.Network.AgentName
+	}
+	command := core.AgentMessage{
+		Type:      "FILE_POST_ACTIONS",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"job_id":        job.ID,
+			"log_id":        uint(run.logID),
+			"source_type":   job.Network.SourceType,
+			"ftp_config":    ftpConfigCommand(job.Network),
+			"minio_config":  minioConfigCommand(job.Network),
+			"files":         paths,
+			"post_action":   action,
+			"archive_path":  job.Schema.FileArchivePath,
+			"rename_suffix": job.Schema.FileRenameSuffix,
+		},
+	}
+	if err := al.SendCommandToAgent(agentName, command); err != nil {
+		log.Printf("⚠️ Failed to send post-load actions to agent %s for job %d: %v", agentName, job.ID, err)
+		al.appendJobLogEvent(run.logID, "Post-load action %s not applied, agent unreachable: %v", action, err)
+	}
+}
+
+// failStaleProcessedFiles fails the manifest entries left loading by runs that are gone (master
+// restart, run never finalized) for longer than maxIdle, so the next run loads them again
+func (al *AgentListener) failStaleProcessedFiles(maxIdle time.Duration) {
+	db := al.handler.db
+	var logIDs []uint
+	db.Model(&core.ProcessedFile{}).
+		Where("status = ? AND processed_at < ?", "loading", time.Now().Add(-maxIdle)).
+		Distinct().Pluck("job_log_id", &logIDs)
+
+	live := al.liveRunLogIDs()
+	for _, logID := range logIDs {
+		if live[logID] {
+			continue
+		}
+		result := db.Model(&core.ProcessedFile{}).Where("job_log_id = ? AND status = ?", logID, "loading").Update("status", "failed")
+		if result.Error != nil {
+			log.Printf("⚠️ Failed to reset processed files of run %d: %v", logID, result.Error)
+			continue
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("⚠️ %d files of run %d were never settled, they will be loaded again", result.RowsAffected, logID)
+			al.appendJobLogEvent(float64(logID), "%d files were never settled (run not finalized), they will be loaded again by the next run", result.RowsAffected)
+		}
+	}
+}
+
+// handleFilePostActionsResult records where the agent moved the loaded files
+func (al *AgentListener) handleFilePostActionsResult(msg core.AgentMessage) {
+	logID, _ := msg.Data["log_id"].(float64)
+	results, _ := msg.Data["results"].([]interface{})
+	for _, r := range results {
+		result, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		filePath, _ := result["path"].(string)
+		movedTo, _ := result["moved_to"].(string)
+		errMsg, _ := result["error"].(string)
+		al.handler.db.Model(&core.ProcessedFile{}).
+			Where("job_log_id = ? AND path = ?", uint(logID), filePath).
+			Updates(map[string]interface{}{"moved_to": movedTo, "post_action_error": errMsg})
+		if errMsg != "" {
+			al.appendJobLogEvent(logID, "Post-load action on %s failed: %s", filePath, errMsg)
+		}
+	}
+}
+
+// GetProcessedFiles returns the manifest of files a multi-file job loaded
+func (h *Handler) GetProcessedFiles(c *gin.Context) {
+	files := []core.ProcessedFile{}
+	if err := h.db.Where("job_id = ?", c.Param("id")).Order("processed_at DESC").Limit(500).Find(&files).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// DeleteProcessedFile removes a manifest entry, so the next run loads the file again
+func (h *Handler) DeleteProcessedFile(c *gin.Context) {
+	var file core.ProcessedFile
+	if err := h.db.Where("id = ? AND job_id = ?", c.Param("fileId"), c.Param("id")).First(&file).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Processed file not found"})
+		return
+	}
+	if err := h.db.Delete(&file).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Log audit
+	go func() {
+		h.db.Create(&core.AuditLog{
+			Username:  c.GetString("username"),
+			UserID:    c.GetUint("user_id"),
+			Action:    "DELETE",
+			Entity:    "PROCESSED_FILE",
+			EntityID:  fmt.Sprintf("%d", file.ID),
+			Details:   fmt.Sprintf("Reset processed file '%s' of job %d", file.Path, file.JobID),
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			CreatedAt: time.Now(),
+		})
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": "File will be loaded again by the next run"})
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"bufio"
+	"dsp-platform/internal/core"
+	"dsp-platform/internal/database"
+	"dsp-platform/internal/filesync"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// isFileTarget reports whether a network target type writes files instead of database rows
+func isFileTarget(targetType string) bool {
+	return targetType == "ftp" || targetType == "sftp"
+}
+
+// isDatabaseTarget reports whether a network target type writes into the target database
+// (sequences, pre/post queries and staging only apply there)
+func isDatabaseTarget(targetType string) bool {
+	return targetType == "" || targetType == "database"
+}
+
+// workRecords returns the records of a batch for targets that take whole records (files,
+// APIs), with duplicate keys collapsed. columns is the batch column order for CSV batches
+func (al *AgentListener) workRecords(work insertWork) ([]map[string]interface{}, []string, error) {
+	var records []map[string]interface{}
+	var columns []string
+	if work.csvData != "" && len(work.csvColumns) > 0 {
+		parsed, err := database.ParseCsvRecords(work.csvData, work.csvColumns)
+		if err != nil {
+			return nil, nil, err
+		}
+		records, columns = parsed, work.csvColumns
+	} else {
+		for _, r := range work.records {
+			if rec, ok := r.(map[string]interface{}); ok {
+				records = append(records, rec)
+			}
+		}
+	}
+	if len(work.keyColumns) > 0 {
+		var dropped int
+		records, dropped = database.DedupeRecords(records, work.keyColumns, work.dedupe)
+		al.logDedupe(work, dropped)
+	}
+	return records, columns, nil
+}
+
+// fileSpool buffers the rows of a run on local disk (JSON Lines) until the run completes,
+// so the export file is only produced once, from every batch, in one format pass
+type fileSpool struct {
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	columns []string // Column order of the export (first seen)
+	seen    map[string]bool
+	rows    int
+}
+
+// newFileSpool creates a spool file in the system temp directory
+func newFileSpool() (*fileSpool, error) {
+	file, err := os.CreateTemp("", "dsp-file-target-*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	return &fileSpool{file: file, writer: bufio.NewWriter(file), seen: make(map[string]bool)}, nil
+}
+
+// add appends records. columns gives the batch column order (CSV batches); JSON records
+// contribute their new keys sorted
+func (s *fileSpool) add(records []map[string]interface{}, columns []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, col := range columns {
+		if !s.seen[col] {
+			s.seen[col] = true
+			s.columns = append(s.columns, col)
+		}
+	}
+	for _, rec := range records {
+		var fresh []string
+		for col := range rec {
+			if !s.seen[col] {
+				fresh = append(fresh, col)
+			}
+		}
+		sort.Strings(fresh)
+		for _, col := range fresh {
+			s.seen[col] = true
+			s.columns = append(s.columns, col)
+		}
+
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("failed to spool record: %w", err)
+		}
+		if _, err := s.writer.Write(data); err != nil {
+			return fmt.Errorf("failed to spool record: %w", err)
+		}
+		if err := s.writer.WriteByte('\n'); err != nil {
+			return fmt.Errorf("failed to spool record: %w", err)
+		}
+		s.rows++
+	}
+	return nil
+}
+
+// render writes the spooled rows to a local export file and returns its path and digest
+func (s *fileSpool) render(format, sheet string) (string, string, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.writer.Flush(); err != nil {
+		return "", "", 0, err
+	}
+	if _, err := s.file.Seek(0, 0); err != nil {
+		return "", "", 0, err
+	}
+
+	out, err := os.CreateTemp("", "dsp-file-export-*")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer out.Close()
+
+	hashed := filesync.NewSHA256Writer(out)
+	buffered := bufio.NewWriter(hashed)
+	fail := func(err error) (string, string, int64, error) {
+		os.Remove(out.Name())
+		return "", "", 0, err
+	}
+
+	enc, err := filesync.NewRecordEncoder(format, buffered, s.columns, sheet)
+	if err != nil {
+		return fail(err)
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(s.file))
+	decoder.UseNumber() // Keep numbers exactly as they arrived
+	for decoder.More() {
+		var rec map[string]interface{}
+		if err := decoder.Decode(&rec); err != nil {
+			return fail(fmt.Errorf("failed to read spool: %w", err))
+		}
+		if err := enc.WriteRecord(rec); err != nil {
+			return fail(fmt.Errorf("failed to write %s row: %w", format, err))
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return fail(err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fail(err)
+	}
+	return out.Name(), hashed.Sum(), hashed.Size(), nil
+}
+
+// discard removes the spool file
+func (s *fileSpool) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// spoolFor returns the run's spool, created on the first batch
+func (r *runState) spoolFor() (*fileSpool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.spool == nil {
+		spool, err := newFileSpool()
+		if err != nil {
+			return nil, err
+		}
+		r.spool = spool
+	}
+	return r.spool, nil
+}
+
+// writeFileBatch spools a batch of a file-target run. Batches outside a tracked run are
+// exported as their own file right away
+func (al *AgentListener) writeFileBatch(work insertWork) (int, error) {
+	records, columns, err := al.workRecords(work)
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+
+	if work.run != nil {
+		spool, err := work.run.spoolFor()
+		if err != nil {
+			return 0, err
+		}
+		if err := spool.add(records, columns); err != nil {
+			return 0, err
+		}
+		return len(records), nil
+	}
+
+	spool, err := newFileSpool()
+	if err != nil {
+		return 0, err
+	}
+	defer spool.discard()
+	if err := spool.add(records, columns); err != nil {
+		return 0, err
+	}
+	if err := al.exportFile(spool, work.networkID, work.jobID, work.logID, work.tableName, true); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// finalizeFileTarget exports the spool of a completed run, or drops it when the run failed
+func (al *AgentListener) finalizeFileTarget(run *runState, failed bool) error {
+	run.mu.Lock()
+	spool := run.spool
+	run.mu.Unlock()
+
+	if spool == nil {
+		if !failed {
+			al.appendJobLogEvent(run.logID, "No rows extracted for %s, no file written", run.table)
+		}
+		return nil
+	}
+	defer spool.discard()
+	if failed {
+		al.appendJobLogEvent(run.logID, "Run failed, %d spooled rows of %s not exported", spool.rows, run.table)
+		return nil
+	}
+	return al.exportFile(spool, run.networkID, run.jobID, run.logID, run.table, false)
+}
+
+// untrackedExports numbers the files and objects of batches outside a tracked run
+var untrackedExports atomic.Uint64
+
+// exportFile renders a spool in the network's file format and uploads it to its FTP/SFTP
+// target: temp name then rename, plus the optional checksum file and done marker. Untracked
+// batches get a unique suffix, several of them in the same second would replace each other
+func (al *AgentListener) exportFile(spool *fileSpool, networkID, jobID uint, logID float64, table string, untracked bool) error {
+	var network core.Network
+	if err := al.handler.db.First(&network, networkID).Error; err != nil {
+		return fmt.Errorf("network %d not found: %w", networkID, err)
+	}
+	format, err := filesync.NormalizeExportFormat(network.TargetFileFormat)
+	if err != nil {
+		return err
+	}
+
+	var job core.Job
+	if jobID > 0 {
+		al.handler.db.Select("id", "name").First(&job, jobID)
+	}
+	name := filesync.RenderFileName(network.TargetFileNameTemplate, filesync.FileNameVars{
+		Table: table,
+		JobID: jobID,
+		Job:   job.Name,
+		RunID: uint(logID),
+		Ext:   format,
+		Time:  time.Now(),
+	})
+	if untracked {
+		ext := path.Ext(name)
+		name = fmt.Sprintf("%s_%d_%d%s", strings.TrimSuffix(name, ext), time.Now().UnixMilli(), untrackedExports.Add(1), ext)
+	}
+	remotePath := path.Join(network.TargetFTPPath, name)
+
+	localPath, digest, size, err := spool.render(format, table)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(localPath)
+
+	content, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	remote, err := connectFileTarget(network)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	written, err := filesync.UploadAtomic(remote, remotePath, content, filesync.UploadOptions{
+		DoneMarker: network.TargetFileDoneMarker,
+		Checksum:   network.TargetFileChecksum,
+		SHA256:     digest,
+	})
+	if err != nil {
+		return fmt.Errorf("upload to %s failed: %w", remotePath, err)
+	}
+
+	log.Printf("📤 Exported %d rows of %s to %s://%s%s (%d bytes)", spool.rows, table, network.TargetSourceType, network.TargetFTPHost, remotePath, size)
+	al.appendJobLogEvent(logID, "Exported %d rows of %s as %s to %s (%d bytes, sha256 %s)", spool.rows, table, format, remotePath, size, digest)
+	for _, extra := range written[1:] {
+		al.appendJobLogEvent(logID, "Wrote %s", extra)
+	}
+	return nil
+}
+
+// connectFileTarget opens the FTP or SFTP connection of a network's target
+func connectFileTarget(network core.Network) (filesync.RemoteWriter, error) {
+	if network.TargetSourceType == "sftp" {
+		port := network.TargetFTPPort
+		if port == "" || port == "21" {
+			port = "22"
+		}
+		return filesync.NewSFTPClient(filesync.SFTPConfig{
+			Host:       network.TargetFTPHost,
+			Port:       port,
+			User:       network.TargetFTPUser,
+			Password:   network.TargetFTPPassword,
+			PrivateKey: network.TargetFTPPrivateKey,
+			Path:       network.TargetFTPPath,
+		})
+	}
+
+	port := network.TargetFTPPort
+	if port == "" {
+		port = "21"
+	}
+	return filesync.NewFTPClient(filesync.FTPConfig{
+		Host:     network.TargetFTPHost,
+		Port:     port,
+		User:     network.TargetFTPUser,
+		Password: network.TargetFTPPassword,
+		Path:     network.TargetFTPPath,
+		Passive:  true,
+	})
+}
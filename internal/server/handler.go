@@ -8,6 +8,7 @@ import (
 	"dsp-platform/internal/database"
 	"dsp-platform/internal/filesync"
 	"dsp-platform/internal/license"
+	"dsp-platform/internal/mapping"
 	"fmt"
 	"io"
 	"log"
@@ -178,6 +179,11 @@ func (h *Handler) CreateSchema(c *gin.Context) {
 		return
 	}
 
+	if err := validateSchemaRules(schema); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Set ownership
 	schema.CreatedBy = c.GetUint("user_id")
 	schema.UpdatedBy = c.GetUint("user_id")
@@ -206,6 +212,93 @@ func (h *Handler) CreateSchema(c *gin.Context) {
 	c.JSON(http.StatusCreated, schema)
 }
 
+// validateSchemaRules checks the file settings and the per-rule write settings before they are saved
+func validateSchemaRules(schema core.Schema) error {
+	switch schema.FileMode {
+	case "", "single", "multi":
+	default:
+		return fmt.Errorf("invalid file_mode %q (single or multi)", schema.FileMode)
+	}
+	switch schema.FileOrder {
+	case "", "name", "mtime":
+	default:
+		return fmt.Errorf("invalid file_order %q (name or mtime)", schema.FileOrder)
+	}
+	if f := strings.ToLower(schema.FileFormat); f == "fixed_width" || f == "fixed" {
+		if _, err := filesync.ParseFixedWidthLayout(schema.FileFixedWidthLayout); err != nil {
+			return err
+		}
+	}
+	switch schema.FileCompression {
+	case "", filesync.CompressionAuto, filesync.CompressionNone, filesync.CompressionGzip,
+		filesync.CompressionZip, filesync.CompressionTar, filesync.CompressionTarGz:
+	default:
+		return fmt.Errorf("invalid file_compression %q (auto, none, gzip, zip, tar or tar.gz)", schema.FileCompression)
+	}
+	switch schema.FilePostAction {
+	case "", filesync.PostActionNone, filesync.PostActionRename, filesync.PostActionDelete:
+	case filesync.PostActionArchive:
+		if schema.FileArchivePath == "" {
+			return fmt.Errorf("file_post_action archive requires a file_archive_path")
+		}
+	default:
+		return fmt.Errorf("invalid file_post_action %q (none, archive, rename or delete)", schema.FilePostAction)
+	}
+	for _, rule := range schema.Rules {
+		switch rule.SchemaChangePolicy {
+		case "", database.SchemaPolicyFail, database.SchemaPolicyIgnore, database.SchemaPolicyText:
+		default:
+			return fmt.Errorf("rule %s: invalid schema_change_policy %q (fail, ignore or text)", rule.TargetTable, rule.SchemaChangePolicy)
+		}
+		switch rule.LoadMode {
+		case "", database.LoadModeDirect, database.LoadModeStagingSwap:
+		default:
+			return fmt.Errorf("rule %s: invalid load_mode %q (direct or staging_swap)", rule.TargetTable, rule.LoadMode)
+		}
+		switch rule.WriteMode {
+		case "", database.WriteModeInsert, database.WriteModeUpsert:
+		case database.WriteModeSCD2:
+			if schema.UniqueKeyColumn == "" && rule.UniqueKey == "" {
+				return fmt.Errorf("rule %s: write_mode scd2 requires a unique key column", rule.TargetTable)
+			}
+			if rule.LoadMode == database.LoadModeStagingSwap {
+				return fmt.Errorf("rule %s: write_mode scd2 keeps history in the live table and can't use load_mode staging_swap", rule.TargetTable)
+			}
+		default:
+			return fmt.Errorf("rule %s: invalid write_mode %q (insert, upsert or scd2)", rule.TargetTable, rule.WriteMode)
+		}
+		dedupe := database.Dedupe{Strategy: rule.DedupeStrategy, OrderColumn: rule.DedupeOrderColumn}
+		if err := dedupe.Validate(); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.TargetTable, err)
+		}
+		if _, err := mapping.Parse(rule.Mapping); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.TargetTable, err)
+		}
+		if rule.PartitionColumn == "" && (rule.PartitionCount > 0 || rule.PartitionBounds != "") {
+			return fmt.Errorf("rule %s: partition_count and partition_bounds require a partition_column", rule.TargetTable)
+		}
+		if rule.PartitionCount < 0 || rule.PartitionRetries < 0 {
+			return fmt.Errorf("rule %s: partition_count and partition_retries can't be negative", rule.TargetTable)
+		}
+		// 0 means the default parallelism
+		if rule.PartitionColumn != "" && (rule.PartitionParallelism < 0 || rule.PartitionParallelism > 16) {
+			return fmt.Errorf("rule %s: partition_parallelism must be between 1 and 16", rule.TargetTable)
+		}
+		if rule.PartitionBounds != "" {
+			if _, err := database.ParsePartitionBounds(rule.PartitionBounds); err != nil {
+				return fmt.Errorf("rule %s: invalid partition_bounds: %w", rule.TargetTable, err)
+			}
+		}
+	}
+	return nil
+}
+
+// truncateBeforeLoad reports whether the live table is truncated before a run.
+// Staging loads replace the table at the end of the run instead
+func truncateBeforeLoad(rule core.SchemaRule) bool {
+	return rule.Truncate && rule.LoadMode != database.LoadModeStagingSwap
+}
+
 // UpdateSchema updates an existing schema
 // @Summary Update a schema
 // @Description Update an existing data sync schema
@@ -240,6 +333,10 @@ func (h *Handler) UpdateSchema(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validateSchemaRules(schema); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	schema.CreatedBy = originalCreatedBy // Restore
 	schema.UpdatedBy = c.GetUint("user_id")
 
@@ -365,6 +462,11 @@ func (h *Handler) CreateNetwork(c *gin.Context) {
 		return
 	}
 
+	if err := validateNetworkSource(network); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Set ownership
 	network.CreatedBy = c.GetUint("user_id")
 	network.UpdatedBy = c.GetUint("user_id")
@@ -439,6 +541,10 @@ func (h *Handler) UpdateNetwork(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validateNetworkSource(network); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	network.CreatedBy = originalCreatedBy
 	network.UpdatedBy = c.GetUint("user_id")
 
@@ -646,6 +752,11 @@ func (h *Handler) CreateJob(c *gin.Context) {
 		return
 	}
 
+	if err := validateDestinationPolicy(job.DestinationFailurePolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Set ownership
 	job.CreatedBy = c.GetUint("user_id")
 	job.UpdatedBy = c.GetUint("user_id")
@@ -694,6 +805,10 @@ func (h *Handler) UpdateJob(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validateDestinationPolicy(job.DestinationFailurePolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	job.CreatedBy = originalCreatedBy
 	job.UpdatedBy = c.GetUint("user_id")
 
@@ -740,6 +855,7 @@ func (h *Handler) DeleteJob(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	h.db.Where("job_id = ?", id).Delete(&core.JobDestination{})
 
 	// Log audit
 	go func() {
@@ -778,9 +894,24 @@ func (h *Handler) AbortJob(c *gin.Context) {
 	job.Status = "failed"
 	h.db.Save(&job)
 
-	// Signal worker pool to skip remaining insert batches for this job
+	// Signal worker pool to skip remaining insert batches for this job, and the agent to stop
+	// extracting (direct write agents would otherwise keep writing the target)
 	if h.agentListener != nil {
 		h.agentListener.MarkJobAborted(job.ID)
+		var network core.Network
+		h.db.Select("id", "name", "agent_name").First(&network, job.NetworkID)
+		agentName := network.Name
+		if network.AgentName != "" {
+			agentName = network.AgentName
+		}
+		abort := core.AgentMessage{
+			Type:      "ABORT_JOB",
+			Timestamp: time.Now(),
+			Data:      map[string]interface{}{"job_id": job.ID},
+		}
+		if err := h.agentListener.SendCommandToAgent(agentName, abort); err != nil {
+			log.Printf("[ABORT] Could not notify agent %s for job %d: %v", agentName, job.ID, err)
+		}
 	}
 
 	// Update the latest job log
@@ -951,6 +1082,7 @@ func (h *Handler) RunJob(c *gin.Context) {
 
 	// Extract schema values safely (Schema is optional for minio_mirror)
 	var targetTable, sqlCommand, fileFormat, filePattern, uniqueKeyColumn, delimiter string
+	var fileCompression, fileInnerPattern, fileNameColumn, fileRecordPath, fileFixedWidthLayout string
 	var hasHeader bool
 	if job.Schema != nil {
 		targetTable = job.Schema.TargetTable
@@ -960,6 +1092,11 @@ func (h *Handler) RunJob(c *gin.Context) {
 		uniqueKeyColumn = job.Schema.UniqueKeyColumn
 		hasHeader = job.Schema.HasHeader
 		delimiter = job.Schema.Delimiter
+		fileCompression = job.Schema.FileCompression
+		fileInnerPattern = job.Schema.FileInnerPattern
+		fileNameColumn = job.Schema.FileNameColumn
+		fileRecordPath = job.Schema.FileRecordPath
+		fileFixedWidthLayout = job.Schema.FileFixedWidthLayout
 
 		// Handle incremental sync ca_pointer replacement
 		if job.Incremental && job.CheckpointColumn != "" && sqlCommand != "" {
@@ -1000,15 +1137,7 @@ func (h *Handler) RunJob(c *gin.Context) {
 				"sslmode":  job.Network.DBSSLMode,
 			},
 			// FTP/SFTP config (for source_type=ftp or sftp)
-			"ftp_config": map[string]interface{}{
-				"host":        job.Network.FTPHost,
-				"port":        job.Network.FTPPort,
-				"user":        job.Network.FTPUser,
-				"password":    job.Network.FTPPassword,
-				"private_key": job.Network.FTPPrivateKey,
-				"path":        job.Network.FTPPath,
-				"passive":     job.Network.FTPPassive,
-			},
+			"ftp_config": ftpConfigCommand(job.Network),
 			// File parsing config from Schema
 			"file_config": map[string]interface{}{
 				"format":            fileFormat,
@@ -1016,17 +1145,15 @@ func (h *Handler) RunJob(c *gin.Context) {
 				"has_header":        hasHeader,
 				"delimiter":         delimiter,
 				"unique_key_column": uniqueKeyColumn,
+				"compression":       fileCompression,
+				"inner_pattern":     fileInnerPattern,
+				"file_name_column":  fileNameColumn,
+				// Format settings (xml, fixed_width)
+				"record_path":        fileRecordPath,
+				"fixed_width_layout": fileFixedWidthLayout,
 			},
 			// API config (for source_type=api)
-			"api_config": map[string]interface{}{
-				"url":        job.Network.APIURL,
-				"method":     job.Network.APIMethod,
-				"headers":    job.Network.APIHeaders,
-				"auth_type":  job.Network.APIAuthType,
-				"auth_key":   job.Network.APIAuthKey,
-				"auth_value": job.Network.APIAuthValue,
-				"body":       job.Network.APIBody,
-			},
+			"api_config": apiConfigCommand(job),
 			// MongoDB config (for source_type=mongodb)
 			"mongo_config": map[string]interface{}{
 				"host":       job.Network.MongoHost,
@@ -1046,15 +1173,7 @@ func (h *Handler) RunJob(c *gin.Context) {
 				"pattern":  job.Network.RedisPattern,
 			},
 			// MinIO/S3 config (for source_type=minio)
-			"minio_config": map[string]interface{}{
-				"endpoint":    job.Network.MinIOEndpoint,
-				"access_key":  job.Network.MinIOAccessKey,
-				"secret_key":  job.Network.MinIOSecretKey,
-				"bucket":      job.Network.MinIOBucket,
-				"object_path": job.Network.MinIOObjectPath,
-				"use_ssl":     job.Network.MinIOUseSSL,
-				"region":      job.Network.MinIORegion,
-			},
+			"minio_config": minioConfigCommand(job.Network),
 			// Schema details
 			"schema": map[string]interface{}{
 				"id":          targetTable, // legacy DTBN
@@ -1062,6 +1181,8 @@ func (h *Handler) RunJob(c *gin.Context) {
 				"source_type": job.Schema.SourceType,
 				"rules":       job.Schema.Rules,
 			},
+			// Flow control: batches the agent may send before the master grants credits back
+			"credit_window": batchCreditWindow,
 			// ===== TARGET CONFIGURATIONS =====
 			"target_source_type": job.Network.TargetSourceType,
 			// Target Database config
@@ -1088,6 +1209,11 @@ func (h *Handler) RunJob(c *gin.Context) {
 		},
 	}
 
+	// Multi-file sources load every matching file not in the job's manifest yet
+	if fileConfig, ok := command.Data["file_config"].(map[string]interface{}); ok {
+		h.agentListener.multiFileConfig(job.ID, job.Schema, fileConfig)
+	}
+
 	// Override source type if schema is JS
 	if job.Schema != nil && job.Schema.SourceType == "javascript" {
 		command.Data["source_type"] = "javascript"
@@ -1096,9 +1222,13 @@ func (h *Handler) RunJob(c *gin.Context) {
 	if job.Schema != nil && len(job.Schema.Rules) > 0 && job.Schema.SourceType != "javascript" {
 		// Multi-rule schema: process each rule and send an individual command
 		for _, rule := range job.Schema.Rules {
-			log.Printf("RunJob: Executing Pre-Job Queries for rule %s", rule.TargetTable)
-			if err := h.agentListener.ExecutePreJobQueries(job.NetworkID, rule.TargetTable, rule.Truncate, rule.UploadPreQuery); err != nil {
-				log.Printf("RunJob: Failed to execute Pre-Job Queries for rule %s: %v", rule.TargetTable, err)
+			// Direct write agents run the pre-job queries themselves
+			direct := h.agentListener.directWriteConfig(job, &rule, rule.TargetTable, jobLog.ID)
+			if direct == nil {
+				log.Printf("RunJob: Executing Pre-Job Queries for rule %s", rule.TargetTable)
+				if err := h.agentListener.ExecutePreJobQueries(job.NetworkID, rule.TargetTable, truncateBeforeLoad(rule), rule.UploadPreQuery); err != nil {
+					log.Printf("RunJob: Failed to execute Pre-Job Queries for rule %s: %v", rule.TargetTable, err)
+				}
 			}
 
 			// Clone command data for this specific rule
@@ -1133,6 +1263,10 @@ func (h *Handler) RunJob(c *gin.Context) {
 				"extract_post": rule.ExtractPostQuery,
 				"upload_pre":   rule.UploadPreQuery,
 				"upload_post":  rule.UploadPostQuery,
+				"partition":    partitionCommand(*job.Schema, rule),
+			}
+			if direct != nil {
+				ruleCmdData["direct_write"] = direct
 			}
 
 			ruleCmd := core.AgentMessage{
@@ -1150,6 +1284,11 @@ func (h *Handler) RunJob(c *gin.Context) {
 		}
 	} else {
 		// Single legacy rule / non-schema job
+		if job.Schema != nil {
+			if direct := h.agentListener.directWriteConfig(job, nil, targetTable, jobLog.ID); direct != nil {
+				command.Data["direct_write"] = direct
+			}
+		}
 		if err := h.agentListener.SendCommandToAgent(agentName, command); err != nil {
 			job.Status = "failed"
 			h.db.Save(&job)
@@ -1429,10 +1568,17 @@ func (h *Handler) GetSystemStatus(c *gin.Context) {
 		"tables": dbTables,
 	}
 
+	// --- Write queue and agent flow control ---
+	var writeQueue map[string]interface{}
+	if agentOk {
+		writeQueue = h.agentListener.FlowStatus()
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"server":         serverStatus,
 		"agent_listener": agentStatus,
 		"database":       dbStatus,
+		"write_queue":    writeQueue,
 	})
 }
 
@@ -1979,7 +2125,50 @@ func (h *Handler) TestNetworkTargetConnection(c *gin.Context) {
 
 		c.JSON(http.StatusOK, result)
 
+	case "mongodb":
+		// Test MongoDB connection directly from master
+		mongoConfig := mongoConfigFromNetwork(network)
+		startTime := time.Now()
+		err := database.TestMongoConnection(mongoConfig)
+		duration := time.Since(startTime).Milliseconds()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success":  false,
+				"error":    err.Error(),
+				"duration": duration,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"message":  "MongoDB target connection successful",
+			"duration": duration,
+			"host":     mongoConfig.Host,
+			"database": mongoConfig.Database,
+		})
+
 	case "api":
+		// OAuth2 targets request a token, proving the client credentials
+		if strings.EqualFold(network.TargetAPIAuthType, filesync.AuthOAuth2) {
+			startTime := time.Now()
+			lifetime, err := filesync.NewAPIClient().RequestOAuth2Token(targetAPIOAuth2(network))
+			duration := time.Since(startTime).Milliseconds()
+			if err != nil {
+				c.JSON(http.StatusOK, gin.H{
+					"success":  false,
+					"error":    err.Error(),
+					"duration": duration,
+				})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"success":  true,
+				"message":  fmt.Sprintf("API target configured, OAuth2 token valid for %v", lifetime),
+				"duration": duration,
+				"url":      network.TargetAPIURL,
+			})
+			return
+		}
 		// For API target, we could do a quick test from master
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -2072,6 +2261,11 @@ func (h *Handler) ReverseNetwork(c *gin.Context) {
 	network.APIAuthKey, network.TargetAPIAuthKey = network.TargetAPIAuthKey, network.APIAuthKey
 	network.APIAuthValue, network.TargetAPIAuthValue = network.TargetAPIAuthValue, network.APIAuthValue
 	network.APIBody, network.TargetAPIBody = network.TargetAPIBody, network.APIBody
+	network.APIOAuthTokenURL, network.TargetAPIOAuthTokenURL = network.TargetAPIOAuthTokenURL, network.APIOAuthTokenURL
+	network.APIOAuthClientID, network.TargetAPIOAuthClientID = network.TargetAPIOAuthClientID, network.APIOAuthClientID
+	network.APIOAuthClientSecret, network.TargetAPIOAuthClientSecret = network.TargetAPIOAuthClientSecret, network.APIOAuthClientSecret
+	network.APIOAuthScope, network.TargetAPIOAuthScope = network.TargetAPIOAuthScope, network.APIOAuthScope
+	network.APIOAuthAudience, network.TargetAPIOAuthAudience = network.TargetAPIOAuthAudience, network.APIOAuthAudience
 
 	// Swap MinIO config
 	network.MinIOEndpoint, network.TargetMinIOEndpoint = network.TargetMinIOEndpoint, network.MinIOEndpoint
@@ -2082,6 +2276,14 @@ func (h *Handler) ReverseNetwork(c *gin.Context) {
 	network.MinIOUseSSL, network.TargetMinIOUseSSL = network.TargetMinIOUseSSL, network.MinIOUseSSL
 	network.MinIORegion, network.TargetMinIORegion = network.TargetMinIORegion, network.MinIORegion
 
+	// Swap MongoDB config (the source collection stays on the source side)
+	network.MongoHost, network.TargetMongoHost = network.TargetMongoHost, network.MongoHost
+	network.MongoPort, network.TargetMongoPort = network.TargetMongoPort, network.MongoPort
+	network.MongoUser, network.TargetMongoUser = network.TargetMongoUser, network.MongoUser
+	network.MongoPassword, network.TargetMongoPassword = network.TargetMongoPassword, network.MongoPassword
+	network.MongoDatabase, network.TargetMongoDatabase = network.TargetMongoDatabase, network.MongoDatabase
+	network.MongoAuthDB, network.TargetMongoAuthDB = network.TargetMongoAuthDB, network.MongoAuthDB
+
 	// Save the reversed network
 	if err := h.db.Save(&network).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save reversed network"})
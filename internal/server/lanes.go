@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxQueuedBatches bounds the batches of agents without flow control (older agents) waiting
+// in the write lanes. Dispatching them blocks above it, which holds back the agent connection.
+// Current agents send every data batch against credits, bounded by the credit window, and
+// those never wait
+const maxQueuedBatches = 256
+
+// writeLane is the ordered queue of one job run and table: its batches are written one at
+// a time in the order the agent sent them
+type writeLane struct {
+	key   string
+	queue []insertWork
+}
+
+// writeLanes schedules lanes on the insert worker pool: one batch per lane at a time,
+// different lanes (other jobs, tables or destinations) in parallel
+type writeLanes struct {
+	mu       sync.Mutex
+	space    *sync.Cond            // Signalled when a queued batch is taken
+	work     *sync.Cond            // Signalled when a lane becomes ready
+	lanes    map[string]*writeLane // Lanes with a batch queued or being written
+	ready    []*writeLane          // Lanes with a queued batch and none being written
+	queued   int                   // Batches queued in all lanes, not yet taken by a worker
+	writing  int                   // Batches being written
+	stalls   int64                 // Dispatches that waited for queue space
+	stalled  time.Duration         // Total time dispatches waited for queue space
+	maxDepth int                   // Highest queue depth seen
+}
+
+// laneStats is a snapshot of the write queue for the system status
+type laneStats struct {
+	Queued      int   `json:"queued_batches"`
+	MaxQueued   int   `json:"max_queued_batches"`
+	PeakQueued  int   `json:"peak_queued_batches"`
+	Writing     int   `json:"writing_batches"`
+	ActiveLanes int   `json:"active_lanes"`
+	Stalls      int64 `json:"dispatch_stalls"`
+	StalledMs   int64 `json:"dispatch_stall_ms"`
+}
+
+// newWriteLanes creates an empty lane scheduler
+func newWriteLanes() *writeLanes {
+	l := &writeLanes{lanes: make(map[string]*writeLane)}
+	l.space = sync.NewCond(&l.mu)
+	l.work = sync.NewCond(&l.mu)
+	return l
+}
+
+// laneKey returns the lane of a batch: its run, or its job and table for untracked batches
+func laneKey(work insertWork) string {
+	if work.run != nil {
+		return work.run.key
+	}
+	return fmt.Sprintf("job:%d:%v:%s", work.jobID, work.logID, work.tableName)
+}
+
+// enqueue appends a batch to its lane. Batches without a credit wait while the lanes are full
+func (l *writeLanes) enqueue(work insertWork) {
+	key := laneKey(work)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if work.credit == nil && l.queued >= maxQueuedBatches {
+		start := time.Now()
+		for l.queued >= maxQueuedBatches {
+			l.space.Wait()
+		}
+		l.stalls++
+		l.stalled += time.Since(start)
+	}
+
+	l.queued++
+	if l.queued > l.maxDepth {
+		l.maxDepth = l.queued
+	}
+	lane, busy := l.lanes[key]
+	if !busy {
+		lane = &writeLane{key: key}
+		l.lanes[key] = lane
+	}
+	lane.queue = append(lane.queue, work)
+
+	// A busy lane is already scheduled, its worker picks the batch up after the current one
+	if !busy {
+		l.ready = append(l.ready, lane)
+		l.work.Signal()
+	}
+}
+
+// next waits for a ready lane and takes its next batch
+func (l *writeLanes) next() (*writeLane, insertWork) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for len(l.ready) == 0 {
+		l.work.Wait()
+	}
+	lane := l.ready[0]
+	l.ready = l.ready[1:]
+	work := lane.queue[0]
+	lane.queue = lane.queue[1:]
+	l.queued--
+	l.writing++
+	l.space.Signal()
+	return lane, work
+}
+
+// done reschedules a lane after one of its batches was written, or retires it when empty
+func (l *writeLanes) done(lane *writeLane) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writing--
+	if len(lane.queue) == 0 {
+		delete(l.lanes, lane.key)
+		return
+	}
+	// Back of the queue, so a long run doesn't starve the other lanes
+	l.ready = append(l.ready, lane)
+	l.work.Signal()
+}
+
+// stats returns a snapshot of the queue
+func (l *writeLanes) stats() laneStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return laneStats{
+		Queued:      l.queued,
+		MaxQueued:   maxQueuedBatches,
+		PeakQueued:  l.maxDepth,
+		Writing:     l.writing,
+		ActiveLanes: len(l.lanes),
+		Stalls:      l.stalls,
+		StalledMs:   l.stalled.Milliseconds(),
+	}
+}
+
+// dispatchInsertWork queues a batch on its write lane
+func (al *AgentListener) dispatchInsertWork(work insertWork) {
+	al.lanes.enqueue(work)
+	log.Printf("⚡ Queued batch (%d records) on write lane for job %d table %s", work.recordCount, work.jobID, work.tableName)
+}
+
+// insertWorker is a goroutine that writes the next batch of scheduled lanes
+func (al *AgentListener) insertWorker(workerID int) {
+	log.Printf("Insert worker %d started", workerID)
+	for {
+		lane, work := al.lanes.next()
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("⚠️ Worker %d recovered from panic (job %d): %v", workerID, work.jobID, r)
+					// Mark job as failed so it doesn't stay stuck as "running"
+					al.updateJobLog(work.logID, work.isPartial, "failed", work.recordCount, 0, work.sampleData, fmt.Sprintf("Internal error: %v", r))
+					al.updateJobStatus(work.jobID, false, "failed")
+				}
+			}()
+			al.executeInsertWork(work)
+		}()
+		al.lanes.done(lane)
+		al.releaseCredit(work.credit)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"dsp-platform/internal/core"
 	"dsp-platform/internal/crypto"
 	"dsp-platform/internal/database"
+	"dsp-platform/internal/mapping"
 	"dsp-platform/internal/security"
 	"encoding/base64"
 	"encoding/json"
@@ -18,6 +19,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // AgentConnection represents an active agent connection
@@ -39,13 +42,19 @@ type cachedTargetConn struct {
 	lastUsed time.Time
 }
 
+// runColumnTypes holds the source column types a run announced with its first batch
+type runColumnTypes struct {
+	specs    []database.ColumnSpec
+	lastUsed time.Time
+}
+
 // insertWork represents a unit of insert work for the worker pool
 type insertWork struct {
 	tableName        string
 	records          []interface{}
 	csvData          string
 	csvColumns       []string
-	uniqueKeyColumn  string
+	keyColumns       []string // Ordered unique key (composite keys have several columns)
 	checkpointColumn string
 	networkID        uint
 	jobID            uint
@@ -58,6 +67,23 @@ type insertWork struct {
 	agentName        string
 	clientAddr       string
 	uploadPostQuery  string
+	schemaPolicy     string
+	columnTypes      []database.ColumnSpec // Source column types (CSV batches), nil when unknown
+	mapping          *mapping.Mapping      // Rule column mapping, applied before writing (nil = none)
+	mappingErr       error                 // Invalid rule column mapping, fails the batch
+	run              *runState             // Run/table the batch belongs to (nil for legacy pushes)
+	writeMode        string                // upsert or scd2
+	dedupe           database.Dedupe       // Winner rule for duplicate keys within a batch
+	skipSequences    bool                  // Rule opted out of the end-of-run sequence resync
+	targetType       string                // Network target type (database, ftp, sftp, ...)
+	credit           *batchCredit          // Flow control credit returned to the agent once written (nil without)
+}
+
+// ensuredTable remembers which columns were already reconciled against a target table during a run
+type ensuredTable struct {
+	specs    map[string]database.ColumnSpec
+	ignored  map[string]bool
+	lastUsed time.Time
 }
 
 // workerPoolSize is the number of concurrent insert workers
@@ -77,13 +103,20 @@ type AgentListener struct {
 	// Performance: Target DB connection cache (keyed by networkID)
 	targetDBCache map[uint]*cachedTargetConn
 	targetDBMu    sync.RWMutex
+	mongoTargets  mongoTargets // MongoDB target connections (target_source_type=mongodb)
 
-	// Performance: EnsureTable cache (skip redundant information_schema queries)
-	ensuredTables map[string]bool
+	// Performance: schema sync cache per run and table (skip redundant catalog queries)
+	ensuredTables map[string]*ensuredTable
+	columnTypes   map[string]*runColumnTypes // Keyed like ensuredTables, filled from csv_column_types
 	ensuredMu     sync.RWMutex
 
-	// Performance: Worker pool for parallel batch inserts
-	insertWorkChan chan insertWork
+	// Performance: Worker pool writing the ordered lanes of job runs in parallel
+	lanes *writeLanes
+	flows agentFlows // Batch credits outstanding per agent
+
+	// Run tracking: in-flight batches and end-of-run work per job run and table
+	runs   map[string]*runState
+	runsMu sync.Mutex
 
 	// Abort tracking: skip insert work for aborted jobs
 	abortedJobs map[uint]bool
@@ -106,9 +139,12 @@ func NewAgentListener(handler *Handler, port string) *AgentListener {
 		pendingRequests: make(map[uint]*PendingRequest),
 		encryptor:       enc,
 		targetDBCache:   make(map[uint]*cachedTargetConn),
-		ensuredTables:   make(map[string]bool),
-		insertWorkChan:  make(chan insertWork, 256),
+		ensuredTables:   make(map[string]*ensuredTable),
+		columnTypes:     make(map[string]*runColumnTypes),
+		lanes:           newWriteLanes(),
+		flows:           agentFlows{agents: make(map[string]*agentFlow)},
 		abortedJobs:     make(map[uint]bool),
+		runs:            make(map[string]*runState),
 	}
 	// Set reference in handler for bidirectional communication
 	handler.agentListener = al
@@ -141,9 +177,12 @@ func NewAgentListenerWithTLS(handler *Handler, port string, tlsConfig security.T
 		pendingRequests: make(map[uint]*PendingRequest),
 		encryptor:       enc,
 		targetDBCache:   make(map[uint]*cachedTargetConn),
-		ensuredTables:   make(map[string]bool),
-		insertWorkChan:  make(chan insertWork, 256),
+		ensuredTables:   make(map[string]*ensuredTable),
+		columnTypes:     make(map[string]*runColumnTypes),
+		lanes:           newWriteLanes(),
+		flows:           agentFlows{agents: make(map[string]*agentFlow)},
 		abortedJobs:     make(map[uint]bool),
+		runs:            make(map[string]*runState),
 	}
 
 	// Load TLS config if enabled
@@ -195,6 +234,9 @@ func (al *AgentListener) Start() error {
 	}
 	defer listener.Close()
 
+	// Runs don't survive a restart, the files they left loading are loaded again
+	al.failStaleProcessedFiles(0)
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -374,6 +416,12 @@ func (al *AgentListener) processMessage(msg core.AgentMessage, clientAddr string
 		al.handleDataSync(msg, clientAddr)
 	case "DATA_RESPONSE":
 		al.handleDataResponse(msg, clientAddr)
+	case "SEGMENT_STATUS":
+		al.handleSegmentStatus(msg)
+	case "FILE_LOADED":
+		al.handleFileLoaded(msg)
+	case "FILE_POST_ACTIONS_RESULT":
+		al.handleFilePostActionsResult(msg)
 	case "CONFIG_PULL":
 		al.handleConfigPull(msg, conn)
 	case "EXEC_COMMAND_RESULT":
@@ -492,7 +540,7 @@ func (al *AgentListener) handleDataSync(msg core.AgentMessage, clientAddr string
 }
 
 // handleDataResponse processes data responses from run job commands
-// Dispatches insert work to the worker pool for parallel processing
+// Queues insert work on the run's write lane, lanes are written in parallel by the worker pool
 func (al *AgentListener) handleDataResponse(msg core.AgentMessage, clientAddr string) {
 	log.Printf("DATA_RESPONSE received from %s", msg.AgentName)
 
@@ -548,24 +596,48 @@ func (al *AgentListener) handleDataResponse(msg core.AgentMessage, clientAddr st
 		targetTable = tt
 	}
 
-	uniqueKeyColumn := ""
+	var keyColumns []string
 	checkpointColumn := ""
 	var networkID uint
 	uploadPostQuery := ""
+	schemaPolicy := ""
+	mappingDef := ""
+	loadMode := ""
+	writeMode := ""
+	maxRejected := 0
+	skipSequences := false
+	targetType := ""
+	destinationPolicy := ""
+	var destinations []core.JobDestination
+	var dedupe database.Dedupe
 	if jobID > 0 {
 		var job core.Job
 		if err := al.handler.db.Preload("Schema.Rules").Preload("Network").First(&job, jobID).Error; err == nil {
 			if targetTable == "" {
 				targetTable = job.Schema.TargetTable
 			}
-			uniqueKeyColumn = job.Schema.UniqueKeyColumn
+			keyColumns = database.ParseKeyColumns(job.Schema.UniqueKeyColumn)
 			checkpointColumn = job.CheckpointColumn
 			networkID = job.NetworkID
+			maxRejected = job.MaxRejectedRows
+			targetType = job.Network.TargetSourceType
+			destinationPolicy = job.DestinationFailurePolicy
+			al.handler.db.Preload("Network").Where("job_id = ? AND enabled = ?", jobID, true).Order("id asc").Find(&destinations)
 
 			// Find rule-specific PostQuery if it's a multi-rule schema
 			for _, rule := range job.Schema.Rules {
 				if rule.TargetTable == targetTable {
 					uploadPostQuery = rule.UploadPostQuery
+					schemaPolicy = rule.SchemaChangePolicy
+					mappingDef = rule.Mapping
+					loadMode = rule.LoadMode
+					writeMode = rule.WriteMode
+					dedupe = database.Dedupe{Strategy: rule.DedupeStrategy, OrderColumn: rule.DedupeOrderColumn}
+					skipSequences = rule.SkipSequenceReset
+					if rule.UniqueKey != "" {
+						keyColumns = database.ParseKeyColumns(rule.UniqueKey)
+					}
+					writeMode, keyColumns = database.ResolveWriteMode(writeMode, keyColumns)
 					break
 				}
 			}
@@ -593,14 +665,89 @@ func (al *AgentListener) handleDataResponse(msg core.AgentMessage, clientAddr st
 		}
 	}
 
-	// Dispatch insert work to worker pool (async, non-blocking)
-	if ((hasRecords && len(records) > 0) || (hasCsv && csvData != "")) && targetTable != "" {
+	// Source column types arrive with the first batch only; remember them for the rest of
+	// the run here (messages are handled in order) since workers may pick batches out of order
+	var columnTypes []database.ColumnSpec
+	if hasCsv && targetTable != "" {
+		columnTypes = al.runColumnTypesFor(networkID, logID, targetTable, msg.Data["csv_column_types"])
+	}
+
+	// Agent direct write: the agent wrote the batch to the target itself and only reports its outcome
+	direct, _ := msg.Data["direct"].(bool)
+
+	// Track the run so its end (swap, sequence reset, post query) waits for every batch
+	var run *runState
+	if jobID > 0 && targetTable != "" {
+		if !isDatabaseTarget(targetType) || direct {
+			loadMode = "" // Staging tables only exist in target databases written by the master
+		}
+		run = al.runStateFor(networkID, jobID, logID, targetTable, loadMode, func(r *runState) {
+			r.targetType = targetType
+			r.direct = direct
+			r.keyColumns = keyColumns
+			r.maxRejected = maxRejected
+			r.uploadPostQuery = uploadPostQuery
+			r.skipSequences = skipSequences
+			r.destinationPolicy = destinationPolicy
+			r.mapping, r.mappingErr = mapping.Parse(mappingDef)
+		})
+	}
+
+	// The mapping is parsed once per run, batches outside a run parse their own
+	var columnMapping *mapping.Mapping
+	var mappingErr error
+	if run != nil {
+		columnMapping, mappingErr = run.mapping, run.mappingErr
+	} else {
+		columnMapping, mappingErr = mapping.Parse(mappingDef)
+	}
+
+	// Fan-out: every batch is also written to the job's extra destinations
+	var destinationRuns []*runState
+	if run != nil && len(destinations) > 0 && !direct {
+		destinationRuns = al.destinationRuns(run, destinations, writeMode, loadMode)
+	}
+
+	// Flow control: a batch sent against a credit returns it once written
+	credited, _ := msg.Data["credit"].(bool)
+	creditWait := time.Duration(0)
+	if ms, ok := msg.Data["credit_wait_ms"].(float64); ok {
+		creditWait = time.Duration(ms) * time.Millisecond
+	}
+	creditTable, _ := msg.Data["target_table"].(string)
+
+	// Queue insert work on the run's lane (written in order, after the run's earlier batches)
+	dispatched, dropped := false, false
+	if direct {
+		dispatched = recordCount > 0
+		al.recordDirectBatch(msg.Data, insertWork{
+			tableName:   targetTable,
+			networkID:   networkID,
+			jobID:       jobID,
+			logID:       logID,
+			isPartial:   isPartial,
+			status:      status,
+			recordCount: recordCount,
+			sampleData:  sampleData,
+			agentName:   msg.AgentName,
+			run:         run,
+			targetType:  targetType,
+		})
+	} else if run != nil && ((hasRecords && len(records) > 0) || (hasCsv && csvData != "")) && !run.beginBatch() {
+		// The run was aborted or expired meanwhile and no longer waits for batches
+		dropped = true
+		log.Printf("⚠️ Batch of job %d for %s arrived after its run was finalized, dropped", jobID, targetTable)
+		if credited {
+			al.releaseCredit(al.newBatchCredit(msg.AgentName, jobID, logID, creditTable, 1, creditWait))
+		}
+	} else if ((hasRecords && len(records) > 0) || (hasCsv && csvData != "")) && targetTable != "" {
+		dispatched = true
 		work := insertWork{
 			tableName:        targetTable,
 			records:          records,
 			csvData:          csvData,
 			csvColumns:       csvColumns,
-			uniqueKeyColumn:  uniqueKeyColumn,
+			keyColumns:       keyColumns,
 			checkpointColumn: checkpointColumn,
 			networkID:        networkID,
 			jobID:            jobID,
@@ -613,17 +760,42 @@ func (al *AgentListener) handleDataResponse(msg core.AgentMessage, clientAddr st
 			agentName:        msg.AgentName,
 			clientAddr:       clientAddr,
 			uploadPostQuery:  uploadPostQuery,
+			schemaPolicy:     schemaPolicy,
+			columnTypes:      columnTypes,
+			mapping:          columnMapping,
+			mappingErr:       mappingErr,
+			run:              run,
+			writeMode:        writeMode,
+			dedupe:           dedupe,
+			skipSequences:    skipSequences,
+			targetType:       targetType,
 		}
+		var accepted []*runState
+		for _, destRun := range destinationRuns {
+			if destRun.beginBatch() {
+				accepted = append(accepted, destRun)
+			}
+		}
+		if credited {
+			work.credit = al.newBatchCredit(msg.AgentName, jobID, logID, creditTable, 1+len(accepted), creditWait)
+		}
+		for _, destRun := range accepted {
+			al.dispatchInsertWork(al.destinationWork(work, destRun, msg.Data["csv_column_types"]))
+		}
+		al.dispatchInsertWork(work)
+	} else if credited {
+		// Nothing to write, the credit goes straight back
+		al.releaseCredit(al.newBatchCredit(msg.AgentName, jobID, logID, creditTable, 1, creditWait))
+	}
 
-		select {
-		case al.insertWorkChan <- work:
-			log.Printf("⚡ Dispatched batch (%d records) to worker pool for job %d", recordCount, jobID)
-		default:
-			// Channel full — fallback to synchronous insert to avoid data loss
-			log.Printf("⚠️ Worker pool full, executing insert synchronously for job %d", jobID)
-			al.executeInsertWork(work)
+	if run != nil && !isPartial {
+		// Final message: finish the run once the dispatched batches are written
+		finalCount, finalSample := 0, ""
+		if !dispatched {
+			finalCount, finalSample = recordCount, sampleData
 		}
-	} else {
+		go al.finalizeRun(run, status, errorMsg, finalCount, finalSample)
+	} else if !dispatched && !dropped {
 		// No records to insert — just update job log/status inline (cheap operation)
 		al.updateJobLog(logID, isPartial, status, recordCount, 0, sampleData, errorMsg)
 		al.updateJobStatus(jobID, isPartial, status)
@@ -632,34 +804,35 @@ func (al *AgentListener) handleDataResponse(msg core.AgentMessage, clientAddr st
 	al.handler.UpdateAgentStatus(msg.AgentName, "online", clientAddr, msg.Data)
 }
 
-// insertWorker is a goroutine that processes insert work from the channel
-func (al *AgentListener) insertWorker(workerID int) {
-	log.Printf("Insert worker %d started", workerID)
-	for work := range al.insertWorkChan {
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("⚠️ Worker %d recovered from panic (job %d): %v", workerID, work.jobID, r)
-					// Mark job as failed so it doesn't stay stuck as "running"
-					al.updateJobLog(work.logID, work.isPartial, "failed", work.recordCount, 0, work.sampleData, fmt.Sprintf("Internal error: %v", r))
-					al.updateJobStatus(work.jobID, false, "failed")
-				}
-			}()
-			al.executeInsertWork(work)
+// executeInsertWork performs the actual insert and updates job log/status
+func (al *AgentListener) executeInsertWork(work insertWork) {
+	if work.run != nil {
+		defer work.run.inflight.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				work.run.recordBatch(0, 0, 0, fmt.Errorf("internal error: %v", r))
+				panic(r)
+			}
 		}()
 	}
-}
 
-// executeInsertWork performs the actual insert and updates job log/status
-func (al *AgentListener) executeInsertWork(work insertWork) {
+	// Batches of a tracked run leave the final status to finalizeRun
+	isPartial := work.isPartial || work.run != nil
+
+	// Batches of fan-out destinations only count on their own run, the job log and status
+	// follow the job's own batches
+	isDestination := work.run != nil && work.run.destination != nil
+
 	// Check if job was aborted — skip insert work entirely
 	if al.isJobAborted(work.jobID) {
 		log.Printf("⏭️ Skipping insert for aborted job %d (%d records)", work.jobID, work.recordCount)
-		al.updateJobLog(work.logID, work.isPartial, "failed", work.recordCount, 0, work.sampleData, "Aborted by user")
+		if !isDestination {
+			al.updateJobLog(work.logID, isPartial, "failed", work.recordCount, 0, work.sampleData, "Aborted by user")
+		}
 		return
 	}
 
-	// Extract maximum checkpoint value if applicable
+	// Extract maximum checkpoint value if applicable (tracked runs commit it when they finalize)
 	var maxCheckpoint string
 	// (Keeping the JSON logic for checkpoint extraction if traditional records are used)
 	if work.checkpointColumn != "" && len(work.records) > 0 {
@@ -676,33 +849,72 @@ func (al *AgentListener) executeInsertWork(work insertWork) {
 	}
 
 	insertedCount := 0
-	if work.csvData != "" && len(work.csvColumns) > 0 {
-		insertedCount = al.upsertCsvToTargetDBWithNetwork(work.tableName, work.csvData, work.csvColumns, work.uniqueKeyColumn, work.networkID)
-		if work.uniqueKeyColumn != "" {
-			log.Printf("Upserted %d CSV records into target table '%s' (key: %s)", insertedCount, work.tableName, work.uniqueKeyColumn)
+	var rejected []database.RejectedRow
+	writeErr := al.applyMapping(&work)
+	if writeErr != nil {
+		log.Printf("⚠️ Column mapping failed for job %d: %v", work.jobID, writeErr)
+	} else if isFileTarget(work.targetType) {
+		insertedCount, writeErr = al.writeFileBatch(work)
+		log.Printf("Spooled %d records of '%s' for %s export", insertedCount, work.tableName, work.targetType)
+	} else if work.targetType == "mongodb" {
+		insertedCount, rejected, writeErr = al.writeMongoBatch(work)
+		log.Printf("Upserted %d documents into MongoDB collection '%s' (%d rejected)", insertedCount, work.tableName, len(rejected))
+	} else if work.targetType == "minio" {
+		insertedCount, rejected, writeErr = al.writeMinioBatch(work)
+		log.Printf("Streamed %d records of '%s' to MinIO target (%d rejected)", insertedCount, work.tableName, len(rejected))
+	} else if work.targetType == "api" {
+		insertedCount, rejected, writeErr = al.writeAPIBatch(work)
+		log.Printf("Sent %d records of '%s' to API target (%d rejected)", insertedCount, work.tableName, len(rejected))
+	} else if work.csvData != "" && len(work.csvColumns) > 0 {
+		insertedCount, rejected, writeErr = al.upsertCsvToTargetDBWithNetwork(work)
+		if len(work.keyColumns) > 0 {
+			log.Printf("Upserted %d CSV records into target table '%s' (key: %s)", insertedCount, work.tableName, strings.Join(work.keyColumns, ", "))
 		} else {
 			log.Printf("Inserted %d CSV records into target table '%s'", insertedCount, work.tableName)
 		}
 	} else {
-		insertedCount = al.upsertToTargetDBWithNetwork(work.tableName, work.records, work.uniqueKeyColumn, work.networkID)
-		if work.uniqueKeyColumn != "" {
-			log.Printf("Upserted %d JSON records into target table '%s' (key: %s)", insertedCount, work.tableName, work.uniqueKeyColumn)
+		insertedCount, rejected, writeErr = al.upsertToTargetDBWithNetwork(work)
+		if len(work.keyColumns) > 0 {
+			log.Printf("Upserted %d JSON records into target table '%s' (key: %s)", insertedCount, work.tableName, strings.Join(work.keyColumns, ", "))
 		} else {
 			log.Printf("Inserted %d JSON records into target table '%s'", insertedCount, work.tableName)
 		}
 	}
 
-	// Reset sequence and run post-queries ONLY at end of job (not per-batch)
-	if !work.isPartial {
-		al.resetSequenceForTable(work.tableName, work.uniqueKeyColumn, work.networkID)
+	if writeErr != nil {
+		work.status = "failed"
+		if work.errorMsg == "" {
+			work.errorMsg = writeErr.Error()
+		}
+	}
+	if len(rejected) > 0 {
+		al.quarantineRows(work, rejected)
+	}
+	if work.run != nil {
+		work.run.recordBatch(work.recordCount, insertedCount, len(rejected), writeErr)
+		work.run.recordCheckpoint(maxCheckpoint)
+		maxCheckpoint = ""
+	}
+	if isDestination {
+		log.Printf("Job %d destination %s batch: records=%d, written=%d", work.jobID, work.run.destination.name, work.recordCount, insertedCount)
+		return
+	}
+
+	// Reset sequence and run post-queries ONLY at end of job (not per-batch).
+	// Tracked runs do this in finalizeRun once all their batches are written
+	if !isPartial && isDatabaseTarget(work.targetType) {
+		if !work.skipSequences {
+			al.resetSequenceForTable(work.tableName, work.networkID, work.logID)
+		}
 		if work.uploadPostQuery != "" {
 			al.ExecuteTargetQuery(work.uploadPostQuery, work.networkID)
 		}
+		al.forgetEnsuredTable(work.networkID, work.logID, work.tableName)
 	}
 
 	// Update job log and status
-	al.updateJobLog(work.logID, work.isPartial, work.status, work.recordCount, insertedCount, work.sampleData, work.errorMsg)
-	al.updateJobStatus(work.jobID, work.isPartial, work.status, maxCheckpoint)
+	al.updateJobLog(work.logID, isPartial, work.status, work.recordCount, insertedCount, work.sampleData, work.errorMsg)
+	al.updateJobStatus(work.jobID, isPartial, work.status, maxCheckpoint)
 
 	log.Printf("Job %d response: status=%s, batch_records=%d, inserted=%d, partial=%v",
 		work.jobID, work.status, work.recordCount, insertedCount, work.isPartial)
@@ -715,9 +927,8 @@ func (al *AgentListener) updateJobLog(logID float64, isPartial bool, status stri
 	}
 	var jobLog core.JobLog
 	if err := al.handler.db.First(&jobLog, uint(logID)).Error; err == nil {
-		// Prevent partial batches from overwriting a 'completed' or 'failed' status
-		// This happens due to network race conditions where the final data pulse (partial=false)
-		// is processed before the worker pool finishes processing the last data batch.
+		// Prevent partial batches from overwriting a 'completed' or 'failed' status. Runs finalize
+		// after their own batches, but the tables of a multi-rule job share one job log
 		if isPartial {
 			if jobLog.Status != "completed" && jobLog.Status != "failed" {
 				jobLog.Status = "running"
@@ -740,12 +951,53 @@ func (al *AgentListener) updateJobLog(logID float64, isPartial bool, status stri
 			jobLog.Status = "failed" // Ensure status is marked failed on error even for partial
 		}
 
-		al.handler.db.Save(&jobLog)
+		// Events and rejected rows are updated concurrently by batches, never overwrite them here
+		al.handler.db.Omit("Events", "RejectedRows").Save(&jobLog)
 		log.Printf("Updated job log %d: status=%s, total_records=%d, batch_inserted=%d, partial=%v",
 			uint(logID), jobLog.Status, jobLog.RecordCount, insertedCount, isPartial)
 	}
 }
 
+// appendJobLogEvent appends a timestamped line to the job log events (DDL executed, ...)
+func (al *AgentListener) appendJobLogEvent(logID float64, format string, args ...interface{}) {
+	if logID == 0 {
+		return
+	}
+	line := fmt.Sprintf("[%s] %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+	al.handler.db.Model(&core.JobLog{}).Where("id = ?", uint(logID)).
+		Update("events", gorm.Expr("COALESCE(events, '') || ?", line))
+}
+
+// quarantineRows stores rows the target refused in the dead-letter table and counts them on the job log
+func (al *AgentListener) quarantineRows(work insertWork, rejected []database.RejectedRow) {
+	entries := make([]core.RejectedRow, 0, len(rejected))
+	for _, row := range rejected {
+		record, err := json.Marshal(row.Record)
+		if err != nil {
+			record = []byte(fmt.Sprintf("%v", row.Record))
+		}
+		entries = append(entries, core.RejectedRow{
+			NetworkID:   work.networkID,
+			JobID:       work.jobID,
+			JobLogID:    uint(work.logID),
+			TargetTable: work.tableName,
+			Record:      string(record),
+			Error:       row.Error,
+			Status:      "pending",
+		})
+	}
+	if err := al.handler.db.CreateInBatches(entries, 500).Error; err != nil {
+		log.Printf("⚠️ Failed to quarantine %d rejected rows for %s: %v", len(entries), work.tableName, err)
+	}
+
+	if work.logID > 0 {
+		al.handler.db.Model(&core.JobLog{}).Where("id = ?", uint(work.logID)).
+			Update("rejected_rows", gorm.Expr("rejected_rows + ?", len(rejected)))
+	}
+	log.Printf("⚠️ %d rows rejected by %s, quarantined", len(rejected), work.tableName)
+	al.appendJobLogEvent(work.logID, "%d rows rejected by %s and quarantined (first error: %s)", len(rejected), work.tableName, rejected[0].Error)
+}
+
 // updateJobStatus updates the job status
 func (al *AgentListener) updateJobStatus(jobID uint, isPartial bool, status string, newCheckpoint ...string) {
 	if jobID == 0 {
@@ -782,33 +1034,21 @@ func (al *AgentListener) insertToTargetDB(tableName string, records []interface{
 }
 
 // upsertToTargetDBWithNetwork connects to target database using Network config and inserts or updates records
-// Uses connection cache and EnsureTable cache for performance
-func (al *AgentListener) upsertToTargetDBWithNetwork(tableName string, records []interface{}, uniqueKeyColumn string, networkID uint) int {
-	// Try to load target database config from Network first
-	config := al.loadTargetDBConfigFromNetwork(networkID)
-
-	// Fall back to global settings if Network config is not available
-	if config.Host == "" {
-		config = al.loadTargetDBConfig()
+// Uses connection cache and schema sync cache for performance
+func (al *AgentListener) upsertToTargetDBWithNetwork(work insertWork) (int, []database.RejectedRow, error) {
+	targetConn, err := al.targetConnForNetwork(work.networkID)
+	if err != nil {
+		return 0, nil, err
 	}
-
-	// Check if target DB is configured
-	if config.Password == "" && config.Host == "" {
+	if targetConn == nil {
 		log.Printf("Target database not configured, skipping insert")
-		return 0
-	}
-
-	// Use cached connection or create new one
-	targetConn, err := al.getOrCreateTargetConn(networkID, config)
-	if err != nil {
-		log.Printf("Failed to get target database connection: %v", err)
-		return 0
+		return 0, nil, nil
 	}
 	// NOTE: Don't close here — connection is cached and reused across batches
 
 	// Convert records to map format
 	var recordMaps []map[string]interface{}
-	for _, r := range records {
+	for _, r := range work.records {
 		if rec, ok := r.(map[string]interface{}); ok {
 			recordMaps = append(recordMaps, rec)
 		}
@@ -816,84 +1056,225 @@ func (al *AgentListener) upsertToTargetDBWithNetwork(tableName string, records [
 
 	if len(recordMaps) == 0 {
 		log.Printf("No valid records to insert")
-		return 0
+		return 0, nil, nil
+	}
+
+	// Staging loads write into the run's staging table
+	if work.tableName, err = al.writeTable(targetConn, work); err != nil {
+		return 0, nil, err
 	}
 
-	// Ensure table exists (cached — only checks once per table)
-	if !al.isTableEnsured(tableName) {
-		if err := targetConn.EnsureTable(tableName, recordMaps[0]); err != nil {
-			log.Printf("Failed to ensure table: %v", err)
-			return 0
+	// Reconcile target schema (cached per run — only re-checked when columns change)
+	specs := work.columnTypes
+	if specs == nil {
+		specs = database.InferColumnSpecs(recordMaps)
+	}
+	if work.writeMode == database.WriteModeSCD2 {
+		specs = append(append([]database.ColumnSpec{}, specs...), database.SCD2Specs()...)
+	}
+	ignored, err := al.ensureTargetSchema(targetConn, work, specs)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(ignored) > 0 {
+		for _, rec := range recordMaps {
+			for col := range ignored {
+				delete(rec, col)
+			}
 		}
-		al.markTableEnsured(tableName)
+	}
+
+	// Collapse duplicate keys before writing
+	if len(work.keyColumns) > 0 {
+		var dropped int
+		recordMaps, dropped = database.DedupeRecords(recordMaps, work.keyColumns, work.dedupe)
+		al.logDedupe(work, dropped)
 	}
 
 	// Upsert or Insert records based on unique key
 	var count int
-	if uniqueKeyColumn != "" {
-		log.Printf("Upserting with unique key column: %s", uniqueKeyColumn)
-		count, err = targetConn.UpsertBatch(tableName, recordMaps, uniqueKeyColumn)
+	var rejected []database.RejectedRow
+	if work.writeMode == database.WriteModeSCD2 {
+		count, err = targetConn.SCD2Batch(work.tableName, recordMaps, work.keyColumns)
+	} else if len(work.keyColumns) > 0 {
+		log.Printf("Upserting with unique key: %s", strings.Join(work.keyColumns, ", "))
+		count, rejected, err = targetConn.UpsertBatch(work.tableName, recordMaps, work.keyColumns)
 	} else {
-		count, err = targetConn.InsertBatch(tableName, recordMaps)
+		count, rejected, err = targetConn.InsertBatch(work.tableName, recordMaps)
 	}
 	if err != nil {
 		log.Printf("Batch operation error: %v", err)
 		// Connection might be stale — evict from cache so next batch reconnects
-		al.evictTargetConn(networkID)
+		al.evictTargetConn(work.networkID)
 	}
 
-	// NOTE: ResetSequence is now called at end-of-job in executeInsertWork(),
+	// NOTE: Sequences are resynced at end-of-job (resetSequenceForTable),
 	// not per-batch, to avoid 14,000+ unnecessary sequence reset queries on large syncs.
 
-	return count
+	return count, rejected, err
 }
 
 // upsertCsvToTargetDBWithNetwork connects to target database and inserts CSV data
-func (al *AgentListener) upsertCsvToTargetDBWithNetwork(tableName string, csvData string, columns []string, uniqueKeyColumn string, networkID uint) int {
-	config := al.loadTargetDBConfigFromNetwork(networkID)
-	if config.Host == "" {
-		config = al.loadTargetDBConfig()
+func (al *AgentListener) upsertCsvToTargetDBWithNetwork(work insertWork) (int, []database.RejectedRow, error) {
+	targetConn, err := al.targetConnForNetwork(work.networkID)
+	if err != nil {
+		return 0, nil, err
 	}
-
-	if config.Password == "" && config.Host == "" {
+	if targetConn == nil {
 		log.Printf("Target database not configured, skipping insert")
-		return 0
+		return 0, nil, nil
 	}
 
-	targetConn, err := al.getOrCreateTargetConn(networkID, config)
+	// Staging loads write into the run's staging table
+	if work.tableName, err = al.writeTable(targetConn, work); err != nil {
+		return 0, nil, err
+	}
+
+	// Reconcile target schema (cached per run), using source types when the agent sent them
+	specs := database.TextColumnSpecs(work.csvColumns)
+	if len(work.columnTypes) == len(work.csvColumns) {
+		specs = work.columnTypes
+	}
+	if work.writeMode == database.WriteModeSCD2 {
+		specs = append(append([]database.ColumnSpec{}, specs...), database.SCD2Specs()...)
+	}
+	ignored, err := al.ensureTargetSchema(targetConn, work, specs)
 	if err != nil {
-		log.Printf("Failed to get target database connection: %v", err)
-		return 0
+		return 0, nil, err
 	}
 
-	// Ensure table exists (cached)
-	if !al.isTableEnsured(tableName) {
-		// Create a dummy record with text values for EnsureTable
-		dummyRecord := make(map[string]interface{})
-		for _, col := range columns {
-			dummyRecord[col] = ""
+	csvData, columns := work.csvData, work.csvColumns
+	if len(ignored) > 0 {
+		csvData, columns, err = database.DropCsvColumns(csvData, columns, ignored)
+		if err != nil {
+			return 0, nil, err
 		}
-		if err := targetConn.EnsureTable(tableName, dummyRecord); err != nil {
-			log.Printf("Failed to ensure table for CSV: %v", err)
-			return 0
+	}
+
+	// Collapse duplicate keys before writing
+	if len(work.keyColumns) > 0 {
+		var dropped int
+		if csvData, dropped, err = database.DedupeCsv(csvData, columns, work.keyColumns, work.dedupe); err != nil {
+			return 0, nil, err
 		}
-		al.markTableEnsured(tableName)
+		al.logDedupe(work, dropped)
 	}
 
 	var count int
-	if uniqueKeyColumn != "" {
-		log.Printf("Upserting CSV with unique key column: %s (parallel mode)", uniqueKeyColumn)
-		count, err = targetConn.UpsertCsvBatchParallel(tableName, csvData, columns, uniqueKeyColumn)
+	var rejected []database.RejectedRow
+	binary := targetConn.BinaryColumns(specs)
+	if work.writeMode == database.WriteModeSCD2 || len(binary) > 0 {
+		// Versions are compared row by row, and binary values need decoding for the target
+		// dialect, so the batch goes through the record path
+		var records []map[string]interface{}
+		if records, err = database.ParseCsvRecords(csvData, columns); err == nil {
+			err = database.DecodeBinaryColumns(records, binary)
+		}
+		if err == nil {
+			switch {
+			case work.writeMode == database.WriteModeSCD2:
+				count, err = targetConn.SCD2Batch(work.tableName, records, work.keyColumns)
+			case len(work.keyColumns) > 0:
+				count, rejected, err = targetConn.UpsertBatch(work.tableName, records, work.keyColumns)
+			default:
+				count, rejected, err = targetConn.InsertBatch(work.tableName, records)
+			}
+		}
+	} else if len(work.keyColumns) > 0 {
+		log.Printf("Upserting CSV with unique key: %s (parallel mode)", strings.Join(work.keyColumns, ", "))
+		count, rejected, err = targetConn.UpsertCsvBatchParallel(work.tableName, csvData, columns, work.keyColumns)
 	} else {
-		count, err = targetConn.InsertCsvBatch(tableName, csvData, columns)
+		count, err = targetConn.InsertCsvBatch(work.tableName, csvData, columns)
+		if err != nil {
+			// COPY is all-or-nothing: retry through the record path to isolate the refused rows
+			log.Printf("CSV COPY into %s failed (%v), retrying row by row", work.tableName, err)
+			var records []map[string]interface{}
+			if records, err = database.ParseCsvRecords(csvData, columns); err == nil {
+				count, rejected, err = targetConn.InsertBatch(work.tableName, records)
+			}
+		}
 	}
 
 	if err != nil {
 		log.Printf("Batch CSV operation error: %v", err)
-		al.evictTargetConn(networkID)
+		al.evictTargetConn(work.networkID)
 	}
 
-	return count
+	return count, rejected, err
+}
+
+// logDedupe reports the duplicates collapsed in a batch to the job log
+func (al *AgentListener) logDedupe(work insertWork, dropped int) {
+	if dropped == 0 {
+		return
+	}
+	log.Printf("Collapsed %d duplicate keys in batch for %s (%s)", dropped, work.tableName, work.dedupe)
+	al.appendJobLogEvent(work.logID, "Batch for %s: collapsed %d duplicate rows on (%s), %s",
+		work.tableName, dropped, strings.Join(work.keyColumns, ", "), work.dedupe)
+}
+
+// applyMapping applies the rule column mapping to a batch (JSON records or CSV),
+// updating the column types so the target table gets the mapped names and casts
+func (al *AgentListener) applyMapping(work *insertWork) error {
+	m := work.mapping
+	if work.mappingErr != nil || m == nil {
+		return work.mappingErr
+	}
+
+	ctx := mapping.Context{
+		Now:   time.Now(),
+		Agent: work.agentName,
+		JobID: work.jobID,
+		RunID: uint(work.logID),
+		Table: work.tableName,
+	}
+
+	if work.csvData != "" && len(work.csvColumns) > 0 {
+		specs := work.columnTypes
+		if len(specs) != len(work.csvColumns) {
+			specs = database.TextColumnSpecs(work.csvColumns)
+		}
+		csvData, columns, err := m.ApplyCSV(work.csvData, work.csvColumns, ctx)
+		if err != nil {
+			return fmt.Errorf("column mapping: %w", err)
+		}
+		work.csvData, work.csvColumns = csvData, columns
+		work.columnTypes = m.OutputSpecs(specs)
+		return nil
+	}
+
+	var source []map[string]interface{}
+	for _, r := range work.records {
+		if rec, ok := r.(map[string]interface{}); ok {
+			source = append(source, rec)
+		}
+	}
+	records, err := m.ApplyRecords(work.records, ctx)
+	if err != nil {
+		return fmt.Errorf("column mapping: %w", err)
+	}
+	work.records = records
+	work.columnTypes = m.OutputSpecs(database.InferColumnSpecs(source))
+	return nil
+}
+
+// targetConnForNetwork returns the cached target DB connection for a network,
+// falling back to the global settings. Returns nil when no target DB is configured
+func (al *AgentListener) targetConnForNetwork(networkID uint) (*database.TargetConnection, error) {
+	config := al.loadTargetDBConfigFromNetwork(networkID)
+	if config.Host == "" {
+		config = al.loadTargetDBConfig()
+	}
+	if config.Password == "" && config.Host == "" {
+		return nil, nil
+	}
+
+	targetConn, err := al.getOrCreateTargetConn(networkID, config)
+	if err != nil {
+		log.Printf("Failed to get target database connection: %v", err)
+		return nil, fmt.Errorf("failed to connect to target database: %w", err)
+	}
+	return targetConn, nil
 }
 
 // getOrCreateTargetConn returns a cached target DB connection or creates a new one
@@ -939,46 +1320,161 @@ func (al *AgentListener) evictTargetConn(networkID uint) {
 	}
 }
 
-// isTableEnsured checks if a table has already been verified to exist
-func (al *AgentListener) isTableEnsured(tableName string) bool {
-	al.ensuredMu.RLock()
-	defer al.ensuredMu.RUnlock()
-	return al.ensuredTables[tableName]
+// ensuredTableKey identifies a target table within a single job run
+func ensuredTableKey(networkID uint, logID float64, tableName string) string {
+	return fmt.Sprintf("%d:%d:%s", networkID, uint(logID), tableName)
+}
+
+// ensureTargetSchema reconciles the target table with the batch columns on the first batch
+// of a run, and again whenever a batch brings new or wider columns.
+// Every DDL statement is recorded in the job log. Returns the columns to leave out of writes
+func (al *AgentListener) ensureTargetSchema(targetConn *database.TargetConnection, work insertWork, specs []database.ColumnSpec) (map[string]bool, error) {
+	key := ensuredTableKey(work.networkID, work.logID, work.tableName)
+
+	al.ensuredMu.Lock()
+	entry := al.ensuredTables[key]
+	if entry != nil {
+		entry.lastUsed = time.Now() // Only read under ensuredMu, by the stale entry cleanup
+	}
+	al.ensuredMu.Unlock()
+
+	if entry != nil && specsCovered(entry.specs, specs) {
+		return entry.ignored, nil
+	}
+
+	changes, err := targetConn.SyncTableSchema(work.tableName, specs, work.schemaPolicy)
+	if changes != nil {
+		for _, stmt := range changes.Statements {
+			al.appendJobLogEvent(work.logID, "DDL on %s: %s", work.tableName, stmt)
+		}
+		for _, col := range changes.Ignored {
+			al.appendJobLogEvent(work.logID, "Column %s.%s ignored: incompatible with target column type", work.tableName, col)
+		}
+	}
+	if err != nil {
+		al.appendJobLogEvent(work.logID, "Schema sync failed for %s: %v", work.tableName, err)
+		return nil, err
+	}
+
+	// Upserts need a unique index on the key, checked once per run (SCD2 keeps several versions per key)
+	if entry == nil && len(work.keyColumns) > 0 && work.writeMode != database.WriteModeSCD2 {
+		stmt, err := targetConn.EnsureUniqueKey(work.tableName, work.keyColumns)
+		if stmt != "" {
+			al.appendJobLogEvent(work.logID, "DDL on %s: %s", work.tableName, stmt)
+		}
+		if err != nil {
+			al.appendJobLogEvent(work.logID, "Unique key check failed for %s: %v", work.tableName, err)
+			return nil, err
+		}
+	}
+
+	// Build a new entry rather than mutating the cached one, readers may still hold it
+	merged := &ensuredTable{
+		specs:    make(map[string]database.ColumnSpec),
+		ignored:  make(map[string]bool),
+		lastUsed: time.Now(),
+	}
+	if entry != nil {
+		for name, spec := range entry.specs {
+			merged.specs[name] = spec
+		}
+		for name := range entry.ignored {
+			merged.ignored[name] = true
+		}
+	}
+	for _, spec := range specs {
+		// A narrower inferred integer keeps the wider type already reconciled
+		if prev, ok := merged.specs[spec.Name]; ok && database.SpecCovers(prev, spec) {
+			continue
+		}
+		merged.specs[spec.Name] = spec
+	}
+	for _, col := range changes.Ignored {
+		merged.ignored[col] = true
+	}
+
+	al.ensuredMu.Lock()
+	al.ensuredTables[key] = merged
+	al.ensuredMu.Unlock()
+
+	return merged.ignored, nil
+}
+
+// specsCovered reports whether every incoming column was already reconciled with
+// the same type and at least the same length
+func specsCovered(known map[string]database.ColumnSpec, specs []database.ColumnSpec) bool {
+	for _, spec := range specs {
+		prev, ok := known[spec.Name]
+		if !ok || !database.SpecCovers(prev, spec) {
+			return false
+		}
+	}
+	return true
 }
 
-// markTableEnsured marks a table as verified
-func (al *AgentListener) markTableEnsured(tableName string) {
+// forgetEnsuredTable drops the schema sync cache of a finished run
+func (al *AgentListener) forgetEnsuredTable(networkID uint, logID float64, tableName string) {
 	al.ensuredMu.Lock()
 	defer al.ensuredMu.Unlock()
-	al.ensuredTables[tableName] = true
+	key := ensuredTableKey(networkID, logID, tableName)
+	delete(al.ensuredTables, key)
+	delete(al.columnTypes, key)
 }
 
-// resetSequenceForTable resets the sequence for a table after job completion
-// Called only once at end-of-job instead of per-batch to avoid massive overhead
-func (al *AgentListener) resetSequenceForTable(tableName string, uniqueKeyColumn string, networkID uint) {
-	config := al.loadTargetDBConfigFromNetwork(networkID)
-	if config.Host == "" {
-		config = al.loadTargetDBConfig()
+// runColumnTypesFor stores the column types sent with a batch, or returns the ones
+// stored earlier in the same run
+func (al *AgentListener) runColumnTypesFor(networkID uint, logID float64, tableName string, raw interface{}) []database.ColumnSpec {
+	key := ensuredTableKey(networkID, logID, tableName)
+
+	if raw != nil {
+		var specs []database.ColumnSpec
+		if b, err := json.Marshal(raw); err == nil {
+			if err := json.Unmarshal(b, &specs); err != nil {
+				log.Printf("⚠️ Ignoring malformed csv_column_types for %s: %v", tableName, err)
+			}
+		}
+		if len(specs) > 0 {
+			al.ensuredMu.Lock()
+			al.columnTypes[key] = &runColumnTypes{specs: specs, lastUsed: time.Now()}
+			al.ensuredMu.Unlock()
+			return specs
+		}
 	}
-	if config.Password == "" && config.Host == "" {
-		return
+
+	al.ensuredMu.Lock()
+	defer al.ensuredMu.Unlock()
+	if cached, ok := al.columnTypes[key]; ok {
+		cached.lastUsed = time.Now()
+		return cached.specs
 	}
+	return nil
+}
 
-	targetConn, err := al.getOrCreateTargetConn(networkID, config)
-	if err != nil {
-		log.Printf("Warning: Failed to get connection for sequence reset: %v", err)
+// resetSequenceForTable resyncs the sequences of a table after job completion and records
+// the result in the job log. Called only once at end-of-job instead of per-batch to avoid massive overhead
+func (al *AgentListener) resetSequenceForTable(tableName string, networkID uint, logID float64) {
+	targetConn, err := al.targetConnForNetwork(networkID)
+	if err != nil || targetConn == nil {
+		log.Printf("Warning: No target connection for sequence reset of %s: %v", tableName, err)
 		return
 	}
 
-	primaryKeyCol := uniqueKeyColumn
-	if primaryKeyCol == "" {
-		primaryKeyCol = "id"
+	resets, err := targetConn.ResyncSequences(tableName)
+	for _, reset := range resets {
+		al.appendJobLogEvent(logID, "Sequence reset on %s: %s", tableName, reset)
 	}
-	if err := targetConn.ResetSequence(tableName, primaryKeyCol); err != nil {
-		log.Printf("Warning: Failed to reset sequence for %s: %v", tableName, err)
-	} else {
-		log.Printf("✅ Reset sequence for table %s (end-of-job)", tableName)
+	if err != nil {
+		log.Printf("Warning: Failed to reset sequences for %s: %v", tableName, err)
+		if len(resets) == 0 {
+			al.appendJobLogEvent(logID, "Sequence reset on %s failed: %v", tableName, err)
+		}
+		return
+	}
+	if len(resets) == 0 {
+		log.Printf("No sequences to reset for table %s", tableName)
+		return
 	}
+	log.Printf("✅ Reset %d sequence(s) for table %s (end-of-job)", len(resets), tableName)
 }
 
 // cleanupStaleTargetConns periodically closes target DB connections unused for 10 minutes
@@ -998,6 +1494,25 @@ func (al *AgentListener) cleanupStaleTargetConns() {
 			}
 		}
 		al.targetDBMu.Unlock()
+		al.mongoTargets.closeIdle(10 * time.Minute)
+
+		// Fail runs that never sent a final batch (drops their staging tables)
+		al.expireStaleRuns(6 * time.Hour)
+		al.failStaleProcessedFiles(6 * time.Hour)
+
+		// Drop schema sync entries of runs that never sent a final batch
+		al.ensuredMu.Lock()
+		for key, entry := range al.ensuredTables {
+			if now.Sub(entry.lastUsed) > 6*time.Hour {
+				delete(al.ensuredTables, key)
+			}
+		}
+		for key, entry := range al.columnTypes {
+			if now.Sub(entry.lastUsed) > 6*time.Hour {
+				delete(al.columnTypes, key)
+			}
+		}
+		al.ensuredMu.Unlock()
 	}
 }
 
@@ -1007,6 +1522,9 @@ func (al *AgentListener) MarkJobAborted(jobID uint) {
 	defer al.abortedMu.Unlock()
 	al.abortedJobs[jobID] = true
 	log.Printf("🛑 Marked job %d as aborted in worker pool", jobID)
+
+	// Close its runs so staging tables are dropped
+	go al.abortRuns(jobID)
 }
 
 // isJobAborted checks if a job has been aborted
@@ -1093,7 +1611,8 @@ func (al *AgentListener) loadTargetDBConfigFromNetwork(networkID uint) database.
 // upsertToTargetDB connects to target database and inserts or updates records
 // Delegates to upsertToTargetDBWithNetwork with networkID=0 to benefit from connection caching
 func (al *AgentListener) upsertToTargetDB(tableName string, records []interface{}, uniqueKeyColumn string) int {
-	return al.upsertToTargetDBWithNetwork(tableName, records, uniqueKeyColumn, 0)
+	count, _, _ := al.upsertToTargetDBWithNetwork(insertWork{tableName: tableName, records: records, keyColumns: database.ParseKeyColumns(uniqueKeyColumn)})
+	return count
 }
 
 // loadTargetDBConfig loads target database config from Settings table
@@ -1422,6 +1941,14 @@ func (al *AgentListener) ExecuteTargetQuery(query string, networkID uint) error
 func (al *AgentListener) ExecutePreJobQueries(networkID uint, tableName string, truncate bool, uploadPreQuery string) error {
 	var errs []string
 
+	// File and API targets have no database: without this the global target DB would be truncated
+	var network core.Network
+	if networkID > 0 && al.handler.db.Select("id", "target_source_type").First(&network, networkID).Error == nil &&
+		!isDatabaseTarget(network.TargetSourceType) {
+		log.Printf("ExecutePreJobQueries: Network %d has a %s target, skipping pre-job queries for %s", networkID, network.TargetSourceType, tableName)
+		return nil
+	}
+
 	// 1. Execute TRUNCATE if requested
 	if truncate && tableName != "" {
 		truncateQuery := fmt.Sprintf("TRUNCATE TABLE %s", tableName)
@@ -1440,7 +1967,7 @@ func (al *AgentListener) ExecutePreJobQueries(networkID uint, tableName string,
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf(strings.Join(errs, "; "))
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
 	}
 	return nil
 }
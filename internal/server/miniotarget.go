@@ -0,0 +1,546 @@
+package server
+
+import (
+	"dsp-platform/internal/core"
+	"dsp-platform/internal/database"
+	"dsp-platform/internal/filesync"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxOpenMinioParts caps the part uploads open at once across the partitions of a run.
+// Each holds a row group and a multipart chunk in memory, the least recently written
+// part is closed first (its partition continues in a new part)
+const maxOpenMinioParts = 32
+
+// minioStream writes the rows of a run to a MinIO target as part objects laid out by the
+// network's key template, one sequence of parts per Hive partition. Each part is uploaded
+// while it is written. A part is closed when it reaches the row or size limit, or when new
+// or widened columns show up that its schema can't hold
+type minioStream struct {
+	mu          sync.Mutex
+	client      *filesync.MinIOClient
+	format      string // parquet, csv, jsonl or xlsx
+	opts        filesync.ParquetOptions
+	partRows    int64
+	partBytes   int64 // 0 = no size limit
+	keyTemplate string
+	manifestKey string
+	partitionBy []string // Hive partition columns, taken out of the records
+	vars        filesync.FileNameVars
+	jobID       uint
+	startedAt   time.Time
+
+	columns map[string]filesync.ParquetColumn // Every column seen in the run
+	order   []string                          // Column order, first seen
+	schema  int                               // Bumped when columns are added or widened
+
+	partitions map[string]*minioPartition // Keyed by partition path
+	open       int                        // Parts currently uploading
+	tick       int64                      // Write sequence, orders parts by last use
+
+	closed []filesync.ManifestPart
+	rows   int64
+}
+
+// minioPartition is the part sequence of one partition value combination
+type minioPartition struct {
+	path   string            // Hive path (empty when unpartitioned)
+	values map[string]string // Partition values for the manifest
+	parts  int               // Parts started so far
+	part   *minioPart        // Part being written (nil between parts)
+}
+
+// minioPart is a part object being uploaded
+type minioPart struct {
+	key      string
+	object   *filesync.ObjectWriter
+	enc      filesync.RecordEncoder
+	schema   int // Stream schema version the part was opened with
+	rows     int64
+	lastUsed int64
+}
+
+// minioConfigFromNetwork builds the MinIO target config of a network
+func minioConfigFromNetwork(network core.Network) filesync.MinIOConfig {
+	return filesync.MinIOConfig{
+		Endpoint:        network.TargetMinIOEndpoint,
+		AccessKeyID:     network.TargetMinIOAccessKey,
+		SecretAccessKey: network.TargetMinIOSecretKey,
+		BucketName:      network.TargetMinIOBucket,
+		ObjectPath:      network.TargetMinIOObjectPath,
+		UseSSL:          network.TargetMinIOUseSSL,
+		Region:          network.TargetMinIORegion,
+	}
+}
+
+// newMinioStream opens the MinIO target of a network for a run's table
+func (al *AgentListener) newMinioStream(networkID, jobID uint, logID float64, table string) (*minioStream, error) {
+	var network core.Network
+	if err := al.handler.db.First(&network, networkID).Error; err != nil {
+		return nil, fmt.Errorf("network %d not found: %w", networkID, err)
+	}
+
+	format := strings.ToLower(strings.TrimSpace(network.TargetMinIOExportFormat))
+	if format != filesync.ExportParquet {
+		// Other formats go through the file encoders, json is written as JSON Lines
+		var err error
+		if format, err = filesync.NormalizeExportFormat(format); err != nil {
+			return nil, err
+		}
+	}
+	opts := filesync.ParquetOptions{
+		Compression:  network.TargetMinIOCompression,
+		RowGroupSize: int64(network.TargetMinIORowGroupSize),
+	}
+	if err := filesync.ValidateParquetOptions(opts); err != nil {
+		return nil, err
+	}
+	partRows := int64(network.TargetMinIOPartRows)
+	if partRows <= 0 {
+		partRows = filesync.DefaultParquetPartRows
+	}
+
+	client, err := filesync.NewMinIOClient(minioConfigFromNetwork(network))
+	if err != nil {
+		return nil, err
+	}
+
+	var job core.Job
+	if jobID > 0 {
+		al.handler.db.Select("id", "name").First(&job, jobID)
+	}
+	now := time.Now()
+	vars := filesync.FileNameVars{
+		Table: table,
+		JobID: jobID,
+		Job:   job.Name,
+		RunID: uint(logID),
+		Ext:   format,
+		Time:  now,
+	}
+	manifestVars := vars
+	manifestVars.Ext = "json"
+
+	var partitionBy []string
+	for _, col := range strings.Split(network.TargetMinIOPartitionColumns, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			partitionBy = append(partitionBy, col)
+		}
+	}
+
+	return &minioStream{
+		client:      client,
+		format:      format,
+		opts:        opts,
+		partRows:    partRows,
+		partBytes:   network.TargetMinIOPartBytes,
+		keyTemplate: filesync.ObjectKeyTemplate(network.TargetMinIOObjectPath),
+		manifestKey: filesync.RenderObjectKey(filesync.ManifestTemplate(network.TargetMinIOManifestPath, network.TargetMinIOObjectPath), manifestVars, 0, ""),
+		partitionBy: partitionBy,
+		vars:        vars,
+		jobID:       jobID,
+		startedAt:   now,
+		columns:     make(map[string]filesync.ParquetColumn),
+		partitions:  make(map[string]*minioPartition),
+	}, nil
+}
+
+// addColumns merges the column types of a batch. A column seen again with a wider
+// compatible type is widened, the next parts using the new type; a type that can't hold the
+// earlier values fails the run. Partition columns live in the object keys, not in the files
+func (s *minioStream) addColumns(specs []database.ColumnSpec) error {
+	for _, spec := range specs {
+		if s.isPartitionColumn(spec.Name) {
+			continue
+		}
+		existing, ok := s.columns[spec.Name]
+		if !ok {
+			s.columns[spec.Name] = filesync.ParquetColumn{
+				Name:      spec.Name,
+				Type:      spec.Type,
+				Precision: spec.Precision,
+				Scale:     spec.Scale,
+			}
+			s.order = append(s.order, spec.Name)
+			s.schema++
+			continue
+		}
+		if s.format != filesync.ExportParquet {
+			continue // Text formats write any value
+		}
+		widened, err := widenParquetColumn(existing, spec)
+		if err != nil {
+			return err
+		}
+		if widened != existing {
+			s.columns[spec.Name] = widened
+			s.schema++
+		}
+	}
+	return nil
+}
+
+// parquetIntegerRank orders the integer column types by width
+var parquetIntegerRank = map[string]int{"smallint": 1, "integer": 2, "bigint": 3}
+
+// widenParquetColumn returns the column type holding both the values written so far and
+// those of spec
+func widenParquetColumn(col filesync.ParquetColumn, spec database.ColumnSpec) (filesync.ParquetColumn, error) {
+	floating := func(t string) bool { return t == "real" || t == "double" }
+	_, colInt := parquetIntegerRank[col.Type]
+	_, specInt := parquetIntegerRank[spec.Type]
+
+	switch {
+	case spec.Type == col.Type && spec.Type != "decimal":
+		return col, nil
+	case col.Type == "varchar" || col.Type == "text":
+		return col, nil // Strings hold any value
+	case spec.Inferred && spec.Type == "text":
+		return col, nil // Batch of nulls (or mixed values, checked row by row)
+	case colInt && specInt:
+		if parquetIntegerRank[spec.Type] > parquetIntegerRank[col.Type] {
+			col.Type = spec.Type
+		}
+		return col, nil
+	case (colInt || floating(col.Type)) && (specInt || floating(spec.Type)):
+		col.Type = "double"
+		return col, nil
+	case col.Type == "decimal" && spec.Type == "decimal":
+		scale := max(col.Scale, spec.Scale)
+		intDigits := max(col.Precision-col.Scale, spec.Precision-spec.Scale)
+		if col.Precision == 0 || spec.Precision == 0 {
+			col.Precision, col.Scale = 0, 0 // Unbounded, written as text
+		} else {
+			col.Precision, col.Scale = intDigits+scale, scale
+		}
+		return col, nil
+	}
+	return col, fmt.Errorf("column %s changed type from %s to %s within the run, Parquet parts can't hold both", col.Name, col.Type, spec.Type)
+}
+
+// isPartitionColumn reports whether a column is one of the partition columns
+func (s *minioStream) isPartitionColumn(name string) bool {
+	for _, col := range s.partitionBy {
+		if col == name {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionOf returns the partition of a record and the record without its partition columns
+func (s *minioStream) partitionOf(record map[string]interface{}) (*minioPartition, map[string]interface{}) {
+	if len(s.partitionBy) == 0 {
+		p, ok := s.partitions[""]
+		if !ok {
+			p = &minioPartition{}
+			s.partitions[""] = p
+		}
+		return p, record
+	}
+
+	values := make([]interface{}, len(s.partitionBy))
+	for i, col := range s.partitionBy {
+		values[i] = record[col]
+	}
+	hivePath := filesync.HivePartitionPath(s.partitionBy, values)
+
+	p, ok := s.partitions[hivePath]
+	if !ok {
+		p = &minioPartition{path: hivePath, values: make(map[string]string, len(s.partitionBy))}
+		for i, col := range s.partitionBy {
+			p.values[col] = filesync.FormatExportValue(values[i])
+		}
+		s.partitions[hivePath] = p
+	}
+
+	data := make(map[string]interface{}, len(record))
+	for k, v := range record {
+		if !s.isPartitionColumn(k) {
+			data[k] = v
+		}
+	}
+	return p, data
+}
+
+// openPart starts the upload of a partition's next part with every column known so far
+func (s *minioStream) openPart(p *minioPartition) error {
+	if s.open >= maxOpenMinioParts {
+		if err := s.closeLeastRecent(); err != nil {
+			return err
+		}
+	}
+
+	key := filesync.RenderObjectKey(s.keyTemplate, s.vars, p.parts+1, p.path)
+	object := s.client.NewObjectWriter(key, filesync.GetContentTypeForFormat(s.format))
+
+	var enc filesync.RecordEncoder
+	var err error
+	if s.format == filesync.ExportParquet {
+		columns := make([]filesync.ParquetColumn, len(s.order))
+		for i, name := range s.order {
+			columns[i] = s.columns[name]
+		}
+		enc, err = filesync.NewParquetEncoder(object, columns, s.opts)
+	} else {
+		enc, err = filesync.NewRecordEncoder(s.format, object, s.order, s.vars.Table)
+	}
+	if err != nil {
+		object.Abort(err)
+		return err
+	}
+
+	p.parts++
+	p.part = &minioPart{key: key, object: object, enc: enc, schema: s.schema}
+	s.open++
+	return nil
+}
+
+// closePart finishes the part being written of a partition and lists it for the manifest
+func (s *minioStream) closePart(p *minioPartition) error {
+	part := p.part
+	if part == nil {
+		return nil
+	}
+	p.part = nil
+	s.open--
+
+	if err := part.enc.Close(); err != nil {
+		part.object.Abort(err)
+		return err
+	}
+	if err := part.object.Close(); err != nil {
+		return err
+	}
+	s.closed = append(s.closed, filesync.ManifestPart{
+		Key:       part.key,
+		Rows:      part.rows,
+		Bytes:     part.object.Size(),
+		Partition: p.values,
+	})
+	return nil
+}
+
+// closeLeastRecent closes the open part written to longest ago
+func (s *minioStream) closeLeastRecent() error {
+	var oldest *minioPartition
+	for _, p := range s.partitions {
+		if p.part != nil && (oldest == nil || p.part.lastUsed < oldest.part.lastUsed) {
+			oldest = p
+		}
+	}
+	if oldest == nil {
+		return nil
+	}
+	return s.closePart(oldest)
+}
+
+// needsNewPart reports whether a part is full or its schema changed since it was opened
+// (JSON Lines parts hold any record). Parquet bytes are only counted as row groups are
+// flushed, so the size limit is reached with row group granularity
+func (s *minioStream) needsNewPart(part *minioPart) bool {
+	if part.rows >= s.partRows || (s.partBytes > 0 && part.object.Size() >= s.partBytes) {
+		return true
+	}
+	return s.format != filesync.ExportJSONL && part.schema < s.schema
+}
+
+// write appends a batch. Records whose values don't fit their column type are returned
+// as rejected; a failed upload is returned as the error
+func (s *minioStream) write(records []map[string]interface{}, specs []database.ColumnSpec) (int, []database.RejectedRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.addColumns(specs); err != nil {
+		return 0, nil, err
+	}
+	written := 0
+	var rejected []database.RejectedRow
+	for _, rec := range records {
+		p, data := s.partitionOf(rec)
+		if p.part != nil && s.needsNewPart(p.part) {
+			if err := s.closePart(p); err != nil {
+				return written, rejected, err
+			}
+		}
+		if p.part == nil {
+			if err := s.openPart(p); err != nil {
+				return written, rejected, err
+			}
+		}
+
+		part := p.part
+		s.tick++
+		part.lastUsed = s.tick
+		if err := part.enc.WriteRecord(data); err != nil {
+			if uploadErr := part.object.Err(); uploadErr != nil {
+				return written, rejected, fmt.Errorf("upload of %s failed: %w", part.key, uploadErr)
+			}
+			rejected = append(rejected, database.RejectedRow{Record: rec, Error: err.Error()})
+			continue
+		}
+		part.rows++
+		s.rows++
+		written++
+	}
+	return written, rejected, nil
+}
+
+// finish closes the open parts and writes the run manifest, which marks the output complete
+func (s *minioStream) finish() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.partitions {
+		if err := s.closePart(p); err != nil {
+			return err
+		}
+	}
+
+	return s.client.WriteManifest(s.manifestKey, filesync.ObjectManifest{
+		JobID:      s.jobID,
+		RunID:      s.vars.RunID,
+		Table:      s.vars.Table,
+		Format:     s.format,
+		Rows:       s.rows,
+		StartedAt:  s.startedAt,
+		FinishedAt: time.Now(),
+		Parts:      s.closed,
+	})
+}
+
+// abort cancels the open parts and removes the parts already uploaded. It returns the
+// number of removed parts
+func (s *minioStream) abort() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.partitions {
+		if p.part != nil {
+			p.part.object.Abort(fmt.Errorf("run failed"))
+			p.part = nil
+		}
+	}
+	s.open = 0
+	for _, part := range s.closed {
+		if err := s.client.RemoveObject(part.Key); err != nil {
+			log.Printf("⚠️ Failed to remove part %s: %v", part.Key, err)
+		}
+	}
+	removed := len(s.closed)
+	s.closed = nil
+	return removed
+}
+
+// minioStreamFor returns the run's MinIO stream, opened on the first batch
+func (al *AgentListener) minioStreamFor(run *runState) (*minioStream, error) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.minio == nil {
+		stream, err := al.newMinioStream(run.networkID, run.jobID, run.logID, run.table)
+		if err != nil {
+			return nil, err
+		}
+		run.minio = stream
+	}
+	return run.minio, nil
+}
+
+// batchColumnSpecs returns the column types of a batch: the source types when the agent
+// sent them, inferred from the values otherwise
+func batchColumnSpecs(work insertWork, records []map[string]interface{}) []database.ColumnSpec {
+	specs := database.InferColumnSpecs(records)
+	declared := make(map[string]database.ColumnSpec, len(work.columnTypes))
+	for _, spec := range work.columnTypes {
+		declared[spec.Name] = spec
+	}
+	for i, spec := range specs {
+		if d, ok := declared[spec.Name]; ok {
+			specs[i] = d
+		} else if spec.Type == "smallint" || spec.Type == "integer" {
+			// Part files can't widen a column later on, inferred integers are written as int64
+			specs[i].Type = "bigint"
+		}
+	}
+	return specs
+}
+
+// writeMinioBatch streams a batch into the part objects of its run. Batches outside a
+// tracked run are written as objects of their own right away
+func (al *AgentListener) writeMinioBatch(work insertWork) (int, []database.RejectedRow, error) {
+	records, _, err := al.workRecords(work)
+	if err != nil || len(records) == 0 {
+		return 0, nil, err
+	}
+	specs := batchColumnSpecs(work, records)
+
+	// CSV batches carry binary values as \x hex text, Parquet stores the bytes
+	if work.csvData != "" {
+		var binary []string
+		for _, spec := range specs {
+			if spec.Type == "binary" {
+				binary = append(binary, spec.Name)
+			}
+		}
+		if err := database.DecodeBinaryColumns(records, binary); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if work.run != nil {
+		stream, err := al.minioStreamFor(work.run)
+		if err != nil {
+			return 0, nil, err
+		}
+		return stream.write(records, specs)
+	}
+
+	stream, err := al.newMinioStream(work.networkID, work.jobID, work.logID, work.tableName)
+	if err != nil {
+		return 0, nil, err
+	}
+	// Untracked batches all render run_id 0, a unique suffix keeps their objects apart
+	suffix := fmt.Sprintf("%d_%d", time.Now().UnixMilli(), untrackedExports.Add(1))
+	stream.keyTemplate = filesync.SuffixObjectKey(stream.keyTemplate, suffix)
+	stream.manifestKey = filesync.SuffixObjectKey(stream.manifestKey, suffix)
+	written, rejected, err := stream.write(records, specs)
+	if err == nil {
+		err = stream.finish()
+	}
+	if err != nil {
+		stream.abort()
+		return 0, nil, err
+	}
+	return written, rejected, nil
+}
+
+// finalizeMinioTarget completes the part objects of a run, or removes them when the run failed
+func (al *AgentListener) finalizeMinioTarget(run *runState, failed bool) error {
+	run.mu.Lock()
+	stream := run.minio
+	run.mu.Unlock()
+
+	if stream == nil {
+		if !failed {
+			al.appendJobLogEvent(run.logID, "No rows extracted for %s, no object written", run.table)
+		}
+		return nil
+	}
+	if failed {
+		removed := stream.abort()
+		al.appendJobLogEvent(run.logID, "Run failed, removed %d part objects of %s", removed, run.table)
+		return nil
+	}
+	if err := stream.finish(); err != nil {
+		stream.abort()
+		return err
+	}
+
+	log.Printf("📤 Wrote %d rows of %s to MinIO bucket %s as %d %s parts", stream.rows, run.table, stream.client.GetBucketName(), len(stream.closed), stream.format)
+	al.appendJobLogEvent(run.logID, "Wrote %d rows of %s as %d %s parts in %d partitions, manifest %s", stream.rows, run.table, len(stream.closed), stream.format, len(stream.partitions), stream.manifestKey)
+	return nil
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"dsp-platform/internal/core"
+	"dsp-platform/internal/database"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mongoTargets caches MongoDB target connections per network and remembers the unique
+// indexes already ensured. The zero value is ready to use
+type mongoTargets struct {
+	mu      sync.Mutex
+	conns   map[uint]*cachedMongoConn
+	indexed map[string]bool
+}
+
+// cachedMongoConn is a MongoDB target connection with the config it was opened with
+type cachedMongoConn struct {
+	conn     *database.MongoConnection
+	config   database.MongoConfig
+	lastUsed time.Time
+}
+
+// mongoConfigFromNetwork builds the MongoDB target config of a network
+func mongoConfigFromNetwork(network core.Network) database.MongoConfig {
+	config := database.MongoConfig{
+		Host:     network.TargetMongoHost,
+		Port:     network.TargetMongoPort,
+		User:     network.TargetMongoUser,
+		Password: network.TargetMongoPassword,
+		Database: network.TargetMongoDatabase,
+		AuthDB:   network.TargetMongoAuthDB,
+	}
+	if config.Port == "" {
+		config.Port = "27017"
+	}
+	return config
+}
+
+// get returns a cached connection, reconnecting when the network config changed
+func (m *mongoTargets) get(networkID uint, config database.MongoConfig) (*database.MongoConnection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conns == nil {
+		m.conns = make(map[uint]*cachedMongoConn)
+	}
+	if cached, ok := m.conns[networkID]; ok {
+		if cached.config == config {
+			cached.lastUsed = time.Now()
+			return cached.conn, nil
+		}
+		cached.conn.Close()
+		delete(m.conns, networkID)
+	}
+
+	conn, err := database.MongoConnect(config)
+	if err != nil {
+		return nil, err
+	}
+	m.conns[networkID] = &cachedMongoConn{conn: conn, config: config, lastUsed: time.Now()}
+	log.Printf("Connected to MongoDB target %s:%s/%s for network %d", config.Host, config.Port, config.Database, networkID)
+	return conn, nil
+}
+
+// needsIndex reports whether the unique index of a collection still has to be ensured
+func (m *mongoTargets) needsIndex(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.indexed[key]
+}
+
+// markIndexed remembers an ensured unique index
+func (m *mongoTargets) markIndexed(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.indexed == nil {
+		m.indexed = make(map[string]bool)
+	}
+	m.indexed[key] = true
+}
+
+// closeIdle closes connections unused for maxIdle
+func (m *mongoTargets) closeIdle(maxIdle time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for networkID, cached := range m.conns {
+		if time.Since(cached.lastUsed) > maxIdle {
+			log.Printf("Closing stale MongoDB target connection for network %d", networkID)
+			cached.conn.Close()
+			delete(m.conns, networkID)
+		}
+	}
+}
+
+// writeMongoBatch upserts a batch into the network's MongoDB target: the target table is
+// the collection, dotted columns become nested fields and the unique key is matched on
+func (al *AgentListener) writeMongoBatch(work insertWork) (int, []database.RejectedRow, error) {
+	records, _, err := al.workRecords(work)
+	if err != nil || len(records) == 0 {
+		return 0, nil, err
+	}
+
+	// CSV values are text, the source column types give them back their BSON types so
+	// documents and key filters match across runs
+	specs := make(map[string]database.ColumnSpec, len(work.columnTypes))
+	for _, spec := range work.columnTypes {
+		specs[spec.Name] = spec
+	}
+	var rejected []database.RejectedRow
+	docs := make([]map[string]interface{}, 0, len(records))
+	for _, rec := range records {
+		doc, err := database.MongoDocument(rec, specs)
+		if err != nil {
+			rejected = append(rejected, database.RejectedRow{Record: rec, Error: err.Error()})
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		return 0, rejected, nil
+	}
+
+	conn, err := al.mongoTargetConn(work.networkID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Unique index of the key, created on the first load of the collection
+	indexKey := fmt.Sprintf("%d:%s:%s", work.networkID, work.tableName, strings.Join(work.keyColumns, ","))
+	if len(work.keyColumns) > 0 && al.mongoTargets.needsIndex(indexKey) {
+		name, err := conn.EnsureUniqueIndex(work.tableName, work.keyColumns)
+		if err != nil {
+			return 0, nil, err
+		}
+		al.mongoTargets.markIndexed(indexKey)
+		al.appendJobLogEvent(work.logID, "Ensured unique index %s on collection %s (%s)", name, work.tableName, strings.Join(work.keyColumns, ", "))
+	}
+
+	written, writeRejected, err := conn.UpsertBatch(work.tableName, docs, work.keyColumns)
+	return written, append(rejected, writeRejected...), err
+}
+
+// mongoTargetConn returns the MongoDB target connection of a network
+func (al *AgentListener) mongoTargetConn(networkID uint) (*database.MongoConnection, error) {
+	var network core.Network
+	if err := al.handler.db.First(&network, networkID).Error; err != nil {
+		return nil, fmt.Errorf("network %d not found: %w", networkID, err)
+	}
+	if network.TargetMongoHost == "" || network.TargetMongoDatabase == "" {
+		return nil, fmt.Errorf("MongoDB target host and database are required")
+	}
+	return al.mongoTargets.get(networkID, mongoConfigFromNetwork(network))
+}
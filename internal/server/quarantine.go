@@ -0,0 +1,339 @@
+package server
+
+import (
+	"dsp-platform/internal/core"
+	"dsp-platform/internal/database"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxReplayRows caps the rows replayed by one request
+const maxReplayRows = 5000
+
+// rejectedRowsQuery applies the quarantine filters of the request
+// (job_id, job_log_id, network_id, table, status)
+func (h *Handler) rejectedRowsQuery(c *gin.Context) *gorm.DB {
+	query := h.db.Model(&core.RejectedRow{})
+	if jobID := c.Query("job_id"); jobID != "" {
+		query = query.Where("job_id = ?", jobID)
+	}
+	if logID := c.Query("job_log_id"); logID != "" {
+		query = query.Where("job_log_id = ?", logID)
+	}
+	if networkID := c.Query("network_id"); networkID != "" {
+		query = query.Where("network_id = ?", networkID)
+	}
+	if table := c.Query("table"); table != "" {
+		query = query.Where("target_table = ?", table)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	return query
+}
+
+// GetRejectedRows returns quarantined rows with pagination
+func (h *Handler) GetRejectedRows(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 50
+	}
+
+	rows := []core.RejectedRow{}
+	var total int64
+
+	query := h.rejectedRowsQuery(c)
+	query.Count(&total)
+
+	if err := query.Order("id desc").Offset((page - 1) * limit).Limit(limit).Find(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quarantined rows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  rows,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// DownloadRejectedRows streams the quarantined rows matching the filters as JSONL
+func (h *Handler) DownloadRejectedRows(c *gin.Context) {
+	filename := fmt.Sprintf("quarantine-%s.jsonl", time.Now().Format("20060102-150405"))
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Type", "application/x-ndjson")
+
+	encoder := json.NewEncoder(c.Writer)
+	batch := []core.RejectedRow{}
+	err := h.rejectedRowsQuery(c).Order("id asc").FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for _, row := range batch {
+			// Keep the original record as JSON rather than an escaped string
+			record := json.RawMessage(row.Record)
+			if !json.Valid(record) {
+				raw, _ := json.Marshal(row.Record)
+				record = raw
+			}
+			if err := encoder.Encode(gin.H{
+				"id":           row.ID,
+				"network_id":   row.NetworkID,
+				"job_id":       row.JobID,
+				"job_log_id":   row.JobLogID,
+				"target_table": row.TargetTable,
+				"status":       row.Status,
+				"attempts":     row.Attempts,
+				"error":        row.Error,
+				"created_at":   row.CreatedAt,
+				"record":       record,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		log.Printf("⚠️ Quarantine download interrupted: %v", err)
+		return
+	}
+
+	go func() {
+		h.db.Create(&core.AuditLog{
+			Username:  c.GetString("username"),
+			UserID:    c.GetUint("user_id"),
+			Action:    "DOWNLOAD",
+			Entity:    "QUARANTINE",
+			Details:   fmt.Sprintf("Downloaded quarantined rows: %s", filename),
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			CreatedAt: time.Now(),
+		})
+	}()
+}
+
+// ReplayRejectedRows writes pending quarantined rows to their target table again,
+// selected by IDs or by job / job log
+func (h *Handler) ReplayRejectedRows(c *gin.Context) {
+	var req struct {
+		IDs      []uint `json:"ids"`
+		JobID    uint   `json:"job_id"`
+		JobLogID uint   `json:"job_log_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.IDs) == 0 && req.JobID == 0 && req.JobLogID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids, job_id or job_log_id is required"})
+		return
+	}
+	if h.agentListener == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Agent listener not available"})
+		return
+	}
+
+	query := h.db.Where("status = ?", "pending")
+	if len(req.IDs) > 0 {
+		query = query.Where("id IN ?", req.IDs)
+	}
+	if req.JobID > 0 {
+		query = query.Where("job_id = ?", req.JobID)
+	}
+	if req.JobLogID > 0 {
+		query = query.Where("job_log_id = ?", req.JobLogID)
+	}
+
+	rows := []core.RejectedRow{}
+	if err := query.Order("id asc").Limit(maxReplayRows).Find(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quarantined rows"})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusOK, gin.H{"replayed": 0, "failed": 0, "message": "No pending rows to replay"})
+		return
+	}
+
+	replayed, failed := h.agentListener.replayRejectedRows(rows)
+
+	go func() {
+		h.db.Create(&core.AuditLog{
+			Username:  c.GetString("username"),
+			UserID:    c.GetUint("user_id"),
+			Action:    "REPLAY",
+			Entity:    "QUARANTINE",
+			Details:   fmt.Sprintf("Replayed %d quarantined rows (%d still failing)", replayed, failed),
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			CreatedAt: time.Now(),
+		})
+	}()
+
+	c.JSON(http.StatusOK, gin.H{
+		"replayed": replayed,
+		"failed":   failed,
+		"limited":  len(rows) == maxReplayRows,
+	})
+}
+
+// replayTarget is the write configuration of a quarantined row's table
+type replayTarget struct {
+	keyColumns []string
+	writeMode  string
+	targetType string // Network target type (database, api, ...)
+}
+
+// replayTargetFor resolves the target type, unique key and write mode of a quarantined
+// row's table: the job's own target, or the fan-out destination the row was written to
+func (al *AgentListener) replayTargetFor(jobID, networkID uint, table string) replayTarget {
+	var target replayTarget
+	var job core.Job
+	if err := al.handler.db.Preload("Schema.Rules").Preload("Network").First(&job, jobID).Error; err != nil {
+		return target
+	}
+	target.targetType = job.Network.TargetSourceType
+	target.keyColumns = database.ParseKeyColumns(job.Schema.UniqueKeyColumn)
+
+	// Rows of a destination carry the destination's network and table
+	var dest *core.JobDestination
+	ruleTable := table
+	if networkID != 0 && networkID != job.NetworkID {
+		var destinations []core.JobDestination
+		al.handler.db.Preload("Network").Where("job_id = ? AND network_id = ?", jobID, networkID).Find(&destinations)
+		tables := []string{job.Schema.TargetTable}
+		for _, rule := range job.Schema.Rules {
+			tables = append(tables, rule.TargetTable)
+		}
+		for i := range destinations {
+			for _, t := range tables {
+				if dest == nil && destinationTable(destinations[i], t) == table {
+					dest, ruleTable = &destinations[i], t
+				}
+			}
+		}
+		if dest != nil {
+			target.targetType = dest.Network.TargetSourceType
+		}
+	}
+
+	for _, rule := range job.Schema.Rules {
+		if rule.TargetTable == ruleTable {
+			target.writeMode = rule.WriteMode
+			if rule.UniqueKey != "" {
+				target.keyColumns = database.ParseKeyColumns(rule.UniqueKey)
+			}
+			target.writeMode, target.keyColumns = database.ResolveWriteMode(target.writeMode, target.keyColumns)
+			break
+		}
+	}
+	if dest != nil {
+		target.writeMode, target.keyColumns = destinationWriteMode(*dest, target.writeMode, target.keyColumns)
+	}
+	return target
+}
+
+// replayRejectedRows writes quarantined rows one by one, so each row gets its own
+// outcome: written rows are marked replayed, the others keep the latest error
+func (al *AgentListener) replayRejectedRows(rows []core.RejectedRow) (int, int) {
+	targets := make(map[string]replayTarget)
+	replayed, failed := 0, 0
+
+	for _, row := range rows {
+		err := al.replayRow(row, targets)
+
+		updates := map[string]interface{}{"attempts": row.Attempts + 1}
+		if err == nil {
+			now := time.Now()
+			updates["status"] = "replayed"
+			updates["replayed_at"] = &now
+			replayed++
+		} else {
+			updates["error"] = err.Error()
+			failed++
+		}
+		al.handler.db.Model(&core.RejectedRow{}).Where("id = ?", row.ID).Updates(updates)
+	}
+
+	log.Printf("🔁 Replayed %d quarantined rows, %d still failing", replayed, failed)
+	return replayed, failed
+}
+
+// replayRow writes one quarantined row with its table's write configuration
+func (al *AgentListener) replayRow(row core.RejectedRow, targets map[string]replayTarget) error {
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(row.Record), &record); err != nil {
+		return fmt.Errorf("invalid quarantined record: %w", err)
+	}
+
+	cacheKey := fmt.Sprintf("%d:%d:%s", row.JobID, row.NetworkID, row.TargetTable)
+	target, ok := targets[cacheKey]
+	if !ok {
+		target = al.replayTargetFor(row.JobID, row.NetworkID, row.TargetTable)
+		targets[cacheKey] = target
+	}
+	records := []map[string]interface{}{record}
+	var rejected []database.RejectedRow
+
+	switch {
+	case target.targetType == "api":
+		api, err := al.apiTargetFor(row.NetworkID, row.JobID, float64(row.JobLogID), row.TargetTable)
+		if err != nil {
+			return err
+		}
+		if _, rejected, err = api.send(records); err != nil {
+			return err
+		}
+		if len(rejected) > 0 {
+			return fmt.Errorf("%s", rejected[0].Error)
+		}
+		return nil
+	case target.targetType == "mongodb":
+		conn, err := al.mongoTargetConn(row.NetworkID)
+		if err != nil {
+			return err
+		}
+		if _, rejected, err = conn.UpsertBatch(row.TargetTable, records, target.keyColumns); err != nil {
+			return err
+		}
+		if len(rejected) > 0 {
+			return fmt.Errorf("%s", rejected[0].Error)
+		}
+		return nil
+	case !isDatabaseTarget(target.targetType):
+		return fmt.Errorf("replay is not supported for %s targets", target.targetType)
+	}
+
+	targetConn, err := al.targetConnForNetwork(row.NetworkID)
+	if err != nil {
+		return err
+	}
+	if targetConn == nil {
+		return fmt.Errorf("target database not configured")
+	}
+
+	switch {
+	case target.writeMode == database.WriteModeSCD2:
+		_, err = targetConn.SCD2Batch(row.TargetTable, records, target.keyColumns)
+	case len(target.keyColumns) > 0:
+		_, rejected, err = targetConn.UpsertBatch(row.TargetTable, records, target.keyColumns)
+	default:
+		_, rejected, err = targetConn.InsertBatch(row.TargetTable, records)
+	}
+	if err != nil {
+		return err
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("%s", rejected[0].Error)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"dsp-platform/internal/database"
+	"dsp-platform/internal/mapping"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runState tracks one target table of a job run across its batches, so the end of
+// the run (sequence reset, post query, staging swap) happens after every batch is written
+type runState struct {
+	key             string
+	networkID       uint
+	jobID           uint
+	logID           float64
+	table           string // Live target table
+	staging         string // Staging table for staging_swap loads ("" = direct)
+	keyColumns      []string
+	uploadPostQuery string
+	skipSequences   bool   // Rule opted out of the sequence resync
+	targetType      string // Network target type; file targets export the spool at the end
+	direct          bool   // Agent writes the target itself and runs the end-of-run queries
+
+	mapping    *mapping.Mapping // Rule column mapping, parsed once for the run (nil = none)
+	mappingErr error            // Invalid mapping, fails every batch of the run
+
+	inflight     sync.WaitGroup // Batches dispatched but not yet written
+	prepareOnce  sync.Once
+	prepareErr   error
+	finalizeOnce sync.Once
+
+	maxRejected int // Fail the run above this many rejected rows (0 = no limit)
+
+	destination       *runDestination // Set on the runs of fan-out destinations
+	destinationPolicy string          // Job's destination failure policy (job's own run)
+	fanout            []*runState     // Destination runs, finalized before this run
+	finalStatus       string          // Outcome once finalized
+	finalError        string
+
+	mu         sync.Mutex
+	finalizing bool         // Set once finalizeRun started waiting, later batches are refused
+	spool      *fileSpool   // Spooled rows of file targets (nil until the first batch)
+	minio      *minioStream // Part objects of MinIO targets (nil until the first batch)
+	api        *apiTarget   // Client of API targets (nil until the first batch)
+	received   int          // Rows reported by the agent
+	written    int          // Rows reported written by the target
+	rejected   int          // Rows refused by the target and quarantined
+	checkpoint string       // Highest checkpoint value written, committed when the run succeeds
+	errMsg     string
+	lastSeen   time.Time
+}
+
+// beginBatch counts a batch as in flight, unless the run is already being finalized (aborted,
+// expired) and waits for its in-flight batches
+func (r *runState) beginBatch() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finalizing {
+		return false
+	}
+	r.inflight.Add(1)
+	return true
+}
+
+// recordBatch adds the outcome of a written batch
+func (r *runState) recordBatch(received, written, rejected int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received += received
+	r.written += written
+	r.rejected += rejected
+	if err != nil && r.errMsg == "" {
+		r.errMsg = err.Error()
+	}
+	r.lastSeen = time.Now()
+}
+
+// recordCheckpoint keeps the highest checkpoint value of the written batches
+func (r *runState) recordCheckpoint(value string) {
+	if value == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if value > r.checkpoint {
+		r.checkpoint = value
+	}
+}
+
+// failure returns the first batch error of the run, or the rejected-row threshold breach
+func (r *runState) failure() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.errMsg == "" && r.maxRejected > 0 && r.rejected > r.maxRejected {
+		return fmt.Sprintf("%d rows rejected by the target (limit %d), see quarantine", r.rejected, r.maxRejected)
+	}
+	return r.errMsg
+}
+
+// runStateFor returns the state of a run's target table, creating it on the first batch.
+// setup fixes the run's settings on creation, before any worker can read them
+func (al *AgentListener) runStateFor(networkID, jobID uint, logID float64, tableName string, loadMode string, setup func(*runState)) *runState {
+	key := ensuredTableKey(networkID, logID, tableName)
+
+	al.runsMu.Lock()
+	defer al.runsMu.Unlock()
+
+	if run, ok := al.runs[key]; ok {
+		run.mu.Lock()
+		run.lastSeen = time.Now()
+		run.mu.Unlock()
+		return run
+	}
+
+	run := &runState{
+		key:       key,
+		networkID: networkID,
+		jobID:     jobID,
+		logID:     logID,
+		table:     tableName,
+		lastSeen:  time.Now(),
+	}
+	if loadMode == database.LoadModeStagingSwap && logID > 0 {
+		run.staging = database.StagingTableName(tableName, uint(logID))
+	}
+	if setup != nil {
+		setup(run)
+	}
+	al.runs[key] = run
+	return run
+}
+
+// liveRunLogIDs returns the job logs of the runs in progress
+func (al *AgentListener) liveRunLogIDs() map[uint]bool {
+	al.runsMu.Lock()
+	defer al.runsMu.Unlock()
+	live := make(map[uint]bool, len(al.runs))
+	for _, run := range al.runs {
+		live[uint(run.logID)] = true
+	}
+	return live
+}
+
+// removeRunState forgets a finished run
+func (al *AgentListener) removeRunState(key string) {
+	al.runsMu.Lock()
+	defer al.runsMu.Unlock()
+	delete(al.runs, key)
+}
+
+// writeTable returns the table a batch must be written to: the run's staging table
+// (created on first use) for staging_swap loads, the live table otherwise
+func (al *AgentListener) writeTable(targetConn *database.TargetConnection, work insertWork) (string, error) {
+	run := work.run
+	if run == nil || run.staging == "" {
+		return work.tableName, nil
+	}
+
+	run.prepareOnce.Do(func() {
+		statements, err := targetConn.PrepareStagingTable(run.table, run.staging)
+		for _, stmt := range statements {
+			al.appendJobLogEvent(run.logID, "DDL on %s: %s", run.staging, stmt)
+		}
+		if err != nil {
+			run.prepareErr = err
+			return
+		}
+		al.appendJobLogEvent(run.logID, "Loading %s through staging table %s", run.table, run.staging)
+	})
+	if run.prepareErr != nil {
+		return "", run.prepareErr
+	}
+	return run.staging, nil
+}
+
+// finalizeRun runs once per run and table after the final batch: it waits for in-flight
+// batches, finalizes the fan-out destinations, swaps or drops the staging table, resets
+// sequences, runs the upload post query, commits the checkpoint and closes the job log
+func (al *AgentListener) finalizeRun(run *runState, status, errorMsg string, recordCount int, sampleData string) {
+	run.finalizeOnce.Do(func() {
+		run.mu.Lock()
+		run.finalizing = true
+		run.mu.Unlock()
+		run.inflight.Wait()
+		al.removeRunState(run.key)
+
+		// Destinations get the agent's outcome, their own failures don't leak into each other
+		destFailures := al.finalizeDestinations(run, status, errorMsg)
+
+		if errorMsg == "" {
+			errorMsg = run.failure()
+		}
+		if errorMsg == "" && len(destFailures) > 0 && run.destinationPolicy != DestinationPolicyContinue {
+			errorMsg = "Destination failed: " + strings.Join(destFailures, "; ")
+		}
+		if errorMsg == "" && al.isJobAborted(run.jobID) {
+			errorMsg = "Aborted by user"
+		}
+		if errorMsg != "" {
+			status = "failed"
+		}
+
+		if run.staging != "" {
+			if status == "failed" {
+				al.dropStaging(run)
+			} else if err := al.swapStaging(run); err != nil {
+				log.Printf("⚠️ Staging swap failed for %s: %v", run.table, err)
+				al.appendJobLogEvent(run.logID, "Staging swap of %s failed, live table left untouched: %v", run.table, err)
+				al.dropStaging(run)
+				status, errorMsg = "failed", err.Error()
+			}
+		}
+
+		if isFileTarget(run.targetType) {
+			if err := al.finalizeFileTarget(run, status == "failed"); err != nil {
+				log.Printf("⚠️ File export failed for %s: %v", run.table, err)
+				al.appendJobLogEvent(run.logID, "File export of %s failed: %v", run.table, err)
+				status, errorMsg = "failed", err.Error()
+			}
+		} else if run.targetType == "minio" {
+			if err := al.finalizeMinioTarget(run, status == "failed"); err != nil {
+				log.Printf("⚠️ MinIO export failed for %s: %v", run.table, err)
+				al.appendJobLogEvent(run.logID, "MinIO export of %s failed: %v", run.table, err)
+				status, errorMsg = "failed", err.Error()
+			}
+		} else if status != "failed" && isDatabaseTarget(run.targetType) && !run.direct {
+			if !run.skipSequences {
+				al.resetSequenceForTable(run.table, run.networkID, run.logID)
+			}
+			if run.uploadPostQuery != "" {
+				al.ExecuteTargetQuery(run.uploadPostQuery, run.networkID)
+			}
+		}
+
+		al.forgetEnsuredTable(run.networkID, run.logID, run.table)
+		if run.staging != "" {
+			al.forgetEnsuredTable(run.networkID, run.logID, run.staging)
+		}
+
+		run.finalStatus, run.finalError = status, errorMsg
+		if run.destination != nil {
+			// The job log belongs to the job's own run
+			al.recordDestinationRun(run, status, errorMsg)
+			log.Printf("✅ Finalized job %d destination %s table %s: status=%s", run.jobID, run.destination.name, run.table, status)
+			return
+		}
+
+		if len(destFailures) > 0 && run.destinationPolicy == DestinationPolicyContinue {
+			al.appendJobLogEvent(run.logID, "%d destinations failed, run status kept (destination failure policy: continue)", len(destFailures))
+		}
+		// A failed run leaves the checkpoint where it was, so its rows are extracted again
+		checkpoint := ""
+		if status != "failed" {
+			run.mu.Lock()
+			checkpoint = run.checkpoint
+			run.mu.Unlock()
+		}
+		al.finishProcessedFiles(run, status)
+		al.updateJobLog(run.logID, false, status, recordCount, 0, sampleData, errorMsg)
+		al.updateJobStatus(run.jobID, false, status, checkpoint)
+		log.Printf("✅ Finalized job %d table %s: status=%s", run.jobID, run.table, status)
+	})
+}
+
+// swapStaging validates the staging row count and swaps it in place of the live table
+func (al *AgentListener) swapStaging(run *runState) error {
+	targetConn, err := al.targetConnForNetwork(run.networkID)
+	if err != nil {
+		return err
+	}
+	if targetConn == nil {
+		return fmt.Errorf("target database not configured")
+	}
+
+	exists, err := targetConn.TableExists(run.staging)
+	if err != nil {
+		return err
+	}
+
+	run.mu.Lock()
+	received, written := run.received, run.written
+	run.mu.Unlock()
+
+	if !exists {
+		if received > 0 {
+			return fmt.Errorf("staging table %s is missing", run.staging)
+		}
+		// Nothing was extracted and the live table doesn't exist yet, nothing to swap
+		al.appendJobLogEvent(run.logID, "No rows extracted for %s, nothing to swap", run.table)
+		return nil
+	}
+
+	count, err := targetConn.CountRows(run.staging)
+	if err != nil {
+		return err
+	}
+	al.appendJobLogEvent(run.logID, "Staging %s holds %d rows (received %d, written %d)", run.staging, count, received, written)
+
+	// Duplicates may legitimately collapse on a unique key, but rows can never appear from
+	// nowhere and a plain insert must keep everything it reported written
+	if count > int64(received) || (count == 0 && received > 0) {
+		return fmt.Errorf("row count check failed: staging has %d rows, agent sent %d", count, received)
+	}
+	if len(run.keyColumns) == 0 && count != int64(written) {
+		return fmt.Errorf("row count check failed: staging has %d rows, %d were written", count, written)
+	}
+
+	// An error leaves the live table as it was, so the caller can drop staging safely
+	result, err := targetConn.SwapStagingTable(run.table, run.staging, uint(run.logID))
+	if err != nil {
+		return err
+	}
+	for _, stmt := range result.Statements {
+		al.appendJobLogEvent(run.logID, "DDL on %s: %s", run.table, stmt)
+	}
+	al.appendJobLogEvent(run.logID, "Swapped staging table %s into %s", run.staging, run.table)
+	if result.Warning != nil {
+		log.Printf("⚠️ Staging swap cleanup of %s: %v", run.table, result.Warning)
+		al.appendJobLogEvent(run.logID, "Warning after swapping %s: %v", run.table, result.Warning)
+	}
+	return nil
+}
+
+// dropStaging removes the staging table of a failed run
+func (al *AgentListener) dropStaging(run *runState) {
+	targetConn, err := al.targetConnForNetwork(run.networkID)
+	if err != nil || targetConn == nil {
+		return
+	}
+	stmt, err := targetConn.DropTableIfExists(run.staging)
+	if err != nil {
+		log.Printf("⚠️ Failed to drop staging table %s: %v", run.staging, err)
+		return
+	}
+	if stmt != "" {
+		al.appendJobLogEvent(run.logID, "Run failed, dropped staging table: %s", stmt)
+	}
+}
+
+// abortRuns finalizes the runs of an aborted job so their staging tables are dropped
+func (al *AgentListener) abortRuns(jobID uint) {
+	al.runsMu.Lock()
+	var runs []*runState
+	for _, run := range al.runs {
+		if run.jobID == jobID {
+			runs = append(runs, run)
+		}
+	}
+	al.runsMu.Unlock()
+
+	for _, run := range runs {
+		go al.finalizeRun(run, "failed", "Aborted by user", 0, "")
+	}
+}
+
+// expireStaleRuns fails runs that never sent their final batch
+func (al *AgentListener) expireStaleRuns(maxIdle time.Duration) {
+	al.runsMu.Lock()
+	var stale []*runState
+	for _, run := range al.runs {
+		run.mu.Lock()
+		if time.Since(run.lastSeen) > maxIdle {
+			stale = append(stale, run)
+		}
+		run.mu.Unlock()
+	}
+	al.runsMu.Unlock()
+
+	for _, run := range stale {
+		log.Printf("⚠️ Run of job %d on %s idle for %s, failing it", run.jobID, run.table, maxIdle)
+		go al.finalizeRun(run, "failed", "No final batch received from agent", 0, "")
+	}
+}
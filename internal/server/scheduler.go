@@ -186,10 +186,12 @@ func (s *Scheduler) runJob(job core.Job) {
 				Type:      "RUN_JOB",
 				Timestamp: time.Now(),
 				Data: map[string]interface{}{
-					"job_id":     job.ID,
-					"job_log_id": jobLog.ID,
-					"name":       rule.TargetTable, // Individual rule identification
-					"query":      sourceQuery,
+					"job_id":        job.ID,
+					"job_log_id":    jobLog.ID,
+					"log_id":        jobLog.ID,
+					"credit_window": batchCreditWindow,
+					"name":          rule.TargetTable, // Individual rule identification
+					"query":         sourceQuery,
 					"schema": map[string]interface{}{
 						"id":           rule.ID,
 						"name":         job.Schema.Name + " - " + rule.TargetTable,
@@ -200,14 +202,19 @@ func (s *Scheduler) runJob(job core.Job) {
 						"extract_post": rule.ExtractPostQuery,
 						"upload_pre":   rule.UploadPreQuery,
 						"upload_post":  rule.UploadPostQuery,
+						"partition":    partitionCommand(*job.Schema, rule),
 					},
 				},
 			}
 
-			// Execute Pre-Job Queries (Truncate and/or UploadPreQuery)
-			log.Printf("Scheduler: Executing Pre-Job Queries for rule %s", rule.TargetTable)
-			if err := s.agentListener.ExecutePreJobQueries(job.NetworkID, rule.TargetTable, rule.Truncate, rule.UploadPreQuery); err != nil {
-				log.Printf("Scheduler: Failed to execute Pre-Job Queries for rule %s: %v", rule.TargetTable, err)
+			// Execute Pre-Job Queries (Truncate and/or UploadPreQuery), direct write agents run them themselves
+			if direct := s.agentListener.directWriteConfig(job, &rule, rule.TargetTable, jobLog.ID); direct != nil {
+				command.Data["direct_write"] = direct
+			} else {
+				log.Printf("Scheduler: Executing Pre-Job Queries for rule %s", rule.TargetTable)
+				if err := s.agentListener.ExecutePreJobQueries(job.NetworkID, rule.TargetTable, truncateBeforeLoad(rule), rule.UploadPreQuery); err != nil {
+					log.Printf("Scheduler: Failed to execute Pre-Job Queries for rule %s: %v", rule.TargetTable, err)
+				}
 			}
 
 			err := s.agentListener.SendCommandToAgent(job.Network.Name, command)
@@ -233,8 +240,10 @@ func (s *Scheduler) runJob(job core.Job) {
 			Type:      "RUN_JOB",
 			Timestamp: time.Now(),
 			Data: map[string]interface{}{
-				"job_id":     job.ID,
-				"job_log_id": jobLog.ID,
+				"job_id":        job.ID,
+				"job_log_id":    jobLog.ID,
+				"log_id":        jobLog.ID,
+				"credit_window": batchCreditWindow,
 				"schema": map[string]interface{}{
 					"id":           job.Schema.ID,
 					"name":         job.Schema.Name,
@@ -243,6 +252,9 @@ func (s *Scheduler) runJob(job core.Job) {
 				},
 			},
 		}
+		if direct := s.agentListener.directWriteConfig(job, nil, job.Schema.TargetTable, jobLog.ID); direct != nil {
+			command.Data["direct_write"] = direct
+		}
 
 		err := s.agentListener.SendCommandToAgent(job.Network.Name, command)
 		if err != nil {
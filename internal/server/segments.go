@@ -0,0 +1,105 @@
+package server
+
+import (
+	"dsp-platform/internal/core"
+	"dsp-platform/internal/database"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultPartitionParallelism matches the column default of SchemaRule.PartitionParallelism
+const defaultPartitionParallelism = 4
+
+// partitionCommand returns the partition section of a rule's RUN_JOB schema, nil when the
+// rule is extracted with a single query
+func partitionCommand(schema core.Schema, rule core.SchemaRule) map[string]interface{} {
+	if rule.PartitionColumn == "" || (rule.PartitionCount < 2 && rule.PartitionBounds == "") {
+		return nil
+	}
+	parallelism := rule.PartitionParallelism
+	if parallelism < 1 {
+		parallelism = defaultPartitionParallelism
+	}
+	keyColumns := database.ParseKeyColumns(schema.UniqueKeyColumn)
+	if rule.UniqueKey != "" {
+		keyColumns = database.ParseKeyColumns(rule.UniqueKey)
+	}
+	_, keyColumns = database.ResolveWriteMode(rule.WriteMode, keyColumns)
+	return map[string]interface{}{
+		"column":      rule.PartitionColumn,
+		"count":       rule.PartitionCount,
+		"bounds":      rule.PartitionBounds,
+		"parallelism": parallelism,
+		"retries":     rule.PartitionRetries,
+		// Keyed writes make it safe to extract a segment again after some of its batches
+		// were already written; unkeyed appends (including insert mode) would duplicate them
+		"idempotent": len(keyColumns) > 0,
+	}
+}
+
+// handleSegmentStatus records the progress of one partition segment of a run
+func (al *AgentListener) handleSegmentStatus(msg core.AgentMessage) {
+	jobID, _ := msg.Data["job_id"].(float64)
+	logID, _ := msg.Data["log_id"].(float64)
+	segmentNo, _ := msg.Data["segment"].(float64)
+	table, _ := msg.Data["target_table"].(string)
+	rangeDesc, _ := msg.Data["range"].(string)
+	status, _ := msg.Data["status"].(string)
+	attempt, _ := msg.Data["attempt"].(float64)
+	records, _ := msg.Data["record_count"].(float64)
+	errMsg, _ := msg.Data["error"].(string)
+	if jobID == 0 || logID == 0 {
+		return
+	}
+
+	db := al.handler.db
+	var segment core.JobLogSegment
+	err := db.Where("job_log_id = ? AND target_table = ? AND segment = ?", uint(logID), table, int(segmentNo)).
+		First(&segment).Error
+	if err != nil {
+		segment = core.JobLogSegment{
+			JobID:       uint(jobID),
+			JobLogID:    uint(logID),
+			TargetTable: table,
+			Segment:     int(segmentNo),
+			StartedAt:   time.Now(),
+		}
+	}
+	segment.Range = rangeDesc
+	segment.Status = status
+	segment.Attempts = int(attempt)
+	segment.RecordCount = int(records)
+	segment.ErrorMessage = errMsg
+	if status == "completed" || status == "failed" {
+		segment.CompletedAt = time.Now()
+	}
+	if err := db.Save(&segment).Error; err != nil {
+		log.Printf("⚠️ Failed to record segment %d of job %d: %v", segment.Segment, segment.JobID, err)
+		return
+	}
+
+	switch status {
+	case "retrying":
+		al.appendJobLogEvent(logID, "Segment %d %s of %s failed (attempt %d), retrying: %s", segment.Segment, rangeDesc, table, segment.Attempts, errMsg)
+	case "failed":
+		al.appendJobLogEvent(logID, "Segment %d %s of %s failed after %d attempts: %s", segment.Segment, rangeDesc, table, segment.Attempts, errMsg)
+	case "completed":
+		var done int64
+		db.Model(&core.JobLogSegment{}).Where("job_log_id = ? AND status = ?", uint(logID), "completed").Count(&done)
+		db.Model(&core.Job{}).Where("id = ?", uint(jobID)).Update("ex_segs", done)
+	}
+}
+
+// GetJobLogSegments returns the partition segments of a job run
+func (h *Handler) GetJobLogSegments(c *gin.Context) {
+	segments := []core.JobLogSegment{}
+	if err := h.db.Where("job_id = ? AND job_log_id = ?", c.Param("id"), c.Param("logId")).
+		Order("target_table, segment").Find(&segments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, segments)
+}